@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+	"github.com/nwesterhausen/domain-monitor/service"
+)
+
+// Search the certificate transparency logs for the monitored domains.
+//
+// Usage: ct list|poll ...
+func runCertificates(dir configuration.ConfigDirectory, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: domain-monitor [-data-dir DIR] ct list|poll ...")
+		return 2
+	}
+	config := dir.ReadAppConfig().Config
+	certificates := service.NewCertificateService(dir.ReadCertificates(), dir.ReadDomains(), config)
+
+	switch args[0] {
+	case "list", "ls":
+		return runCertificateList(certificates, args[1:])
+	case "poll":
+		return runCertificatePoll(dir, config, certificates, args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "Unknown ct command %q\n", args[0])
+	return 2
+}
+
+func runCertificateList(certificates *service.CertificateService, args []string) int {
+	flags := flag.NewFlagSet("ct list", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "Print the certificates as JSON")
+	domain := flags.String("domain", "", "Only list the certificates of this domain")
+	flags.Parse(args)
+
+	list := certificates.List(*domain)
+	if *asJSON {
+		return printJSON(list)
+	}
+	printCertificates(list)
+	return 0
+}
+
+// Search the certificates of the monitored domains (or only the given ones) now and print the new ones. With -send
+// the alerts of the suspicious certificates are sent, like the scheduler does.
+//
+// Usage: ct poll [-send] [fqdn...]
+func runCertificatePoll(dir configuration.ConfigDirectory, config configuration.ConfigurationFile, certificates *service.CertificateService, args []string) int {
+	flags := flag.NewFlagSet("ct poll", flag.ExitOnError)
+	send := flags.Bool("send", false, "Send the alerts of the suspicious certificates")
+	flags.Parse(args)
+
+	now := time.Now()
+	results := []service.CertificateResult{}
+	if flags.NArg() > 0 {
+		for _, fqdn := range flags.Args() {
+			result, err := certificates.Poll(fqdn, now)
+			if err != nil {
+				return fail("Unable to search %s: %s", fqdn, err)
+			}
+			results = append(results, result)
+		}
+	} else {
+		results = certificates.PollAll(now)
+	}
+
+	added := []configuration.Certificate{}
+	failures := 0
+	for _, result := range results {
+		if result.Poll.Error != "" {
+			fmt.Printf("❌ %s: %s\n", result.Domain.FQDN, result.Poll.Error)
+			failures++
+			continue
+		}
+		fmt.Printf("📜 %s: %d certificates, %d new, %d suspicious\n", result.Domain.FQDN, result.Poll.Found, len(result.New), len(result.Suspicious))
+		added = append(added, result.New...)
+	}
+	if len(added) > 0 {
+		printCertificates(added)
+	}
+
+	if *send {
+		if !config.Alerts.SendAlerts {
+			return fail("Alerts are disabled (alerts.sendAlerts = false), nothing was sent")
+		}
+		notifications := newAlertNotifications(config, dir, dir.ReadDomains())
+		if !notifications.Enabled() {
+			return fail("No mailer or notifier webhook configured, nothing was sent")
+		}
+		service.NotifyCertificates(notifications, config, results, now)
+
+		// Deliver everything that is due now, failed notifications stay queued for the server to retry
+		sent, failed := notifications.Process(time.Now())
+		if failed > 0 {
+			return fail("%d notifications delivered, %d failed and are queued for retry", sent, failed)
+		}
+		fmt.Fprintf(os.Stderr, "📤 %d notifications delivered\n", sent)
+	}
+	if failures > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printCertificates(list []configuration.Certificate) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COMMON NAME\tOF\tISSUER\tNOT BEFORE\tNOT AFTER\tNAMES\tFLAGS")
+	for _, certificate := range list {
+		flags := []string{}
+		if certificate.NewIssuer {
+			flags = append(flags, "new issuer")
+		}
+		if len(certificate.UnexpectedNames) > 0 {
+			flags = append(flags, "unexpected "+strings.Join(certificate.UnexpectedNames, " "))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", certificate.CommonName, certificate.Of, certificate.IssuerOrganization(),
+			certificate.NotBefore.Format("2006-01-02"), certificate.NotAfter.Format("2006-01-02"), len(certificate.Names), strings.Join(flags, ", "))
+	}
+	w.Flush()
+}
@@ -0,0 +1,579 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+	"github.com/nwesterhausen/domain-monitor/service"
+)
+
+// Print the top level usage, including the subcommands
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, `Usage: domain-monitor [-data-dir DIR] [command] [arguments]
+
+Commands:
+  serve                          Start the web server and the schedulers (default)
+  domain add [flags] <fqdn>      Add a domain to monitor
+  domain list [-json] [-tag TAG] [-group GROUP] [-owner OWNER] [-state STATE]
+                                 List the monitored domains
+  domain rm <fqdn>               Stop monitoring a domain
+  domain update [flags] <fqdn>   Change the settings of a domain
+  domain pause|resume|archive|restore <fqdn>
+                                 Change the lifecycle state of a domain
+  whois refresh [-force] [fqdn]  Refresh the WHOIS cache (one domain is always refreshed)
+  watch add [-notes N] [-owners O] <fqdn>
+                                 Watch a name you don't own for availability
+  watch list [-json]             List the watched names
+  watch rm <fqdn>                Stop watching a name
+  watch check [-send] [fqdn...]  Check the watched names now (and send the alerts of changes)
+  lookalike list [-json] [-domain FQDN]
+                                 List the lookalikes found for the monitored domains
+  lookalike scan [-send] [fqdn...]
+                                 Look for lookalikes now (and send the alerts of new ones)
+  lookalike permutations <fqdn>  Print the lookalikes generated for a name
+  ct list [-json] [-domain FQDN] List the certificates found in the certificate transparency logs
+  ct poll [-send] [fqdn...]      Search the certificate transparency logs now (and send the alerts of suspicious
+                                 certificates)
+  subdomain list [-json] [-domain FQDN]
+                                 List the subdomains discovered below the monitored domains
+  subdomain discover [fqdn...]   Discover the subdomains now (and check the promoted ones)
+  subdomain import -domain FQDN <zonefile>
+                                 Add the hosts of a zone file ("-" for stdin) to the inventory
+  subdomain promote|demote <host>
+                                 Turn the TLS check of a subdomain on or off
+  mailsec list [-json]           List the mail security reports of the monitored domains
+  mailsec check [-send] [fqdn...]
+                                 Check the SPF, DMARC, DKIM, MTA-STS, TLS-RPT and BIMI records now (and send the
+                                 alerts of regressions)
+  dnssec list [-json]            List the DNSSEC reports of the monitored domains
+  dnssec check [-send] [fqdn...] Check the DS records, keys and signatures now (and send the alerts of problems)
+  delegation list [-json]        List the delegation health reports of the monitored domains
+  delegation check [-send] [fqdn...]
+                                 Check the nameservers, their authority and SOA serials now (and send the alerts of
+                                 degraded delegations)
+  check [-send [-digest]] [-json]
+                                 Evaluate the expiration alerts once and print the results
+  nagios [-w DAYS] [-c DAYS] [-live] [fqdn...]
+                                 Check expirations as a Nagios/Icinga plugin
+  mail test [address]            Send a test e-mail (defaults to the admin address)
+  export [-o FILE] [-include-secrets]
+                                 Write a backup archive
+  import|restore [-dry-run] <archive.tar.gz>
+                                 Restore a backup archive
+
+Global flags:`)
+	flag.PrintDefaults()
+}
+
+// Print an error for a subcommand and return the exit code for failures
+func fail(format string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "❌ "+format+"\n", args...)
+	return 1
+}
+
+// writesData reports if a command (other than serve) may change the data files. Listing, checking without -send,
+// exporting and test mails only read them.
+func writesData(command string, args []string) bool {
+	switch command {
+	case "nagios", "icinga", "export", "mail":
+		return false
+	case "check":
+		return hasFlag(args, "send")
+	case "restore", "import":
+		return !hasFlag(args, "dry-run")
+	}
+	return len(args) > 0 && args[0] != "list" && args[0] != "permutations"
+}
+
+// hasFlag reports if the boolean flag name is set in args, in any of the spellings the flag package accepts
+func hasFlag(args []string, name string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		switch strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-") {
+		case name, name + "=true", name + "=1":
+			return true
+		}
+	}
+	return false
+}
+
+// Manage the monitored domains.
+//
+// Usage: domain add|list|rm|update|pause|resume|archive|restore ...
+func runDomain(dir configuration.ConfigDirectory, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: domain-monitor [-data-dir DIR] domain add|list|rm|update|pause|resume|archive|restore ...")
+		return 2
+	}
+	domains := service.NewDomainService(dir.ReadDomains())
+
+	switch args[0] {
+	case "add":
+		return runDomainAdd(domains, args[1:])
+	case "list", "ls":
+		return runDomainList(dir, domains, args[1:])
+	case "rm", "remove":
+		return runDomainRemove(dir, domains, args[1:])
+	case "update":
+		return runDomainUpdate(domains, args[1:])
+	case configuration.TransitionPause, configuration.TransitionResume, configuration.TransitionArchive, configuration.TransitionRestore:
+		return runDomainTransition(domains, args[0], args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "Unknown domain command %q\n", args[0])
+	return 2
+}
+
+// Flags shared by `domain add` and `domain update`
+type domainFlags struct {
+	flags    *flag.FlagSet
+	name     *string
+	alerts   *bool
+	enabled  *bool
+	price    *float64
+	currency *string
+	owners   *string
+	cc       *string
+	notifier *string
+	tags     *string
+	group    *string
+	notes    *string
+	dkim     *string
+}
+
+func newDomainFlags(command string) domainFlags {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	return domainFlags{
+		flags:    flags,
+		name:     flags.String("name", "", "Display name (defaults to the FQDN)"),
+		alerts:   flags.Bool("alerts", true, "Send expiration alerts for the domain"),
+		enabled:  flags.Bool("enabled", true, "Monitor the domain, false pauses it"),
+		price:    flags.Float64("price", 0, "Renewal price"),
+		currency: flags.String("currency", "", "Currency symbol of the renewal price"),
+		owners:   flags.String("owners", "", "Comma separated email addresses or contact groups receiving the alerts instead of the default recipients"),
+		cc:       flags.String("cc", "", "Comma separated email addresses or contact groups copied on the alerts"),
+		notifier: flags.String("notifier", "", "Webhook URL that also receives the alerts"),
+		tags:     flags.String("tags", "", "Comma separated tags"),
+		group:    flags.String("group", "", "Group or project of the domain"),
+		notes:    flags.String("notes", "", "Notes about the domain"),
+		dkim:     flags.String("dkim", "", "Comma separated DKIM selectors checked instead of mailSecurity.dkimSelectors"),
+	}
+}
+
+// Parse the arguments and return the FQDN, or an empty string if it's missing
+func (f domainFlags) parse(args []string, usage string) string {
+	f.flags.Usage = func() {
+		fmt.Fprintln(f.flags.Output(), "Usage: domain-monitor [-data-dir DIR] "+usage)
+		f.flags.PrintDefaults()
+	}
+	f.flags.Parse(args)
+	if f.flags.NArg() != 1 {
+		f.flags.Usage()
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(f.flags.Arg(0)))
+}
+
+// Apply the flags that were given on the command line to a domain
+func (f domainFlags) apply(domain *configuration.Domain) {
+	f.flags.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "name":
+			domain.Name = *f.name
+		case "alerts":
+			domain.Alerts = *f.alerts
+		case "enabled":
+			domain.Enabled = *f.enabled
+		case "price":
+			domain.RenewalPrice = *f.price
+		case "currency":
+			domain.Currency = *f.currency
+		case "owners":
+			domain.Owners = configuration.SplitList(*f.owners)
+		case "cc":
+			domain.CC = configuration.SplitList(*f.cc)
+		case "notifier":
+			domain.Notifier = *f.notifier
+		case "tags":
+			domain.Tags = configuration.SplitList(*f.tags)
+		case "group":
+			domain.Group = *f.group
+		case "notes":
+			domain.Notes = *f.notes
+		case "dkim":
+			domain.DKIMSelectors = configuration.SplitList(*f.dkim)
+		}
+	})
+}
+
+func runDomainAdd(domains *service.ServicesDomain, args []string) int {
+	f := newDomainFlags("domain add")
+	fqdn := f.parse(args, "domain add [flags] <fqdn>")
+	if fqdn == "" {
+		return 2
+	}
+	if _, err := domains.GetDomain(fqdn); err == nil {
+		return fail("%s is already monitored, use `domain update` to change it", fqdn)
+	}
+
+	domain := configuration.Domain{Name: fqdn, FQDN: fqdn, Alerts: *f.alerts, Enabled: *f.enabled}
+	f.apply(&domain)
+	if _, err := domains.CreateDomain(domain); err != nil {
+		return fail("Unable to add %s: %s", fqdn, err)
+	}
+	fmt.Printf("✅ Added %s\n", fqdn)
+	return 0
+}
+
+func runDomainUpdate(domains *service.ServicesDomain, args []string) int {
+	f := newDomainFlags("domain update")
+	fqdn := f.parse(args, "domain update [flags] <fqdn>")
+	if fqdn == "" {
+		return 2
+	}
+	domain, err := domains.GetDomain(fqdn)
+	if err != nil {
+		return fail("%s is not monitored", fqdn)
+	}
+
+	f.apply(&domain)
+	if err := domains.UpdateDomain(domain); err != nil {
+		return fail("Unable to update %s: %s", fqdn, err)
+	}
+	fmt.Printf("✅ Updated %s\n", fqdn)
+	return 0
+}
+
+func runDomainRemove(dir configuration.ConfigDirectory, domains *service.ServicesDomain, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: domain-monitor [-data-dir DIR] domain rm <fqdn>")
+		return 2
+	}
+	fqdn := strings.ToLower(strings.TrimSpace(args[0]))
+	if _, err := domains.GetDomain(fqdn); err != nil {
+		return fail("%s is not monitored", fqdn)
+	}
+	if err := domains.DeleteDomain(fqdn); err != nil {
+		return fail("Unable to remove %s: %s", fqdn, err)
+	}
+	// Drop the cached WHOIS entry as well, like the web UI does
+	cache := dir.ReadWhoisCache()
+	cache.Remove(fqdn)
+	fmt.Printf("✅ Removed %s\n", fqdn)
+	return 0
+}
+
+func runDomainTransition(domains *service.ServicesDomain, transition string, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: domain-monitor [-data-dir DIR] domain %s <fqdn>\n", transition)
+		return 2
+	}
+	fqdn := strings.ToLower(strings.TrimSpace(args[0]))
+	if _, err := domains.GetDomain(fqdn); err != nil {
+		return fail("%s is not monitored", fqdn)
+	}
+	domain, err := domains.TransitionDomain(fqdn, transition)
+	if err != nil {
+		return fail("Unable to %s %s: %s", transition, fqdn, err)
+	}
+	fmt.Printf("✅ %s is %s\n", fqdn, domain.State())
+	return 0
+}
+
+func runDomainList(dir configuration.ConfigDirectory, domains *service.ServicesDomain, args []string) int {
+	flags := flag.NewFlagSet("domain list", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "Print the domains as JSON")
+	var filter configuration.DomainFilter
+	flags.StringVar(&filter.Tag, "tag", "", "Only list the domains with this tag")
+	flags.StringVar(&filter.Group, "group", "", "Only list the domains of this group")
+	flags.StringVar(&filter.Owner, "owner", "", "Only list the domains of this owner (email address or contact group)")
+	flags.StringVar(&filter.State, "state", "", "Only list the domains in this state (active, paused or archived)")
+	flags.Parse(args)
+	filter.Normalize()
+	if err := filter.Validate(); err != nil {
+		return fail("%s", err)
+	}
+
+	list, err := domains.GetDomains()
+	if err != nil {
+		return fail("Unable to list domains: %s", err)
+	}
+	if !filter.Empty() {
+		list = filter.Filter(list, dir.ReadAppConfig().Config)
+	}
+	if *asJSON {
+		return printJSON(list)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FQDN\tNAME\tSTATE\tALERTS\tRENEWAL\tGROUP\tTAGS")
+	for _, d := range list {
+		renewal := ""
+		if d.RenewalPrice > 0 {
+			renewal = fmt.Sprintf("%s%.2f", d.Currency, d.RenewalPrice)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\t%s\n", d.FQDN, d.Name, d.State(), d.Alerts, renewal, d.Group, strings.Join(d.Tags, ","))
+	}
+	w.Flush()
+	return 0
+}
+
+// Refresh the WHOIS cache.
+//
+// Usage: whois refresh [-force] [fqdn]
+func runWhois(dir configuration.ConfigDirectory, args []string) int {
+	if len(args) == 0 || args[0] != "refresh" {
+		fmt.Fprintln(os.Stderr, "Usage: domain-monitor [-data-dir DIR] whois refresh [-force] [fqdn]")
+		return 2
+	}
+	flags := flag.NewFlagSet("whois refresh", flag.ExitOnError)
+	force := flags.Bool("force", false, "Refresh every entry, not only the ones that are out of date")
+	flags.Parse(args[1:])
+
+	whois := service.NewWhoisService(dir.ReadWhoisCache())
+	if flags.NArg() > 0 {
+		failed := 0
+		for _, fqdn := range flags.Args() {
+			entry, err := whois.RefreshWhois(strings.ToLower(fqdn))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "❌", err)
+				failed++
+				continue
+			}
+			fmt.Printf("✅ Refreshed %s (expires %s)\n", entry.FQDN, formatExpiration(&entry))
+		}
+		if failed > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	// paused and archived domains are skipped, name them to refresh them anyway
+	domains := dir.ReadDomains()
+	monitored := 0
+	for _, domain := range domains.List() {
+		if !domain.Monitored() {
+			continue
+		}
+		monitored++
+		if *force {
+			if _, err := whois.RefreshWhois(domain.FQDN); err != nil {
+				fmt.Fprintln(os.Stderr, "❌", err)
+			}
+		}
+	}
+	if !*force {
+		whois.RefreshAll(domains)
+	}
+	fmt.Printf("✅ WHOIS cache refreshed for %d domains\n", monitored)
+	return 0
+}
+
+// Run the expiration evaluation once, like the scheduler does, and print the result. Snoozed alerts, and alerts held
+// back by the send window or quiet hours, are listed but not due. With -send the due alerts are mailed and marked as sent, following alerts.digestMode (or all in one digest with
+// -digest).
+//
+// Usage: check [-send [-digest]] [-json]
+func runCheck(dir configuration.ConfigDirectory, args []string) int {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	send := flags.Bool("send", false, "Send the due alerts and mark them as sent")
+	digest := flags.Bool("digest", false, "With -send, send every due alert as one digest regardless of alerts.digestMode")
+	asJSON := flags.Bool("json", false, "Print the results as JSON")
+	flags.Parse(args)
+
+	appConfig := dir.ReadAppConfig()
+	// the alert e-mails link to the acknowledge and snooze pages, which need a signing key
+	if *send {
+		appConfig.EnsureLinkSecret()
+	}
+	config := appConfig.Config
+	domains := dir.ReadDomains()
+	cache := dir.ReadWhoisCache()
+	whois := service.NewWhoisService(cache)
+	snoozes := service.NewSnoozeService(dir.ReadSnoozes(), config)
+	schedule := service.NewAlertSchedule(config.Scheduler)
+	now := schedule.Now()
+	statuses := schedule.Hold(snoozes.Apply(whois.EvaluateExpirations(domains.List(), config.Alerts, now), now), now)
+
+	if *asJSON {
+		printJSON(checkResults(statuses))
+	} else {
+		printCheck(os.Stdout, statuses)
+	}
+
+	if !*send {
+		return 0
+	}
+	if !config.Alerts.SendAlerts {
+		return fail("Alerts are disabled (alerts.sendAlerts = false), nothing was sent")
+	}
+	notifications := newAlertNotifications(config, dir, domains)
+	if !notifications.Enabled() {
+		return fail("No mailer or notifier webhook configured, nothing was sent")
+	}
+	notifications.UseSnoozeLinks(snoozes)
+	if *digest {
+		if sendDigest(cache, alertableStatuses(cache, domains, snoozes, config), notifications, config) {
+			cache.Flush()
+		}
+	} else {
+		sendDueAlerts(cache, domains, notifications, snoozes, config)
+		cache.Flush()
+	}
+
+	// Deliver everything that is due now, failed notifications stay queued for the server to retry
+	sent, failed := notifications.Process(time.Now())
+	if failed > 0 {
+		return fail("%d notifications delivered, %d failed and are queued for retry", sent, failed)
+	}
+	fmt.Fprintf(os.Stderr, "📤 %d notifications delivered\n", sent)
+	return 0
+}
+
+// JSON shape of a single `check` result
+type checkResult struct {
+	FQDN       string     `json:"fqdn"`
+	Expiration *time.Time `json:"expiration,omitempty"`
+	DaysLeft   int        `json:"daysLeft"`
+	Due        []string   `json:"due"`
+	Snoozed    []string   `json:"snoozed,omitempty"`
+	Held       []string   `json:"held,omitempty"`
+	Problem    string     `json:"problem,omitempty"`
+}
+
+func checkResults(statuses []service.ExpiryStatus) []checkResult {
+	results := make([]checkResult, 0, len(statuses))
+	for _, status := range statuses {
+		result := checkResult{
+			FQDN:       status.Domain.FQDN,
+			Expiration: status.Expiration,
+			DaysLeft:   int(status.DaysLeft),
+			Due:        []string{},
+			Problem:    status.Problem,
+		}
+		for _, alert := range status.Due {
+			result.Due = append(result.Due, alert.String())
+		}
+		for _, alert := range status.Snoozed {
+			result.Snoozed = append(result.Snoozed, alert.String())
+		}
+		for _, alert := range status.Held {
+			result.Held = append(result.Held, alert.String())
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func printCheck(out io.Writer, statuses []service.ExpiryStatus) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FQDN\tEXPIRES\tDAYS LEFT\tDUE ALERTS")
+	for _, status := range statuses {
+		if status.Problem != "" {
+			fmt.Fprintf(w, "%s\t-\t-\t%s\n", status.Domain.FQDN, status.Problem)
+			continue
+		}
+		due := make([]string, 0, len(status.Due))
+		for _, alert := range status.Due {
+			due = append(due, alert.String())
+		}
+		for _, alert := range status.Snoozed {
+			due = append(due, alert.String()+" (snoozed)")
+		}
+		for _, alert := range status.Held {
+			due = append(due, alert.String()+" (held)")
+		}
+		if !status.Domain.Alerts {
+			due = append(due, "(alerts off)")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", status.Domain.FQDN, status.Expiration.Format("2006-01-02"), int(status.DaysLeft), strings.Join(due, ", "))
+	}
+	w.Flush()
+}
+
+// Send a test e-mail with the configured SMTP settings.
+//
+// Usage: mail test [address]
+func runMail(dir configuration.ConfigDirectory, args []string) int {
+	if len(args) == 0 || args[0] != "test" || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, "Usage: domain-monitor [-data-dir DIR] mail test [address]")
+		return 2
+	}
+	config := dir.ReadAppConfig().Config
+	to := service.DefaultRecipients(config)
+	if len(args) == 2 {
+		to = []string{args[1]}
+	}
+	if len(to) == 0 {
+		return fail("No recipients configured, set alerts.admin or pass an address")
+	}
+	for _, address := range to {
+		if !configuration.IsEmailAddress(address) {
+			return fail("%q is not a valid e-mail address", address)
+		}
+	}
+
+	mailer := service.NewMailerService(config.SMTP)
+	if mailer == nil {
+		return fail("Unable to set up the SMTP mailer, check the smtp configuration")
+	}
+	mailer.UseTemplates(service.NewTemplateService(dir.DataDir), config.App.BaseURL)
+	if err := mailer.TestMail(to...); err != nil {
+		return fail("Test mail failed: %s", err)
+	}
+	fmt.Printf("✅ Test mail sent to %s\n", strings.Join(to, ", "))
+	return 0
+}
+
+// Write a backup archive of the data directory.
+//
+// Usage: export [-o FILE] [-include-secrets]
+func runExport(dir configuration.ConfigDirectory, args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flags.String("o", "-", "File to write the archive to (- for stdout)")
+	includeSecrets := flags.Bool("include-secrets", false, "Include the SMTP password in the archive")
+	flags.Parse(args)
+
+	var out io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.OpenFile(*output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			return fail("%s", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if err := service.NewBackupService(dir).WriteArchive(out, *includeSecrets); err != nil {
+		return fail("Export failed: %s", err)
+	}
+	if *output != "-" {
+		fmt.Printf("✅ Backup written to %s\n", *output)
+	}
+	return 0
+}
+
+func printJSON(v interface{}) int {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return fail("%s", err)
+	}
+	return 0
+}
+
+func formatExpiration(entry *configuration.WhoisCache) string {
+	if entry.WhoisInfo.Domain == nil || entry.WhoisInfo.Domain.ExpirationDateInTime == nil {
+		return "unknown"
+	}
+	return entry.WhoisInfo.Domain.ExpirationDateInTime.Format("2006-01-02")
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+	"github.com/nwesterhausen/domain-monitor/service"
+)
+
+// Check the nameserver delegations of the monitored domains.
+//
+// Usage: delegation list|check ...
+func runDelegation(dir configuration.ConfigDirectory, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: domain-monitor [-data-dir DIR] delegation list|check ...")
+		return 2
+	}
+	config := dir.ReadAppConfig().Config
+	delegation := service.NewDelegationService(dir.ReadDelegation(), dir.ReadWhoisCache(), dir.ReadDomains(), config)
+
+	switch args[0] {
+	case "list", "ls":
+		return runDelegationList(delegation, args[1:])
+	case "check":
+		return runDelegationCheck(dir, config, delegation, args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "Unknown delegation command %q\n", args[0])
+	return 2
+}
+
+func runDelegationList(delegation *service.DelegationService, args []string) int {
+	flags := flag.NewFlagSet("delegation list", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "Print the reports as JSON")
+	flags.Parse(args)
+
+	list := delegation.List()
+	if *asJSON {
+		return printJSON(list)
+	}
+	printDelegation(list)
+	return 0
+}
+
+// Check the delegations of the monitored domains (or only the given ones) now and print the reports. With -send the
+// alerts of the problems are sent, like the scheduler does.
+//
+// Usage: delegation check [-send] [fqdn...]
+func runDelegationCheck(dir configuration.ConfigDirectory, config configuration.ConfigurationFile, delegation *service.DelegationService, args []string) int {
+	flags := flag.NewFlagSet("delegation check", flag.ExitOnError)
+	send := flags.Bool("send", false, "Send the alerts of the problems")
+	flags.Parse(args)
+
+	now := time.Now()
+	results := []service.DelegationResult{}
+	if flags.NArg() > 0 {
+		for _, fqdn := range flags.Args() {
+			result, err := delegation.Check(fqdn, now)
+			if err != nil {
+				return fail("Unable to check %s: %s", fqdn, err)
+			}
+			results = append(results, result)
+		}
+	} else {
+		results = delegation.CheckAll(now)
+	}
+
+	failures := 0
+	for _, result := range results {
+		if result.Report.Error != "" {
+			fmt.Printf("❌ %s: %s\n", result.Domain.FQDN, result.Report.Error)
+			failures++
+			continue
+		}
+		fmt.Printf("🧭 %s: %s\n", result.Domain.FQDN, result.Report.Status)
+		for _, finding := range result.Report.Findings {
+			fmt.Printf("   - %s\n", finding)
+		}
+		for _, problem := range result.Problems {
+			fmt.Printf("   ⚠️ %s\n", problem)
+		}
+	}
+
+	if *send {
+		if !config.Alerts.SendAlerts {
+			return fail("Alerts are disabled (alerts.sendAlerts = false), nothing was sent")
+		}
+		notifications := newAlertNotifications(config, dir, dir.ReadDomains())
+		if !notifications.Enabled() {
+			return fail("No mailer or notifier webhook configured, nothing was sent")
+		}
+		service.NotifyDelegations(notifications, config, results, now)
+
+		// Deliver everything that is due now, failed notifications stay queued for the server to retry
+		sent, failed := notifications.Process(time.Now())
+		if failed > 0 {
+			return fail("%d notifications delivered, %d failed and are queued for retry", sent, failed)
+		}
+		fmt.Fprintf(os.Stderr, "📤 %d notifications delivered\n", sent)
+	}
+	if failures > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printDelegation(list []configuration.DelegationReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FQDN\tSTATUS\tPARENT\tREGISTRY\tLAME\tSERIALS\tCHECKED")
+	for _, report := range list {
+		if !report.Complete() {
+			fmt.Fprintf(w, "%s\t-\t\t\t\t\t%s\n", report.FQDN, report.Error)
+			continue
+		}
+		serials := []string{}
+		for _, nameserver := range report.Nameservers {
+			if !nameserver.Lame {
+				serials = append(serials, fmt.Sprint(nameserver.Serial))
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", report.FQDN, report.Status, strings.Join(report.Parent, ","), strings.Join(report.Registry, ","),
+			strings.Join(report.Lame(), ","), strings.Join(serials, ","), report.Succeeded.Format("2006-01-02 15:04"))
+	}
+	w.Flush()
+}
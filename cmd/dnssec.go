@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+	"github.com/nwesterhausen/domain-monitor/service"
+)
+
+// Check the DNSSEC chains of the monitored domains.
+//
+// Usage: dnssec list|check ...
+func runDNSSEC(dir configuration.ConfigDirectory, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: domain-monitor [-data-dir DIR] dnssec list|check ...")
+		return 2
+	}
+	config := dir.ReadAppConfig().Config
+	dnssec := service.NewDNSSECService(dir.ReadDNSSEC(), dir.ReadDomains(), config)
+
+	switch args[0] {
+	case "list", "ls":
+		return runDNSSECList(dnssec, args[1:])
+	case "check":
+		return runDNSSECCheck(dir, config, dnssec, args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "Unknown dnssec command %q\n", args[0])
+	return 2
+}
+
+func runDNSSECList(dnssec *service.DNSSECService, args []string) int {
+	flags := flag.NewFlagSet("dnssec list", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "Print the reports as JSON")
+	flags.Parse(args)
+
+	list := dnssec.List()
+	if *asJSON {
+		return printJSON(list)
+	}
+	printDNSSEC(list)
+	return 0
+}
+
+// Check the chains of the monitored domains (or only the given ones) now and print the reports. With -send the
+// alerts of the problems are sent, like the scheduler does.
+//
+// Usage: dnssec check [-send] [fqdn...]
+func runDNSSECCheck(dir configuration.ConfigDirectory, config configuration.ConfigurationFile, dnssec *service.DNSSECService, args []string) int {
+	flags := flag.NewFlagSet("dnssec check", flag.ExitOnError)
+	send := flags.Bool("send", false, "Send the alerts of the problems")
+	flags.Parse(args)
+
+	now := time.Now()
+	results := []service.DNSSECResult{}
+	if flags.NArg() > 0 {
+		for _, fqdn := range flags.Args() {
+			result, err := dnssec.Check(fqdn, now)
+			if err != nil {
+				return fail("Unable to check %s: %s", fqdn, err)
+			}
+			results = append(results, result)
+		}
+	} else {
+		results = dnssec.CheckAll(now)
+	}
+
+	failures := 0
+	for _, result := range results {
+		if result.Report.Error != "" {
+			fmt.Printf("❌ %s: %s\n", result.Domain.FQDN, result.Report.Error)
+			failures++
+			continue
+		}
+		fmt.Printf("🔐 %s: %s\n", result.Domain.FQDN, result.Report.Status)
+		for _, finding := range result.Report.Findings {
+			fmt.Printf("   - %s\n", finding)
+		}
+		for _, problem := range result.Problems {
+			fmt.Printf("   ⚠️ %s\n", problem)
+		}
+	}
+
+	if *send {
+		if !config.Alerts.SendAlerts {
+			return fail("Alerts are disabled (alerts.sendAlerts = false), nothing was sent")
+		}
+		notifications := newAlertNotifications(config, dir, dir.ReadDomains())
+		if !notifications.Enabled() {
+			return fail("No mailer or notifier webhook configured, nothing was sent")
+		}
+		service.NotifyDNSSECs(notifications, config, results, now)
+
+		// Deliver everything that is due now, failed notifications stay queued for the server to retry
+		sent, failed := notifications.Process(time.Now())
+		if failed > 0 {
+			return fail("%d notifications delivered, %d failed and are queued for retry", sent, failed)
+		}
+		fmt.Fprintf(os.Stderr, "📤 %d notifications delivered\n", sent)
+	}
+	if failures > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printDNSSEC(list []configuration.DNSSECReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FQDN\tSTATUS\tDS\tKEYS\tEXPIRES\tREGISTRY\tCHECKED")
+	for _, report := range list {
+		if !report.Complete() {
+			fmt.Fprintf(w, "%s\t-\t\t\t\t\t%s\n", report.FQDN, report.Error)
+			continue
+		}
+		ds := []string{}
+		for _, record := range report.DS {
+			state := "ok"
+			if !record.Matched {
+				state = "unmatched"
+			}
+			ds = append(ds, strconv.Itoa(record.KeyTag)+"="+state)
+		}
+		keys := []string{}
+		for _, key := range report.Keys {
+			keys = append(keys, strconv.Itoa(key.KeyTag))
+		}
+		expires := "-"
+		if !report.Expires.IsZero() {
+			expires = report.Expires.Format("2006-01-02 15:04")
+		}
+		registry := "-"
+		if report.Registry != nil {
+			registry = "unsigned"
+			if report.Registry.DelegationSigned {
+				registry = "signed"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", report.FQDN, report.Status, strings.Join(ds, ","), strings.Join(keys, ","), expires, registry,
+			report.Succeeded.Format("2006-01-02 15:04"))
+	}
+	w.Flush()
+}
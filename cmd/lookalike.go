@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+	"github.com/nwesterhausen/domain-monitor/service"
+)
+
+// Look for lookalikes of the monitored domains.
+//
+// Usage: lookalike list|scan|permutations ...
+func runLookalike(dir configuration.ConfigDirectory, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: domain-monitor [-data-dir DIR] lookalike list|scan|permutations ...")
+		return 2
+	}
+	config := dir.ReadAppConfig().Config
+	lookalikes := service.NewLookalikeService(dir.ReadLookalikes(), dir.ReadLookalikeWhoisCache(), dir.ReadDomains(), config)
+
+	switch args[0] {
+	case "list", "ls":
+		return runLookalikeList(lookalikes, args[1:])
+	case "scan":
+		return runLookalikeScan(dir, config, lookalikes, args[1:])
+	case "permutations":
+		return runLookalikePermutations(lookalikes, args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "Unknown lookalike command %q\n", args[0])
+	return 2
+}
+
+func runLookalikeList(lookalikes *service.LookalikeService, args []string) int {
+	flags := flag.NewFlagSet("lookalike list", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "Print the lookalikes as JSON")
+	domain := flags.String("domain", "", "Only list the lookalikes of this domain")
+	flags.Parse(args)
+
+	list := lookalikes.List(*domain)
+	if *asJSON {
+		return printJSON(list)
+	}
+	printLookalikes(list)
+	return 0
+}
+
+// Scan the monitored domains (or only the given ones) now and print the lookalikes that appeared. With -send the
+// alerts of the new lookalikes are sent, like the scheduler does.
+//
+// Usage: lookalike scan [-send] [fqdn...]
+func runLookalikeScan(dir configuration.ConfigDirectory, config configuration.ConfigurationFile, lookalikes *service.LookalikeService, args []string) int {
+	flags := flag.NewFlagSet("lookalike scan", flag.ExitOnError)
+	send := flags.Bool("send", false, "Send the alerts of the new lookalikes")
+	flags.Parse(args)
+
+	now := time.Now()
+	results := []service.LookalikeResult{}
+	if flags.NArg() > 0 {
+		for _, fqdn := range flags.Args() {
+			result, err := lookalikes.Scan(fqdn, now)
+			if err != nil {
+				return fail("Unable to scan %s: %s", fqdn, err)
+			}
+			results = append(results, result)
+		}
+	} else {
+		results = lookalikes.ScanAll(now)
+	}
+
+	appeared := []configuration.Lookalike{}
+	for _, result := range results {
+		fmt.Printf("🎭 %s: %d of %d lookalikes resolve, %d new\n", result.Domain.FQDN, result.Scan.Found, result.Scan.Candidates, len(result.Appeared))
+		appeared = append(appeared, result.Appeared...)
+	}
+	if len(appeared) > 0 {
+		printLookalikes(appeared)
+	}
+
+	if !*send {
+		return 0
+	}
+	if !config.Alerts.SendAlerts {
+		return fail("Alerts are disabled (alerts.sendAlerts = false), nothing was sent")
+	}
+	notifications := newAlertNotifications(config, dir, dir.ReadDomains())
+	if !notifications.Enabled() {
+		return fail("No mailer or notifier webhook configured, nothing was sent")
+	}
+	service.NotifyLookalikes(notifications, config, results, now)
+
+	// Deliver everything that is due now, failed notifications stay queued for the server to retry
+	sent, failed := notifications.Process(time.Now())
+	if failed > 0 {
+		return fail("%d notifications delivered, %d failed and are queued for retry", sent, failed)
+	}
+	fmt.Fprintf(os.Stderr, "📤 %d notifications delivered\n", sent)
+	return 0
+}
+
+func runLookalikePermutations(lookalikes *service.LookalikeService, args []string) int {
+	flags := flag.NewFlagSet("lookalike permutations", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "Print the permutations as JSON")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: domain-monitor [-data-dir DIR] lookalike permutations [-json] <fqdn>")
+		return 2
+	}
+
+	permutations := lookalikes.Permutations(flags.Arg(0))
+	if *asJSON {
+		return printJSON(permutations)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tKIND\tREGISTERED AS")
+	for _, permutation := range permutations {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", permutation.Display(), permutation.Kind, permutation.FQDN)
+	}
+	w.Flush()
+
+	counts := configuration.PermutationCounts(permutations)
+	summary := []string{}
+	for _, kind := range configuration.PermutationKinds {
+		summary = append(summary, fmt.Sprintf("%d %s", counts[kind], kind))
+	}
+	fmt.Fprintf(os.Stderr, "%d permutations: %s\n", len(permutations), strings.Join(summary, ", "))
+	return 0
+}
+
+func printLookalikes(list []configuration.Lookalike) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LOOKALIKE\tOF\tKIND\tREGISTRAR\tCREATED\tFIRST SEEN\tRESOLVES")
+	for _, lookalike := range list {
+		created, resolves := "-", "no"
+		if lookalike.Created != nil {
+			created = lookalike.Created.Format("2006-01-02")
+		}
+		if lookalike.Active {
+			resolves = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", lookalike.Display(), lookalike.Of, lookalike.Kind, lookalike.Registrar, created, lookalike.FirstSeen.Format("2006-01-02"), resolves)
+	}
+	w.Flush()
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+	"github.com/nwesterhausen/domain-monitor/service"
+)
+
+// Check the email security records of the monitored domains.
+//
+// Usage: mailsec list|check ...
+func runMailSecurity(dir configuration.ConfigDirectory, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: domain-monitor [-data-dir DIR] mailsec list|check ...")
+		return 2
+	}
+	config := dir.ReadAppConfig().Config
+	mailSecurity := service.NewMailSecurityService(dir.ReadMailSecurity(), dir.ReadDomains(), config)
+
+	switch args[0] {
+	case "list", "ls":
+		return runMailSecurityList(mailSecurity, args[1:])
+	case "check":
+		return runMailSecurityCheck(dir, config, mailSecurity, args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "Unknown mailsec command %q\n", args[0])
+	return 2
+}
+
+func runMailSecurityList(mailSecurity *service.MailSecurityService, args []string) int {
+	flags := flag.NewFlagSet("mailsec list", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "Print the reports as JSON")
+	flags.Parse(args)
+
+	list := mailSecurity.List()
+	if *asJSON {
+		return printJSON(list)
+	}
+	printMailSecurity(list)
+	return 0
+}
+
+// Check the records of the monitored domains (or only the given ones) now and print the reports. With -send the
+// alerts of the regressions are sent, like the scheduler does.
+//
+// Usage: mailsec check [-send] [fqdn...]
+func runMailSecurityCheck(dir configuration.ConfigDirectory, config configuration.ConfigurationFile, mailSecurity *service.MailSecurityService, args []string) int {
+	flags := flag.NewFlagSet("mailsec check", flag.ExitOnError)
+	send := flags.Bool("send", false, "Send the alerts of the regressions")
+	flags.Parse(args)
+
+	now := time.Now()
+	results := []service.MailSecurityResult{}
+	if flags.NArg() > 0 {
+		for _, fqdn := range flags.Args() {
+			result, err := mailSecurity.Check(fqdn, now)
+			if err != nil {
+				return fail("Unable to check %s: %s", fqdn, err)
+			}
+			results = append(results, result)
+		}
+	} else {
+		results = mailSecurity.CheckAll(now)
+	}
+
+	failures := 0
+	for _, result := range results {
+		if result.Report.Error != "" {
+			fmt.Printf("❌ %s: %s\n", result.Domain.FQDN, result.Report.Error)
+			failures++
+			continue
+		}
+		fmt.Printf("📧 %s: scored %d (%s)\n", result.Domain.FQDN, result.Report.Score, result.Report.Grade())
+		for _, finding := range result.Report.Findings {
+			fmt.Printf("   - %s\n", finding)
+		}
+		for _, regression := range result.Regressions {
+			fmt.Printf("   ⚠️ %s\n", regression)
+		}
+	}
+
+	if *send {
+		if !config.Alerts.SendAlerts {
+			return fail("Alerts are disabled (alerts.sendAlerts = false), nothing was sent")
+		}
+		notifications := newAlertNotifications(config, dir, dir.ReadDomains())
+		if !notifications.Enabled() {
+			return fail("No mailer or notifier webhook configured, nothing was sent")
+		}
+		service.NotifyMailSecurities(notifications, config, results, now)
+
+		// Deliver everything that is due now, failed notifications stay queued for the server to retry
+		sent, failed := notifications.Process(time.Now())
+		if failed > 0 {
+			return fail("%d notifications delivered, %d failed and are queued for retry", sent, failed)
+		}
+		fmt.Fprintf(os.Stderr, "📤 %d notifications delivered\n", sent)
+	}
+	if failures > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printMailSecurity(list []configuration.MailSecurityReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FQDN\tSCORE\tSPF\tDMARC\tDKIM\tMTA-STS\tTLS-RPT\tBIMI\tCHECKED")
+	for _, report := range list {
+		if !report.Complete() {
+			fmt.Fprintf(w, "%s\t-\t\t\t\t\t\t\t%s\n", report.FQDN, report.Error)
+			continue
+		}
+		dkim := []string{}
+		for _, key := range report.DKIM {
+			state := "ok"
+			if key.Revoked {
+				state = "revoked"
+			} else if !key.Valid() {
+				state = "missing"
+			}
+			dkim = append(dkim, key.Selector+"="+state)
+		}
+		spf := recordState(report.SPF.Record, report.SPF.Error, report.SPF.All+" "+strconv.Itoa(report.SPF.Lookups)+" lookups")
+		dmarc := recordState(report.DMARC.Record, report.DMARC.Error, "p="+report.DMARC.Policy)
+		mtaSts := recordState(report.MTASTS.Record, report.MTASTS.Error, report.MTASTS.Mode)
+		fmt.Fprintf(w, "%s\t%d (%s)\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", report.FQDN, report.Score, report.Grade(), spf, dmarc, strings.Join(dkim, ","),
+			mtaSts, recordState(report.TLSRPT.Record, report.TLSRPT.Error, "ok"), recordState(report.BIMI.Record, report.BIMI.Error, "ok"),
+			report.Succeeded.Format("2006-01-02 15:04"))
+	}
+	w.Flush()
+}
+
+// recordState summarizes a record for the report table: missing, invalid or the given state of a valid record
+func recordState(record string, err string, valid string) string {
+	switch {
+	case record == "":
+		return "missing"
+	case err != "":
+		return "invalid"
+	}
+	return valid
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
 	"time"
+	// embed the timezone database, so the scheduler timezone works in minimal containers
+	_ "time/tzdata"
 
 	"github.com/nwesterhausen/domain-monitor/configuration"
 	"github.com/nwesterhausen/domain-monitor/handlers"
@@ -18,8 +23,20 @@ import (
 func main() {
 	// setup the data directory which is passed in via a program argument
 	dataDirectory := flag.String("data-dir", "./data", "Directory to store configuration and cache files")
+	flag.Usage = usage
 	flag.Parse()
 
+	command, args := "serve", []string{}
+	if flag.NArg() > 0 {
+		command, args = flag.Arg(0), flag.Args()[1:]
+	}
+	// monitoring plugins must only print the plugin output, with every error reported as UNKNOWN. The plugin only reads
+	// the data directory, so it skips the setup below, which may create or change files.
+	if command == "nagios" || command == "icinga" {
+		log.SetOutput(io.Discard)
+		os.Exit(runNagios(configuration.ConfigDirectory{DataDir: *dataDirectory}, args))
+	}
+
 	// output the data directory to log and validate it
 	log.Println("📁 Data directory set to", *dataDirectory)
 	validateDirectory(*dataDirectory)
@@ -27,27 +44,97 @@ func main() {
 	// setup the configuration directory
 	configDirectory := configuration.ConfigDirectory{DataDir: *dataDirectory}
 
+	// The server keeps the data files in memory and overwrites every change made next to it, so it and the commands
+	// that write data files hold the lock on the data directory. The lock is released when the process exits.
+	if command == "serve" || writesData(command, args) {
+		lock, err := configDirectory.Lock()
+		if errors.Is(err, configuration.ErrLocked) && command == "serve" {
+			log.Fatalf("❌ Another server is running on %s", *dataDirectory)
+		}
+		if errors.Is(err, configuration.ErrLocked) {
+			os.Exit(fail("A server is running on %s, stop it first or make the change in the web interface", *dataDirectory))
+		}
+		if err != nil {
+			log.Fatalf("❌ Failed to lock the data directory: %s", err)
+		}
+		defer lock.Release()
+	}
+
+	// restoring a backup works on the files directly, so it happens before anything is loaded
+	if command == "restore" || command == "import" {
+		os.Exit(runRestore(configDirectory, args))
+	}
+
+	// Only the server applies staged restores and migrates the data files. The commands that only read may run next to
+	// a running server, which would overwrite the swapped in files from memory, so the other commands only check the
+	// file versions.
+	if command == "serve" {
+		// swap in a backup that was uploaded while the server was running
+		if err := configDirectory.ApplyStagedRestore(); err != nil {
+			log.Fatalf("❌ Failed to apply staged restore: %s", err)
+		}
+
+		// bring older data files up to the current format before reading them
+		if err := configDirectory.Migrate(); err != nil {
+			log.Fatalf("❌ Failed to migrate data files: %s", err)
+		}
+	} else {
+		if configDirectory.HasStagedRestore() {
+			log.Println("📦 A restore is staged for the next server start, this command works on the current data")
+		}
+		if err := configDirectory.CheckVersions(); err != nil {
+			os.Exit(fail("%s", err))
+		}
+	}
+
+	switch command {
+	case "serve":
+		serve(configDirectory)
+	case "domain":
+		os.Exit(runDomain(configDirectory, args))
+	case "whois":
+		os.Exit(runWhois(configDirectory, args))
+	case "watch":
+		os.Exit(runWatch(configDirectory, args))
+	case "lookalike", "lookalikes":
+		os.Exit(runLookalike(configDirectory, args))
+	case "ct", "certificates":
+		os.Exit(runCertificates(configDirectory, args))
+	case "subdomain", "subdomains":
+		os.Exit(runSubdomain(configDirectory, args))
+	case "mailsec", "mail-security":
+		os.Exit(runMailSecurity(configDirectory, args))
+	case "dnssec":
+		os.Exit(runDNSSEC(configDirectory, args))
+	case "delegation":
+		os.Exit(runDelegation(configDirectory, args))
+	case "check":
+		os.Exit(runCheck(configDirectory, args))
+	case "mail":
+		os.Exit(runMail(configDirectory, args))
+	case "export":
+		os.Exit(runExport(configDirectory, args))
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", command)
+		usage()
+		os.Exit(2)
+	}
+}
+
+// Run the web server and the schedulers
+func serve(configDirectory configuration.ConfigDirectory) {
 	log.Println("⤴️ Loading configuration and cache files...")
 
 	// read the app configuration
 	config := configDirectory.ReadAppConfig()
+	if config.EnsureLinkSecret() {
+		log.Println("🔑 Generated the key for signed links in alert e-mails")
+	}
 	// configure the SMTP mailer
 	var _mailer *service.MailerService = nil
 	// provide some sanity log messages, to confirm the alert and mailer settings
 	if config.Config.Alerts.SendAlerts {
-		if !config.Config.SMTP.Enabled {
-			log.Println("❌ Email notifications are disabled (SMTP.Enabled = false)")
-		} else if len(config.Config.SMTP.Host) == 0 || config.Config.SMTP.Host == "smtp.example.com" {
-			log.Println("❌ SMTP is not configured (host is empty or default)")
-			config.Config.SMTP.Enabled = false
-		} else {
-			_mailer = service.NewMailerService(config.Config.SMTP)
-			if _mailer == nil {
-				log.Println("❌ Failed to initialize SMTP mailer service. Check SMTP configuration.")
-			} else {
-				log.Printf("✅ SMTP mailer service initialized. Alerts will be sent to %s", config.Config.Alerts.Admin)
-			}
-		}
+		_mailer = newAlertMailer(config.Config, configDirectory)
 	} else {
 		log.Println("📵 Alerts are disabled (Alerts.SendAlerts = false)")
 	}
@@ -56,11 +143,55 @@ func main() {
 
 	// read the domain configuration
 	domains := configDirectory.ReadDomains()
-	log.Printf("📄 Loaded %d domains from domain list", len(domains.DomainFile.Domains))
+	log.Printf("📄 Loaded %d domains from domain list", len(domains.List()))
 
 	// read the WHOIS cache
 	whoisCache := configDirectory.ReadWhoisCache()
-	log.Printf("📄 Found %d cached whois entries", len(whoisCache.FileContents.Entries))
+	log.Printf("📄 Found %d cached whois entries", len(whoisCache.GetAll()))
+
+	// read the alert ledger
+	ledger := configDirectory.ReadAlertLedger()
+	log.Printf("📄 Found %d alert deliveries in the ledger", len(ledger.FileContents.Records))
+
+	// read the outbound notification queue
+	queue := configDirectory.ReadNotificationQueue()
+	log.Printf("📄 Found %d pending notifications in the queue", len(queue.List(configuration.QueuePending)))
+	notifications := service.NewNotificationService(queue, ledger, _mailer)
+	// the notifier webhooks of the domains are delivered without a mailer
+	notifications.UseWebhooks(config.Config.Alerts.SendAlerts && domains.HasNotifiers())
+
+	// read the alert acknowledgements and snoozes
+	snoozes := service.NewSnoozeService(configDirectory.ReadSnoozes(), config.Config)
+	notifications.UseSnoozeLinks(snoozes)
+
+	// read the names watched for availability
+	watches := service.NewWatchService(configDirectory.ReadWatchlist(), domains)
+	log.Printf("📄 Watching %d names for availability", len(watches.List()))
+
+	// read the lookalikes of the monitored domains, with their own WHOIS cache
+	lookalikes := service.NewLookalikeService(configDirectory.ReadLookalikes(), configDirectory.ReadLookalikeWhoisCache(), domains, config.Config)
+	log.Printf("📄 Found %d lookalikes of the monitored domains", len(lookalikes.List("")))
+
+	// read the certificates of the monitored domains found in the certificate transparency logs
+	certificateStore := configDirectory.ReadCertificates()
+	certificates := service.NewCertificateService(certificateStore, domains, config.Config)
+	log.Printf("📄 Found %d certificates of the monitored domains", len(certificates.List("")))
+
+	// read the subdomains discovered below the monitored domains, the certificates are one of their sources
+	discovery := service.NewDiscoveryService(configDirectory.ReadSubdomains(), certificateStore, domains, config.Config)
+	log.Printf("📄 Found %d subdomains of the monitored domains", len(discovery.List("")))
+
+	// read the email security reports of the monitored domains
+	mailSecurity := service.NewMailSecurityService(configDirectory.ReadMailSecurity(), domains, config.Config)
+	log.Printf("📄 Found %d mail security reports of the monitored domains", len(mailSecurity.List()))
+
+	// read the DNSSEC reports of the monitored domains
+	dnssec := service.NewDNSSECService(configDirectory.ReadDNSSEC(), domains, config.Config)
+	log.Printf("📄 Found %d DNSSEC reports of the monitored domains", len(dnssec.List()))
+
+	// read the delegation health reports of the monitored domains, the registry nameservers come from the whois cache
+	delegation := service.NewDelegationService(configDirectory.ReadDelegation(), whoisCache, domains, config.Config)
+	log.Printf("📄 Found %d delegation reports of the monitored domains", len(delegation.List()))
 
 	// initialize the web server
 	app := echo.New()
@@ -75,15 +206,53 @@ func main() {
 
 	// set up our routes
 	handlers.SetupRoutes(app, config.Config.App.ShowConfiguration)
-	handlers.SetupConfigRoutes(app, config)
-	handlers.SetupDomainRoutes(app, domains, whoisCache, config.Config.App.ShowConfiguration)
+	cs := service.NewConfigurationService(config)
+	handlers.SetupConfigRoutes(app, cs)
+	handlers.SetupDomainRoutes(app, domains, whoisCache, cs, config.Config.App.ShowConfiguration)
 
 	// Setup mailer routes (always register, handler will check if mailer is configured)
-	handlers.SetupMailerRoutes(app, _mailer, config.Config.Alerts.Admin)
+	handlers.SetupMailerRoutes(app, _mailer, cs)
+
+	// Setup alert ledger routes
+	handlers.SetupAlertRoutes(app, ledger, notifications, cs)
+
+	// Setup acknowledge and snooze routes
+	handlers.SetupSnoozeRoutes(app, snoozes, domains, whoisCache, config.Config.App.ShowConfiguration)
+
+	// Setup the calendar feed
+	handlers.SetupCalendarRoutes(app, domains, whoisCache, cs)
+	handlers.SetupCostRoutes(app, domains, whoisCache, cs)
+
+	// Setup mail template routes
+	handlers.SetupTemplateRoutes(app, service.NewTemplateService(configDirectory.DataDir), domains, whoisCache, config.Config.App.BaseURL)
+
+	// Setup backup and restore routes
+	handlers.SetupBackupRoutes(app, configDirectory, config.Config.App.ShowConfiguration)
+
+	// Setup the watchlist of names that aren't owned
+	handlers.SetupWatchlistRoutes(app, watches, notifications, cs)
+
+	// Setup the lookalikes of the monitored domains
+	handlers.SetupLookalikeRoutes(app, lookalikes, notifications, cs)
+
+	// Setup the certificates of the monitored domains
+	handlers.SetupCertificateRoutes(app, certificates, notifications, cs)
+
+	// Setup the subdomain inventory
+	handlers.SetupSubdomainRoutes(app, discovery, cs, domains)
+
+	// Setup the mail security reports on the domain cards
+	handlers.SetupMailSecurityRoutes(app, mailSecurity, notifications, cs)
+
+	// Setup the DNSSEC reports on the domain cards
+	handlers.SetupDNSSECRoutes(app, dnssec, notifications, cs)
+
+	// Setup the delegation health panel on the domain cards
+	handlers.SetupDelegationRoutes(app, delegation, notifications, cs)
 
 	// Setup whois routes
 	_whoisService := service.NewWhoisService(whoisCache)
-	handlers.SetupWhoisRoutes(app, _whoisService)
+	handlers.SetupWhoisRoutes(app, _whoisService, cs)
 
 	// Connect scheduler for whois cache updates. First delay is after 5 seconds, then every (configured amount) of hours
 	// Does not automatically update the interval if the config changes, so a server reset is required to change the interval
@@ -101,91 +270,326 @@ func main() {
 	// Does not automatically update the interval if the config changes, so a server reset is required to change the interval
 	// This uses the WhoisRefreshInterval as the interval for the domain expiration checks
 	time.AfterFunc(60*time.Second, func() {
-		domainExpirationCheckOnSchedule(whoisCache, domains, _mailer, config.Config, configuration.WhoisRefreshInterval)
+		domainExpirationCheckOnSchedule(whoisCache, domains, notifications, snoozes, config.Config, configuration.WhoisRefreshInterval)
 		log.Printf("📆 Scheduler running domain expiration checks every %s", configuration.WhoisRefreshInterval)
 	})
 
+	// Check the watched names for availability. First check is after 90 seconds, then every scheduler.watchCheckInterval
+	// hours (24 by default)
+	time.AfterFunc(90*time.Second, func() {
+		interval := service.WatchInterval(config.Config.Scheduler)
+		watchlistCheckOnSchedule(watches, notifications, config.Config, interval)
+		log.Printf("📆 Scheduler running watchlist checks every %s", interval)
+	})
+
+	// Look for lookalikes of the monitored domains. First scan is after 2 minutes, then every
+	// scheduler.lookalikeScanInterval hours (24 by default)
+	if lookalikes.Enabled() {
+		time.AfterFunc(2*time.Minute, func() {
+			interval := service.LookalikeInterval(config.Config.Scheduler)
+			lookalikeScanOnSchedule(lookalikes, notifications, config.Config, interval)
+			log.Printf("📆 Scheduler running lookalike scans every %s", interval)
+		})
+	} else {
+		log.Println("🚫 Lookalike scans are disabled by configuration. (Check `lookalikes.enabled` in config.yaml)")
+	}
+
+	// Search the certificate transparency logs for the monitored domains. First search is after 3 minutes, then every
+	// scheduler.certificatePollInterval hours (12 by default)
+	if certificates.Enabled() {
+		time.AfterFunc(3*time.Minute, func() {
+			interval := service.CertificateInterval(config.Config.Scheduler)
+			certificatePollOnSchedule(certificates, notifications, config.Config, interval)
+			log.Printf("📆 Scheduler running certificate searches every %s", interval)
+		})
+	} else {
+		log.Println("🚫 Certificate searches are disabled by configuration. (Check `certificates.enabled` in config.yaml)")
+	}
+
+	// Discover the subdomains of the monitored domains. First discovery is after 5 minutes, so it can use the
+	// certificates of the first search, then every scheduler.discoveryInterval hours (24 by default)
+	if discovery.Enabled() {
+		time.AfterFunc(5*time.Minute, func() {
+			interval := service.DiscoveryInterval(config.Config.Scheduler)
+			discoveryOnSchedule(discovery, interval)
+			log.Printf("📆 Scheduler running subdomain discovery every %s", interval)
+		})
+	} else {
+		log.Println("🚫 Subdomain discovery is disabled by configuration. (Check `discovery.enabled` in config.yaml)")
+	}
+
+	// Check the email security records of the monitored domains. First check is after 4 minutes, then every
+	// scheduler.mailSecurityInterval hours (24 by default)
+	if mailSecurity.Enabled() {
+		time.AfterFunc(4*time.Minute, func() {
+			interval := service.MailSecurityInterval(config.Config.Scheduler)
+			mailSecurityOnSchedule(mailSecurity, notifications, config.Config, interval)
+			log.Printf("📆 Scheduler running mail security checks every %s", interval)
+		})
+	} else {
+		log.Println("🚫 Mail security checks are disabled by configuration. (Check `mailSecurity.enabled` in config.yaml)")
+	}
+
+	// Check the DNSSEC chains of the monitored domains. First check is after 6 minutes, then every
+	// scheduler.dnssecInterval hours (12 by default)
+	if dnssec.Enabled() {
+		time.AfterFunc(6*time.Minute, func() {
+			interval := service.DNSSECInterval(config.Config.Scheduler)
+			dnssecOnSchedule(dnssec, notifications, config.Config, interval)
+			log.Printf("📆 Scheduler running DNSSEC checks every %s", interval)
+		})
+	} else {
+		log.Println("🚫 DNSSEC checks are disabled by configuration. (Check `dnssec.enabled` in config.yaml)")
+	}
+
+	// Check the nameserver delegations of the monitored domains. First check is after 7 minutes, then every
+	// scheduler.delegationInterval hours (6 by default)
+	if delegation.Enabled() {
+		time.AfterFunc(7*time.Minute, func() {
+			interval := service.DelegationInterval(config.Config.Scheduler)
+			delegationOnSchedule(delegation, notifications, config.Config, interval)
+			log.Printf("📆 Scheduler running delegation checks every %s", interval)
+		})
+	} else {
+		log.Println("🚫 Delegation checks are disabled by configuration. (Check `delegation.enabled` in config.yaml)")
+	}
+
+	// Scheduled digests run on their own timer, the expiry checks above leave the collected alerts for them
+	if notifications.Enabled() && (config.Config.Alerts.DigestMode == service.DigestDaily || config.Config.Alerts.DigestMode == service.DigestWeekly) {
+		digestOnSchedule(whoisCache, domains, notifications, snoozes, config.Config)
+	}
+
+	// Deliver queued notifications, including the ones left over from before a restart, and retry failed ones
+	if notifications.Enabled() {
+		notificationQueueOnSchedule(notifications, time.Minute)
+	}
+
 	// Start server on configured port
 	app.Logger.Fatal(app.Start(":" + fmt.Sprint(config.Config.App.Port)))
 }
 
-// When called on schedule, check for domain expirations in the WHOIS cache and send mail
-func domainExpirationCheckOnSchedule(whoisCache configuration.WhoisCacheStorage, domains configuration.DomainConfiguration, mailer *service.MailerService, appConfig configuration.ConfigurationFile, interval time.Duration) {
+// Create the mailer used for alerts, logging why if it can't be created
+func newAlertMailer(config configuration.ConfigurationFile, dir configuration.ConfigDirectory) *service.MailerService {
+	if !config.SMTP.Enabled {
+		log.Println("❌ Email notifications are disabled (SMTP.Enabled = false)")
+		return nil
+	}
+	if len(config.SMTP.Host) == 0 || config.SMTP.Host == "smtp.example.com" {
+		log.Println("❌ SMTP is not configured (host is empty or default)")
+		return nil
+	}
+	mailer := service.NewMailerService(config.SMTP)
 	if mailer == nil {
-		log.Println("🚫 No mailer configured, canceling domain expiration checks.")
+		log.Println("❌ Failed to initialize SMTP mailer service. Check SMTP configuration.")
+		return nil
+	}
+	mailer.UseTemplates(service.NewTemplateService(dir.DataDir), config.App.BaseURL)
+	log.Printf("✅ SMTP mailer service initialized. Alerts will be sent to %s", strings.Join(service.DefaultRecipients(config), ", "))
+	return mailer
+}
+
+// Create the notification service for alerts sent from the command line. E-mails need the SMTP mailer, the notifier
+// webhooks of the domains are delivered without it, so the service is enabled when either is configured.
+func newAlertNotifications(config configuration.ConfigurationFile, dir configuration.ConfigDirectory, domains *configuration.DomainConfiguration) *service.NotificationService {
+	notifications := service.NewNotificationService(dir.ReadNotificationQueue(), dir.ReadAlertLedger(), newAlertMailer(config, dir))
+	notifications.UseWebhooks(domains.HasNotifiers())
+	return notifications
+}
+
+// When called on schedule, check for domain expirations in the WHOIS cache and send mail. The next check runs after the
+// interval, or earlier when the daily alert window opens or the quiet hours end.
+func domainExpirationCheckOnSchedule(whoisCache *configuration.WhoisCacheStorage, domains *configuration.DomainConfiguration, notifications *service.NotificationService, snoozes *service.SnoozeService, appConfig configuration.ConfigurationFile, interval time.Duration) {
+	if !notifications.Enabled() {
+		log.Println("🚫 No mailer or notifier webhook configured, canceling domain expiration checks.")
 		return
 	}
 
-	// for every domain in the domains configuration, if alerts are turned on, check the expiration from the WHOIS cache and then send an alert if one hasn't been sent.
-	for _, domain := range domains.DomainFile.Domains {
-		if domain.Alerts {
-			whoisEntry := whoisCache.Get(domain.FQDN)
-			if whoisEntry == nil {
-				log.Printf("❌ WHOIS entry for %s not found, skipping", domain.FQDN)
-				continue
-			}
+	sendDueAlerts(whoisCache, domains, notifications, snoozes, appConfig)
+	notifications.Process(time.Now())
+	snoozes.Prune(time.Now())
 
-			// Get the days until expiration
-			daysUntilExpiration := whoisEntry.WhoisInfo.Domain.ExpirationDateInTime.Sub(time.Now()).Hours() / 24
+	schedule := service.NewAlertSchedule(appConfig.Scheduler)
+	next := schedule.NextCheck(schedule.Now(), interval)
+	if time.Until(next) < interval {
+		log.Printf("📆 Next domain expiration check at %s", next.Format("2006-01-02 15:04 MST"))
+	}
+	time.AfterFunc(time.Until(next), func() {
+		domainExpirationCheckOnSchedule(whoisCache, domains, notifications, snoozes, appConfig, interval)
+	})
+}
 
-			// Check the 2-month, 1-month, 2-week, 1-week, 3-day and within 1 week of expiration thresholds
-			if daysUntilExpiration <= 60 && !whoisEntry.Sent2MonthAlert && appConfig.Alerts.Send2MonthAlert {
-				if err := mailer.SendAlert(appConfig.Alerts.Admin, domain.FQDN, configuration.Alert2Months); err != nil {
-					log.Printf("❌ Failed to send 2-month alert for %s: %s", domain.FQDN, err)
-					continue
-				}
-				whoisEntry.MarkAlertSent(configuration.Alert2Months)
-			}
-			if daysUntilExpiration <= 30 && !whoisEntry.Sent1MonthAlert && appConfig.Alerts.Send1MonthAlert {
-				if err := mailer.SendAlert(appConfig.Alerts.Admin, domain.FQDN, configuration.Alert1Month); err != nil {
-					log.Printf("❌ Failed to send 1-month alert for %s: %s", domain.FQDN, err)
-					continue
-				}
-				whoisEntry.MarkAlertSent(configuration.Alert1Month)
-			}
-			if daysUntilExpiration <= 14 && !whoisEntry.Sent2WeekAlert && appConfig.Alerts.Send2WeekAlert {
-				if err := mailer.SendAlert(appConfig.Alerts.Admin, domain.FQDN, configuration.Alert2Weeks); err != nil {
-					log.Printf("❌ Failed to send 2-week alert for %s: %s", domain.FQDN, err)
-					continue
-				}
-				whoisEntry.MarkAlertSent(configuration.Alert2Weeks)
-			}
-			if daysUntilExpiration <= 7 && !whoisEntry.Sent1WeekAlert && appConfig.Alerts.Send1WeekAlert {
-				if err := mailer.SendAlert(appConfig.Alerts.Admin, domain.FQDN, configuration.Alert1Week); err != nil {
-					log.Printf("❌ Failed to send 1-week alert for %s: %s", domain.FQDN, err)
-					continue
-				}
-				whoisEntry.MarkAlertSent(configuration.Alert1Week)
+// Deliver the notifications that are due on a schedule
+func notificationQueueOnSchedule(notifications *service.NotificationService, interval time.Duration) {
+	now := time.Now()
+	if sent, failed := notifications.Process(now); sent+failed > 0 {
+		log.Printf("📤 Notification queue: %d delivered, %d failed", sent, failed)
+	}
+	notifications.Prune(now)
+
+	time.AfterFunc(interval, func() { notificationQueueOnSchedule(notifications, interval) })
+}
+
+// For every domain in the domains configuration, if alerts are turned on, check the expiration from the WHOIS cache and
+// then queue each alert that hasn't been sent yet and isn't snoozed. Daily alerts outside the send window and alerts that
+// aren't critical during the quiet hours are held back for a later check. With digests enabled, the alerts are collected into one mail (per run)
+// or left for the scheduled digest, except for critical alerts if they should still be sent right away. Queued alerts
+// are marked as sent, the notification queue takes care of delivering them.
+func sendDueAlerts(whoisCache *configuration.WhoisCacheStorage, domains *configuration.DomainConfiguration, notifications *service.NotificationService, snoozes *service.SnoozeService, appConfig configuration.ConfigurationFile) {
+	schedule := service.NewAlertSchedule(appConfig.Scheduler)
+	statuses := schedule.Hold(alertableStatuses(whoisCache, domains, snoozes, appConfig), schedule.Now())
+	immediate, digest := service.SplitDigest(statuses, appConfig.Alerts)
+	sent := false
+
+	for _, status := range immediate {
+		for _, alert := range status.Due {
+			received, err := service.NotifyAlert(notifications, appConfig, status, alert, schedule.Now())
+			if len(received) > 0 {
+				status.Entry.MarkAlertSentTo(alert, received)
+				whoisCache.SaveAlerts(status.Entry)
+				sent = true
 			}
-			if daysUntilExpiration <= 3 && !whoisEntry.Sent3DayAlert && appConfig.Alerts.Send3DayAlert {
-				if err := mailer.SendAlert(appConfig.Alerts.Admin, domain.FQDN, configuration.Alert3Days); err != nil {
-					log.Printf("❌ Failed to send 3-day alert for %s: %s", domain.FQDN, err)
-					continue
-				}
-				whoisEntry.MarkAlertSent(configuration.Alert3Days)
+			if err != nil {
+				log.Printf("❌ Failed to queue %s for %s: %s", alert, status.Domain.FQDN, err)
 			}
-			// The daily alerts within one week of expiration need to check the last alert sent date, and confirm that expiration is within 7 days
-			if daysUntilExpiration <= 7 && daysUntilExpiration > 0 && appConfig.Alerts.SendDailyExpiryAlert {
-				// Check if the last alert sent was on this day, month and year. If it was, don't send another alert.
-				if whoisEntry.LastAlertSent.Day() == time.Now().Day() && whoisEntry.LastAlertSent.Month() == time.Now().Month() && whoisEntry.LastAlertSent.Year() == time.Now().Year() {
-					log.Printf("⚠️ Daily alert for %s was already sent today", domain.FQDN)
-					continue
-				}
-
-				if err := mailer.SendAlert(appConfig.Alerts.Admin, domain.FQDN, configuration.AlertDaily); err != nil {
-					log.Printf("❌ Failed to send daily alert for %s: %s", domain.FQDN, err)
-					continue
-				}
-				whoisEntry.MarkAlertSent(configuration.AlertDaily)
 		}
 	}
+
+	if appConfig.Alerts.DigestMode == service.DigestRun && sendDigest(whoisCache, digest, notifications, appConfig) {
+		sent = true
+	} else if len(digest) > 0 && appConfig.Alerts.DigestMode != service.DigestRun {
+		log.Printf("🗃️ %d domains with due alerts are waiting for the %s digest", len(digest), appConfig.Alerts.DigestMode)
+	}
+
+	if sent {
+		whoisCache.Flush()
+	}
+}
+
+// Evaluate the domains with alerts turned on, in the scheduler timezone, logging the ones that can't be evaluated.
+// Snoozed alerts are left out.
+func alertableStatuses(whoisCache *configuration.WhoisCacheStorage, domains *configuration.DomainConfiguration, snoozes *service.SnoozeService, appConfig configuration.ConfigurationFile) []service.ExpiryStatus {
+	statuses := []service.ExpiryStatus{}
+	now := time.Now().In(service.SchedulerLocation(appConfig.Scheduler))
+	for _, status := range snoozes.Apply(service.EvaluateExpirations(domains.List(), whoisCache, appConfig.Alerts, now), now) {
+		if !status.Domain.Alerts {
+			continue
+		}
+		if status.Problem != "" {
+			log.Printf("❌ %s for %s, skipping", status.Problem, status.Domain.FQDN)
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
 }
 
-time.AfterFunc(interval, func() { domainExpirationCheckOnSchedule(whoisCache, domains, mailer, appConfig, interval) })
+// Queue the digests for the given statuses, one per set of recipients, and mark the alerts as sent in the WHOIS cache.
+// Returns true if a digest was queued.
+func sendDigest(whoisCache *configuration.WhoisCacheStorage, statuses []service.ExpiryStatus, notifications *service.NotificationService, appConfig configuration.ConfigurationFile) bool {
+	if service.NotifyDigest(notifications, appConfig, statuses, time.Now().In(service.SchedulerLocation(appConfig.Scheduler))) == 0 {
+		return false
+	}
+	for _, status := range statuses {
+		whoisCache.SaveAlerts(status.Entry)
+	}
+	return true
+}
+
+// Send the daily or weekly digest at the configured time, then schedule the next one
+func digestOnSchedule(whoisCache *configuration.WhoisCacheStorage, domains *configuration.DomainConfiguration, notifications *service.NotificationService, snoozes *service.SnoozeService, appConfig configuration.ConfigurationFile) {
+	next := service.NextDigestTime(appConfig.Alerts, service.SchedulerLocation(appConfig.Scheduler), time.Now())
+	log.Printf("📆 Next %s digest scheduled for %s", appConfig.Alerts.DigestMode, next.Format("2006-01-02 15:04 MST"))
+
+	time.AfterFunc(time.Until(next), func() {
+		_, digest := service.SplitDigest(alertableStatuses(whoisCache, domains, snoozes, appConfig), appConfig.Alerts)
+		if sendDigest(whoisCache, digest, notifications, appConfig) {
+			whoisCache.Flush()
+			notifications.Process(time.Now())
+		} else if len(digest) == 0 {
+			log.Printf("✅ No alerts due, skipping the %s digest", appConfig.Alerts.DigestMode)
+		}
+		digestOnSchedule(whoisCache, domains, notifications, snoozes, appConfig)
+	})
+}
+
+// Check the watched names for availability on a schedule, and queue the alerts of the changes
+func watchlistCheckOnSchedule(watches *service.WatchService, notifications *service.NotificationService, appConfig configuration.ConfigurationFile, interval time.Duration) {
+	if len(watches.List()) > 0 {
+		log.Println("👀 Checking the watchlist")
+		now := time.Now()
+		if service.NotifyWatchChanges(notifications, appConfig, watches.CheckAll(now), now) > 0 {
+			notifications.Process(now)
+		}
+	}
+
+	time.AfterFunc(interval, func() { watchlistCheckOnSchedule(watches, notifications, appConfig, interval) })
+}
+
+// Look for lookalikes of the monitored domains on a schedule, and queue the alerts of the new ones
+func lookalikeScanOnSchedule(lookalikes *service.LookalikeService, notifications *service.NotificationService, appConfig configuration.ConfigurationFile, interval time.Duration) {
+	log.Println("🎭 Scanning for lookalikes")
+	now := time.Now()
+	if service.NotifyLookalikes(notifications, appConfig, lookalikes.ScanAll(now), now) > 0 {
+		notifications.Process(now)
+	}
+
+	time.AfterFunc(interval, func() { lookalikeScanOnSchedule(lookalikes, notifications, appConfig, interval) })
+}
+
+// Search the certificate transparency logs on a schedule, and queue the alerts of suspicious certificates
+func certificatePollOnSchedule(certificates *service.CertificateService, notifications *service.NotificationService, appConfig configuration.ConfigurationFile, interval time.Duration) {
+	log.Println("📜 Searching the certificate transparency logs")
+	now := time.Now()
+	if service.NotifyCertificates(notifications, appConfig, certificates.PollAll(now), now) > 0 {
+		notifications.Process(now)
+	}
+
+	time.AfterFunc(interval, func() { certificatePollOnSchedule(certificates, notifications, appConfig, interval) })
+}
+
+// Check the email security records on a schedule, and queue the alerts of regressions
+func mailSecurityOnSchedule(mailSecurity *service.MailSecurityService, notifications *service.NotificationService, appConfig configuration.ConfigurationFile, interval time.Duration) {
+	log.Println("📧 Checking the mail security records")
+	now := time.Now()
+	if service.NotifyMailSecurities(notifications, appConfig, mailSecurity.CheckAll(now), now) > 0 {
+		notifications.Process(now)
+	}
+
+	time.AfterFunc(interval, func() { mailSecurityOnSchedule(mailSecurity, notifications, appConfig, interval) })
+}
+
+// Check the DNSSEC chains on a schedule, and queue the alerts of their problems
+func dnssecOnSchedule(dnssec *service.DNSSECService, notifications *service.NotificationService, appConfig configuration.ConfigurationFile, interval time.Duration) {
+	log.Println("🔐 Checking the DNSSEC chains")
+	now := time.Now()
+	if service.NotifyDNSSECs(notifications, appConfig, dnssec.CheckAll(now), now) > 0 {
+		notifications.Process(now)
+	}
+
+	time.AfterFunc(interval, func() { dnssecOnSchedule(dnssec, notifications, appConfig, interval) })
+}
+
+// Check the nameserver delegations on a schedule, and queue the alerts of the ones that degraded
+func delegationOnSchedule(delegation *service.DelegationService, notifications *service.NotificationService, appConfig configuration.ConfigurationFile, interval time.Duration) {
+	log.Println("🧭 Checking the nameserver delegations")
+	now := time.Now()
+	if service.NotifyDelegations(notifications, appConfig, delegation.CheckAll(now), now) > 0 {
+		notifications.Process(now)
+	}
+
+	time.AfterFunc(interval, func() { delegationOnSchedule(delegation, notifications, appConfig, interval) })
+}
+
+// Discover the subdomains of the monitored domains on a schedule, checking the certificates of the promoted ones
+func discoveryOnSchedule(discovery *service.DiscoveryService, interval time.Duration) {
+	log.Println("🗂 Discovering subdomains")
+	discovery.DiscoverAll(time.Now())
+
+	time.AfterFunc(interval, func() { discoveryOnSchedule(discovery, interval) })
 }
 
 // Refresh the whois cache on a schedule, and flush the cache. This runs every 6 hours.
-func whoisRefreshOnSchedule(whoisCache configuration.WhoisCacheStorage, domains configuration.DomainConfiguration, interval time.Duration) {
+func whoisRefreshOnSchedule(whoisCache *configuration.WhoisCacheStorage, domains *configuration.DomainConfiguration, interval time.Duration) {
 	log.Println("🔄 Refreshing WHOIS cache")
 	whoisCache.RefreshWithDomains(domains)
 	whoisCache.Flush()
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+	"github.com/nwesterhausen/domain-monitor/service"
+)
+
+// Check domain expirations as a Nagios/Icinga plugin. Prints the plugin output with performance data and returns the
+// plugin exit code (0 OK, 1 WARNING, 2 CRITICAL, 3 UNKNOWN). Every error, including an unusable data directory or
+// data file, is reported as UNKNOWN. The plugin never writes to the data directory.
+//
+// Usage: nagios [-w DAYS] [-c DAYS] [-live] [-max-age DURATION] [fqdn...]
+func runNagios(dir configuration.ConfigDirectory, args []string) int {
+	defaultWarning, _ := service.ThresholdDays(configuration.Alert1Month)
+	defaultCritical, _ := service.ThresholdDays(configuration.Alert1Week)
+
+	flags := flag.NewFlagSet("nagios", flag.ContinueOnError)
+	warning := flags.Float64("w", defaultWarning, "Warning when a domain expires within this many days")
+	critical := flags.Float64("c", defaultCritical, "Critical when a domain expires within this many days")
+	live := flags.Bool("live", false, "Look up WHOIS/RDAP now instead of reading the cache (the cache is not updated)")
+	maxAge := flags.Duration("max-age", 0, "Unknown when the cached WHOIS entry is older than this (0 to disable)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: domain-monitor [-data-dir DIR] nagios [-w DAYS] [-c DAYS] [-live] [-max-age DURATION] [fqdn...]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return int(service.CheckUnknown)
+	}
+	if *critical > *warning {
+		return nagiosUnknown("critical threshold (%g) must not be above the warning threshold (%g)", *critical, *warning)
+	}
+
+	if info, err := os.Stat(dir.DataDir); err != nil {
+		return nagiosUnknown("data directory is not usable: %s", err)
+	} else if !info.IsDir() {
+		return nagiosUnknown("data directory %s is not a directory", dir.DataDir)
+	}
+	if err := dir.CheckVersions(); err != nil {
+		return nagiosUnknown("%s", err)
+	}
+
+	// Without arguments, check every monitored domain
+	fqdns := flags.Args()
+	if len(fqdns) == 0 {
+		domains, err := dir.LoadDomains()
+		if err != nil {
+			return nagiosUnknown("%s", err)
+		}
+		for _, domain := range domains.List() {
+			if domain.Monitored() {
+				fqdns = append(fqdns, domain.FQDN)
+			}
+		}
+		if len(fqdns) == 0 {
+			return nagiosUnknown("no domains are monitored")
+		}
+	}
+
+	cache, err := dir.LoadWhoisCache()
+	if err != nil {
+		return nagiosUnknown("%s", err)
+	}
+	config, err := dir.LoadAppConfig()
+	if err != nil {
+		return nagiosUnknown("%s", err)
+	}
+	// count the days in the scheduler timezone, like the alerts
+	now := time.Now().In(service.SchedulerLocation(config.Config.Scheduler))
+	results := make([]nagiosResult, 0, len(fqdns))
+	for _, fqdn := range fqdns {
+		fqdn = strings.ToLower(fqdn)
+		var entry *configuration.WhoisCache
+		if *live {
+			entry = &configuration.WhoisCache{FQDN: fqdn}
+			entry.Refresh()
+			if entry.LastUpdated.IsZero() {
+				entry = nil
+			}
+		} else {
+			entry = cache.Get(fqdn)
+		}
+
+		status := service.EvaluateExpiration(configuration.Domain{FQDN: fqdn}, entry, configuration.AlertsConfiguration{}, now)
+		if entry != nil && !*live && *maxAge > 0 && now.Sub(entry.LastUpdated) > *maxAge {
+			status.Problem = fmt.Sprintf("cached WHOIS entry is older than %s", *maxAge)
+		}
+		if entry == nil && *live {
+			status.Problem = "WHOIS lookup failed"
+		}
+		results = append(results, nagiosResult{status: status, state: service.CheckExpiry(status, *warning, *critical)})
+	}
+
+	return printNagios(results, *warning, *critical)
+}
+
+// nagiosUnknown prints an error as the UNKNOWN status line of the plugin and returns its exit code
+func nagiosUnknown(format string, args ...interface{}) int {
+	fmt.Printf("DOMAIN UNKNOWN - "+format+"\n", args...)
+	return int(service.CheckUnknown)
+}
+
+type nagiosResult struct {
+	status service.ExpiryStatus
+	state  service.CheckState
+}
+
+func (r nagiosResult) message() string {
+	if r.status.Problem != "" {
+		return fmt.Sprintf("%s: %s", r.status.Domain.FQDN, r.status.Problem)
+	}
+	days := int(r.status.DaysLeft)
+	if r.status.DaysLeft <= 0 {
+		return fmt.Sprintf("%s expired on %s", r.status.Domain.FQDN, r.status.Expiration.Format("2006-01-02"))
+	}
+	return fmt.Sprintf("%s expires in %d days (%s)", r.status.Domain.FQDN, days, r.status.Expiration.Format("2006-01-02"))
+}
+
+// Print the plugin output: a status line with the performance data, followed by one line per domain when several
+// domains were checked. Returns the exit code of the worst state.
+func printNagios(results []nagiosResult, warning float64, critical float64) int {
+	overall := service.CheckOK
+	counts := map[service.CheckState]int{}
+	perfdata := []string{}
+	for _, r := range results {
+		counts[r.state]++
+		if r.state.Severity() > overall.Severity() {
+			overall = r.state
+		}
+		if r.status.Problem == "" {
+			// The thresholds are ranges ("N:"), as a lower number of days is worse
+			perfdata = append(perfdata, fmt.Sprintf("'%s'=%d;%g:;%g:;0;", r.status.Domain.FQDN, int(r.status.DaysLeft), warning, critical))
+		}
+	}
+
+	var summary string
+	if len(results) == 1 {
+		summary = results[0].message()
+	} else {
+		parts := []string{}
+		for _, state := range []service.CheckState{service.CheckCritical, service.CheckWarning, service.CheckUnknown, service.CheckOK} {
+			if counts[state] > 0 {
+				parts = append(parts, fmt.Sprintf("%d %s", counts[state], strings.ToLower(state.String())))
+			}
+		}
+		summary = fmt.Sprintf("%d domains: %s", len(results), strings.Join(parts, ", "))
+		// Name the domains that need attention right in the status line
+		for _, r := range results {
+			if r.state == overall && overall != service.CheckOK {
+				summary += "; " + r.message()
+			}
+		}
+	}
+
+	line := fmt.Sprintf("DOMAIN %s - %s", overall, summary)
+	if len(perfdata) > 0 {
+		line += " | " + strings.Join(perfdata, " ")
+	}
+	fmt.Println(line)
+	if len(results) > 1 {
+		for _, r := range results {
+			fmt.Printf("[%s] %s\n", r.state, r.message())
+		}
+	}
+	return int(overall)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+	"github.com/nwesterhausen/domain-monitor/service"
+)
+
+// Restore a backup archive into the data directory. The server must not be running while doing this.
+//
+// Usage: restore [-dry-run] <archive.tar.gz>
+func runRestore(dir configuration.ConfigDirectory, args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "Only validate the archive and show what would change")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: domain-monitor [-data-dir DIR] restore [-dry-run] <archive.tar.gz>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌", err)
+		return 1
+	}
+	defer file.Close()
+
+	archive, err := service.ReadArchive(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌ Invalid backup:", err)
+		return 1
+	}
+	bs := service.NewBackupService(dir)
+	summary, err := bs.Summarize(archive)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌ Unable to compare backup:", err)
+		return 1
+	}
+	printRestoreSummary(summary)
+
+	if *dryRun {
+		fmt.Println("Dry run, nothing was changed.")
+		return 0
+	}
+	if err := bs.Restore(archive); err != nil {
+		fmt.Fprintln(os.Stderr, "❌ Restore failed:", err)
+		return 1
+	}
+	// The backup may be from an older version, bring it up to the current format right away
+	if err := dir.Migrate(); err != nil {
+		fmt.Fprintln(os.Stderr, "❌ Backup restored, but migrating it failed:", err)
+		return 1
+	}
+	fmt.Println("✅ Backup restored.")
+	return 0
+}
+
+func printRestoreSummary(summary service.RestoreSummary) {
+	fmt.Printf("Backup created %s (secrets included: %t)\n", summary.CreatedAt.Format("2006-01-02 15:04:05 MST"), summary.IncludesSecrets)
+	names := make([]string, 0, len(summary.Entries))
+	for name := range summary.Entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		counts := summary.Entries[name]
+		fmt.Printf("  %-20s %d entries now, %d in backup\n", name, counts[0], counts[1])
+	}
+	printList := func(label string, items []string) {
+		if len(items) > 0 {
+			fmt.Printf("  %s: %s\n", label, strings.Join(items, ", "))
+		}
+	}
+	printList("Files changed", summary.FilesChanged)
+	printList("Domains added", summary.DomainsAdded)
+	printList("Domains removed", summary.DomainsRemoved)
+	printList("Domains changed", summary.DomainsChanged)
+	printList("Configuration changed", summary.ConfigChanged)
+}
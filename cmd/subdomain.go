@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+	"github.com/nwesterhausen/domain-monitor/service"
+)
+
+// Keep the inventory of the subdomains of the monitored domains.
+//
+// Usage: subdomain list|discover|import|promote|demote ...
+func runSubdomain(dir configuration.ConfigDirectory, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: domain-monitor [-data-dir DIR] subdomain list|discover|import|promote|demote ...")
+		return 2
+	}
+	config := dir.ReadAppConfig().Config
+	discovery := service.NewDiscoveryService(dir.ReadSubdomains(), dir.ReadCertificates(), dir.ReadDomains(), config)
+
+	switch args[0] {
+	case "list", "ls":
+		return runSubdomainList(discovery, args[1:])
+	case "discover":
+		return runSubdomainDiscover(discovery, args[1:])
+	case "import":
+		return runSubdomainImport(discovery, args[1:])
+	case "promote", "demote":
+		if len(args) != 2 {
+			fmt.Fprintf(os.Stderr, "Usage: domain-monitor [-data-dir DIR] subdomain %s <host>\n", args[0])
+			return 2
+		}
+		subdomain, err := discovery.Promote(args[1], args[0] == "promote", time.Now())
+		if err != nil {
+			return fail("Unable to %s %s: %s", args[0], args[1], err)
+		}
+		printSubdomains([]configuration.Subdomain{subdomain})
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "Unknown subdomain command %q\n", args[0])
+	return 2
+}
+
+func runSubdomainList(discovery *service.DiscoveryService, args []string) int {
+	flags := flag.NewFlagSet("subdomain list", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "Print the subdomains as JSON")
+	domain := flags.String("domain", "", "Only list the subdomains of this domain")
+	flags.Parse(args)
+
+	list := discovery.List(*domain)
+	if *asJSON {
+		return printJSON(list)
+	}
+	printSubdomains(list)
+	return 0
+}
+
+// Discover the subdomains of the monitored domains (or only the given ones) now.
+//
+// Usage: subdomain discover [fqdn...]
+func runSubdomainDiscover(discovery *service.DiscoveryService, args []string) int {
+	flags := flag.NewFlagSet("subdomain discover", flag.ExitOnError)
+	flags.Parse(args)
+
+	now := time.Now()
+	results := []service.DiscoveryResult{}
+	if flags.NArg() > 0 {
+		for _, fqdn := range flags.Args() {
+			result, err := discovery.Discover(fqdn, now)
+			if err != nil {
+				return fail("Unable to discover %s: %s", fqdn, err)
+			}
+			results = append(results, result)
+		}
+	} else {
+		results = discovery.DiscoverAll(now)
+	}
+
+	for _, result := range results {
+		sources := []string{}
+		for source, count := range result.Found {
+			sources = append(sources, fmt.Sprintf("%d from %s", count, source))
+		}
+		sort.Strings(sources)
+		fmt.Printf("🗂 %s: %s, %d new", result.Domain.FQDN, strings.Join(sources, ", "), len(result.New))
+		if result.Wildcard {
+			fmt.Print(" (wildcard DNS, wordlist skipped)")
+		}
+		fmt.Println()
+		for _, host := range result.New {
+			fmt.Printf("   + %s\n", host)
+		}
+	}
+	return 0
+}
+
+// Import the hosts of a zone file into the inventory of a domain.
+//
+// Usage: subdomain import -domain FQDN <zonefile|->
+func runSubdomainImport(discovery *service.DiscoveryService, args []string) int {
+	flags := flag.NewFlagSet("subdomain import", flag.ExitOnError)
+	domain := flags.String("domain", "", "The monitored domain the zone file belongs to")
+	flags.Parse(args)
+	if *domain == "" || flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: domain-monitor [-data-dir DIR] subdomain import -domain FQDN <zonefile|->")
+		return 2
+	}
+
+	var zone io.Reader = os.Stdin
+	if flags.Arg(0) != "-" {
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return fail("Unable to open the zone file: %s", err)
+		}
+		defer file.Close()
+		zone = file
+	}
+	added, err := discovery.Import(*domain, zone, time.Now())
+	if err != nil {
+		return fail("Unable to import the zone file: %s", err)
+	}
+	fmt.Printf("🗂 %d new hosts\n", len(added))
+	for _, host := range added {
+		fmt.Printf("   + %s\n", host)
+	}
+	return 0
+}
+
+func printSubdomains(list []configuration.Subdomain) {
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tOF\tSOURCES\tFIRST SEEN\tLAST SEEN\tTLS")
+	for _, subdomain := range list {
+		tls := "-"
+		if subdomain.TLS {
+			tls = "not checked"
+		}
+		if check := subdomain.TLSCheck; subdomain.TLS && check != nil {
+			switch {
+			case check.NotAfter.IsZero():
+				tls = "unreachable: " + check.Error
+			case check.Error != "":
+				tls = "invalid: " + check.Error
+			default:
+				tls = fmt.Sprintf("valid until %s (%d days)", check.NotAfter.Format("2006-01-02"), check.DaysLeft(now))
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", subdomain.FQDN, subdomain.Of, strings.Join(subdomain.Sources, ","),
+			subdomain.FirstSeen.Format("2006-01-02"), subdomain.LastSeen.Format("2006-01-02"), tls)
+	}
+	w.Flush()
+}
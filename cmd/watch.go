@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+	"github.com/nwesterhausen/domain-monitor/service"
+)
+
+// Manage the names watched for availability.
+//
+// Usage: watch add|list|rm|check ...
+func runWatch(dir configuration.ConfigDirectory, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: domain-monitor [-data-dir DIR] watch add|list|rm|check ...")
+		return 2
+	}
+	watches := service.NewWatchService(dir.ReadWatchlist(), dir.ReadDomains())
+
+	switch args[0] {
+	case "add":
+		return runWatchAdd(watches, args[1:])
+	case "list", "ls":
+		return runWatchList(watches, args[1:])
+	case "rm", "remove":
+		return runWatchRemove(watches, args[1:])
+	case "check":
+		return runWatchCheck(dir, watches, args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "Unknown watch command %q\n", args[0])
+	return 2
+}
+
+func runWatchAdd(watches *service.WatchService, args []string) int {
+	flags := flag.NewFlagSet("watch add", flag.ExitOnError)
+	notes := flags.String("notes", "", "Why the name is watched")
+	owners := flags.String("owners", "", "Comma separated addresses or contact groups that receive the alerts instead of the default recipients")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: domain-monitor [-data-dir DIR] watch add [-notes NOTES] [-owners OWNERS] <fqdn>")
+		return 2
+	}
+
+	watch, err := watches.Add(configuration.WatchedDomain{FQDN: flags.Arg(0), Notes: *notes, Owners: []string{*owners}})
+	if err != nil {
+		return fail("Unable to watch %s: %s", watch.FQDN, err)
+	}
+	fmt.Printf("✅ Watching %s, run `watch check` to check it now\n", watch.FQDN)
+	return 0
+}
+
+func runWatchList(watches *service.WatchService, args []string) int {
+	flags := flag.NewFlagSet("watch list", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "Print the watched names as JSON")
+	flags.Parse(args)
+
+	list := watches.List()
+	if *asJSON {
+		return printJSON(list)
+	}
+	printWatches(list)
+	return 0
+}
+
+func runWatchRemove(watches *service.WatchService, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: domain-monitor [-data-dir DIR] watch rm <fqdn>")
+		return 2
+	}
+	if err := watches.Remove(args[0]); err != nil {
+		return fail("Unable to remove %s: %s", args[0], err)
+	}
+	fmt.Printf("✅ Stopped watching %s\n", strings.ToLower(strings.TrimSpace(args[0])))
+	return 0
+}
+
+// Check the watched names (or only the given ones) now and print their availability. With -send the alerts of the
+// changes are sent, like the scheduler does.
+//
+// Usage: watch check [-send] [fqdn...]
+func runWatchCheck(dir configuration.ConfigDirectory, watches *service.WatchService, args []string) int {
+	flags := flag.NewFlagSet("watch check", flag.ExitOnError)
+	send := flags.Bool("send", false, "Send the alerts of the changes")
+	flags.Parse(args)
+
+	now := time.Now()
+	changes := []service.WatchChange{}
+	if flags.NArg() > 0 {
+		for _, fqdn := range flags.Args() {
+			change, err := watches.Check(fqdn, now)
+			if err != nil {
+				return fail("Unable to check %s: %s", fqdn, err)
+			}
+			changes = append(changes, change)
+		}
+	} else {
+		changes = watches.CheckAll(now)
+	}
+
+	checked := []configuration.WatchedDomain{}
+	for _, change := range changes {
+		checked = append(checked, change.Domain)
+		if change.Changed() {
+			fmt.Printf("👀 %s changed from %s to %s\n", change.Domain.FQDN, change.Previous, change.Domain.Availability)
+		}
+	}
+	printWatches(checked)
+
+	if !*send {
+		return 0
+	}
+	config := dir.ReadAppConfig().Config
+	if !config.Alerts.SendAlerts {
+		return fail("Alerts are disabled (alerts.sendAlerts = false), nothing was sent")
+	}
+	notifications := newAlertNotifications(config, dir, dir.ReadDomains())
+	if !notifications.Enabled() {
+		return fail("No mailer or notifier webhook configured, nothing was sent")
+	}
+	service.NotifyWatchChanges(notifications, config, changes, now)
+
+	// Deliver everything that is due now, failed notifications stay queued for the server to retry
+	sent, failed := notifications.Process(time.Now())
+	if failed > 0 {
+		return fail("%d notifications delivered, %d failed and are queued for retry", sent, failed)
+	}
+	fmt.Fprintf(os.Stderr, "📤 %d notifications delivered\n", sent)
+	return 0
+}
+
+func printWatches(list []configuration.WatchedDomain) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FQDN\tAVAILABILITY\tREGISTRAR\tEXPIRES\tLAST CHECKED\tNOTES")
+	for _, watch := range list {
+		expires, checked := "-", "never"
+		if watch.Expiration != nil {
+			expires = watch.Expiration.Format("2006-01-02")
+		}
+		if watch.LastChecked != nil {
+			checked = watch.LastChecked.Format("2006-01-02 15:04")
+		}
+		if watch.LastError != "" {
+			checked += " (failed)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", watch.FQDN, watch.Availability, watch.Registrar, expires, checked, watch.Notes)
+	}
+	w.Flush()
+}
@@ -0,0 +1,204 @@
+package configuration
+
+import (
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Delivery status of an alert record
+const (
+	DeliverySent = "sent"
+	// Failed, will be retried
+	DeliveryRetrying = "retrying"
+	// Gave up after the maximum number of attempts
+	DeliveryFailed = "failed"
+)
+
+// Delivery channels of an alert record
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+)
+
+// AlertRecord is a single delivery of an alert (or digest) over one channel
+type AlertRecord struct {
+	// Unique identifier of the record, the ID of the queued notification it was delivered from
+	ID string `yaml:"id" json:"id"`
+	// Domain the alert is about, empty for digests
+	FQDN string `yaml:"fqdn,omitempty" json:"fqdn,omitempty"`
+	// Domains included in a digest
+	Domains []string `yaml:"domains,omitempty" json:"domains,omitempty"`
+	// Alert type, e.g. "1 week alert", or "digest"
+	Alert string `yaml:"alert" json:"alert"`
+	// ChannelEmail or ChannelWebhook
+	Channel string `yaml:"channel" json:"channel"`
+	// Email addresses, or the webhook host
+	Recipients []string `yaml:"recipients" json:"recipients,omitempty"`
+	// Rendered subject of the message
+	Subject string `yaml:"subject" json:"subject"`
+	// DeliverySent or DeliveryFailed
+	Status string `yaml:"status" json:"status"`
+	// Error of the last failed attempt
+	Error string `yaml:"error,omitempty" json:"error,omitempty"`
+	// Number of delivery attempts
+	Attempts int `yaml:"attempts" json:"attempts"`
+	// When the alert was first attempted
+	CreatedAt time.Time `yaml:"createdAt" json:"createdAt"`
+	// When the last attempt was made
+	UpdatedAt time.Time `yaml:"updatedAt" json:"updatedAt"`
+}
+
+type AlertLedgerFile struct {
+	// Version of the file format, used for migrations
+	Version int `yaml:"version" json:"version"`
+	// Every alert delivery, oldest first
+	Records []AlertRecord `yaml:"records" json:"records"`
+}
+
+// AlertLedgerFilter selects alert records, empty fields match everything
+type AlertLedgerFilter struct {
+	FQDN    string `query:"fqdn"`
+	Alert   string `query:"alert"`
+	Channel string `query:"channel"`
+	Status  string `query:"status"`
+	// Only records created at or after Since
+	Since time.Time `query:"-"`
+	// Maximum number of records, newest first (0 for all)
+	Limit int `query:"limit"`
+}
+
+// AlertLedgerStorage is the append-only log of alert deliveries. It is shared by the schedulers and the web handlers,
+// so it is always used as a pointer and guards its records with a lock.
+type AlertLedgerStorage struct {
+	mu sync.Mutex
+	// The ledger file contents
+	FileContents AlertLedgerFile
+	// The path to the ledger file
+	Filepath string
+}
+
+func DefaultAlertLedgerStorage(path string) *AlertLedgerStorage {
+	return &AlertLedgerStorage{
+		FileContents: AlertLedgerFile{Version: AlertLedgerVersion, Records: []AlertRecord{}},
+		Filepath:     path,
+	}
+}
+
+// Record adds a record to the ledger and writes it to disk right away. The ID and timestamps are set if empty.
+func (l *AlertLedgerStorage) Record(record AlertRecord) AlertRecord {
+	if l == nil {
+		return record
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now()
+	}
+	if record.UpdatedAt.IsZero() {
+		record.UpdatedAt = record.CreatedAt
+	}
+	if record.ID == "" {
+		record.ID = newID(record.CreatedAt.UTC().Format("20060102T150405.000000"))
+	}
+	l.FileContents.Records = append(l.FileContents.Records, record)
+	l.flush()
+	return record
+}
+
+// Save adds a record, or replaces the record with the same ID, and writes the ledger to disk
+func (l *AlertLedgerStorage) Save(record AlertRecord) AlertRecord {
+	if l == nil || record.ID == "" {
+		return l.Record(record)
+	}
+	l.mu.Lock()
+	for i := range l.FileContents.Records {
+		if l.FileContents.Records[i].ID == record.ID {
+			if record.CreatedAt.IsZero() {
+				record.CreatedAt = l.FileContents.Records[i].CreatedAt
+			}
+			if record.UpdatedAt.IsZero() {
+				record.UpdatedAt = time.Now()
+			}
+			l.FileContents.Records[i] = record
+			l.flush()
+			l.mu.Unlock()
+			return record
+		}
+	}
+	l.mu.Unlock()
+	return l.Record(record)
+}
+
+// Find returns the matching records, newest first
+func (l *AlertLedgerStorage) Find(filter AlertLedgerFilter) []AlertRecord {
+	records := []AlertRecord{}
+	if l == nil {
+		return records
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i := len(l.FileContents.Records) - 1; i >= 0; i-- {
+		record := l.FileContents.Records[i]
+		if filter.FQDN != "" && record.FQDN != filter.FQDN && !containsFold(record.Domains, filter.FQDN) {
+			continue
+		}
+		if filter.Alert != "" && !strings.EqualFold(record.Alert, filter.Alert) {
+			continue
+		}
+		if filter.Channel != "" && record.Channel != filter.Channel {
+			continue
+		}
+		if filter.Status != "" && record.Status != filter.Status {
+			continue
+		}
+		if !filter.Since.IsZero() && record.CreatedAt.Before(filter.Since) {
+			continue
+		}
+		records = append(records, record)
+		if filter.Limit > 0 && len(records) == filter.Limit {
+			break
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].CreatedAt.After(records[j].CreatedAt) })
+	return records
+}
+
+// Flush the ledger to its storage
+func (l *AlertLedgerStorage) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.flush()
+}
+
+func (l *AlertLedgerStorage) flush() {
+	// Always write the current file format version
+	l.FileContents.Version = AlertLedgerVersion
+
+	data, err := MarshalYAML(l.FileContents)
+	if err != nil {
+		log.Printf("❌ Error while marshalling the alert ledger: %v", err)
+		return
+	}
+
+	if err := writeFileAtomic(l.Filepath, data); err != nil {
+		log.Printf("❌ Error while writing alert ledger file: %v", err)
+		return
+	}
+
+	log.Printf("💾 Flushed alert ledger to %s", filepath.Base(l.Filepath))
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
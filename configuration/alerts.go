@@ -9,8 +9,20 @@ const (
 	Alert1Week
 	Alert3Days
 	AlertDaily
+	// Registry status alerts, sent once when a domain enters the status
+	AlertHold
+	AlertRedemption
+	AlertPendingDelete
 )
 
+// AllAlerts lists every alert type, the expiry alerts first
+var AllAlerts = []Alert{Alert2Months, Alert1Month, Alert2Weeks, Alert1Week, Alert3Days, AlertDaily, AlertHold, AlertRedemption, AlertPendingDelete}
+
 func (a Alert) String() string {
-	return [...]string{"2 month alert", "1 month alert", "2 week alert", "1 week alert", "3 day alert", "daily alert"}[a]
+	return [...]string{"2 month alert", "1 month alert", "2 week alert", "1 week alert", "3 day alert", "daily alert", "hold alert", "redemption alert", "pending delete alert"}[a]
+}
+
+// IsStatusAlert reports if the alert is about the registry status instead of the expiration date
+func (a Alert) IsStatusAlert() bool {
+	return a == AlertHold || a == AlertRedemption || a == AlertPendingDelete
 }
@@ -1,133 +1,88 @@
 package configuration
 
 import (
-	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"log"
-	"os"
-	"regexp"
-	"strings"
-
-	"gopkg.in/yaml.v3"
+	"path/filepath"
 )
 
-// quoteYAMLStrings ensures all string values in YAML are quoted for security
-func quoteYAMLStrings(data []byte) []byte {
-	lines := strings.Split(string(data), "\n")
-	var result []string
-	
-	// Regex to match key: value patterns where value is an unquoted string
-	// Matches: "key: value" where value doesn't start with quotes and isn't a boolean/number/list
-	valuePattern := regexp.MustCompile(`^(\s*)([^:]+):\s*(.+?)\s*$`)
-	boolOrNumPattern := regexp.MustCompile(`^(true|false|\d+|null)$`)
-	
-	for _, line := range lines {
-		// Skip empty lines and comments
-		trimmed := strings.TrimSpace(line)
-		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
-			result = append(result, line)
-			continue
-		}
-		
-		// Skip list items (lines starting with -)
-		if strings.HasPrefix(trimmed, "- ") {
-			result = append(result, line)
-			continue
-		}
-		
-		// Match key: value pattern
-		if matches := valuePattern.FindStringSubmatch(line); matches != nil {
-			indent := matches[1]
-			key := strings.TrimSpace(matches[2])
-			value := strings.TrimSpace(matches[3])
-			
-			// Skip if value is already quoted
-			if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, `'`) {
-				result = append(result, line)
-				continue
-			}
-			
-			// Skip if value is a boolean, number, or null
-			if boolOrNumPattern.MatchString(value) {
-				result = append(result, line)
-				continue
-			}
-			
-			// Skip if value starts with [ or { (list or map)
-			if strings.HasPrefix(value, "[") || strings.HasPrefix(value, "{") {
-				result = append(result, line)
-				continue
-			}
-			
-			// Skip renewalPrice field (should remain as numeric/decimal)
-			if key == "renewalPrice" {
-				result = append(result, line)
-				continue
-			}
-			
-			// Quote the value and escape internal quotes
-			escapedValue := strings.ReplaceAll(value, `"`, `\"`)
-			escapedValue = strings.ReplaceAll(escapedValue, "\n", "\\n")
-			result = append(result, indent+key+`: "`+escapedValue+`"`)
-		} else {
-			result = append(result, line)
-		}
-	}
-	
-	return []byte(strings.Join(result, "\n"))
-}
-
 type AppConfiguration struct {
 	// The port the application listens on
-	Port int `yaml:"port" json:"port" default:"3124"`
+	Port int `yaml:"port" json:"port" default:"3124" validate:"min=1,max=65535" description:"The port the application listens on"`
 	// Allow automtic WHOIS refresh
-	AutomateWHOISRefresh bool `yaml:"automateWHOISRefresh" json:"automateWHOISRefresh" default:"true"`
+	AutomateWHOISRefresh bool `yaml:"automateWHOISRefresh" json:"automateWHOISRefresh" default:"true" description:"Allow automatic WHOIS refresh"`
 	// Show the configuration in the web interface. This is a security risk and should be disabled in production
-	ShowConfiguration bool `yaml:"showConfiguration" json:"showConfiguration" default:"false"`
+	ShowConfiguration bool `yaml:"showConfiguration" json:"showConfiguration" default:"false" description:"Show the configuration in the web interface"`
+	// Public URL of the web interface, used for links in e-mails (optional)
+	BaseURL string `yaml:"baseUrl" json:"baseUrl" validate:"url" description:"Public URL of the web interface, used for links in e-mails"`
+	// Key for signing the acknowledge and snooze links in alert e-mails, generated when empty
+	LinkSecret string `yaml:"linkSecret" json:"linkSecret" secret:"true" sensitive:"true" description:"Key for signing the acknowledge and snooze links in alert e-mails (generated when empty)"`
+	// Token required to subscribe to the calendar feed, empty for an open feed
+	CalendarToken string `yaml:"calendarToken" json:"calendarToken" secret:"true" sensitive:"true" description:"Token required to subscribe to /calendar.ics (?token=...), empty for an open feed"`
+	// Reminders of the calendar events, in days before expiry. Empty to match the enabled alerts.
+	CalendarAlarms []string `yaml:"calendarAlarms" json:"calendarAlarms" validate:"days" description:"Reminders of the calendar events in days before expiry, empty to match the enabled alerts"`
 }
 
 type AlertsConfiguration struct {
 	// The admin email address for receiving alerts
-	Admin string `yaml:"admin" json:"admin"`
+	Admin string `yaml:"admin" json:"admin" validate:"email" sensitive:"true" description:"The admin email address for receiving alerts"`
 	// Send alerts for monitored domains
-	SendAlerts bool `yaml:"sendAlerts" json:"sendAlerts"`
+	SendAlerts bool `yaml:"sendAlerts" json:"sendAlerts" description:"Send alerts for monitored domains"`
 	// Send 2-month alert for domain expiry date
-	Send2MonthAlert bool `yaml:"send2MonthAlert" json:"send2MonthAlert"`
+	Send2MonthAlert bool `yaml:"send2MonthAlert" json:"send2MonthAlert" description:"Send 2-month alert for domain expiry date"`
 	// Send 1-month alert for domain expiry date
-	Send1MonthAlert bool `yaml:"send1MonthAlert" json:"send1MonthAlert" default:"true"`
+	Send1MonthAlert bool `yaml:"send1MonthAlert" json:"send1MonthAlert" default:"true" description:"Send 1-month alert for domain expiry date"`
 	// Send 2-week alert for domain expiry date
-	Send2WeekAlert bool `yaml:"send2WeekAlert" json:"send2WeekAlert"`
+	Send2WeekAlert bool `yaml:"send2WeekAlert" json:"send2WeekAlert" description:"Send 2-week alert for domain expiry date"`
 	// Send 1-week alert for domain expiry date
-	Send1WeekAlert bool `yaml:"send1WeekAlert" json:"send1WeekAlert"`
+	Send1WeekAlert bool `yaml:"send1WeekAlert" json:"send1WeekAlert" description:"Send 1-week alert for domain expiry date"`
 	// Send 3-day alert for domain expiry date
-	Send3DayAlert bool `yaml:"send3DayAlert" json:"send3DayAlert" default:"true"`
+	Send3DayAlert bool `yaml:"send3DayAlert" json:"send3DayAlert" default:"true" description:"Send 3-day alert for domain expiry date"`
 	// Send daily alerts within 7 days of domain expiry
-	SendDailyExpiryAlert bool `yaml:"sendDailyExpiryAlert" json:"sendDailyExpiryAlert"`
+	SendDailyExpiryAlert bool `yaml:"sendDailyExpiryAlert" json:"sendDailyExpiryAlert" description:"Send daily alerts within 7 days of domain expiry"`
+	// Additional recipients for alerts of domains without owners (email addresses or contact group names)
+	Recipients []string `yaml:"recipients" json:"recipients" validate:"recipient" sensitive:"true" description:"Additional recipients (email addresses or contact group names) for domains without owners"`
+	// Include the escalation recipients once a domain expires within this many days (0 to disable)
+	EscalationDays int `yaml:"escalationDays" json:"escalationDays" validate:"min=0" description:"Include the escalation recipients once a domain expires within this many days (0 to disable)"`
+	// Extra recipients for alerts below the escalation threshold (email addresses or contact group names)
+	EscalationRecipients []string `yaml:"escalationRecipients" json:"escalationRecipients" validate:"recipient" sensitive:"true" description:"Extra recipients (email addresses or contact group names) below the escalation threshold"`
+	// Collect alerts into digest mails: "off" (one mail per alert), "run" (one mail per check), "daily" or "weekly"
+	DigestMode string `yaml:"digestMode" json:"digestMode" validate:"oneof=off|run|daily|weekly" description:"Collect alerts into one digest mail per check (run), per day (daily) or per week (weekly)"`
+	// Day of the week the weekly digest is sent
+	DigestWeekday string `yaml:"digestWeekday" json:"digestWeekday" validate:"oneof=monday|tuesday|wednesday|thursday|friday|saturday|sunday" description:"Day of the week the weekly digest is sent"`
+	// Time of day (HH:MM, in the scheduler timezone) the daily or weekly digest is sent
+	DigestTime string `yaml:"digestTime" json:"digestTime" validate:"clock" description:"Time of day (HH:MM) the daily or weekly digest is sent"`
+	// Still send critical alerts (1 week and less) right away when digests are enabled
+	DigestImmediateCritical bool `yaml:"digestImmediateCritical" json:"digestImmediateCritical" description:"Send critical alerts (1 week or less) immediately instead of waiting for the digest"`
+	// Alert once when a domain enters a registry hold, the redemption period or pending delete
+	SendStatusAlerts bool `yaml:"sendStatusAlerts" json:"sendStatusAlerts" default:"true" description:"Alert when a domain enters a registry hold, the redemption period or pending delete"`
+	// Locks every domain should have, a missing one is flagged on the dashboard: "transfer", "delete" or "update"
+	ExpectedLocks []string `yaml:"expectedLocks" json:"expectedLocks" validate:"oneof=transfer|delete|update" description:"Locks every domain should have (transfer, delete, update), a missing one is flagged on the dashboard"`
 }
 
 type SMTPConfiguration struct {
 	// SMTP host
-	Host string `yaml:"host" json:"host"`
+	Host string `yaml:"host" json:"host" description:"SMTP host"`
 	// SMTP port
-	Port int `yaml:"port" json:"port"`
-	// Use secure connection (TLS) - deprecated, use EncryptionType instead
-	Secure bool `yaml:"secure" json:"secure"`
-	// Encryption type: "none", "ssl", "starttls-mandatory", "starttls-opportunistic"
-	EncryptionType string `yaml:"encryptionType" json:"encryptionType"`
+	Port int `yaml:"port" json:"port" validate:"min=0,max=65535" description:"SMTP port"`
+	// Encryption type: "none", "ssl" or "starttls"
+	EncryptionType string `yaml:"encryptionType" json:"encryptionType" validate:"oneof=none|ssl|starttls" description:"Encryption type for the SMTP connection"`
 	// SMTP user name
-	AuthUser string `yaml:"authUser" json:"authUser"`
+	AuthUser string `yaml:"authUser" json:"authUser" description:"SMTP user name"`
 	// SMTP user password
-	AuthPass string `yaml:"authPass" json:"authPass"`
+	AuthPass string `yaml:"authPass" json:"authPass" secret:"true" description:"SMTP user password"`
 	// Enable SMTP
-	Enabled bool `yaml:"enabled" json:"enabled"`
+	Enabled bool `yaml:"enabled" json:"enabled" description:"Enable SMTP"`
 	// Name of the sender
-	FromName string `yaml:"fromName" json:"fromName"`
+	FromName string `yaml:"fromName" json:"fromName" description:"Name of the sender"`
 	// Email address of the sender
-	FromAddress string `yaml:"fromAddress" json:"fromAddress"`
+	FromAddress string `yaml:"fromAddress" json:"fromAddress" validate:"email" description:"Email address of the sender"`
 }
 
 type SchedulerConfiguration struct {
 	// Interval after which WHOIS cache data is considered stale (in days)
-	WhoisCacheStaleInterval int `yaml:"whoisCacheStaleInterval" json:"whoisCacheStaleInterval"`
+	WhoisCacheStaleInterval int `yaml:"whoisCacheStaleInterval" json:"whoisCacheStaleInterval" validate:"min=1" description:"Interval after which WHOIS cache data is considered stale (in days)"`
 	// Use standard WHOIS refresh schedule:
 	//
 	// 0. Cache miss for domain
@@ -138,18 +93,138 @@ type SchedulerConfiguration struct {
 	// 5. 2 weeks before expiry
 	//
 	// As always, manual refresh is possible, and can be triggered via the API or the web interface
-	UseStandardWhoisRefreshSchedule bool `yaml:"useStandardWhoisRefreshSchedule" json:"useStandardWhoisRefreshSchedule"`
+	UseStandardWhoisRefreshSchedule bool `yaml:"useStandardWhoisRefreshSchedule" json:"useStandardWhoisRefreshSchedule" description:"Use the standard WHOIS refresh schedule"`
+	// IANA timezone used for scheduling, e.g. "Europe/Berlin" (empty for the server timezone)
+	Timezone string `yaml:"timezone" json:"timezone" validate:"timezone" description:"IANA timezone used for scheduling, e.g. Europe/Berlin (empty for the server timezone)"`
+	// Daily alerts are only sent between these times of day (HH:MM, empty for any time)
+	AlertWindowStart string `yaml:"alertWindowStart" json:"alertWindowStart" validate:"clock" description:"Daily alerts are only sent from this time of day (HH:MM, empty for any time)"`
+	AlertWindowEnd   string `yaml:"alertWindowEnd" json:"alertWindowEnd" validate:"clock" description:"Daily alerts are only sent until this time of day (HH:MM, empty for any time)"`
+	// Alerts that aren't critical (more than 1 week before expiry) are held back between these times of day (HH:MM)
+	QuietHoursStart string `yaml:"quietHoursStart" json:"quietHoursStart" validate:"clock" description:"Start of the quiet hours, when alerts more than 1 week before expiry are held back (HH:MM, empty for none)"`
+	QuietHoursEnd   string `yaml:"quietHoursEnd" json:"quietHoursEnd" validate:"clock" description:"End of the quiet hours (HH:MM, empty for none)"`
+	// How often the watched names are checked for availability (in hours, 0 for the default of 24)
+	WatchCheckInterval int `yaml:"watchCheckInterval" json:"watchCheckInterval" validate:"min=0" description:"How often the watched names are checked for availability (in hours, 0 for every 24 hours)"`
+	// How often the lookalikes of the monitored domains are looked for (in hours, 0 for the default of 24)
+	LookalikeScanInterval int `yaml:"lookalikeScanInterval" json:"lookalikeScanInterval" validate:"min=0" description:"How often the lookalikes of the monitored domains are looked for (in hours, 0 for every 24 hours)"`
+	// How often the certificate transparency logs are searched (in hours, 0 for the default of 12)
+	CertificatePollInterval int `yaml:"certificatePollInterval" json:"certificatePollInterval" validate:"min=0" description:"How often the certificate transparency logs are searched for new certificates (in hours, 0 for every 12 hours)"`
+	// How often the subdomains are discovered and their certificates checked (in hours, 0 for the default of 24)
+	DiscoveryInterval int `yaml:"discoveryInterval" json:"discoveryInterval" validate:"min=0" description:"How often the subdomains are discovered and the certificates of the promoted ones checked (in hours, 0 for every 24 hours)"`
+	// How often the email security records are checked (in hours, 0 for the default of 24)
+	MailSecurityInterval int `yaml:"mailSecurityInterval" json:"mailSecurityInterval" validate:"min=0" description:"How often the email security records of the monitored domains are checked (in hours, 0 for every 24 hours)"`
+	// How often the DNSSEC chains are checked (in hours, 0 for the default of 12)
+	DNSSECInterval int `yaml:"dnssecInterval" json:"dnssecInterval" validate:"min=0" description:"How often the DNSSEC chains of the monitored domains are checked (in hours, 0 for every 12 hours)"`
+	// How often the delegations are checked (in hours, 0 for the default of 6)
+	DelegationInterval int `yaml:"delegationInterval" json:"delegationInterval" validate:"min=0" description:"How often the nameserver delegations of the monitored domains are checked (in hours, 0 for every 6 hours)"`
+}
+
+type CostsConfiguration struct {
+	// Currency the cost reports are converted to with the exchange rates, e.g. "€" (empty for no conversion)
+	BaseCurrency string `yaml:"baseCurrency" json:"baseCurrency" description:"Currency the cost reports are converted to with the exchange rates (empty for no conversion)"`
+}
+
+type DNSConfiguration struct {
+	// DNS server used for the DNS checks, as host or host:port (empty for the system resolver)
+	Resolver string `yaml:"resolver" json:"resolver" validate:"hostport" description:"DNS server used for the DNS checks, as host or host:port (empty for the system resolver)"`
+	// Timeout of a single DNS query (in seconds, 0 for the default of 5)
+	Timeout int `yaml:"timeout" json:"timeout" validate:"min=0,max=60" description:"Timeout of a single DNS query (in seconds, 0 for 5 seconds)"`
+}
+
+type LookalikesConfiguration struct {
+	// Look for registered lookalikes (typosquats) of the monitored domains
+	Enabled bool `yaml:"enabled" json:"enabled" description:"Look for registered lookalikes (typosquats) of the monitored domains"`
+	// TLDs swapped in for the TLD of each domain (empty for a built-in list of popular TLDs)
+	TLDs []string `yaml:"tlds" json:"tlds" description:"TLDs swapped in for the TLD of each domain, e.g. com, net, org (empty for a built-in list)"`
+}
+
+type CertificatesConfiguration struct {
+	// Search the certificate transparency logs for certificates of the monitored domains and their subdomains
+	Enabled bool `yaml:"enabled" json:"enabled" description:"Search the certificate transparency logs for certificates of the monitored domains and their subdomains"`
+	// Search API compatible with the JSON output of crt.sh (empty for https://crt.sh/)
+	URL string `yaml:"url" json:"url" validate:"url" description:"Certificate search API compatible with the JSON output of crt.sh (empty for https://crt.sh/)"`
+	// Timeout of a single search (in seconds, 0 for the default of 60)
+	Timeout int `yaml:"timeout" json:"timeout" validate:"min=0,max=600" description:"Timeout of a single search (in seconds, 0 for 60 seconds)"`
+	// Organizations expected to issue certificates, e.g. "Let's Encrypt" (empty to only alert issuers that weren't seen before)
+	Issuers []string `yaml:"issuers" json:"issuers" description:"Organizations expected to issue certificates, e.g. Let's Encrypt (empty to only alert issuers that weren't seen before)"`
+	// Names outside the monitored domains that may share a certificate with them, e.g. "cdn.example.net" or "*.example.net"
+	AllowedNames []string `yaml:"allowedNames" json:"allowedNames" description:"Names outside the monitored domains that may share a certificate with them, e.g. *.example.net"`
+}
+
+type DiscoveryConfiguration struct {
+	// Discover the subdomains of the monitored domains on a schedule
+	Enabled bool `yaml:"enabled" json:"enabled" description:"Discover the subdomains of the monitored domains on a schedule"`
+	// Names resolved below each domain, e.g. www, mail, vpn (empty for a built-in list of common names)
+	Wordlist []string `yaml:"wordlist" json:"wordlist" description:"Names resolved below each domain, e.g. www, mail, vpn (empty for a built-in list of common names)"`
+}
+
+type MailSecurityConfiguration struct {
+	// Check the SPF, DMARC, DKIM, MTA-STS, TLS-RPT and BIMI records of the monitored domains on a schedule
+	Enabled bool `yaml:"enabled" json:"enabled" description:"Check the SPF, DMARC, DKIM, MTA-STS, TLS-RPT and BIMI records of the monitored domains on a schedule"`
+	// DKIM selectors checked for the domains that don't have their own, e.g. "google" or "selector1"
+	DKIMSelectors []string `yaml:"dkimSelectors" json:"dkimSelectors" description:"DKIM selectors checked for the domains that don't have their own, e.g. google, selector1 (empty to leave DKIM out of the score)"`
+}
+
+type DNSSECConfiguration struct {
+	// Check the DS records, keys and signatures of the monitored domains on a schedule
+	Enabled bool `yaml:"enabled" json:"enabled" description:"Check the DS records, keys and signatures of the monitored domains on a schedule"`
+	// Alert when a signature of a zone expires within this many days (0 for the default of 3)
+	ExpiryWarningDays int `yaml:"expiryWarningDays" json:"expiryWarningDays" validate:"min=0,max=60" description:"Alert when a signature of a zone expires within this many days (0 for 3 days)"`
+}
+
+type DelegationConfiguration struct {
+	// Check the nameservers of the monitored domains on a schedule
+	Enabled bool `yaml:"enabled" json:"enabled" description:"Check that the nameservers of the monitored domains answer authoritatively and agree on a schedule"`
 }
 
 type ConfigurationFile struct {
+	// Version of the file format, used for migrations
+	Version int `yaml:"version" json:"version"`
 	// The application configuration
 	App AppConfiguration `yaml:"app" json:"app"`
 	// The alerts configuration
 	Alerts AlertsConfiguration `yaml:"alerts" json:"alerts"`
 	// The SMTP configuration
-	SMTP SMTPConfiguration `yaml:"smtp" json:"smtp"`
+	SMTP SMTPConfiguration `yaml:"smtp" json:"smtp" sensitive:"true"`
 	// The scheduler configuration
 	Scheduler SchedulerConfiguration `yaml:"scheduler" json:"scheduler"`
+	// The cost report configuration
+	Costs CostsConfiguration `yaml:"costs" json:"costs"`
+	// The DNS resolver configuration
+	DNS DNSConfiguration `yaml:"dns" json:"dns"`
+	// The lookalike domain detection
+	Lookalikes LookalikesConfiguration `yaml:"lookalikes" json:"lookalikes"`
+	// The certificate transparency monitor
+	Certificates CertificatesConfiguration `yaml:"certificates" json:"certificates"`
+	// The subdomain discovery
+	Discovery DiscoveryConfiguration `yaml:"discovery" json:"discovery"`
+	// The email security posture checks
+	MailSecurity MailSecurityConfiguration `yaml:"mailSecurity" json:"mailSecurity"`
+	// The DNSSEC monitor
+	DNSSEC DNSSECConfiguration `yaml:"dnssec" json:"dnssec"`
+	// The nameserver delegation health checks
+	Delegation DelegationConfiguration `yaml:"delegation" json:"delegation"`
+	// Named lists of recipients that can be used instead of email addresses
+	ContactGroups []ContactGroup `yaml:"contactGroups" json:"contactGroups"`
+	// Extra recipients for the alerts of domains with a tag
+	TagRoutes []TagRoute `yaml:"tagRoutes" json:"tagRoutes"`
+	// Exchange rates to the base currency of the cost reports, maintained by the operator
+	ExchangeRates []ExchangeRate `yaml:"exchangeRates" json:"exchangeRates"`
+}
+
+// ContactGroup is a named list of email addresses, referenced by its name wherever recipients are configured
+type ContactGroup struct {
+	// Name of the group (lowercase letters, digits, "-" and "_")
+	Name string `yaml:"name" json:"name"`
+	// Email addresses of the members
+	Members []string `yaml:"members" json:"members"`
+}
+
+// TagRoute sends the alerts of every domain with a tag to extra recipients
+type TagRoute struct {
+	// Domain tag (lowercase)
+	Tag string `yaml:"tag" json:"tag"`
+	// Email addresses or contact group names
+	Recipients []string `yaml:"recipients" json:"recipients"`
 }
 
 type Configuration struct {
@@ -164,6 +239,7 @@ func DefaultConfiguration(filepath string) Configuration {
 	return Configuration{
 		Filepath: filepath,
 		Config: ConfigurationFile{
+			Version: AppConfigVersion,
 			App: AppConfiguration{
 				Port:                 3124,
 				AutomateWHOISRefresh: true,
@@ -175,7 +251,15 @@ func DefaultConfiguration(filepath string) Configuration {
 			},
 			Alerts: AlertsConfiguration{
 				Send1MonthAlert: true,
-				Send3DayAlert:   true,
+				Send3DayAlert:    true,
+				DigestMode:       "off",
+				DigestWeekday:    "monday",
+				DigestTime:       "08:00",
+				SendStatusAlerts: true,
+				ExpectedLocks:    []string{LockTransfer},
+			},
+			SMTP: SMTPConfiguration{
+				EncryptionType: "starttls",
 			},
 		},
 	}
@@ -184,41 +268,37 @@ func DefaultConfiguration(filepath string) Configuration {
 // Write the app configuration to the config file
 func (c Configuration) Flush() {
 	// Create encoder that always quotes string values for security
-	var buf bytes.Buffer
-	encoder := yaml.NewEncoder(&buf)
-	encoder.SetIndent(4)
-	err := encoder.Encode(c.Config)
+	// Always write the current file format version
+	c.Config.Version = AppConfigVersion
+
+	data, err := MarshalYAML(c.Config)
 	if err != nil {
 		log.Println("Error while marshalling configuration")
 		log.Fatalf("error: %v", err)
 	}
-	encoder.Close()
-	
-	// Process the YAML to ensure all string values are quoted
-	data := quoteYAMLStrings(buf.Bytes())
 
-	file, err := os.Create(c.Filepath)
-	if err != nil {
-		log.Println("Error while creating configuration file")
-		log.Fatalf("error: %v", err)
+	if err := writeFileAtomic(c.Filepath, data); err != nil {
+		log.Printf("❌ Error while writing configuration file: %v", err)
+		return
 	}
 
-	defer file.Close()
+	log.Printf("💾 Configuration flushed to %s", filepath.Base(c.Filepath))
+}
 
-	_, err = file.Write(data)
-	if err != nil {
-		log.Println("Error while writing configuration file")
-		log.Fatalf("error: %v", err)
+// EnsureLinkSecret generates the key for signed e-mail links if there is none yet, and writes the configuration.
+// Returns true if a key was generated.
+func (c *Configuration) EnsureLinkSecret() bool {
+	if c.Config.App.LinkSecret != "" {
+		return false
 	}
-
-	// Check if the file has been written
-	fileInfo, err := file.Stat()
-	if err != nil {
-		log.Println("Error while checking configuration file")
-		log.Fatalf("error: %v", err)
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Printf("❌ Unable to generate the link signing key: %v", err)
+		return false
 	}
-
-	log.Printf("💾 Configuration flushed to %s", fileInfo.Name())
+	c.Config.App.LinkSecret = hex.EncodeToString(key)
+	c.Flush()
+	return true
 }
 
 // Update the app configuration with the given data
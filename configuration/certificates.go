@@ -0,0 +1,281 @@
+package configuration
+
+import (
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Certificate is a certificate for a monitored domain or one of its subdomains, found in the certificate transparency
+// logs
+type Certificate struct {
+	// Id of the log entry at the search API
+	ID int64 `yaml:"id" json:"id"`
+	// The monitored domain it was found for
+	Of string `yaml:"of" json:"of"`
+	// Distinguished name of the issuer, e.g. "C=US, O=Let's Encrypt, CN=R11"
+	Issuer string `yaml:"issuer" json:"issuer"`
+	// Subject common name and every name on the certificate
+	CommonName string   `yaml:"commonName" json:"commonName"`
+	Names      []string `yaml:"names" json:"names"`
+	Serial     string   `yaml:"serial" json:"serial"`
+	// Validity of the certificate
+	NotBefore time.Time `yaml:"notBefore" json:"notBefore"`
+	NotAfter  time.Time `yaml:"notAfter" json:"notAfter"`
+	// When the certificate was added to the log
+	LoggedAt time.Time `yaml:"loggedAt" json:"loggedAt"`
+	// When the certificate was first found
+	FirstSeen time.Time `yaml:"firstSeen" json:"firstSeen"`
+	// The issuer organization wasn't expected or seen before for the domain
+	NewIssuer bool `yaml:"newIssuer,omitempty" json:"newIssuer,omitempty"`
+	// Names on the certificate outside the monitored domains and the allowed names
+	UnexpectedNames []string `yaml:"unexpectedNames,omitempty" json:"unexpectedNames,omitempty"`
+	// Found by the first search of the domain, which isn't alerted
+	Baseline bool `yaml:"baseline,omitempty" json:"baseline,omitempty"`
+}
+
+// IssuerOrganization returns the organization of the issuer, e.g. "Let's Encrypt"
+func (c Certificate) IssuerOrganization() string {
+	return IssuerOrganization(c.Issuer)
+}
+
+// Suspicious reports if the certificate has a new issuer or unexpected names
+func (c Certificate) Suspicious() bool {
+	return c.NewIssuer || len(c.UnexpectedNames) > 0
+}
+
+// Expired reports if the certificate is no longer valid
+func (c Certificate) Expired(now time.Time) bool {
+	return !c.NotAfter.IsZero() && now.After(c.NotAfter)
+}
+
+// key identifies a certificate across log entries, a precertificate and its certificate share issuer and serial
+func (c Certificate) key() string {
+	if c.Serial == "" {
+		return c.Issuer + "#" + strconv.FormatInt(c.ID, 10)
+	}
+	return c.Issuer + "/" + strings.ToLower(c.Serial)
+}
+
+// IssuerOrganization returns the organization (O=) of an issuer distinguished name, or the whole name if it has none.
+// Issuing CAs rotate their common names (e.g. R10 and R11), the organization stays the same.
+func IssuerOrganization(dn string) string {
+	for _, part := range strings.Split(dn, ",") {
+		part = strings.TrimSpace(part)
+		if value, ok := strings.CutPrefix(part, "O="); ok {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return strings.TrimSpace(dn)
+}
+
+// CertificatePoll records the last search of a monitored domain
+type CertificatePoll struct {
+	// The monitored domain
+	FQDN string `yaml:"fqdn" json:"fqdn"`
+	// When it was searched
+	PolledAt time.Time `yaml:"polledAt" json:"polledAt"`
+	// When it was last searched successfully, zero if it never was
+	Succeeded time.Time `yaml:"succeeded,omitempty" json:"succeeded,omitempty"`
+	// Number of certificates returned and how many of them are new
+	Found int `yaml:"found" json:"found"`
+	New   int `yaml:"new" json:"new"`
+	// Why the search failed, empty if it succeeded
+	Error string `yaml:"error,omitempty" json:"error,omitempty"`
+}
+
+type CertificateFile struct {
+	// Version of the file format, used for migrations
+	Version int `yaml:"version" json:"version"`
+	// The last search of each monitored domain
+	Polls []CertificatePoll `yaml:"polls" json:"polls"`
+	// Every certificate found so far
+	Certificates []Certificate `yaml:"certificates" json:"certificates"`
+}
+
+// CertificateStorage keeps the certificates found for the monitored domains. It is shared by the scheduler and the web
+// handlers, so it is always used as a pointer and guards its contents with a lock.
+type CertificateStorage struct {
+	mu sync.Mutex
+	// The certificates file contents
+	FileContents CertificateFile
+	// The path to the certificates file
+	Filepath string
+}
+
+func DefaultCertificateStorage(path string) *CertificateStorage {
+	return &CertificateStorage{
+		FileContents: CertificateFile{Version: CertificatesVersion, Polls: []CertificatePoll{}, Certificates: []Certificate{}},
+		Filepath:     path,
+	}
+}
+
+// List returns the certificates of a monitored domain (every certificate for an empty fqdn), the newest first
+func (s *CertificateStorage) List(of string) []Certificate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := []Certificate{}
+	for _, certificate := range s.FileContents.Certificates {
+		if of == "" || certificate.Of == of {
+			list = append(list, certificate)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if !list[i].NotBefore.Equal(list[j].NotBefore) {
+			return list[i].NotBefore.After(list[j].NotBefore)
+		}
+		return list[i].ID > list[j].ID
+	})
+	return list
+}
+
+// Polls returns the last search of each monitored domain, sorted by domain
+func (s *CertificateStorage) Polls() []CertificatePoll {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	polls := append([]CertificatePoll{}, s.FileContents.Polls...)
+	sort.Slice(polls, func(i, j int) bool { return polls[i].FQDN < polls[j].FQDN })
+	return polls
+}
+
+// Issuers returns the issuer organizations of the certificates found for a monitored domain
+func (s *CertificateStorage) Issuers(of string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := map[string]bool{}
+	issuers := []string{}
+	for _, certificate := range s.FileContents.Certificates {
+		organization := certificate.IssuerOrganization()
+		if certificate.Of == of && !seen[organization] {
+			seen[organization] = true
+			issuers = append(issuers, organization)
+		}
+	}
+	sort.Strings(issuers)
+	return issuers
+}
+
+// Baseline reports if a monitored domain was never searched successfully, so its certificates are a baseline
+func (s *CertificateStorage) Baseline(of string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, poll := range s.FileContents.Polls {
+		if poll.FQDN == of {
+			return poll.Succeeded.IsZero()
+		}
+	}
+	return true
+}
+
+// Record stores the search of a monitored domain and adds the certificates that weren't found before. Returns the new
+// certificates, none for the first successful search of a domain (they are marked as baseline) or a failed search.
+// The file isn't written, call Flush after recording a batch of searches.
+func (s *CertificateStorage) Record(poll CertificatePoll, found []Certificate) []Certificate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := -1
+	for i := range s.FileContents.Polls {
+		if s.FileContents.Polls[i].FQDN == poll.FQDN {
+			index = i
+		}
+	}
+	baseline := index < 0 || s.FileContents.Polls[index].Succeeded.IsZero()
+	if poll.Error != "" {
+		// Keep the counts of the last successful search
+		if index >= 0 {
+			poll.Succeeded, poll.Found, poll.New = s.FileContents.Polls[index].Succeeded, s.FileContents.Polls[index].Found, 0
+		}
+		found = nil
+	} else {
+		poll.Succeeded = poll.PolledAt
+	}
+
+	known := map[string]bool{}
+	for _, certificate := range s.FileContents.Certificates {
+		if certificate.Of == poll.FQDN {
+			known[certificate.key()] = true
+		}
+	}
+	added := []Certificate{}
+	for _, certificate := range found {
+		if known[certificate.key()] {
+			continue
+		}
+		known[certificate.key()] = true
+		certificate.FirstSeen = poll.PolledAt
+		certificate.Baseline = baseline
+		s.FileContents.Certificates = append(s.FileContents.Certificates, certificate)
+		if !baseline {
+			added = append(added, certificate)
+		}
+	}
+	if poll.Error == "" {
+		poll.Found, poll.New = len(found), len(added)
+	}
+
+	if index < 0 {
+		s.FileContents.Polls = append(s.FileContents.Polls, poll)
+	} else {
+		s.FileContents.Polls[index] = poll
+	}
+	return added
+}
+
+// Forget removes the search and certificates of a domain that is no longer monitored. Returns true if there was
+// something to remove. The file isn't written.
+func (s *CertificateStorage) Forget(fqdn string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := false
+	polls := s.FileContents.Polls[:0]
+	for _, poll := range s.FileContents.Polls {
+		if poll.FQDN == fqdn {
+			removed = true
+			continue
+		}
+		polls = append(polls, poll)
+	}
+	s.FileContents.Polls = polls
+	certificates := s.FileContents.Certificates[:0]
+	for _, certificate := range s.FileContents.Certificates {
+		if certificate.Of == fqdn {
+			removed = true
+			continue
+		}
+		certificates = append(certificates, certificate)
+	}
+	s.FileContents.Certificates = certificates
+	return removed
+}
+
+// Flush the certificates to their storage
+func (s *CertificateStorage) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Always write the current file format version
+	s.FileContents.Version = CertificatesVersion
+
+	data, err := MarshalYAML(s.FileContents)
+	if err != nil {
+		log.Printf("❌ Error while marshalling the certificates: %v", err)
+		return
+	}
+
+	if err := writeFileAtomic(s.Filepath, data); err != nil {
+		log.Printf("❌ Error while writing certificates file: %v", err)
+		return
+	}
+
+	log.Printf("💾 Flushed certificates to %s", filepath.Base(s.Filepath))
+}
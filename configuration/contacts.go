@@ -0,0 +1,138 @@
+package configuration
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// GetContactGroup returns the contact group with the given name
+func (c *ConfigurationFile) GetContactGroup(name string) (ContactGroup, bool) {
+	for _, group := range c.ContactGroups {
+		if group.Name == name {
+			return group, true
+		}
+	}
+	return ContactGroup{}, false
+}
+
+// SetContactGroup validates a contact group and adds it, or replaces the group with the same name
+func (c *ConfigurationFile) SetContactGroup(group ContactGroup) error {
+	group.Name = strings.ToLower(strings.TrimSpace(group.Name))
+	if !IsContactGroupName(group.Name) {
+		return ValidationErrors{{Section: "contactGroups", Key: "name", Message: fmt.Sprintf("must only contain lowercase letters, digits, - and _, got %q", group.Name)}}
+	}
+	members := []string{}
+	for _, member := range group.Members {
+		for _, address := range SplitList(member) {
+			if !IsEmailAddress(address) {
+				return ValidationErrors{{Section: "contactGroups", Key: "members", Message: fmt.Sprintf("must only contain valid email addresses, got %q", address)}}
+			}
+			members = append(members, address)
+		}
+	}
+	group.Members = members
+
+	for i := range c.ContactGroups {
+		if c.ContactGroups[i].Name == group.Name {
+			c.ContactGroups[i] = group
+			return nil
+		}
+	}
+	c.ContactGroups = append(c.ContactGroups, group)
+	sort.Slice(c.ContactGroups, func(i, j int) bool { return c.ContactGroups[i].Name < c.ContactGroups[j].Name })
+	return nil
+}
+
+// RemoveContactGroup removes a contact group, returning false if there is no group with that name
+func (c *ConfigurationFile) RemoveContactGroup(name string) bool {
+	for i := range c.ContactGroups {
+		if c.ContactGroups[i].Name == name {
+			c.ContactGroups = append(c.ContactGroups[:i], c.ContactGroups[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// SetTagRoute validates a tag route and adds it, or replaces the route of the same tag
+func (c *ConfigurationFile) SetTagRoute(route TagRoute) error {
+	route.Tag = strings.ToLower(strings.TrimSpace(route.Tag))
+	if route.Tag == "" || strings.ContainsAny(route.Tag, ",; ") {
+		return ValidationErrors{{Section: "tagRoutes", Key: "tag", Message: fmt.Sprintf("must be a single tag, got %q", route.Tag)}}
+	}
+	recipients := []string{}
+	for _, entry := range route.Recipients {
+		for _, recipient := range SplitList(entry) {
+			if !IsRecipient(recipient) {
+				return ValidationErrors{{Section: "tagRoutes", Key: "recipients", Message: fmt.Sprintf("must only contain email addresses or contact group names, got %q", recipient)}}
+			}
+			recipients = append(recipients, recipient)
+		}
+	}
+	if len(recipients) == 0 {
+		return ValidationErrors{{Section: "tagRoutes", Key: "recipients", Message: "must not be empty"}}
+	}
+	route.Recipients = recipients
+
+	for i := range c.TagRoutes {
+		if c.TagRoutes[i].Tag == route.Tag {
+			c.TagRoutes[i] = route
+			return nil
+		}
+	}
+	c.TagRoutes = append(c.TagRoutes, route)
+	sort.Slice(c.TagRoutes, func(i, j int) bool { return c.TagRoutes[i].Tag < c.TagRoutes[j].Tag })
+	return nil
+}
+
+// RemoveTagRoute removes the route of a tag, returning false if there is none
+func (c *ConfigurationFile) RemoveTagRoute(tag string) bool {
+	for i := range c.TagRoutes {
+		if c.TagRoutes[i].Tag == tag {
+			c.TagRoutes = append(c.TagRoutes[:i], c.TagRoutes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// TagRecipients returns the recipients of the tag routes that match the tags of a domain
+func (c *ConfigurationFile) TagRecipients(domain Domain) []string {
+	recipients := []string{}
+	for _, route := range c.TagRoutes {
+		if domain.HasTag(route.Tag) {
+			recipients = append(recipients, route.Recipients...)
+		}
+	}
+	return recipients
+}
+
+// ExpandRecipients replaces contact group names with their members. The result has no duplicates (ignoring case) and
+// keeps the order of first appearance. Unknown groups are logged and skipped.
+func (c *ConfigurationFile) ExpandRecipients(entries []string) []string {
+	seen := map[string]bool{}
+	addresses := []string{}
+	add := func(address string) {
+		if key := strings.ToLower(address); !seen[key] {
+			seen[key] = true
+			addresses = append(addresses, address)
+		}
+	}
+	for _, entry := range entries {
+		if IsEmailAddress(entry) {
+			add(entry)
+			continue
+		}
+		group, ok := c.GetContactGroup(entry)
+		if !ok {
+			log.Printf("⚠️ Unknown contact group %q, skipping", entry)
+			continue
+		}
+		for _, member := range group.Members {
+			add(member)
+		}
+	}
+	return addresses
+}
@@ -0,0 +1,122 @@
+package configuration
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ExchangeRate converts a currency to the base currency of the cost reports
+type ExchangeRate struct {
+	// Currency as used in the domains, e.g. "$" or "USD"
+	Currency string `yaml:"currency" json:"currency"`
+	// Value of one unit of the currency in the base currency
+	Rate float64 `yaml:"rate" json:"rate"`
+}
+
+// CurrencyAmount is an amount of money in a currency
+type CurrencyAmount struct {
+	Currency string  `json:"currency"`
+	Amount   float64 `json:"amount"`
+}
+
+// ProjectedRenewal is an expected renewal of a domain in a cost report
+type ProjectedRenewal struct {
+	FQDN      string    `json:"fqdn"`
+	Name      string    `json:"name"`
+	Registrar string    `json:"registrar"`
+	Group     string    `json:"group,omitempty"`
+	Tags      []string  `json:"tags,omitempty"`
+	Date      time.Time `json:"date"`
+	// The domain expired before the start of the report, the renewal is counted in the first month
+	Overdue  bool    `json:"overdue"`
+	Price    float64 `json:"price"`
+	Currency string  `json:"currency"`
+	// Price in the base currency, only set if Convertible
+	Converted   float64 `json:"converted"`
+	Convertible bool    `json:"convertible"`
+}
+
+// CostTotal sums up the renewals of a group (a month, year, currency, registrar or tag) in a cost report
+type CostTotal struct {
+	Key      string `json:"key"`
+	Renewals int    `json:"renewals"`
+	// Sum per currency, largest first
+	Amounts []CurrencyAmount `json:"amounts"`
+	// Sum in the base currency, of the renewals with an exchange rate
+	Converted float64 `json:"converted"`
+	// Every renewal of the group could be converted to the base currency
+	Complete bool `json:"complete"`
+}
+
+// CostReport projects the renewal spend of the monitored domains over a period
+type CostReport struct {
+	From         time.Time    `json:"from"`
+	To           time.Time    `json:"to"`
+	Months       int          `json:"months"`
+	BaseCurrency string       `json:"baseCurrency"`
+	Filter       DomainFilter `json:"filter"`
+	Total        CostTotal    `json:"total"`
+	ByMonth      []CostTotal  `json:"byMonth"`
+	ByYear       []CostTotal  `json:"byYear"`
+	ByCurrency   []CostTotal  `json:"byCurrency"`
+	ByRegistrar  []CostTotal  `json:"byRegistrar"`
+	// A renewal is counted for each of its tags, so these totals can add up to more than the total
+	ByTag    []CostTotal        `json:"byTag"`
+	Renewals []ProjectedRenewal `json:"renewals"`
+	// Currencies without an exchange rate to the base currency
+	MissingRates []string `json:"missingRates"`
+	// Domains left out of the projection because they have no renewal price or no known expiration date
+	Unpriced      []string `json:"unpriced"`
+	UnknownExpiry []string `json:"unknownExpiry"`
+	// Archived domains, they are let lapse and not renewed
+	Archived []string `json:"archived"`
+}
+
+// GetExchangeRate returns the rate of a currency to the base currency of the cost reports. The base currency itself
+// has a rate of 1.
+func (c *ConfigurationFile) GetExchangeRate(currency string) (float64, bool) {
+	currency = strings.TrimSpace(currency)
+	if strings.EqualFold(currency, strings.TrimSpace(c.Costs.BaseCurrency)) {
+		return 1, true
+	}
+	for _, rate := range c.ExchangeRates {
+		if strings.EqualFold(rate.Currency, currency) {
+			return rate.Rate, true
+		}
+	}
+	return 0, false
+}
+
+// SetExchangeRate validates an exchange rate and adds it, or replaces the rate of the same currency
+func (c *ConfigurationFile) SetExchangeRate(rate ExchangeRate) error {
+	rate.Currency = strings.TrimSpace(rate.Currency)
+	if rate.Currency == "" || len(rate.Currency) > 8 {
+		return ValidationErrors{{Section: "exchangeRates", Key: "currency", Message: fmt.Sprintf("must be a currency symbol or code of up to 8 characters, got %q", rate.Currency)}}
+	}
+	if rate.Rate <= 0 {
+		return ValidationErrors{{Section: "exchangeRates", Key: "rate", Message: fmt.Sprintf("must be greater than 0, got %v", rate.Rate)}}
+	}
+
+	for i := range c.ExchangeRates {
+		if strings.EqualFold(c.ExchangeRates[i].Currency, rate.Currency) {
+			c.ExchangeRates[i] = rate
+			return nil
+		}
+	}
+	c.ExchangeRates = append(c.ExchangeRates, rate)
+	sort.Slice(c.ExchangeRates, func(i, j int) bool { return c.ExchangeRates[i].Currency < c.ExchangeRates[j].Currency })
+	return nil
+}
+
+// RemoveExchangeRate removes the rate of a currency, returning false if there is none
+func (c *ConfigurationFile) RemoveExchangeRate(currency string) bool {
+	for i := range c.ExchangeRates {
+		if strings.EqualFold(c.ExchangeRates[i].Currency, strings.TrimSpace(currency)) {
+			c.ExchangeRates = append(c.ExchangeRates[:i], c.ExchangeRates[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
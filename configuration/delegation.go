@@ -0,0 +1,208 @@
+package configuration
+
+import (
+	"log"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Delegation health states of a domain
+const (
+	// Every nameserver answers authoritatively and the registry, the parent zone and the nameservers agree
+	DelegationHealthy = "healthy"
+	// Every nameserver answers, but the NS sets or the SOA serials don't agree or an address doesn't answer
+	DelegationDegraded = "degraded"
+	// A delegated nameserver doesn't answer authoritatively for the domain (a lame delegation)
+	DelegationLame = "lame"
+)
+
+// Where a nameserver of a domain is listed
+const (
+	// In the WHOIS or RDAP data of the registry
+	ListedRegistry = "registry"
+	// In the referral of the parent zone
+	ListedParent = "parent"
+	// In the NS records the nameservers of the domain answer with
+	ListedZone = "zone"
+)
+
+// DelegationReport is the delegation of a monitored domain: the nameservers the registry and the parent zone list,
+// and how each of them answers for the domain
+type DelegationReport struct {
+	// The checked domain
+	FQDN string `yaml:"fqdn" json:"fqdn"`
+	// When the domain was last checked
+	CheckedAt time.Time `yaml:"checkedAt" json:"checkedAt"`
+	// When the last complete check finished, the results are from that check
+	Succeeded time.Time `yaml:"succeeded,omitempty" json:"succeeded,omitempty"`
+	// Why the last check couldn't be completed (e.g. the parent zone didn't answer), empty if it was
+	Error string `yaml:"error,omitempty" json:"error,omitempty"`
+	// One of DelegationHealthy, DelegationDegraded or DelegationLame
+	Status string `yaml:"status" json:"status"`
+	// The parent zone and the nameservers it delegates the domain to
+	ParentZone string   `yaml:"parentZone" json:"parentZone"`
+	Parent     []string `yaml:"parent" json:"parent"`
+	// The nameservers of the WHOIS or RDAP data, empty if the registry data has none
+	Registry []string `yaml:"registry,omitempty" json:"registry,omitempty"`
+	// Every nameserver listed by the registry, the parent zone or the nameservers themselves, sorted by host
+	Nameservers []NameserverCheck `yaml:"nameservers" json:"nameservers"`
+	// Problems found by the check
+	Findings []string `yaml:"findings,omitempty" json:"findings,omitempty"`
+}
+
+// Complete reports if the domain was checked completely at least once
+func (r DelegationReport) Complete() bool {
+	return !r.Succeeded.IsZero()
+}
+
+// Lame returns the hosts of the lame nameservers
+func (r DelegationReport) Lame() []string {
+	lame := []string{}
+	for _, nameserver := range r.Nameservers {
+		if nameserver.Lame {
+			lame = append(lame, nameserver.Host)
+		}
+	}
+	return lame
+}
+
+// NameserverCheck is how a nameserver answers for a domain
+type NameserverCheck struct {
+	// The host of the nameserver
+	Host string `yaml:"host" json:"host"`
+	// Where the nameserver is listed: ListedRegistry, ListedParent and/or ListedZone
+	Listed []string `yaml:"listed" json:"listed"`
+	// The addresses of the nameserver, from the glue of the parent zone or resolved
+	Addresses []string `yaml:"addresses,omitempty" json:"addresses,omitempty"`
+	// Every address answered authoritatively for the domain
+	Authoritative bool `yaml:"authoritative" json:"authoritative"`
+	// The SOA serial of the domain the nameserver answers with, 0 if it didn't
+	Serial uint32 `yaml:"serial,omitempty" json:"serial,omitempty"`
+	// The NS records of the domain the nameserver answers with
+	NameServers []string `yaml:"nameServers,omitempty" json:"nameServers,omitempty"`
+	// No address of the nameserver answers authoritatively for the domain
+	Lame bool `yaml:"lame" json:"lame"`
+	// Problems with the answers of the addresses (no answer, not authoritative, a different serial), empty if there
+	// were none
+	Error string `yaml:"error,omitempty" json:"error,omitempty"`
+}
+
+// IsListed reports if the nameserver is listed in a place, e.g. ListedParent
+func (c NameserverCheck) IsListed(place string) bool {
+	for _, listed := range c.Listed {
+		if listed == place {
+			return true
+		}
+	}
+	return false
+}
+
+type DelegationFile struct {
+	// Version of the file format, used for migrations
+	Version int `yaml:"version" json:"version"`
+	// The last report of each checked domain
+	Reports []DelegationReport `yaml:"reports" json:"reports"`
+}
+
+// DelegationStorage keeps the delegation reports of the monitored domains. It is shared by the scheduler and the
+// web handlers, so it is always used as a pointer and guards its contents with a lock.
+type DelegationStorage struct {
+	mu sync.Mutex
+	// The delegation file contents
+	FileContents DelegationFile
+	// The path to the delegation file
+	Filepath string
+}
+
+func DefaultDelegationStorage(path string) *DelegationStorage {
+	return &DelegationStorage{
+		FileContents: DelegationFile{Version: DelegationVersion, Reports: []DelegationReport{}},
+		Filepath:     path,
+	}
+}
+
+// List returns the report of every checked domain, sorted by name
+func (s *DelegationStorage) List() []DelegationReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := append([]DelegationReport{}, s.FileContents.Reports...)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].FQDN < list[j].FQDN })
+	return list
+}
+
+// Get returns the report of a domain
+func (s *DelegationStorage) Get(fqdn string) (DelegationReport, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, report := range s.FileContents.Reports {
+		if report.FQDN == fqdn {
+			return report, true
+		}
+	}
+	return DelegationReport{}, false
+}
+
+// Record stores the report of a check. A check that couldn't be completed only updates the time and the error, the
+// results of the last complete check are kept. Returns the last complete report the new one replaced, and false if
+// there was none to compare it with. The file isn't written.
+func (s *DelegationStorage) Record(report DelegationReport) (DelegationReport, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.FileContents.Reports {
+		existing := &s.FileContents.Reports[i]
+		if existing.FQDN != report.FQDN {
+			continue
+		}
+		if report.Error != "" {
+			existing.CheckedAt, existing.Error = report.CheckedAt, report.Error
+			return DelegationReport{}, false
+		}
+		previous := *existing
+		*existing = report
+		return previous, previous.Complete()
+	}
+	s.FileContents.Reports = append(s.FileContents.Reports, report)
+	return DelegationReport{}, false
+}
+
+// Forget removes the report of a domain that is no longer monitored. Returns true if there was one. The file isn't
+// written.
+func (s *DelegationStorage) Forget(fqdn string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, report := range s.FileContents.Reports {
+		if report.FQDN == fqdn {
+			s.FileContents.Reports = append(s.FileContents.Reports[:i], s.FileContents.Reports[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Flush the reports to their storage
+func (s *DelegationStorage) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Always write the current file format version
+	s.FileContents.Version = DelegationVersion
+
+	data, err := MarshalYAML(s.FileContents)
+	if err != nil {
+		log.Printf("❌ Error while marshalling the delegation reports: %v", err)
+		return
+	}
+
+	if err := writeFileAtomic(s.Filepath, data); err != nil {
+		log.Printf("❌ Error while writing delegation file: %v", err)
+		return
+	}
+
+	log.Printf("💾 Flushed delegation reports to %s", filepath.Base(s.Filepath))
+}
@@ -0,0 +1,214 @@
+package configuration
+
+import (
+	"log"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DNSSEC states of a domain
+const (
+	// The parent zone has no DS record and the zone isn't signed
+	DNSSECUnsigned = "unsigned"
+	// The zone is signed but the parent zone has no DS record, so resolvers treat it as unsigned
+	DNSSECUndelegated = "undelegated"
+	// A DS record of the parent zone matches a key that signs the keys of the zone, and the signatures are valid
+	DNSSECSecure = "secure"
+	// The parent zone has DS records but the chain to the signatures of the zone doesn't hold, validating resolvers
+	// fail to resolve the domain
+	DNSSECBroken = "broken"
+)
+
+// DNSSECReport is the DNSSEC chain of a monitored domain: the DS records of the parent zone, the keys of the zone and
+// its signatures, and the delegation the registry reports
+type DNSSECReport struct {
+	// The checked domain
+	FQDN string `yaml:"fqdn" json:"fqdn"`
+	// When the domain was last checked
+	CheckedAt time.Time `yaml:"checkedAt" json:"checkedAt"`
+	// When the last complete check finished, the results are from that check
+	Succeeded time.Time `yaml:"succeeded,omitempty" json:"succeeded,omitempty"`
+	// Why the last check couldn't be completed (e.g. a DNS timeout), empty if it was
+	Error string `yaml:"error,omitempty" json:"error,omitempty"`
+	// One of DNSSECUnsigned, DNSSECUndelegated, DNSSECSecure or DNSSECBroken
+	Status string `yaml:"status" json:"status"`
+	// The DS records of the parent zone
+	DS []DSRecord `yaml:"ds,omitempty" json:"ds,omitempty"`
+	// The DNSKEY records of the zone
+	Keys []DNSKEYRecord `yaml:"keys,omitempty" json:"keys,omitempty"`
+	// The signatures over the keys and the SOA record of the zone
+	Signatures []RRSIGRecord `yaml:"signatures,omitempty" json:"signatures,omitempty"`
+	// When the first of the signatures expires, zero if the zone isn't signed
+	Expires time.Time `yaml:"expires,omitempty" json:"expires,omitempty"`
+	// The delegation the registry reports over RDAP, nil if it reports none or couldn't be asked
+	Registry *RDAPSecureDNS `yaml:"registry,omitempty" json:"registry,omitempty"`
+	// Why the registry couldn't be asked, empty if it could
+	RegistryError string `yaml:"registryError,omitempty" json:"registryError,omitempty"`
+	// Problems found by the check
+	Findings []string `yaml:"findings,omitempty" json:"findings,omitempty"`
+}
+
+// Complete reports if the domain was checked completely at least once
+func (r DNSSECReport) Complete() bool {
+	return !r.Succeeded.IsZero()
+}
+
+// Signed reports if the zone publishes keys, whether or not the parent zone delegates to them
+func (r DNSSECReport) Signed() bool {
+	return len(r.Keys) > 0
+}
+
+// ExpiresWithin reports if a signature of the zone expires in less than the given time
+func (r DNSSECReport) ExpiresWithin(window time.Duration, now time.Time) bool {
+	return !r.Expires.IsZero() && r.Expires.Sub(now) < window
+}
+
+// DSRecord is a DS record of the parent zone
+type DSRecord struct {
+	KeyTag     int    `yaml:"keyTag" json:"keyTag"`
+	Algorithm  int    `yaml:"algorithm" json:"algorithm"`
+	DigestType int    `yaml:"digestType" json:"digestType"`
+	Digest     string `yaml:"digest" json:"digest"`
+	// The digest matches a key of the zone
+	Matched bool `yaml:"matched" json:"matched"`
+}
+
+// DNSKEYRecord is a key of the zone
+type DNSKEYRecord struct {
+	KeyTag    int `yaml:"keyTag" json:"keyTag"`
+	Flags     int `yaml:"flags" json:"flags"`
+	Algorithm int `yaml:"algorithm" json:"algorithm"`
+}
+
+// KSK reports if the key is flagged as a key signing key (the secure entry point flag)
+func (k DNSKEYRecord) KSK() bool {
+	return k.Flags&1 == 1
+}
+
+// RRSIGRecord is a signature over a record set of the zone
+type RRSIGRecord struct {
+	// The type of the signed records, e.g. DNSKEY or SOA
+	Covered   string `yaml:"covered" json:"covered"`
+	KeyTag    int    `yaml:"keyTag" json:"keyTag"`
+	Algorithm int    `yaml:"algorithm" json:"algorithm"`
+	// The time the signature is valid in
+	Inception  time.Time `yaml:"inception" json:"inception"`
+	Expiration time.Time `yaml:"expiration" json:"expiration"`
+	// Why the signature doesn't verify, empty if it does
+	Error string `yaml:"error,omitempty" json:"error,omitempty"`
+}
+
+// Valid reports if the signature verified with a key of the zone
+func (s RRSIGRecord) Valid() bool {
+	return s.Error == ""
+}
+
+type DNSSECFile struct {
+	// Version of the file format, used for migrations
+	Version int `yaml:"version" json:"version"`
+	// The last report of each checked domain
+	Reports []DNSSECReport `yaml:"reports" json:"reports"`
+}
+
+// DNSSECStorage keeps the DNSSEC reports of the monitored domains. It is shared by the scheduler and the
+// web handlers, so it is always used as a pointer and guards its contents with a lock.
+type DNSSECStorage struct {
+	mu sync.Mutex
+	// The DNSSEC file contents
+	FileContents DNSSECFile
+	// The path to the DNSSEC file
+	Filepath string
+}
+
+func DefaultDNSSECStorage(path string) *DNSSECStorage {
+	return &DNSSECStorage{
+		FileContents: DNSSECFile{Version: DNSSECVersion, Reports: []DNSSECReport{}},
+		Filepath:     path,
+	}
+}
+
+// List returns the report of every checked domain, sorted by name
+func (s *DNSSECStorage) List() []DNSSECReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := append([]DNSSECReport{}, s.FileContents.Reports...)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].FQDN < list[j].FQDN })
+	return list
+}
+
+// Get returns the report of a domain
+func (s *DNSSECStorage) Get(fqdn string) (DNSSECReport, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, report := range s.FileContents.Reports {
+		if report.FQDN == fqdn {
+			return report, true
+		}
+	}
+	return DNSSECReport{}, false
+}
+
+// Record stores the report of a check. A check that couldn't be completed only updates the time and the error, the
+// results of the last complete check are kept. Returns the last complete report the new one replaced, and false if
+// there was none to compare it with. The file isn't written.
+func (s *DNSSECStorage) Record(report DNSSECReport) (DNSSECReport, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.FileContents.Reports {
+		existing := &s.FileContents.Reports[i]
+		if existing.FQDN != report.FQDN {
+			continue
+		}
+		if report.Error != "" {
+			existing.CheckedAt, existing.Error = report.CheckedAt, report.Error
+			return DNSSECReport{}, false
+		}
+		previous := *existing
+		*existing = report
+		return previous, previous.Complete()
+	}
+	s.FileContents.Reports = append(s.FileContents.Reports, report)
+	return DNSSECReport{}, false
+}
+
+// Forget removes the report of a domain that is no longer monitored. Returns true if there was one. The file isn't
+// written.
+func (s *DNSSECStorage) Forget(fqdn string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, report := range s.FileContents.Reports {
+		if report.FQDN == fqdn {
+			s.FileContents.Reports = append(s.FileContents.Reports[:i], s.FileContents.Reports[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Flush the reports to their storage
+func (s *DNSSECStorage) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Always write the current file format version
+	s.FileContents.Version = DNSSECVersion
+
+	data, err := MarshalYAML(s.FileContents)
+	if err != nil {
+		log.Printf("❌ Error while marshalling the DNSSEC reports: %v", err)
+		return
+	}
+
+	if err := writeFileAtomic(s.Filepath, data); err != nil {
+		log.Printf("❌ Error while writing DNSSEC file: %v", err)
+		return
+	}
+
+	log.Printf("💾 Flushed DNSSEC reports to %s", filepath.Base(s.Filepath))
+}
@@ -1,11 +1,14 @@
 package configuration
 
 import (
-	"bytes"
+	"errors"
+	"fmt"
 	"log"
-	"os"
-
-	"gopkg.in/yaml.v3"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 // Domain represents a domain that is monitored
@@ -16,83 +19,329 @@ type Domain struct {
 	FQDN string `yaml:"fqdn" json:"fqdn" form:"fqdn" query:"fqdn"`
 	// Send alerts for this domain
 	Alerts bool `yaml:"alerts" json:"alerts" form:"alerts" query:"alerts"`
-	// Monitoring enabled for this domain
+	// Monitoring enabled for this domain, a disabled domain is paused: no WHOIS refresh, checks or alerts
 	Enabled bool `yaml:"enabled" json:"enabled" form:"enabled" query:"enabled"`
+	// Archived domains were let lapse on purpose, they are kept for history and reports but are no longer monitored
+	Archived bool `yaml:"archived,omitempty" json:"archived,omitempty"`
+	// When the domain was archived
+	ArchivedAt *time.Time `yaml:"archivedAt,omitempty" json:"archivedAt,omitempty"`
 	// Renewal price (optional)
 	RenewalPrice float64 `yaml:"renewalPrice,omitempty" json:"renewalPrice,omitempty" form:"renewalPrice" query:"renewalPrice"`
 	// Currency symbol (optional, e.g., "$", "€", "₽")
 	Currency string `yaml:"currency,omitempty" json:"currency,omitempty" form:"currency" query:"currency"`
+	// Owners receive the alerts instead of the default recipients (email addresses or contact group names)
+	Owners []string `yaml:"owners,omitempty" json:"owners,omitempty" form:"owners" query:"owners"`
+	// Copied on every alert (email addresses or contact group names)
+	CC []string `yaml:"cc,omitempty" json:"cc,omitempty" form:"cc" query:"cc"`
+	// Webhook URL that also receives the alerts, e.g. a Slack or Mattermost incoming webhook (optional)
+	Notifier string `yaml:"notifier,omitempty" json:"notifier,omitempty" form:"notifier" query:"notifier"`
+	// Free-form labels for filtering, reports and alert routing (lowercase)
+	Tags []string `yaml:"tags,omitempty" json:"tags,omitempty" form:"tags" query:"tags"`
+	// Group or project the domain belongs to (optional)
+	Group string `yaml:"group,omitempty" json:"group,omitempty" form:"group" query:"group"`
+	// Notes about the domain, e.g. why it is kept or where it is used (optional)
+	Notes string `yaml:"notes,omitempty" json:"notes,omitempty" form:"notes" query:"notes"`
+	// DKIM selectors the domain signs its mail with, checked instead of mailSecurity.dkimSelectors (optional)
+	DKIMSelectors []string `yaml:"dkimSelectors,omitempty" json:"dkimSelectors,omitempty" form:"dkimSelectors" query:"dkimSelectors"`
+}
+
+// Lifecycle states of a domain
+const (
+	DomainActive   = "active"
+	DomainPaused   = "paused"
+	DomainArchived = "archived"
+)
+
+// Lifecycle transitions of a domain
+const (
+	TransitionPause   = "pause"
+	TransitionResume  = "resume"
+	TransitionArchive = "archive"
+	TransitionRestore = "restore"
+)
+
+// State returns the lifecycle state of the domain
+func (d Domain) State() string {
+	if d.Archived {
+		return DomainArchived
+	}
+	if !d.Enabled {
+		return DomainPaused
+	}
+	return DomainActive
+}
+
+// Monitored reports if the domain is active: its WHOIS entry is refreshed, it is checked and alerts are sent
+func (d Domain) Monitored() bool {
+	return d.State() == DomainActive
+}
+
+// IsDomainState reports if a string is a known lifecycle state
+func IsDomainState(state string) bool {
+	return state == DomainActive || state == DomainPaused || state == DomainArchived
+}
+
+// HasTag reports if the domain carries a tag (ignoring case)
+func (d Domain) HasTag(tag string) bool {
+	for _, t := range d.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// DomainFilter selects domains by tag, group, owner and lifecycle state. Empty fields match every domain.
+type DomainFilter struct {
+	Tag   string `query:"tag" json:"tag,omitempty"`
+	Group string `query:"group" json:"group,omitempty"`
+	// An e-mail address (also matching members of owner contact groups) or a contact group name
+	Owner string `query:"owner" json:"owner,omitempty"`
+	// active, paused or archived
+	State string `query:"state" json:"state,omitempty"`
+}
+
+// Normalize trims the filter values
+func (f *DomainFilter) Normalize() {
+	f.Tag = strings.ToLower(strings.TrimSpace(f.Tag))
+	f.Group = strings.TrimSpace(f.Group)
+	f.Owner = strings.TrimSpace(f.Owner)
+	f.State = strings.ToLower(strings.TrimSpace(f.State))
+}
+
+// Validate checks the lifecycle state of the filter
+func (f DomainFilter) Validate() error {
+	if f.State != "" && !IsDomainState(f.State) {
+		return ValidationErrors{{Section: "filter", Key: "state", Message: fmt.Sprintf("must be active, paused or archived, got %q", f.State)}}
+	}
+	return nil
+}
+
+// Empty reports if the filter matches every domain
+func (f DomainFilter) Empty() bool {
+	return f.Tag == "" && f.Group == "" && f.Owner == "" && f.State == ""
+}
+
+// Matches reports if a domain passes the filter. Contact groups of the owners are expanded with the configuration.
+func (f DomainFilter) Matches(domain Domain, config ConfigurationFile) bool {
+	if f.Tag != "" && !domain.HasTag(f.Tag) {
+		return false
+	}
+	if f.Group != "" && !strings.EqualFold(domain.Group, f.Group) {
+		return false
+	}
+	if f.Owner != "" && !containsFold(domain.Owners, f.Owner) && !containsFold(config.ExpandRecipients(domain.Owners), f.Owner) {
+		return false
+	}
+	if f.State != "" && domain.State() != f.State {
+		return false
+	}
+	return true
+}
+
+// Filter returns the domains that pass the filter
+func (f DomainFilter) Filter(domains []Domain, config ConfigurationFile) []Domain {
+	if f.Empty() {
+		return domains
+	}
+	matching := []Domain{}
+	for _, domain := range domains {
+		if f.Matches(domain, config) {
+			matching = append(matching, domain)
+		}
+	}
+	return matching
+}
+
+// DomainTags returns every tag in use, sorted
+func DomainTags(domains []Domain) []string {
+	return distinct(domains, func(d Domain) []string { return d.Tags })
+}
+
+// DomainGroups returns every group in use, sorted
+func DomainGroups(domains []Domain) []string {
+	return distinct(domains, func(d Domain) []string { return []string{d.Group} })
+}
+
+func distinct(domains []Domain, values func(Domain) []string) []string {
+	seen := map[string]bool{}
+	list := []string{}
+	for _, domain := range domains {
+		for _, value := range values(domain) {
+			if value != "" && !seen[value] {
+				seen[value] = true
+				list = append(list, value)
+			}
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Normalize cleans up user input: recipient and tag lists may be sent as comma separated strings by forms
+func (d *Domain) Normalize() {
+	d.FQDN = strings.ToLower(strings.TrimSpace(d.FQDN))
+	d.Notifier = strings.TrimSpace(d.Notifier)
+	d.Owners = normalizeList(d.Owners)
+	d.CC = normalizeList(d.CC)
+	d.Group = strings.TrimSpace(d.Group)
+	d.Notes = strings.TrimSpace(d.Notes)
+	selectors := []string{}
+	for _, selector := range normalizeList(d.DKIMSelectors) {
+		if selector = strings.ToLower(selector); !containsFold(selectors, selector) {
+			selectors = append(selectors, selector)
+		}
+	}
+	d.DKIMSelectors = selectors
+	if len(selectors) == 0 {
+		d.DKIMSelectors = nil
+	}
+	tags := []string{}
+	for _, tag := range normalizeList(d.Tags) {
+		if tag = strings.ToLower(tag); !containsFold(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	d.Tags = tags
+	if len(tags) == 0 {
+		d.Tags = nil
+	}
+}
+
+// Validate checks the alert routing of a domain
+func (d Domain) Validate() error {
+	var errs ValidationErrors
+	for _, field := range []struct {
+		key  string
+		list []string
+	}{{"owners", d.Owners}, {"cc", d.CC}} {
+		for _, recipient := range field.list {
+			if !IsRecipient(recipient) {
+				errs = append(errs, FieldError{Section: "domain", Key: field.key, Message: fmt.Sprintf("must only contain email addresses or contact group names, got %q", recipient)})
+				break
+			}
+		}
+	}
+	if d.Notifier != "" && !IsWebURL(d.Notifier) {
+		errs = append(errs, FieldError{Section: "domain", Key: "notifier", Message: fmt.Sprintf("must be an http(s) webhook URL, got %q", d.Notifier)})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func normalizeList(list []string) []string {
+	normalized := []string{}
+	for _, item := range list {
+		normalized = append(normalized, SplitList(item)...)
+	}
+	if len(normalized) == 0 {
+		return nil
+	}
+	return normalized
 }
 
 // The file content of the domain configuration file
 type DomainFile struct {
+	// Version of the file format, used for migrations
+	Version int `yaml:"version" json:"version"`
 	// List of monitored domains
 	Domains []Domain `yaml:"domains" json:"domains"`
 }
 
-// The saved domains that are monitored
+// The saved domains that are monitored. It is shared by the schedulers, the services and the web handlers, so it is
+// always used as a pointer and guards the domain list with a lock.
 type DomainConfiguration struct {
+	mu sync.Mutex
 	// List of domains
 	DomainFile DomainFile
 	// Filepath of the domain configuration
 	Filepath string
 }
 
-func (dc DomainConfiguration) Flush() {
-	var buf bytes.Buffer
-	encoder := yaml.NewEncoder(&buf)
-	encoder.SetIndent(4)
-	err := encoder.Encode(dc.DomainFile)
+// Flush the domain list to its storage
+func (dc *DomainConfiguration) Flush() {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.flush()
+}
+
+func (dc *DomainConfiguration) flush() {
+	// Always write the current file format version
+	dc.DomainFile.Version = DomainsVersion
+
+	data, err := MarshalYAML(dc.DomainFile)
 	if err != nil {
 		log.Println("Error while marshalling domain table")
 		log.Fatalf("error: %v", err)
 	}
-	encoder.Close()
-	
-	// Process the YAML to ensure all string values are quoted
-	data := quoteYAMLStrings(buf.Bytes())
 
-	file, err := os.Create(dc.Filepath)
-	if err != nil {
-		log.Println("Error while creating domain table file")
-		log.Fatalf("error: %v", err)
+	if err := writeFileAtomic(dc.Filepath, data); err != nil {
+		log.Printf("❌ Error while writing domain table file: %v", err)
+		return
 	}
 
-	defer file.Close()
+	log.Printf("💾 Flushed domain table to %s", filepath.Base(dc.Filepath))
+}
 
-	_, err = file.Write(data)
-	if err != nil {
-		log.Println("Error while writing domain table file")
-		log.Fatalf("error: %v", err)
+// Returns a default domain configuration (empty)
+func DefaultDomainConfiguration(filepath string) *DomainConfiguration {
+	return &DomainConfiguration{
+		Filepath:   filepath,
+		DomainFile: DomainFile{Version: DomainsVersion},
 	}
+}
 
-	// Check if the file has been written
-	fileInfo, err := file.Stat()
-	if err != nil {
-		log.Println("Error while checking domain table file")
-		log.Fatalf("error: %v", err)
-	}
+// List returns a copy of the domains, in the order of the file
+func (dc *DomainConfiguration) List() []Domain {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
 
-	log.Printf("💾 Flushed domain table to %s", fileInfo.Name())
+	return append([]Domain{}, dc.DomainFile.Domains...)
 }
 
-// Returns a default domain configuration (empty)
-func DefaultDomainConfiguration(filepath string) DomainConfiguration {
-	return DomainConfiguration{
-		Filepath:   filepath,
-		DomainFile: DomainFile{},
+// Get returns a domain by its FQDN
+func (dc *DomainConfiguration) Get(fqdn string) (Domain, bool) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	for _, domain := range dc.DomainFile.Domains {
+		if domain.FQDN == fqdn {
+			return domain, true
+		}
 	}
+	return Domain{}, false
+}
+
+// HasNotifiers reports if any domain sends its alerts to a notifier webhook
+func (dc *DomainConfiguration) HasNotifiers() bool {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	for _, domain := range dc.DomainFile.Domains {
+		if domain.Notifier != "" {
+			return true
+		}
+	}
+	return false
 }
 
 // AddDomain adds a domain to the configuration
 //
 // The domain is added to the list if it doesn't exist (based on FQDN). If it does exist, we update the domain instead.
 func (dc *DomainConfiguration) AddDomain(domain Domain) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.addDomain(domain)
+}
+
+func (dc *DomainConfiguration) addDomain(domain Domain) {
 	for i, d := range dc.DomainFile.Domains {
 		if d.FQDN == domain.FQDN {
 			dc.DomainFile.Domains[i] = domain
 			log.Println("🔄 Updated domain " + domain.FQDN)
-			dc.Flush()
+			dc.flush()
 			return
 		}
 	}
@@ -100,13 +349,16 @@ func (dc *DomainConfiguration) AddDomain(domain Domain) {
 
 	log.Println("🆕 Added domain " + domain.FQDN)
 
-	dc.Flush()
+	dc.flush()
 }
 
 // RemoveDomain removes a domain from the configuration
 //
 // The domain is identified by its FQDN
 func (dc *DomainConfiguration) RemoveDomain(domain Domain) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
 	for i, d := range dc.DomainFile.Domains {
 		if d.FQDN == domain.FQDN {
 			// this creates a new slice with the domain removed (the domain to remove is at index i)
@@ -117,7 +369,7 @@ func (dc *DomainConfiguration) RemoveDomain(domain Domain) {
 
 	log.Println("🗑 Removed domain " + domain.FQDN)
 
-	dc.Flush()
+	dc.flush()
 }
 
 // UpdateDomain updates a domain in the configuration
@@ -125,6 +377,9 @@ func (dc *DomainConfiguration) RemoveDomain(domain Domain) {
 // The domain is identified by its FQDN. If the domain doesn't exist, it is added to the list.
 // For optional fields (renewalPrice, currency), existing values are preserved if only one field is provided.
 func (dc *DomainConfiguration) UpdateDomain(domain Domain) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
 	// Find existing domain to preserve optional fields
 	for i, d := range dc.DomainFile.Domains {
 		if d.FQDN == domain.FQDN {
@@ -143,12 +398,52 @@ func (dc *DomainConfiguration) UpdateDomain(domain Domain) {
 			}
 			// If both are provided, use them as-is
 			
+			// The archived state only changes with a lifecycle transition
+			domain.Archived, domain.ArchivedAt = d.Archived, d.ArchivedAt
 			dc.DomainFile.Domains[i] = domain
 			log.Println("🔄 Updated domain " + domain.FQDN)
-			dc.Flush()
+			dc.flush()
 			return
 		}
 	}
 	// Domain doesn't exist, add it
-	dc.AddDomain(domain)
+	dc.addDomain(domain)
+}
+
+// Transition moves a domain to another lifecycle state and returns the updated domain.
+//
+// A domain is paused and resumed with its enabled flag. Archiving also pauses it; restoring an archived domain makes it
+// active again. An archived domain has to be restored before it can be paused or resumed.
+func (dc *DomainConfiguration) Transition(fqdn string, transition string, now time.Time) (Domain, error) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	for i := range dc.DomainFile.Domains {
+		domain := &dc.DomainFile.Domains[i]
+		if domain.FQDN != fqdn {
+			continue
+		}
+		switch transition {
+		case TransitionPause, TransitionResume:
+			if domain.Archived {
+				return *domain, fmt.Errorf("%s is archived, restore it first", fqdn)
+			}
+			domain.Enabled = transition == TransitionResume
+		case TransitionArchive:
+			if !domain.Archived {
+				domain.Archived, domain.Enabled, domain.ArchivedAt = true, false, &now
+			}
+		case TransitionRestore:
+			if domain.Archived {
+				domain.Archived, domain.Enabled, domain.ArchivedAt = false, true, nil
+			}
+		default:
+			return *domain, fmt.Errorf("unknown transition %q, use pause, resume, archive or restore", transition)
+		}
+
+		log.Printf("🔀 Domain %s is %s", fqdn, domain.State())
+		dc.flush()
+		return *domain, nil
+	}
+	return Domain{}, errors.New("domain not found")
 }
@@ -0,0 +1,32 @@
+package configuration
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDomainNotesRoundTrip(t *testing.T) {
+	dir := ConfigDirectory{DataDir: t.TempDir()}
+	domains := DefaultDomainConfiguration(filepath.Join(dir.DataDir, Domains))
+
+	notes := map[string]string{
+		"example.com": "Kept for the \"old\" brand.\nOwner: marketing\n  - renew: yes\n# not a comment\n",
+		// Browsers submit textareas with CRLF line endings
+		"example.net": "Registrar: Example Registrar, LLC\r\nBilling: 'finance@example.com'\r\n",
+		"example.org": "single line: with a colon",
+	}
+	for _, fqdn := range []string{"example.com", "example.net", "example.org"} {
+		domains.AddDomain(Domain{FQDN: fqdn, Name: "Example", Enabled: true, Notes: notes[fqdn]})
+	}
+	domains.UpdateDomain(Domain{FQDN: "example.com", Name: "Example", Enabled: true, Alerts: true, Notes: notes["example.com"] + "Renewed twice."})
+
+	file := DomainFile{}
+	readBackRaw(t, domains.Filepath, &file)
+	if !reflect.DeepEqual(file.Domains, domains.DomainFile.Domains) {
+		t.Errorf("read back %+v, want %+v", file.Domains, domains.DomainFile.Domains)
+	}
+	if read := dir.ReadDomains(); !reflect.DeepEqual(read.DomainFile.Domains, domains.DomainFile.Domains) {
+		t.Errorf("ReadDomains returned %+v, want %+v", read.DomainFile.Domains, domains.DomainFile.Domains)
+	}
+}
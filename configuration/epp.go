@@ -0,0 +1,219 @@
+package configuration
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Severity of a domain status, from harmless to the domain not resolving or about to be lost
+const (
+	StatusSeverityOK      = "ok"
+	StatusSeverityInfo    = "info"
+	StatusSeverityWarning = "warning"
+	StatusSeverityDanger  = "danger"
+)
+
+// Locks that can be expected on a domain (alerts.expectedLocks)
+const (
+	LockTransfer = "transfer"
+	LockDelete   = "delete"
+	LockUpdate   = "update"
+)
+
+// EPPStatus is a domain status code as reported by WHOIS or RDAP, mapped to its EPP code (RFC 5731 and RFC 3915)
+type EPPStatus struct {
+	// EPP spelling of the status, e.g. "clientTransferProhibited". Unknown values keep the reported spelling.
+	Code string `json:"code"`
+	// The value as reported
+	Raw string `json:"raw"`
+	// What the status means, empty for unknown values
+	Description string `json:"description,omitempty"`
+	// ok, info, warning or danger
+	Severity string `json:"severity"`
+}
+
+// Known reports if the status was recognized
+func (s EPPStatus) Known() bool {
+	return s.Description != ""
+}
+
+// RegistryStatus is the normalized status of a domain at its registry
+type RegistryStatus struct {
+	Statuses []EPPStatus `json:"statuses"`
+	// The domain can't be transferred, deleted or changed without removing a lock first
+	TransferLocked bool `json:"transferLocked"`
+	DeleteLocked   bool `json:"deleteLocked"`
+	UpdateLocked   bool `json:"updateLocked"`
+	// The domain is not published in DNS (clientHold or serverHold)
+	Hold bool `json:"hold"`
+	// The domain expired and can only be restored at extra cost (redemptionPeriod or pendingRestore)
+	Redemption bool `json:"redemption"`
+	// The registry is about to delete the domain
+	PendingDelete bool `json:"pendingDelete"`
+	// Within the grace period after a registration, renewal or transfer
+	GracePeriod bool `json:"gracePeriod"`
+}
+
+type eppCode struct {
+	code        string
+	description string
+	severity    string
+	apply       func(*RegistryStatus)
+	// Other spellings, besides the RDAP one (RFC 8056) which matches the code when spaces and case are ignored
+	aliases []string
+}
+
+func transferLock(r *RegistryStatus)  { r.TransferLocked = true }
+func deleteLock(r *RegistryStatus)    { r.DeleteLocked = true }
+func updateLock(r *RegistryStatus)    { r.UpdateLocked = true }
+func hold(r *RegistryStatus)          { r.Hold = true }
+func redemption(r *RegistryStatus)    { r.Redemption = true }
+func pendingDelete(r *RegistryStatus) { r.PendingDelete = true }
+func gracePeriod(r *RegistryStatus)   { r.GracePeriod = true }
+
+var eppCodes = []eppCode{
+	{code: "ok", description: "No pending operations or restrictions", severity: StatusSeverityOK, aliases: []string{"active", "registered", "connect"}},
+	{code: "inactive", description: "No name servers are delegated", severity: StatusSeverityWarning},
+	{code: "clientTransferProhibited", description: "Registrar lock against transfers", severity: StatusSeverityOK, apply: transferLock, aliases: []string{"registrar-lock"}},
+	{code: "serverTransferProhibited", description: "Registry lock against transfers", severity: StatusSeverityOK, apply: transferLock, aliases: []string{"registry-lock"}},
+	{code: "transferProhibited", description: "Transfers are prohibited", severity: StatusSeverityOK, apply: transferLock},
+	{code: "clientDeleteProhibited", description: "Registrar lock against deletion", severity: StatusSeverityOK, apply: deleteLock},
+	{code: "serverDeleteProhibited", description: "Registry lock against deletion", severity: StatusSeverityOK, apply: deleteLock},
+	{code: "deleteProhibited", description: "Deletion is prohibited", severity: StatusSeverityOK, apply: deleteLock},
+	{code: "clientUpdateProhibited", description: "Registrar lock against changes", severity: StatusSeverityOK, apply: updateLock},
+	{code: "serverUpdateProhibited", description: "Registry lock against changes", severity: StatusSeverityOK, apply: updateLock},
+	{code: "updateProhibited", description: "Changes are prohibited", severity: StatusSeverityOK, apply: updateLock},
+	{code: "locked", description: "Locked against changes, transfers and deletion", severity: StatusSeverityOK, apply: func(r *RegistryStatus) {
+		transferLock(r)
+		deleteLock(r)
+		updateLock(r)
+	}},
+	{code: "clientRenewProhibited", description: "The registrar blocks renewals", severity: StatusSeverityWarning},
+	{code: "serverRenewProhibited", description: "The registry blocks renewals", severity: StatusSeverityWarning},
+	{code: "renewProhibited", description: "Renewals are prohibited", severity: StatusSeverityWarning},
+	{code: "clientHold", description: "The registrar took the domain out of DNS", severity: StatusSeverityDanger, apply: hold},
+	{code: "serverHold", description: "The registry took the domain out of DNS", severity: StatusSeverityDanger, apply: hold},
+	{code: "redemptionPeriod", description: "Expired and deleted, can only be restored at extra cost", severity: StatusSeverityDanger, apply: redemption},
+	{code: "pendingRestore", description: "Being restored from the redemption period", severity: StatusSeverityDanger, apply: redemption},
+	{code: "pendingDelete", description: "About to be deleted and released for registration", severity: StatusSeverityDanger, apply: pendingDelete},
+	{code: "pendingCreate", description: "Registration in progress", severity: StatusSeverityInfo},
+	{code: "pendingRenew", description: "Renewal in progress", severity: StatusSeverityInfo},
+	{code: "pendingTransfer", description: "Transfer to another registrar in progress", severity: StatusSeverityWarning},
+	{code: "pendingUpdate", description: "Change in progress", severity: StatusSeverityInfo},
+	{code: "addPeriod", description: "Grace period after the registration", severity: StatusSeverityInfo, apply: gracePeriod},
+	{code: "autoRenewPeriod", description: "Grace period after an automatic renewal", severity: StatusSeverityInfo, apply: gracePeriod},
+	{code: "renewPeriod", description: "Grace period after a renewal", severity: StatusSeverityInfo, apply: gracePeriod},
+	{code: "transferPeriod", description: "Grace period after a transfer", severity: StatusSeverityInfo, apply: gracePeriod},
+}
+
+// eppLookup maps the normalized spellings to the EPP codes
+var eppLookup = func() map[string]eppCode {
+	lookup := map[string]eppCode{}
+	for _, code := range eppCodes {
+		lookup[statusKey(code.code)] = code
+		for _, alias := range code.aliases {
+			lookup[statusKey(alias)] = code
+		}
+	}
+	return lookup
+}()
+
+// statusKey reduces a status to lowercase letters, so "clientTransferProhibited", "client transfer prohibited" and
+// "CLIENT-TRANSFER-PROHIBITED" match
+func statusKey(status string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, status)
+}
+
+// InterpretStatus maps the status values of a WHOIS or RDAP response to their EPP codes. WHOIS values often carry an
+// ICANN link after the code ("clientHold https://icann.org/epp#clientHold"), which is ignored. Duplicates are dropped.
+func InterpretStatus(raw []string) RegistryStatus {
+	status := RegistryStatus{Statuses: []EPPStatus{}}
+	seen := map[string]bool{}
+	for _, value := range raw {
+		value = strings.TrimSpace(value)
+		if i := strings.Index(value, "http"); i > 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		if value == "" {
+			continue
+		}
+
+		parsed := EPPStatus{Code: value, Raw: value, Severity: StatusSeverityInfo}
+		if code, ok := eppLookup[statusKey(value)]; ok {
+			parsed.Code, parsed.Description, parsed.Severity = code.code, code.description, code.severity
+			if code.apply != nil {
+				code.apply(&status)
+			}
+		}
+		if seen[parsed.Code] {
+			continue
+		}
+		seen[parsed.Code] = true
+		status.Statuses = append(status.Statuses, parsed)
+	}
+	return status
+}
+
+// Dangerous reports if the domain doesn't resolve or is about to be lost
+func (r RegistryStatus) Dangerous() bool {
+	return r.Hold || r.Redemption || r.PendingDelete
+}
+
+// Known reports if the registry reported any status
+func (r RegistryStatus) Known() bool {
+	return len(r.Statuses) > 0
+}
+
+// MissingLocks returns the expected locks (transfer, delete, update) the domain doesn't have. Nothing is missing while
+// the status is unknown or the domain is already in danger.
+func (r RegistryStatus) MissingLocks(expected []string) []string {
+	missing := []string{}
+	if !r.Known() || r.Dangerous() {
+		return missing
+	}
+	for _, lock := range expected {
+		switch lock {
+		case LockTransfer:
+			if !r.TransferLocked {
+				missing = append(missing, lock)
+			}
+		case LockDelete:
+			if !r.DeleteLocked {
+				missing = append(missing, lock)
+			}
+		case LockUpdate:
+			if !r.UpdateLocked {
+				missing = append(missing, lock)
+			}
+		}
+	}
+	return missing
+}
+
+// Alerts returns the status alerts that apply to the domain
+func (r RegistryStatus) Alerts() []Alert {
+	alerts := []Alert{}
+	if r.Hold {
+		alerts = append(alerts, AlertHold)
+	}
+	if r.Redemption {
+		alerts = append(alerts, AlertRedemption)
+	}
+	if r.PendingDelete {
+		alerts = append(alerts, AlertPendingDelete)
+	}
+	return alerts
+}
+
+// RegistryStatus interprets the status values of the WHOIS entry
+func (w *WhoisCache) RegistryStatus() RegistryStatus {
+	if w == nil || w.WhoisInfo.Domain == nil {
+		return InterpretStatus(nil)
+	}
+	return InterpretStatus(w.WhoisInfo.Domain.Status)
+}
@@ -0,0 +1,194 @@
+package configuration
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	whoisparser "github.com/likexian/whois-parser"
+)
+
+var allLocks = []string{LockTransfer, LockDelete, LockUpdate}
+
+// readWhoisSample parses a WHOIS response or RDAP domain object captured in testdata
+func readWhoisSample(t *testing.T, name string) whoisparser.WhoisInfo {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Ext(name) == ".json" {
+		var rdap rdapDomain
+		if err := json.Unmarshal(data, &rdap); err != nil {
+			t.Fatal(err)
+		}
+		return convertRDAPToWhoisInfo(rdap, rdap.LDHName)
+	}
+	info, err := whoisparser.Parse(string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestRegistryStatusOfSamples(t *testing.T) {
+	tests := []struct {
+		sample    string
+		codes     []string
+		dangerous bool
+		alerts    []Alert
+		missing   []string
+	}{
+		{
+			sample:    "whois-com-clienthold.txt",
+			codes:     []string{"clientHold", "clientTransferProhibited"},
+			dangerous: true,
+			alerts:    []Alert{AlertHold},
+			missing:   []string{},
+		},
+		{
+			sample:    "rdap-serverhold.json",
+			codes:     []string{"serverHold", "clientTransferProhibited", "serverTransferProhibited"},
+			dangerous: true,
+			alerts:    []Alert{AlertHold},
+			missing:   []string{},
+		},
+		{
+			sample:    "whois-com-redemption.txt",
+			codes:     []string{"redemptionPeriod"},
+			dangerous: true,
+			alerts:    []Alert{AlertRedemption},
+			missing:   []string{},
+		},
+		{
+			sample:    "rdap-redemption.json",
+			codes:     []string{"redemptionPeriod", "pendingRestore"},
+			dangerous: true,
+			alerts:    []Alert{AlertRedemption},
+			missing:   []string{},
+		},
+		{
+			sample:    "whois-com-pendingdelete.txt",
+			codes:     []string{"pendingDelete", "redemptionPeriod"},
+			dangerous: true,
+			alerts:    []Alert{AlertRedemption, AlertPendingDelete},
+			missing:   []string{},
+		},
+		{
+			sample:  "whois-com-transferlock.txt",
+			codes:   []string{"clientTransferProhibited"},
+			alerts:  []Alert{},
+			missing: []string{LockDelete, LockUpdate},
+		},
+		{
+			sample:  "rdap-locked.json",
+			codes:   []string{"clientDeleteProhibited", "clientTransferProhibited", "clientUpdateProhibited", "ok"},
+			alerts:  []Alert{},
+			missing: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sample, func(t *testing.T) {
+			info := readWhoisSample(t, tt.sample)
+			entry := &WhoisCache{FQDN: info.Domain.Domain, WhoisInfo: info}
+			status := entry.RegistryStatus()
+
+			codes := []string{}
+			for _, s := range status.Statuses {
+				codes = append(codes, s.Code)
+			}
+			if !reflect.DeepEqual(codes, tt.codes) {
+				t.Errorf("codes = %v, want %v", codes, tt.codes)
+			}
+			if status.Dangerous() != tt.dangerous {
+				t.Errorf("Dangerous() = %v, want %v", status.Dangerous(), tt.dangerous)
+			}
+			if alerts := status.Alerts(); !reflect.DeepEqual(alerts, tt.alerts) {
+				t.Errorf("Alerts() = %v, want %v", alerts, tt.alerts)
+			}
+			if missing := status.MissingLocks(allLocks); !reflect.DeepEqual(missing, tt.missing) {
+				t.Errorf("MissingLocks() = %v, want %v", missing, tt.missing)
+			}
+		})
+	}
+}
+
+func TestInterpretStatusSpellings(t *testing.T) {
+	tests := []struct {
+		raw      string
+		code     string
+		severity string
+	}{
+		// WHOIS, with and without the ICANN link
+		{"clientHold https://icann.org/epp#clientHold", "clientHold", StatusSeverityDanger},
+		{"serverHold", "serverHold", StatusSeverityDanger},
+		{"redemptionPeriod https://www.icann.org/epp#redemptionPeriod", "redemptionPeriod", StatusSeverityDanger},
+		{"pendingDelete", "pendingDelete", StatusSeverityDanger},
+		{"clientTransferProhibited", "clientTransferProhibited", StatusSeverityOK},
+		// RDAP (RFC 8056)
+		{"client hold", "clientHold", StatusSeverityDanger},
+		{"server hold", "serverHold", StatusSeverityDanger},
+		{"redemption period", "redemptionPeriod", StatusSeverityDanger},
+		{"pending delete", "pendingDelete", StatusSeverityDanger},
+		{"client transfer prohibited", "clientTransferProhibited", StatusSeverityOK},
+		{"active", "ok", StatusSeverityOK},
+		// Registry specific spellings
+		{"CLIENT-HOLD", "clientHold", StatusSeverityDanger},
+		{"PENDING-DELETE", "pendingDelete", StatusSeverityDanger},
+		{"registrar-lock", "clientTransferProhibited", StatusSeverityOK},
+		{"connect", "ok", StatusSeverityOK},
+		// Unknown values keep their spelling
+		{"someRegistryThing", "someRegistryThing", StatusSeverityInfo},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			status := InterpretStatus([]string{tt.raw})
+			if len(status.Statuses) != 1 {
+				t.Fatalf("got %d statuses, want 1", len(status.Statuses))
+			}
+			got := status.Statuses[0]
+			if got.Code != tt.code || got.Severity != tt.severity {
+				t.Errorf("got %s (%s), want %s (%s)", got.Code, got.Severity, tt.code, tt.severity)
+			}
+		})
+	}
+}
+
+func TestInterpretStatusSameStatusInBothSpellings(t *testing.T) {
+	status := InterpretStatus([]string{"clientHold https://icann.org/epp#clientHold", "client hold", "", "  "})
+	if len(status.Statuses) != 1 || status.Statuses[0].Code != "clientHold" {
+		t.Errorf("statuses = %v, want only clientHold", status.Statuses)
+	}
+	if !status.Hold {
+		t.Error("Hold = false, want true")
+	}
+}
+
+func TestMissingLocks(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      []string
+		expected []string
+		missing  []string
+	}{
+		{"no transfer lock", []string{"ok"}, []string{LockTransfer}, []string{LockTransfer}},
+		{"transfer lock only", []string{"clientTransferProhibited"}, allLocks, []string{LockDelete, LockUpdate}},
+		{"registry locks count", []string{"serverTransferProhibited", "serverDeleteProhibited", "serverUpdateProhibited"}, allLocks, []string{}},
+		{"locked covers everything", []string{"locked"}, allLocks, []string{}},
+		{"unknown status", nil, allLocks, []string{}},
+		{"nothing expected", []string{"ok"}, nil, []string{}},
+		{"on hold", []string{"clientHold"}, allLocks, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if missing := InterpretStatus(tt.raw).MissingLocks(tt.expected); !reflect.DeepEqual(missing, tt.missing) {
+				t.Errorf("MissingLocks(%v) = %v, want %v", tt.expected, missing, tt.missing)
+			}
+		})
+	}
+}
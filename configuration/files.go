@@ -0,0 +1,365 @@
+package configuration
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Name of the directory (inside the data directory) where a restore is staged until the next start
+const restoreStagingDir = ".restore"
+
+// DataFile describes a file in the data directory that is versioned, migrated and included in backups
+type DataFile struct {
+	// File name, relative to the data directory
+	Name string `json:"name"`
+	// Current format version
+	Version int `json:"version"`
+}
+
+// DataFiles returns every file in the data directory that belongs to the application state
+func DataFiles() []DataFile {
+	files := []DataFile{}
+	for _, f := range versionedFiles() {
+		files = append(files, DataFile{Name: f.Name, Version: f.Version})
+	}
+	return files
+}
+
+// IsDataFile reports if name is one of the known data files
+func IsDataFile(name string) bool {
+	for _, f := range DataFiles() {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalYAML encodes a data file the same way it is written to disk
+func MarshalYAML(v interface{}) ([]byte, error) {
+	var node yaml.Node
+	if err := node.Encode(v); err != nil {
+		return nil, err
+	}
+	quoteYAMLStrings(&node)
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(4)
+	if err := encoder.Encode(&node); err != nil {
+		return nil, err
+	}
+	encoder.Close()
+	return buf.Bytes(), nil
+}
+
+// quoteYAMLStrings quotes the string values of the mappings, so a value is never read back as another type. Multi-line
+// values are written as literal blocks instead, which keep their content as is.
+func quoteYAMLStrings(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode {
+		for i := 1; i < len(node.Content); i += 2 {
+			value := node.Content[i]
+			if value.Kind != yaml.ScalarNode {
+				continue
+			}
+			switch value.ShortTag() {
+			case "!!bool", "!!int", "!!float", "!!null":
+				continue
+			}
+			value.Tag = "!!str"
+			if strings.Contains(value.Value, "\n") {
+				value.Style = yaml.LiteralStyle
+			} else {
+				value.Style = yaml.DoubleQuotedStyle
+			}
+		}
+	}
+	for _, child := range node.Content {
+		quoteYAMLStrings(child)
+	}
+}
+
+// ReplaceFiles swaps the given data files into the data directory.
+//
+// Every file is first written and synced next to its destination, and only once all of them are staged are they
+// renamed into place, so a failure while writing leaves the current data untouched. The replaced files are rotated into
+// the regular backups.
+func (dir ConfigDirectory) ReplaceFiles(files map[string][]byte) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		if !IsDataFile(name) {
+			return fmt.Errorf("%s is not a data file", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	staged := map[string]string{}
+	defer func() {
+		for _, tmp := range staged {
+			os.Remove(tmp)
+		}
+	}()
+	for _, name := range names {
+		tmp, err := os.CreateTemp(dir.DataDir, "."+name+".restore-*")
+		if err != nil {
+			return err
+		}
+		staged[name] = tmp.Name()
+		_, err = tmp.Write(files[name])
+		if err == nil {
+			err = tmp.Sync()
+		}
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("unable to stage %s: %w", name, err)
+		}
+	}
+
+	for _, name := range names {
+		path := filepath.Join(dir.DataDir, name)
+		if err := rotateBackups(path); err != nil {
+			log.Printf("⚠️ Unable to rotate backups of %s: %s", name, err)
+		}
+		if err := os.Rename(staged[name], path); err != nil {
+			return fmt.Errorf("unable to swap in %s: %w", name, err)
+		}
+		delete(staged, name)
+		log.Printf("♻️ Restored %s", name)
+	}
+
+	if d, err := os.Open(dir.DataDir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// StageRestore saves files to be swapped into the data directory on the next start (see ApplyStagedRestore).
+// This is used while the server is running, since it would otherwise overwrite the restored files from memory.
+func (dir ConfigDirectory) StageRestore(files map[string][]byte) error {
+	staging := filepath.Join(dir.DataDir, restoreStagingDir)
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(staging, 0o700); err != nil {
+		return err
+	}
+	for name, data := range files {
+		if !IsDataFile(name) {
+			return fmt.Errorf("%s is not a data file", name)
+		}
+		if err := os.WriteFile(filepath.Join(staging, name), data, 0o600); err != nil {
+			return err
+		}
+	}
+	log.Printf("📦 Staged %d files to be restored on the next start", len(files))
+	return nil
+}
+
+// HasStagedRestore reports if files staged by StageRestore are waiting for the next start
+func (dir ConfigDirectory) HasStagedRestore() bool {
+	_, err := os.Stat(filepath.Join(dir.DataDir, restoreStagingDir))
+	return err == nil
+}
+
+// ApplyStagedRestore swaps in files staged by StageRestore, if there are any. It must run before the data files are
+// read or migrated.
+func (dir ConfigDirectory) ApplyStagedRestore() error {
+	staging := filepath.Join(dir.DataDir, restoreStagingDir)
+	entries, err := os.ReadDir(staging)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	files := map[string][]byte{}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(staging, entry.Name()))
+		if err != nil {
+			return err
+		}
+		files[entry.Name()] = data
+	}
+	log.Printf("📦 Applying staged restore of %d files", len(files))
+	if err := dir.ReplaceFiles(files); err != nil {
+		return err
+	}
+	return os.RemoveAll(staging)
+}
+
+// writeFileAtomic replaces the file at path with data without ever leaving a partially written file behind.
+//
+// The data is written to a temporary file in the same directory, synced to disk and then renamed over the original.
+// Before the rename, the current file is rotated into the backups (`<file>.1` being the newest).
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// Clean up the temp file if anything below fails (after a successful rename this is a no-op)
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o600); err != nil {
+		return err
+	}
+
+	if err := rotateBackups(path); err != nil {
+		log.Printf("⚠️ Unable to rotate backups of %s: %s", filepath.Base(path), err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// Sync the directory so the rename itself survives a crash
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
+
+// backupPath returns the path of the n-th backup of a file (1 is the newest)
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// rotateBackups shifts the existing backups of a file up by one and copies the current file to `<file>.1`.
+// Only FileBackupCount backups are kept. A current file that doesn't parse is not rotated in, so a corrupt file never
+// pushes a good backup out.
+func rotateBackups(path string) error {
+	if FileBackupCount < 1 {
+		return nil
+	}
+	current, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := parseYAML(current, &map[string]interface{}{}); err != nil {
+		log.Printf("⚠️ Not keeping %s as a backup, it is corrupt: %s", filepath.Base(path), err)
+		return nil
+	}
+
+	os.Remove(backupPath(path, FileBackupCount))
+	for n := FileBackupCount - 1; n >= 1; n-- {
+		if err := os.Rename(backupPath(path, n), backupPath(path, n+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.WriteFile(backupPath(path, 1), current, 0o600)
+}
+
+// readYAMLFile reads a YAML data file into out. If the file exists but can't be parsed (e.g. it was truncated by a
+// crash), the newest backup that parses is restored in its place and used instead.
+//
+// Returns os.ErrNotExist if there is no file at path.
+func readYAMLFile(path string, out interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	parseErr := parseYAML(data, out)
+	if parseErr == nil {
+		return nil
+	}
+
+	log.Printf("🚨 %s is corrupt (%s), trying to recover from backups", filepath.Base(path), parseErr)
+	data, err = recoverFromBackup(path, func(data []byte) error { return parseYAML(data, out) })
+	if err != nil {
+		return fmt.Errorf("%s is corrupt (%w) and could not be recovered: %s", filepath.Base(path), parseErr, err)
+	}
+	return parseYAML(data, out)
+}
+
+// parseYAML unmarshals data, treating an empty file as corrupt
+func parseYAML(data []byte, out interface{}) error {
+	if len(data) == 0 {
+		return errors.New("file is empty")
+	}
+	return yaml.Unmarshal(data, out)
+}
+
+// loadYAMLFile reads a YAML data file into out like readYAMLFile, but never writes to the data directory: a corrupt
+// file is read from its newest valid backup and left in place.
+//
+// Returns os.ErrNotExist if there is no file at path.
+func loadYAMLFile(path string, out interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	parseErr := parseYAML(data, out)
+	if parseErr == nil {
+		return nil
+	}
+
+	data, _, err = newestBackup(path, func(data []byte) error { return parseYAML(data, out) })
+	if err != nil {
+		return fmt.Errorf("%s is corrupt (%w) and has no valid backup: %s", filepath.Base(path), parseErr, err)
+	}
+	return parseYAML(data, out)
+}
+
+// recoverFromBackup finds the newest backup of path accepted by valid, and writes it back in place of the file
+func recoverFromBackup(path string, valid func([]byte) error) ([]byte, error) {
+	data, backup, err := newestBackup(path, valid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the corrupt file around for inspection, then put the backup in place
+	os.Rename(path, path+".corrupt")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return nil, err
+	}
+	log.Printf("♻️ Recovered %s from %s (corrupt file kept as %s)", filepath.Base(path), filepath.Base(backup), filepath.Base(path)+".corrupt")
+	return data, nil
+}
+
+// newestBackup returns the contents and path of the newest backup of path accepted by valid
+func newestBackup(path string, valid func([]byte) error) ([]byte, string, error) {
+	for n := 1; n <= FileBackupCount; n++ {
+		backup := backupPath(path, n)
+		data, err := os.ReadFile(backup)
+		if err != nil {
+			continue
+		}
+		if err := valid(data); err != nil {
+			log.Printf("⚠️ Backup %s is not usable either: %s", filepath.Base(backup), err)
+			continue
+		}
+		return data, backup, nil
+	}
+	return nil, "", errors.New("no valid backup found")
+}
@@ -0,0 +1,85 @@
+package configuration
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Multi-line text with content that looks like YAML, quotes and trailing spaces
+const multilineText = `Registrar: Example Registrar, LLC
+Expires: 2024-10-30 ("in 1 week")
+  - indented: with a dash
+# not a comment
+'single' and "double" quotes, a trailing space
+`
+
+const multilineHTML = `<p style="font-family: Arial, sans-serif; color: #333">
+  Registrar: <b>Example Registrar</b>
+</p>`
+
+// readBackRaw parses the file at path without falling back to a backup, so a file that doesn't parse fails the test
+func readBackRaw(t *testing.T, path string, out interface{}) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parseYAML(data, out); err != nil {
+		t.Fatalf("%s doesn't parse: %s\n%s", filepath.Base(path), err, data)
+	}
+}
+
+func TestMarshalYAMLQuotesStrings(t *testing.T) {
+	data, err := MarshalYAML(map[string]interface{}{"name": "yes", "port": 3124, "enabled": true, "text": "a\nb\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{`name: "yes"`, `port: 3124`, `enabled: true`, `text: |`} {
+		if !strings.Contains(string(data), line+"\n") {
+			t.Errorf("missing %q in\n%s", line, data)
+		}
+	}
+}
+
+func TestNotificationQueueRoundTrip(t *testing.T) {
+	dir := ConfigDirectory{DataDir: t.TempDir()}
+	queue := DefaultNotificationQueueStorage(filepath.Join(dir.DataDir, NotificationQueueName))
+	item, _ := queue.Enqueue(QueuedNotification{
+		Key:     "1 week alert/example.com/2024-10-30/email/admin@example.com",
+		Channel: "email",
+		FQDN:    "example.com",
+		Alert:   "1 week alert",
+		To:      []string{"admin@example.com"},
+		Subject: `example.com expires in 1 week: "renew now"`,
+		Text:    multilineText,
+		HTML:    multilineHTML,
+		Payload: `{"text": "line one\nline two"}`,
+	})
+	// A second write rotates the first file into the backups, which only happens if it parses
+	item.LastError = "dial tcp: connection refused"
+	queue.Update(item)
+	if _, err := os.Stat(backupPath(queue.Filepath, 1)); err != nil {
+		t.Errorf("the first write wasn't kept as a backup: %s", err)
+	}
+
+	file := NotificationQueueFile{}
+	readBackRaw(t, queue.Filepath, &file)
+	if len(file.Items) != 1 {
+		t.Fatalf("read %d items, want 1", len(file.Items))
+	}
+	got := file.Items[0]
+	if got.Text != multilineText || got.HTML != multilineHTML || got.Subject != item.Subject || got.Payload != item.Payload {
+		t.Errorf("read back\n%q\n%q\n%q\n%q\nwant\n%q\n%q\n%q\n%q", got.Text, got.HTML, got.Subject, got.Payload, multilineText, multilineHTML, item.Subject, item.Payload)
+	}
+	if got.Key != item.Key || got.ID != item.ID || got.LastError != item.LastError || !got.CreatedAt.Equal(item.CreatedAt) {
+		t.Errorf("read back %+v, want %+v", got, item)
+	}
+
+	read := dir.ReadNotificationQueue()
+	if !reflect.DeepEqual(read.List(""), file.Items) {
+		t.Errorf("ReadNotificationQueue returned %+v, want %+v", read.List(""), file.Items)
+	}
+}
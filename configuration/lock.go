@@ -0,0 +1,43 @@
+package configuration
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Name of the file (inside the data directory) that is locked by the process working on the data files
+const LockName = ".lock"
+
+// ErrLocked is returned by Lock when another process, usually the server, works on the data directory
+var ErrLocked = errors.New("the data directory is in use by another domain-monitor process")
+
+// DirectoryLock is the exclusive lock on a data directory, it is held until Release is called or the process exits
+type DirectoryLock struct {
+	file *os.File
+}
+
+// Lock takes the exclusive lock on the data directory, or returns ErrLocked if another process holds it.
+//
+// The server holds the lock while it runs, since it keeps the data files in memory and overwrites every change made
+// next to it. Commands that write data files take it too, so they never run next to the server or each other.
+func (dir ConfigDirectory) Lock() (*DirectoryLock, error) {
+	file, err := os.OpenFile(filepath.Join(dir.DataDir, LockName), os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	// the pid is only informational, the lock itself is released by the system when the process exits
+	file.Truncate(0)
+	fmt.Fprintf(file, "%d\n", os.Getpid())
+	return &DirectoryLock{file: file}, nil
+}
+
+// Release gives up the lock on the data directory
+func (l *DirectoryLock) Release() error {
+	return l.file.Close()
+}
@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package configuration
+
+import "os"
+
+// There is no file locking on these systems, the data directory is not protected against concurrent use
+func lockFile(file *os.File) error {
+	return nil
+}
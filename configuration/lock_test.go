@@ -0,0 +1,23 @@
+package configuration
+
+import "testing"
+
+func TestLock(t *testing.T) {
+	dir := ConfigDirectory{DataDir: t.TempDir()}
+	lock, err := dir.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Lock(); err != ErrLocked {
+		t.Errorf("second Lock() = %v, want ErrLocked", err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = dir.Lock()
+	if err != nil {
+		t.Errorf("Lock() after Release = %v, want the lock", err)
+	} else {
+		lock.Release()
+	}
+}
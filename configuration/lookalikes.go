@@ -0,0 +1,224 @@
+package configuration
+
+import (
+	"log"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Lookalike is a registered name that could be mistaken for a monitored domain
+type Lookalike struct {
+	// The lookalike as it is registered (IDNs in their xn-- form)
+	FQDN string `yaml:"fqdn" json:"fqdn"`
+	// The lookalike as it is displayed, only set for IDNs
+	Unicode string `yaml:"unicode,omitempty" json:"unicode,omitempty"`
+	// The monitored domain it looks like
+	Of string `yaml:"of" json:"of"`
+	// How the name was derived from the domain, see PermutationKinds
+	Kind string `yaml:"kind" json:"kind"`
+	// Addresses and nameservers it resolved to on the last scan that found it
+	Addresses   []string `yaml:"addresses,omitempty" json:"addresses,omitempty"`
+	NameServers []string `yaml:"nameServers,omitempty" json:"nameServers,omitempty"`
+	// Registrar and creation date from WHOIS, empty if the lookup failed
+	Registrar string     `yaml:"registrar,omitempty" json:"registrar,omitempty"`
+	Created   *time.Time `yaml:"created,omitempty" json:"created,omitempty"`
+	// When the lookalike was first and last found
+	FirstSeen time.Time `yaml:"firstSeen" json:"firstSeen"`
+	LastSeen  time.Time `yaml:"lastSeen" json:"lastSeen"`
+	// Resolved on the last scan, a lookalike that stops resolving is kept but no longer active
+	Active bool `yaml:"active" json:"active"`
+	// Found by the first scan of the domain, which isn't alerted
+	Baseline bool `yaml:"baseline,omitempty" json:"baseline,omitempty"`
+}
+
+// Display returns the name as it is displayed, the Unicode form for IDNs
+func (l Lookalike) Display() string {
+	if l.Unicode != "" {
+		return l.Unicode
+	}
+	return l.FQDN
+}
+
+// LookalikeScan records the last scan of a monitored domain
+type LookalikeScan struct {
+	// The monitored domain
+	FQDN string `yaml:"fqdn" json:"fqdn"`
+	// When it was scanned
+	ScannedAt time.Time `yaml:"scannedAt" json:"scannedAt"`
+	// Number of generated lookalikes and how many of them resolved
+	Candidates int `yaml:"candidates" json:"candidates"`
+	Found      int `yaml:"found" json:"found"`
+	// Number of lookalikes that couldn't be resolved, e.g. because of timeouts
+	Failed int `yaml:"failed,omitempty" json:"failed,omitempty"`
+}
+
+type LookalikeFile struct {
+	// Version of the file format, used for migrations
+	Version int `yaml:"version" json:"version"`
+	// The last scan of each monitored domain
+	Scans []LookalikeScan `yaml:"scans" json:"scans"`
+	// Every lookalike found so far
+	Lookalikes []Lookalike `yaml:"lookalikes" json:"lookalikes"`
+}
+
+// LookalikeStorage keeps the lookalikes found for the monitored domains. It is shared by the scheduler and the web
+// handlers, so it is always used as a pointer and guards its contents with a lock.
+type LookalikeStorage struct {
+	mu sync.Mutex
+	// The lookalike file contents
+	FileContents LookalikeFile
+	// The path to the lookalike file
+	Filepath string
+}
+
+func DefaultLookalikeStorage(path string) *LookalikeStorage {
+	return &LookalikeStorage{
+		FileContents: LookalikeFile{Version: LookalikesVersion, Scans: []LookalikeScan{}, Lookalikes: []Lookalike{}},
+		Filepath:     path,
+	}
+}
+
+// List returns the lookalikes of a monitored domain (every lookalike for an empty fqdn), the active ones first, then by
+// domain and name
+func (s *LookalikeStorage) List(of string) []Lookalike {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := []Lookalike{}
+	for _, lookalike := range s.FileContents.Lookalikes {
+		if of == "" || lookalike.Of == of {
+			list = append(list, lookalike)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Active != list[j].Active {
+			return list[i].Active
+		}
+		if list[i].Of != list[j].Of {
+			return list[i].Of < list[j].Of
+		}
+		return list[i].FQDN < list[j].FQDN
+	})
+	return list
+}
+
+// Scans returns the last scan of each monitored domain, sorted by domain
+func (s *LookalikeStorage) Scans() []LookalikeScan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scans := append([]LookalikeScan{}, s.FileContents.Scans...)
+	sort.Slice(scans, func(i, j int) bool { return scans[i].FQDN < scans[j].FQDN })
+	return scans
+}
+
+// Record stores the result of scanning a monitored domain: the lookalikes that resolved are added or updated, the
+// other lookalikes of the domain are marked inactive, except the failed ones which couldn't be resolved. Returns the
+// lookalikes that appeared, new ones and ones that resolve again. The first scan of a domain marks its lookalikes as
+// baseline and returns none. The file isn't written, call Flush after recording a batch of scans.
+func (s *LookalikeStorage) Record(scan LookalikeScan, found []Lookalike, failed []string) []Lookalike {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	baseline := true
+	for i := range s.FileContents.Scans {
+		if s.FileContents.Scans[i].FQDN == scan.FQDN {
+			s.FileContents.Scans[i] = scan
+			baseline = false
+		}
+	}
+	if baseline {
+		s.FileContents.Scans = append(s.FileContents.Scans, scan)
+	}
+
+	resolved := map[string]Lookalike{}
+	for _, lookalike := range found {
+		resolved[lookalike.FQDN] = lookalike
+	}
+
+	appeared := []Lookalike{}
+	for i := range s.FileContents.Lookalikes {
+		existing := &s.FileContents.Lookalikes[i]
+		if existing.Of != scan.FQDN {
+			continue
+		}
+		lookalike, ok := resolved[existing.FQDN]
+		if !ok {
+			if !contains(failed, existing.FQDN) {
+				existing.Active = false
+			}
+			continue
+		}
+		delete(resolved, existing.FQDN)
+		wasActive := existing.Active
+		lookalike.FirstSeen, lookalike.Baseline = existing.FirstSeen, existing.Baseline
+		*existing = lookalike
+		if !wasActive {
+			appeared = append(appeared, lookalike)
+		}
+	}
+	for _, lookalike := range found {
+		if _, ok := resolved[lookalike.FQDN]; !ok {
+			continue
+		}
+		lookalike.FirstSeen = scan.ScannedAt
+		lookalike.Baseline = baseline
+		s.FileContents.Lookalikes = append(s.FileContents.Lookalikes, lookalike)
+		if !baseline {
+			appeared = append(appeared, lookalike)
+		}
+	}
+	return appeared
+}
+
+// Forget removes the scan and lookalikes of a domain that is no longer monitored. Returns true if there was something
+// to remove. The file isn't written.
+func (s *LookalikeStorage) Forget(fqdn string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := false
+	scans := s.FileContents.Scans[:0]
+	for _, scan := range s.FileContents.Scans {
+		if scan.FQDN == fqdn {
+			removed = true
+			continue
+		}
+		scans = append(scans, scan)
+	}
+	s.FileContents.Scans = scans
+	lookalikes := s.FileContents.Lookalikes[:0]
+	for _, lookalike := range s.FileContents.Lookalikes {
+		if lookalike.Of == fqdn {
+			removed = true
+			continue
+		}
+		lookalikes = append(lookalikes, lookalike)
+	}
+	s.FileContents.Lookalikes = lookalikes
+	return removed
+}
+
+// Flush the lookalikes to their storage
+func (s *LookalikeStorage) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Always write the current file format version
+	s.FileContents.Version = LookalikesVersion
+
+	data, err := MarshalYAML(s.FileContents)
+	if err != nil {
+		log.Printf("❌ Error while marshalling the lookalikes: %v", err)
+		return
+	}
+
+	if err := writeFileAtomic(s.Filepath, data); err != nil {
+		log.Printf("❌ Error while writing lookalikes file: %v", err)
+		return
+	}
+
+	log.Printf("💾 Flushed lookalikes to %s", filepath.Base(s.Filepath))
+}
@@ -0,0 +1,265 @@
+package configuration
+
+import (
+	"log"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MailSecurityReport is the email security posture of a monitored domain: its SPF, DMARC, DKIM, MTA-STS, TLS-RPT and
+// BIMI records
+type MailSecurityReport struct {
+	// The checked domain
+	FQDN string `yaml:"fqdn" json:"fqdn"`
+	// When the domain was last checked
+	CheckedAt time.Time `yaml:"checkedAt" json:"checkedAt"`
+	// When the last complete check finished, the results are from that check
+	Succeeded time.Time `yaml:"succeeded,omitempty" json:"succeeded,omitempty"`
+	// Why the last check couldn't be completed (e.g. a DNS timeout), empty if it was
+	Error string `yaml:"error,omitempty" json:"error,omitempty"`
+	// Score from 0 to 100, see Grade
+	Score  int         `yaml:"score" json:"score"`
+	SPF    SPFCheck    `yaml:"spf" json:"spf"`
+	DMARC  DMARCCheck  `yaml:"dmarc" json:"dmarc"`
+	DKIM   []DKIMCheck `yaml:"dkim,omitempty" json:"dkim,omitempty"`
+	MTASTS MTASTSCheck `yaml:"mtaSts" json:"mtaSts"`
+	TLSRPT TLSRPTCheck `yaml:"tlsRpt" json:"tlsRpt"`
+	BIMI   BIMICheck   `yaml:"bimi" json:"bimi"`
+	// Problems found by the check, in the order of the records
+	Findings []string `yaml:"findings,omitempty" json:"findings,omitempty"`
+}
+
+// Grade returns the letter of the score: A from 90, B from 75, C from 60, D from 40, F below
+func (r MailSecurityReport) Grade() string {
+	switch {
+	case r.Score >= 90:
+		return "A"
+	case r.Score >= 75:
+		return "B"
+	case r.Score >= 60:
+		return "C"
+	case r.Score >= 40:
+		return "D"
+	}
+	return "F"
+}
+
+// Complete reports if the domain was checked completely at least once
+func (r MailSecurityReport) Complete() bool {
+	return !r.Succeeded.IsZero()
+}
+
+// SPFCheck is the SPF record of a domain
+type SPFCheck struct {
+	// The record, empty if the domain has none
+	Record string `yaml:"record,omitempty" json:"record,omitempty"`
+	// DNS lookups needed to evaluate the record, including the included records (at most 10 are allowed)
+	Lookups int `yaml:"lookups,omitempty" json:"lookups,omitempty"`
+	// The final mechanism, e.g. "-all", empty if the record doesn't end in one
+	All string `yaml:"all,omitempty" json:"all,omitempty"`
+	// Why the record is invalid, empty if it is valid
+	Error string `yaml:"error,omitempty" json:"error,omitempty"`
+}
+
+// Valid reports if the domain has a valid SPF record
+func (c SPFCheck) Valid() bool {
+	return c.Record != "" && c.Error == ""
+}
+
+// DMARCCheck is the DMARC record of a domain
+type DMARCCheck struct {
+	// The record, empty if the domain has none
+	Record string `yaml:"record,omitempty" json:"record,omitempty"`
+	// The policy (none, quarantine or reject) and the policy of the subdomains
+	Policy          string `yaml:"policy,omitempty" json:"policy,omitempty"`
+	SubdomainPolicy string `yaml:"subdomainPolicy,omitempty" json:"subdomainPolicy,omitempty"`
+	// Percentage of the mail the policy is applied to
+	Percent int `yaml:"percent,omitempty" json:"percent,omitempty"`
+	// Where the aggregate reports are sent
+	Reports []string `yaml:"reports,omitempty" json:"reports,omitempty"`
+	// Why the record is invalid, empty if it is valid
+	Error string `yaml:"error,omitempty" json:"error,omitempty"`
+}
+
+// Valid reports if the domain has a valid DMARC record
+func (c DMARCCheck) Valid() bool {
+	return c.Record != "" && c.Error == ""
+}
+
+// DKIMCheck is the DKIM key of a selector
+type DKIMCheck struct {
+	// The selector, the key is published at <selector>._domainkey.<domain>
+	Selector string `yaml:"selector" json:"selector"`
+	// The key type (rsa or ed25519) and its size in bits
+	KeyType string `yaml:"keyType,omitempty" json:"keyType,omitempty"`
+	KeyBits int    `yaml:"keyBits,omitempty" json:"keyBits,omitempty"`
+	// The key was revoked by publishing an empty key
+	Revoked bool `yaml:"revoked,omitempty" json:"revoked,omitempty"`
+	// Why the key is missing or invalid, empty if it is valid
+	Error string `yaml:"error,omitempty" json:"error,omitempty"`
+}
+
+// Valid reports if the selector has a usable key
+func (c DKIMCheck) Valid() bool {
+	return c.KeyType != "" && !c.Revoked && c.Error == ""
+}
+
+// MTASTSCheck is the MTA-STS record of a domain and the policy it announces
+type MTASTSCheck struct {
+	// The record, empty if the domain has none
+	Record string `yaml:"record,omitempty" json:"record,omitempty"`
+	// The policy id of the record
+	ID string `yaml:"id,omitempty" json:"id,omitempty"`
+	// The mode (enforce, testing or none), the MX patterns and the max age (in seconds) of the fetched policy
+	Mode   string   `yaml:"mode,omitempty" json:"mode,omitempty"`
+	MX     []string `yaml:"mx,omitempty" json:"mx,omitempty"`
+	MaxAge int      `yaml:"maxAge,omitempty" json:"maxAge,omitempty"`
+	// Why the record or the policy is invalid or the policy couldn't be fetched, empty if both are valid
+	Error string `yaml:"error,omitempty" json:"error,omitempty"`
+}
+
+// Valid reports if the domain has a valid MTA-STS record and policy
+func (c MTASTSCheck) Valid() bool {
+	return c.Record != "" && c.Error == ""
+}
+
+// TLSRPTCheck is the TLS reporting (TLS-RPT) record of a domain
+type TLSRPTCheck struct {
+	// The record, empty if the domain has none
+	Record string `yaml:"record,omitempty" json:"record,omitempty"`
+	// Where the reports are sent
+	Reports []string `yaml:"reports,omitempty" json:"reports,omitempty"`
+	// Why the record is invalid, empty if it is valid
+	Error string `yaml:"error,omitempty" json:"error,omitempty"`
+}
+
+// Valid reports if the domain has a valid TLS-RPT record
+func (c TLSRPTCheck) Valid() bool {
+	return c.Record != "" && c.Error == ""
+}
+
+// BIMICheck is the default BIMI record of a domain
+type BIMICheck struct {
+	// The record, empty if the domain has none
+	Record string `yaml:"record,omitempty" json:"record,omitempty"`
+	// The logo URL and the URL of the mark certificate
+	Logo      string `yaml:"logo,omitempty" json:"logo,omitempty"`
+	Authority string `yaml:"authority,omitempty" json:"authority,omitempty"`
+	// Why the record is invalid, empty if it is valid
+	Error string `yaml:"error,omitempty" json:"error,omitempty"`
+}
+
+// Valid reports if the domain has a valid BIMI record
+func (c BIMICheck) Valid() bool {
+	return c.Record != "" && c.Error == ""
+}
+
+type MailSecurityFile struct {
+	// Version of the file format, used for migrations
+	Version int `yaml:"version" json:"version"`
+	// The last report of each checked domain
+	Reports []MailSecurityReport `yaml:"reports" json:"reports"`
+}
+
+// MailSecurityStorage keeps the email security reports of the monitored domains. It is shared by the scheduler and the
+// web handlers, so it is always used as a pointer and guards its contents with a lock.
+type MailSecurityStorage struct {
+	mu sync.Mutex
+	// The mail security file contents
+	FileContents MailSecurityFile
+	// The path to the mail security file
+	Filepath string
+}
+
+func DefaultMailSecurityStorage(path string) *MailSecurityStorage {
+	return &MailSecurityStorage{
+		FileContents: MailSecurityFile{Version: MailSecurityVersion, Reports: []MailSecurityReport{}},
+		Filepath:     path,
+	}
+}
+
+// List returns the report of every checked domain, sorted by name
+func (s *MailSecurityStorage) List() []MailSecurityReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := append([]MailSecurityReport{}, s.FileContents.Reports...)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].FQDN < list[j].FQDN })
+	return list
+}
+
+// Get returns the report of a domain
+func (s *MailSecurityStorage) Get(fqdn string) (MailSecurityReport, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, report := range s.FileContents.Reports {
+		if report.FQDN == fqdn {
+			return report, true
+		}
+	}
+	return MailSecurityReport{}, false
+}
+
+// Record stores the report of a check. A check that couldn't be completed only updates the time and the error, the
+// results of the last complete check are kept. Returns the last complete report the new one replaced, and false if
+// there was none to compare it with. The file isn't written.
+func (s *MailSecurityStorage) Record(report MailSecurityReport) (MailSecurityReport, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.FileContents.Reports {
+		existing := &s.FileContents.Reports[i]
+		if existing.FQDN != report.FQDN {
+			continue
+		}
+		if report.Error != "" {
+			existing.CheckedAt, existing.Error = report.CheckedAt, report.Error
+			return MailSecurityReport{}, false
+		}
+		previous := *existing
+		*existing = report
+		return previous, previous.Complete()
+	}
+	s.FileContents.Reports = append(s.FileContents.Reports, report)
+	return MailSecurityReport{}, false
+}
+
+// Forget removes the report of a domain that is no longer monitored. Returns true if there was one. The file isn't
+// written.
+func (s *MailSecurityStorage) Forget(fqdn string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, report := range s.FileContents.Reports {
+		if report.FQDN == fqdn {
+			s.FileContents.Reports = append(s.FileContents.Reports[:i], s.FileContents.Reports[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Flush the reports to their storage
+func (s *MailSecurityStorage) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Always write the current file format version
+	s.FileContents.Version = MailSecurityVersion
+
+	data, err := MarshalYAML(s.FileContents)
+	if err != nil {
+		log.Printf("❌ Error while marshalling the mail security reports: %v", err)
+		return
+	}
+
+	if err := writeFileAtomic(s.Filepath, data); err != nil {
+		log.Printf("❌ Error while writing mail security file: %v", err)
+		return
+	}
+
+	log.Printf("💾 Flushed mail security reports to %s", filepath.Base(s.Filepath))
+}
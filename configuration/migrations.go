@@ -0,0 +1,315 @@
+package configuration
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+)
+
+// Current on-disk format versions of the data files. When a format changes, bump the version and append a
+// migration to the matching list below.
+const (
+	AppConfigVersion         = 2
+	DomainsVersion           = 2
+	WhoisCacheVersion        = 1
+	AlertLedgerVersion       = 1
+	NotificationQueueVersion = 1
+	SnoozesVersion           = 1
+	WatchlistVersion         = 1
+	LookalikesVersion        = 1
+	CertificatesVersion      = 1
+	SubdomainsVersion        = 1
+	MailSecurityVersion      = 1
+	DNSSECVersion            = 1
+	DelegationVersion        = 1
+)
+
+// A Migration upgrades a data file document to Version. Documents are handled as generic YAML maps so a migration
+// doesn't depend on the current shape of the Go structs.
+type Migration struct {
+	// The version this migration upgrades the document to
+	Version int
+	// Short description, used for logging
+	Description string
+	// Apply the migration to the document in place
+	Migrate func(doc map[string]interface{}) error
+}
+
+// ErrNewerVersion is returned when a data file was written by a newer version of domain-monitor
+type ErrNewerVersion struct {
+	File      string
+	Version   int
+	Supported int
+}
+
+func (e *ErrNewerVersion) Error() string {
+	return fmt.Sprintf("%s has version %d, but this build only supports up to version %d", e.File, e.Version, e.Supported)
+}
+
+// ErrOlderVersion is returned by CheckVersions when a data file still has to be migrated
+type ErrOlderVersion struct {
+	File    string
+	Version int
+	Current int
+}
+
+func (e *ErrOlderVersion) Error() string {
+	return fmt.Sprintf("%s has version %d and has to be migrated to version %d, start the server once to migrate it", e.File, e.Version, e.Current)
+}
+
+// A versioned data file and the migrations to bring it to the current version
+type versionedFile struct {
+	Name       string
+	Version    int
+	Migrations []Migration
+}
+
+var appConfigMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "replace smtp.secure with smtp.encryptionType",
+		Migrate: func(doc map[string]interface{}) error {
+			smtp, ok := doc["smtp"].(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			encryptionType, _ := smtp["encryptionType"].(string)
+			switch encryptionType {
+			case "":
+				// The secure flag was never used to pick the connection type, the port was
+				port, _ := smtp["port"].(int)
+				if port == 25 {
+					encryptionType = "none"
+				} else if port == 465 {
+					encryptionType = "ssl"
+				} else {
+					encryptionType = "starttls"
+				}
+			case "tls", "starttls-mandatory", "starttls-opportunistic":
+				encryptionType = "starttls"
+			}
+			smtp["encryptionType"] = encryptionType
+			delete(smtp, "secure")
+			return nil
+		},
+	},
+	{
+		Version:     2,
+		Description: "turn on the registry status alerts and expect a transfer lock",
+		Migrate: func(doc map[string]interface{}) error {
+			alerts, ok := doc["alerts"].(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			if _, ok := alerts["sendStatusAlerts"]; !ok {
+				alerts["sendStatusAlerts"] = true
+			}
+			if _, ok := alerts["expectedLocks"]; !ok {
+				alerts["expectedLocks"] = []interface{}{LockTransfer}
+			}
+			return nil
+		},
+	},
+}
+
+var domainsMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "store renewal prices as numbers",
+		Migrate: func(doc map[string]interface{}) error {
+			domains, ok := doc["domains"].([]interface{})
+			if !ok {
+				return nil
+			}
+			for _, d := range domains {
+				domain, ok := d.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				// Older files quoted every value, including the price
+				if price, ok := domain["renewalPrice"].(string); ok {
+					value, err := strconv.ParseFloat(price, 64)
+					if err != nil {
+						return fmt.Errorf("invalid renewal price %q for %v: %w", price, domain["fqdn"], err)
+					}
+					domain["renewalPrice"] = value
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     2,
+		Description: "keep the domains that were disabled but still alerted active",
+		Migrate: func(doc map[string]interface{}) error {
+			domains, ok := doc["domains"].([]interface{})
+			if !ok {
+				return nil
+			}
+			for _, d := range domains {
+				domain, ok := d.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				// Before the lifecycle states, enabled was ignored and every domain was monitored. A domain that had
+				// alerts on is kept active so it keeps alerting, the others were never alerted and are paused.
+				enabled, set := domain["enabled"].(bool)
+				alerts, _ := domain["alerts"].(bool)
+				if !set {
+					domain["enabled"] = true
+				} else if !enabled && alerts {
+					log.Printf("▶️ %v was disabled but still alerted, it stays active (pause it to stop its alerts)", domain["fqdn"])
+					domain["enabled"] = true
+				}
+			}
+			return nil
+		},
+	},
+}
+
+var whoisCacheMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "add version field",
+		Migrate:     func(doc map[string]interface{}) error { return nil },
+	},
+}
+
+var alertLedgerMigrations = []Migration{}
+
+var notificationQueueMigrations = []Migration{}
+
+var snoozesMigrations = []Migration{}
+
+var watchlistMigrations = []Migration{}
+
+var lookalikesMigrations = []Migration{}
+
+var certificatesMigrations = []Migration{}
+
+var subdomainsMigrations = []Migration{}
+
+var mailSecurityMigrations = []Migration{}
+
+var dnssecMigrations = []Migration{}
+
+var delegationMigrations = []Migration{}
+
+func versionedFiles() []versionedFile {
+	return []versionedFile{
+		{Name: AppConfig, Version: AppConfigVersion, Migrations: appConfigMigrations},
+		{Name: Domains, Version: DomainsVersion, Migrations: domainsMigrations},
+		{Name: WhoisCacheName, Version: WhoisCacheVersion, Migrations: whoisCacheMigrations},
+		{Name: AlertLedgerName, Version: AlertLedgerVersion, Migrations: alertLedgerMigrations},
+		{Name: NotificationQueueName, Version: NotificationQueueVersion, Migrations: notificationQueueMigrations},
+		{Name: SnoozesName, Version: SnoozesVersion, Migrations: snoozesMigrations},
+		{Name: WatchlistName, Version: WatchlistVersion, Migrations: watchlistMigrations},
+		{Name: LookalikesName, Version: LookalikesVersion, Migrations: lookalikesMigrations},
+		{Name: LookalikeWhoisCacheName, Version: WhoisCacheVersion, Migrations: whoisCacheMigrations},
+		{Name: CertificatesName, Version: CertificatesVersion, Migrations: certificatesMigrations},
+		{Name: SubdomainsName, Version: SubdomainsVersion, Migrations: subdomainsMigrations},
+		{Name: MailSecurityName, Version: MailSecurityVersion, Migrations: mailSecurityMigrations},
+		{Name: DNSSECName, Version: DNSSECVersion, Migrations: dnssecMigrations},
+		{Name: DelegationName, Version: DelegationVersion, Migrations: delegationMigrations},
+	}
+}
+
+// Migrate brings every data file in the directory up to the current version.
+//
+// The original file is copied to `<name>.v<version>.bak` before it is rewritten. Files that don't exist yet are skipped
+// (they are created with the current version when first read), and files from a newer version cause an error.
+func (dir ConfigDirectory) Migrate() error {
+	for _, file := range versionedFiles() {
+		if err := migrateFile(dir.DataDir+"/"+file.Name, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckVersions makes sure every data file has the current version, without changing anything. Only the server
+// migrates files, so the other commands use this to refuse to work on an older format. Commands that write data files
+// can't run next to a server (see Lock), but the reading ones can and must not rewrite the files it holds in memory.
+// Files that don't exist yet are skipped.
+func (dir ConfigDirectory) CheckVersions() error {
+	for _, file := range versionedFiles() {
+		doc := struct {
+			Version int `yaml:"version"`
+		}{}
+		err := loadYAMLFile(dir.DataDir+"/"+file.Name, &doc)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if doc.Version > file.Version {
+			return &ErrNewerVersion{File: file.Name, Version: doc.Version, Supported: file.Version}
+		}
+		if doc.Version < file.Version {
+			return &ErrOlderVersion{File: file.Name, Version: doc.Version, Current: file.Version}
+		}
+	}
+	return nil
+}
+
+func migrateFile(path string, file versionedFile) error {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	doc := map[string]interface{}{}
+	if err := parseYAML(raw, &doc); err != nil {
+		log.Printf("🚨 %s is corrupt (%s), trying to recover from backups", file.Name, err)
+		raw, err = recoverFromBackup(path, func(data []byte) error { return parseYAML(data, &map[string]interface{}{}) })
+		if err != nil {
+			return fmt.Errorf("%s is corrupt and could not be recovered: %w", file.Name, err)
+		}
+		doc = map[string]interface{}{}
+		if err := parseYAML(raw, &doc); err != nil {
+			return err
+		}
+	}
+
+	version, _ := doc["version"].(int)
+	if version > file.Version {
+		return &ErrNewerVersion{File: file.Name, Version: version, Supported: file.Version}
+	}
+	if version == file.Version {
+		return nil
+	}
+
+	backup := fmt.Sprintf("%s.v%d.bak", path, version)
+	if err := os.WriteFile(backup, raw, 0o600); err != nil {
+		return fmt.Errorf("unable to back up %s before migration: %w", file.Name, err)
+	}
+	log.Printf("🗄️ Backed up %s (version %d) to %s", file.Name, version, backup)
+
+	for _, migration := range file.Migrations {
+		if migration.Version <= version {
+			continue
+		}
+		log.Printf("🔀 Migrating %s to version %d: %s", file.Name, migration.Version, migration.Description)
+		if err := migration.Migrate(doc); err != nil {
+			return fmt.Errorf("migration of %s to version %d failed: %w", file.Name, migration.Version, err)
+		}
+	}
+	doc["version"] = file.Version
+
+	data, err := MarshalYAML(doc)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("unable to write migrated %s: %w", file.Name, err)
+	}
+	log.Printf("✅ Migrated %s from version %d to %d", file.Name, version, file.Version)
+
+	return nil
+}
@@ -0,0 +1,243 @@
+package configuration
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// State of a queued notification
+const (
+	// Waiting for its first or next delivery attempt
+	QueuePending = "pending"
+	// Delivered
+	QueueSent = "sent"
+	// Gave up after the maximum number of attempts, kept until it is retried or discarded
+	QueueDead = "dead"
+)
+
+// How long delivered notifications are kept, so their idempotency keys keep preventing duplicates
+const QueueSentRetention = 45 * 24 * time.Hour
+
+// QueuedNotification is a rendered message waiting to be delivered over one channel
+type QueuedNotification struct {
+	// Unique identifier (the start of the idempotency key and a random suffix), also used for the alert ledger record
+	ID string `yaml:"id" json:"id"`
+	// Idempotency key, a notification with the same key is never queued twice
+	Key string `yaml:"key" json:"key"`
+	// ChannelEmail or ChannelWebhook
+	Channel string `yaml:"channel" json:"channel"`
+	// Domain the alert is about, empty for digests
+	FQDN string `yaml:"fqdn,omitempty" json:"fqdn,omitempty"`
+	// Domains included in a digest
+	Domains []string `yaml:"domains,omitempty" json:"domains,omitempty"`
+	// Alert type, e.g. "1 week alert", or "digest"
+	Alert string `yaml:"alert" json:"alert"`
+	// Email recipients
+	To []string `yaml:"to,omitempty" json:"to,omitempty"`
+	CC []string `yaml:"cc,omitempty" json:"cc,omitempty"`
+	// Webhook URL and the JSON payload posted to it
+	Webhook string `yaml:"webhook,omitempty" json:"-"`
+	Payload string `yaml:"payload,omitempty" json:"-"`
+	// The rendered message
+	Subject string `yaml:"subject" json:"subject"`
+	Text    string `yaml:"text" json:"-"`
+	HTML    string `yaml:"html,omitempty" json:"-"`
+	// QueuePending, QueueSent or QueueDead
+	State string `yaml:"state" json:"state"`
+	// Number of delivery attempts so far
+	Attempts int `yaml:"attempts" json:"attempts"`
+	// Number of times a dead notification was retried manually, each retry allows another round of attempts
+	Retries int `yaml:"retries,omitempty" json:"retries,omitempty"`
+	// Error of the last failed attempt
+	LastError string `yaml:"lastError,omitempty" json:"lastError,omitempty"`
+	// When the next attempt is due (pending only)
+	NextAttempt time.Time `yaml:"nextAttempt" json:"nextAttempt"`
+	CreatedAt   time.Time `yaml:"createdAt" json:"createdAt"`
+	UpdatedAt   time.Time `yaml:"updatedAt" json:"updatedAt"`
+}
+
+type NotificationQueueFile struct {
+	// Version of the file format, used for migrations
+	Version int `yaml:"version" json:"version"`
+	// Queued, delivered and dead notifications, oldest first
+	Items []QueuedNotification `yaml:"items" json:"items"`
+}
+
+// NotificationQueueStorage persists outbound notifications until they are delivered. It is shared by the schedulers
+// and the web handlers, so it is always used as a pointer and guards its items with a lock.
+type NotificationQueueStorage struct {
+	mu sync.Mutex
+	// The queue file contents
+	FileContents NotificationQueueFile
+	// The path to the queue file
+	Filepath string
+}
+
+func DefaultNotificationQueueStorage(path string) *NotificationQueueStorage {
+	return &NotificationQueueStorage{
+		FileContents: NotificationQueueFile{Version: NotificationQueueVersion, Items: []QueuedNotification{}},
+		Filepath:     path,
+	}
+}
+
+// Enqueue adds a pending notification and writes the queue to disk. Returns false if a notification with the same
+// idempotency key was already queued (in any state), in which case nothing is added.
+func (q *NotificationQueueStorage) Enqueue(item QueuedNotification) (QueuedNotification, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, existing := range q.FileContents.Items {
+		if item.Key != "" && existing.Key == item.Key {
+			return existing, false
+		}
+	}
+
+	now := time.Now()
+	prefix := item.Key
+	if len(prefix) > 16 {
+		prefix = prefix[:16]
+	} else if prefix == "" {
+		prefix = now.UTC().Format("20060102T150405.000000")
+	}
+	item.ID = newID(prefix)
+	item.State = QueuePending
+	item.Attempts = 0
+	item.NextAttempt = now
+	item.CreatedAt = now
+	item.UpdatedAt = now
+	q.FileContents.Items = append(q.FileContents.Items, item)
+	q.flush()
+	return item, true
+}
+
+// newID returns a unique identifier made of prefix and a random suffix. Unlike a counter, the suffix can't repeat the
+// ID of an item that was pruned in the meantime.
+func newID(prefix string) string {
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	return prefix + "-" + hex.EncodeToString(suffix)
+}
+
+// Due returns the pending notifications whose next attempt is due, oldest first
+func (q *NotificationQueueStorage) Due(now time.Time) []QueuedNotification {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	due := []QueuedNotification{}
+	for _, item := range q.FileContents.Items {
+		if item.State == QueuePending && !item.NextAttempt.After(now) {
+			due = append(due, item)
+		}
+	}
+	return due
+}
+
+// List returns the notifications in a state (all if empty), newest first
+func (q *NotificationQueueStorage) List(state string) []QueuedNotification {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items := []QueuedNotification{}
+	for i := len(q.FileContents.Items) - 1; i >= 0; i-- {
+		if state == "" || q.FileContents.Items[i].State == state {
+			items = append(items, q.FileContents.Items[i])
+		}
+	}
+	return items
+}
+
+// Get returns a notification by ID
+func (q *NotificationQueueStorage) Get(id string) (QueuedNotification, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, item := range q.FileContents.Items {
+		if item.ID == id {
+			return item, true
+		}
+	}
+	return QueuedNotification{}, false
+}
+
+// Update replaces a notification (matched by ID) and writes the queue to disk
+func (q *NotificationQueueStorage) Update(item QueuedNotification) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := range q.FileContents.Items {
+		if q.FileContents.Items[i].ID == item.ID {
+			item.UpdatedAt = time.Now()
+			q.FileContents.Items[i] = item
+			q.flush()
+			return true
+		}
+	}
+	return false
+}
+
+// Remove deletes a notification, returning false if it doesn't exist
+func (q *NotificationQueueStorage) Remove(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := range q.FileContents.Items {
+		if q.FileContents.Items[i].ID == id {
+			q.FileContents.Items = append(q.FileContents.Items[:i], q.FileContents.Items[i+1:]...)
+			q.flush()
+			return true
+		}
+	}
+	return false
+}
+
+// Prune drops delivered notifications older than the retention, dead and pending ones are always kept
+func (q *NotificationQueueStorage) Prune(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	kept := q.FileContents.Items[:0]
+	for _, item := range q.FileContents.Items {
+		if item.State == QueueSent && now.Sub(item.UpdatedAt) > QueueSentRetention {
+			continue
+		}
+		kept = append(kept, item)
+	}
+	if len(kept) == len(q.FileContents.Items) {
+		return
+	}
+	q.FileContents.Items = kept
+	sort.SliceStable(q.FileContents.Items, func(i, j int) bool {
+		return q.FileContents.Items[i].CreatedAt.Before(q.FileContents.Items[j].CreatedAt)
+	})
+	q.flush()
+}
+
+// Flush the queue to its storage
+func (q *NotificationQueueStorage) Flush() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.flush()
+}
+
+func (q *NotificationQueueStorage) flush() {
+	// Always write the current file format version
+	q.FileContents.Version = NotificationQueueVersion
+
+	data, err := MarshalYAML(q.FileContents)
+	if err != nil {
+		log.Printf("❌ Error while marshalling the notification queue: %v", err)
+		return
+	}
+
+	if err := writeFileAtomic(q.Filepath, data); err != nil {
+		log.Printf("❌ Error while writing notification queue file: %v", err)
+		return
+	}
+
+	log.Printf("💾 Flushed notification queue to %s", filepath.Base(q.Filepath))
+}
@@ -0,0 +1,210 @@
+package configuration
+
+import (
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Kinds of lookalike permutations
+const (
+	// A character left out: exmple.com
+	PermutationOmission = "omission"
+	// Two neighbouring characters swapped: exmaple.com
+	PermutationTransposition = "transposition"
+	// Characters replaced by ASCII characters that look alike: examp1e.com, rnail.com
+	PermutationHomoglyph = "homoglyph"
+	// A character replaced by a Unicode character that looks alike, registered as an IDN: exаmple.com (Cyrillic а)
+	PermutationIDN = "idn"
+	// The same name under another TLD: example.net
+	PermutationTLD = "tld"
+	// One bit of a character flipped, as caused by faulty memory: exampme.com
+	PermutationBitFlip = "bitflip"
+	// A hyphen inserted: exam-ple.com
+	PermutationHyphenation = "hyphenation"
+)
+
+// PermutationKinds are all kinds of permutations, in the order they are generated
+var PermutationKinds = []string{PermutationOmission, PermutationTransposition, PermutationHomoglyph, PermutationIDN, PermutationTLD, PermutationBitFlip, PermutationHyphenation}
+
+// DefaultLookalikeTLDs are swapped in for the TLD of a domain when lookalikes.tlds is empty
+var DefaultLookalikeTLDs = []string{"com", "net", "org", "info", "biz", "co", "io", "app", "dev", "online", "site", "xyz", "shop"}
+
+// ASCII characters and sequences that are easily mistaken for each other
+var asciiHomoglyphs = map[string][]string{
+	"o":  {"0"},
+	"0":  {"o"},
+	"l":  {"1", "i"},
+	"i":  {"1", "l"},
+	"1":  {"l", "i"},
+	"m":  {"rn", "nn"},
+	"rn": {"m"},
+	"w":  {"vv"},
+	"vv": {"w"},
+	"d":  {"cl"},
+	"cl": {"d"},
+	"g":  {"q"},
+	"q":  {"g"},
+	"u":  {"v"},
+	"v":  {"u"},
+	"e":  {"3"},
+	"s":  {"5"},
+	"b":  {"6"},
+}
+
+// Unicode characters (mostly Cyrillic and Greek) that look like ASCII letters
+var idnHomoglyphs = map[rune][]rune{
+	'a': {'а', 'à', 'á', 'ä', 'ɑ'},
+	'c': {'с', 'ç'},
+	'd': {'ԁ'},
+	'e': {'е', 'é', 'è', 'ë'},
+	'h': {'һ'},
+	'i': {'і', 'í', 'ï'},
+	'j': {'ј'},
+	'k': {'κ'},
+	'l': {'ӏ'},
+	'n': {'ո', 'ñ'},
+	'o': {'о', 'ο', 'ó', 'ö'},
+	'p': {'р'},
+	's': {'ѕ'},
+	'u': {'υ', 'ü'},
+	'x': {'х'},
+	'y': {'у', 'ý'},
+}
+
+// Permutation is a name that could be mistaken for a domain
+type Permutation struct {
+	// The name as it is registered and resolved (IDNs in their xn-- form)
+	FQDN string `json:"fqdn"`
+	// The name as it is displayed, only set for IDNs
+	Unicode string `json:"unicode,omitempty"`
+	// How the name was derived from the domain, see PermutationKinds
+	Kind string `json:"kind"`
+}
+
+// Display returns the name as it is displayed, the Unicode form for IDNs
+func (p Permutation) Display() string {
+	if p.Unicode != "" {
+		return p.Unicode
+	}
+	return p.FQDN
+}
+
+// Permutations generates the lookalikes of a domain: omissions, transpositions, ASCII and IDN homoglyphs, TLD swaps,
+// bit flips and hyphenations of its first label. Each name is only returned once, with the kind that generated it
+// first. The domain itself is left out. The TLDs are swapped in for everything after the first label, empty uses
+// DefaultLookalikeTLDs.
+func Permutations(fqdn string, tlds []string) []Permutation {
+	fqdn = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(fqdn), "."))
+	label, suffix, ok := strings.Cut(fqdn, ".")
+	if !ok || label == "" || suffix == "" {
+		return []Permutation{}
+	}
+	if len(tlds) == 0 {
+		tlds = DefaultLookalikeTLDs
+	}
+
+	seen := map[string]bool{fqdn: true}
+	permutations := []Permutation{}
+	add := func(kind string, name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		permutation := Permutation{FQDN: name, Kind: kind}
+		if !isASCII(name) {
+			ascii, err := idna.Lookup.ToASCII(name)
+			if err != nil || seen[ascii] {
+				return
+			}
+			seen[ascii] = true
+			permutation.FQDN, permutation.Unicode = ascii, name
+		}
+		permutations = append(permutations, permutation)
+	}
+	addLabel := func(kind string, candidate string) {
+		if isLabel(candidate) {
+			add(kind, candidate+"."+suffix)
+		}
+	}
+
+	runes := []rune(label)
+	for i := range runes {
+		addLabel(PermutationOmission, string(runes[:i])+string(runes[i+1:]))
+	}
+	for i := 0; i+1 < len(runes); i++ {
+		if runes[i] == runes[i+1] {
+			continue
+		}
+		swapped := append([]rune{}, runes...)
+		swapped[i], swapped[i+1] = swapped[i+1], swapped[i]
+		addLabel(PermutationTransposition, string(swapped))
+	}
+	for i := 0; i < len(label); i++ {
+		for _, size := range []int{1, 2} {
+			if i+size > len(label) {
+				continue
+			}
+			for _, replacement := range asciiHomoglyphs[label[i:i+size]] {
+				addLabel(PermutationHomoglyph, label[:i]+replacement+label[i+size:])
+			}
+		}
+	}
+	for i, r := range runes {
+		for _, replacement := range idnHomoglyphs[r] {
+			candidate := append([]rune{}, runes...)
+			candidate[i] = replacement
+			addLabel(PermutationIDN, string(candidate))
+		}
+	}
+	for _, tld := range tlds {
+		tld = strings.ToLower(strings.Trim(strings.TrimSpace(tld), "."))
+		if tld != "" && tld != suffix {
+			add(PermutationTLD, label+"."+tld)
+		}
+	}
+	for i := 0; i < len(label); i++ {
+		for bit := 0; bit < 8; bit++ {
+			flipped := label[i] ^ (1 << bit)
+			if isHostnameByte(flipped) {
+				addLabel(PermutationBitFlip, label[:i]+string(flipped)+label[i+1:])
+			}
+		}
+	}
+	for i := 1; i < len(label); i++ {
+		if label[i-1] != '-' && label[i] != '-' {
+			addLabel(PermutationHyphenation, label[:i]+"-"+label[i:])
+		}
+	}
+
+	return permutations
+}
+
+// PermutationCounts counts the permutations of each kind
+func PermutationCounts(permutations []Permutation) map[string]int {
+	counts := map[string]int{}
+	for _, permutation := range permutations {
+		counts[permutation.Kind]++
+	}
+	return counts
+}
+
+// isLabel reports if a permuted first label can be registered: not empty, at most 63 characters and no hyphen at the
+// start or end
+func isLabel(label string) bool {
+	return label != "" && len(label) <= 63 && !strings.HasPrefix(label, "-") && !strings.HasSuffix(label, "-")
+}
+
+// isHostnameByte reports if a byte may appear in an ASCII host name label (lowercase, as DNS ignores case)
+func isHostnameByte(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || b == '-'
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
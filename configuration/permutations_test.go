@@ -0,0 +1,164 @@
+package configuration
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/idna"
+)
+
+// permutationsByName indexes permutations by their FQDN, failing on duplicates
+func permutationsByName(t *testing.T, permutations []Permutation) map[string]Permutation {
+	t.Helper()
+	byName := map[string]Permutation{}
+	for _, permutation := range permutations {
+		if _, ok := byName[permutation.FQDN]; ok {
+			t.Errorf("%s was generated twice", permutation.FQDN)
+		}
+		byName[permutation.FQDN] = permutation
+	}
+	return byName
+}
+
+func TestPermutationsOfEachKind(t *testing.T) {
+	byName := permutationsByName(t, Permutations("example.com", []string{"net", "com"}))
+
+	tests := []struct {
+		fqdn string
+		kind string
+	}{
+		{"xample.com", PermutationOmission},
+		{"exmple.com", PermutationOmission},
+		{"exampl.com", PermutationOmission},
+		{"xeample.com", PermutationTransposition},
+		{"exmaple.com", PermutationTransposition},
+		{"examlpe.com", PermutationTransposition},
+		{"examp1e.com", PermutationHomoglyph},
+		{"exampie.com", PermutationHomoglyph},
+		{"exarnple.com", PermutationHomoglyph},
+		{"3xample.com", PermutationHomoglyph},
+		{"example.net", PermutationTLD},
+		{"exampme.com", PermutationBitFlip},
+		{"dxample.com", PermutationBitFlip},
+		{"e-xample.com", PermutationHyphenation},
+		{"exam-ple.com", PermutationHyphenation},
+	}
+	for _, tt := range tests {
+		permutation, ok := byName[tt.fqdn]
+		if !ok {
+			t.Errorf("%s was not generated", tt.fqdn)
+			continue
+		}
+		if permutation.Kind != tt.kind {
+			t.Errorf("%s has kind %s, want %s", tt.fqdn, permutation.Kind, tt.kind)
+		}
+		if permutation.Unicode != "" {
+			t.Errorf("%s has a Unicode form %q, want none", tt.fqdn, permutation.Unicode)
+		}
+	}
+
+	for _, unwanted := range []string{"example.com", "example-.com", "-example.com"} {
+		if _, ok := byName[unwanted]; ok {
+			t.Errorf("%s should not be generated", unwanted)
+		}
+	}
+}
+
+func TestPermutationsIDN(t *testing.T) {
+	byName := permutationsByName(t, Permutations("example.com", []string{"com"}))
+
+	cyrillic := "еxample.com" // Cyrillic е
+	found := false
+	for fqdn, permutation := range byName {
+		if permutation.Kind != PermutationIDN {
+			continue
+		}
+		if !strings.HasPrefix(fqdn, "xn--") {
+			t.Errorf("IDN %s is not in its ASCII form", fqdn)
+		}
+		unicode, err := idna.Lookup.ToUnicode(fqdn)
+		if err != nil {
+			t.Errorf("IDN %s doesn't convert back: %s", fqdn, err)
+		}
+		if unicode != permutation.Unicode {
+			t.Errorf("IDN %s is displayed as %q, want %q", fqdn, permutation.Unicode, unicode)
+		}
+		if permutation.Display() != permutation.Unicode {
+			t.Errorf("Display() of %s = %q, want the Unicode form", fqdn, permutation.Display())
+		}
+		if permutation.Unicode == cyrillic {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("%s (Cyrillic е) was not generated", cyrillic)
+	}
+}
+
+func TestPermutationsKeepTheFirstKind(t *testing.T) {
+	byName := permutationsByName(t, Permutations("book.com", []string{"com"}))
+
+	// Leaving out either o gives the same name, and swapping them gives the domain itself
+	if permutation := byName["bok.com"]; permutation.Kind != PermutationOmission {
+		t.Errorf("bok.com has kind %q, want %s", permutation.Kind, PermutationOmission)
+	}
+	if _, ok := byName["book.com"]; ok {
+		t.Error("the domain itself was generated")
+	}
+	// o → 0 is a homoglyph, not a bit flip
+	if permutation := byName["b0ok.com"]; permutation.Kind != PermutationHomoglyph {
+		t.Errorf("b0ok.com has kind %q, want %s", permutation.Kind, PermutationHomoglyph)
+	}
+}
+
+func TestPermutationsNormalizeInput(t *testing.T) {
+	want := Permutations("example.com", []string{"net"})
+	got := Permutations("  Example.COM. ", []string{" .NET "})
+	if len(got) != len(want) {
+		t.Fatalf("got %d permutations, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("permutation %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	for _, fqdn := range []string{"", "localhost", ".com", "example."} {
+		if permutations := Permutations(fqdn, nil); len(permutations) != 0 {
+			t.Errorf("Permutations(%q) returned %d names, want none", fqdn, len(permutations))
+		}
+	}
+}
+
+func TestPermutationsTLDs(t *testing.T) {
+	counts := PermutationCounts(Permutations("example.com", nil))
+	if counts[PermutationTLD] != len(DefaultLookalikeTLDs)-1 {
+		t.Errorf("got %d TLD swaps with the default TLDs, want %d (all but com)", counts[PermutationTLD], len(DefaultLookalikeTLDs)-1)
+	}
+
+	// Everything after the first label is swapped
+	byName := permutationsByName(t, Permutations("example.co.uk", []string{"com", "co.uk"}))
+	if permutation := byName["example.com"]; permutation.Kind != PermutationTLD {
+		t.Errorf("example.com has kind %q, want %s", permutation.Kind, PermutationTLD)
+	}
+	if _, ok := byName["exmple.co.uk"]; !ok {
+		t.Error("exmple.co.uk was not generated")
+	}
+	for fqdn, permutation := range byName {
+		if permutation.Kind == PermutationTLD && fqdn != "example.com" {
+			t.Errorf("unexpected TLD swap %s", fqdn)
+		}
+	}
+}
+
+func TestPermutationsAreValidLabels(t *testing.T) {
+	for _, permutation := range Permutations("a-b.com", []string{"com"}) {
+		label, _, _ := strings.Cut(permutation.FQDN, ".")
+		if !isLabel(label) {
+			t.Errorf("%s (%s) has an invalid label", permutation.FQDN, permutation.Kind)
+		}
+		if strings.Contains(label, "--") && !strings.HasPrefix(label, "xn--") {
+			t.Errorf("%s (%s) has a double hyphen", permutation.FQDN, permutation.Kind)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package configuration
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -14,33 +15,34 @@ import (
 
 // RDAP domain response structure (simplified, only fields we need)
 type rdapDomain struct {
-	ObjectClassName string          `json:"objectClassName"`
-	Handle          string          `json:"handle"`
-	LDHName         string          `json:"ldhName"`
+	ObjectClassName string           `json:"objectClassName"`
+	Handle          string           `json:"handle"`
+	LDHName         string           `json:"ldhName"`
 	Nameservers     []rdapNameserver `json:"nameservers"`
-	Status          []string        `json:"status"`
-	Entities        []rdapEntity    `json:"entities"`
-	Events          []rdapEvent     `json:"events"`
-	PublicIDs       []rdapPublicID  `json:"publicIds"`
-	Links           []rdapLink      `json:"links"`
+	Status          []string         `json:"status"`
+	Entities        []rdapEntity     `json:"entities"`
+	Events          []rdapEvent      `json:"events"`
+	PublicIDs       []rdapPublicID   `json:"publicIds"`
+	Links           []rdapLink       `json:"links"`
+	SecureDNS       *RDAPSecureDNS   `json:"secureDNS"`
 }
 
 type rdapNameserver struct {
-	ObjectClassName string      `json:"objectClassName"`
-	LDHName         string      `json:"ldhName"`
-	Links           []rdapLink  `json:"links"`
+	ObjectClassName string     `json:"objectClassName"`
+	LDHName         string     `json:"ldhName"`
+	Links           []rdapLink `json:"links"`
 }
 
 type rdapEntity struct {
-	ObjectClassName string      `json:"objectClassName"`
-	Handle          string      `json:"handle"`
+	ObjectClassName string        `json:"objectClassName"`
+	Handle          string        `json:"handle"`
 	VCardArray      []interface{} `json:"vcardArray"`
-	Roles           []string    `json:"roles"`
+	Roles           []string      `json:"roles"`
 }
 
 type rdapEvent struct {
-	EventAction string    `json:"eventAction"`
-	EventDate   string    `json:"eventDate"` // RDAP uses ISO8601 strings for dates
+	EventAction string `json:"eventAction"`
+	EventDate   string `json:"eventDate"` // RDAP uses ISO8601 strings for dates
 }
 
 type rdapPublicID struct {
@@ -55,18 +57,71 @@ type rdapLink struct {
 	Type  string `json:"type"`
 }
 
+// RDAPSecureDNS is the DNSSEC delegation a registry reports for a domain (the secureDNS member of RFC 9083)
+type RDAPSecureDNS struct {
+	// The zone of the domain is signed, nil if the registry doesn't say
+	ZoneSigned *bool `yaml:"zoneSigned,omitempty" json:"zoneSigned,omitempty"`
+	// The registry publishes DS records (or keys) for the domain in the parent zone
+	DelegationSigned bool `yaml:"delegationSigned" json:"delegationSigned"`
+	// Signature lifetime the registry was asked for (in seconds, 0 if none)
+	MaxSigLife int `yaml:"maxSigLife,omitempty" json:"maxSigLife,omitempty"`
+	// The DS records, and the keys of registries that take keys instead of DS records
+	DSData  []RDAPDSData  `yaml:"dsData,omitempty" json:"dsData,omitempty"`
+	KeyData []RDAPKeyData `yaml:"keyData,omitempty" json:"keyData,omitempty"`
+}
+
+// RDAPDSData is a DS record reported by a registry
+type RDAPDSData struct {
+	KeyTag     int    `yaml:"keyTag" json:"keyTag"`
+	Algorithm  int    `yaml:"algorithm" json:"algorithm"`
+	DigestType int    `yaml:"digestType" json:"digestType"`
+	Digest     string `yaml:"digest" json:"digest"`
+}
+
+// RDAPKeyData is a DNSKEY reported by a registry
+type RDAPKeyData struct {
+	Flags     int    `yaml:"flags" json:"flags"`
+	Protocol  int    `yaml:"protocol" json:"protocol"`
+	Algorithm int    `yaml:"algorithm" json:"algorithm"`
+	PublicKey string `yaml:"publicKey" json:"publicKey"`
+}
+
 type rdapError struct {
-	ErrorCode    int      `json:"errorCode"`
-	Title        string   `json:"title"`
-	Description  []string `json:"description"`
+	ErrorCode   int      `json:"errorCode"`
+	Title       string   `json:"title"`
+	Description []string `json:"description"`
 }
 
+// errRDAPNotFound is returned by QueryRDAP when the registry doesn't know the domain
+var errRDAPNotFound = errors.New("RDAP: domain not found")
+
 // QueryRDAP queries RDAP servers for domain information
 func QueryRDAP(fqdn string) (whoisparser.WhoisInfo, error) {
+	rdapDomainResp, err := queryRDAPDomain(fqdn)
+	if err != nil {
+		return whoisparser.WhoisInfo{}, err
+	}
+
+	// Convert RDAP response to whoisparser.WhoisInfo
+	return convertRDAPToWhoisInfo(rdapDomainResp, fqdn), nil
+}
+
+// QuerySecureDNS queries RDAP servers for the DNSSEC delegation of a domain. Returns nil if the registry doesn't
+// report one.
+func QuerySecureDNS(fqdn string) (*RDAPSecureDNS, error) {
+	rdapDomainResp, err := queryRDAPDomain(fqdn)
+	if err != nil {
+		return nil, err
+	}
+	return rdapDomainResp.SecureDNS, nil
+}
+
+// queryRDAPDomain fetches the RDAP domain object of a domain from the registry's RDAP server
+func queryRDAPDomain(fqdn string) (rdapDomain, error) {
 	// Extract TLD from FQDN
 	parts := strings.Split(fqdn, ".")
 	if len(parts) < 2 {
-		return whoisparser.WhoisInfo{}, fmt.Errorf("invalid domain: %s", fqdn)
+		return rdapDomain{}, fmt.Errorf("invalid domain: %s", fqdn)
 	}
 	tld := parts[len(parts)-1]
 
@@ -85,46 +140,47 @@ func QueryRDAP(fqdn string) (whoisparser.WhoisInfo, error) {
 	client := &http.Client{
 		Timeout: 10 * time.Second,
 	}
-	
+
 	resp, err := client.Get(domainURL)
 	if err != nil {
-		return whoisparser.WhoisInfo{}, fmt.Errorf("RDAP query failed: %w", err)
+		return rdapDomain{}, fmt.Errorf("RDAP query failed: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return rdapDomain{}, errRDAPNotFound
+	}
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		var rdapErr rdapError
 		if err := json.Unmarshal(bodyBytes, &rdapErr); err == nil && rdapErr.Title != "" {
-			return whoisparser.WhoisInfo{}, fmt.Errorf("RDAP error: %s", rdapErr.Title)
+			return rdapDomain{}, fmt.Errorf("RDAP error: %s", rdapErr.Title)
 		}
-		return whoisparser.WhoisInfo{}, fmt.Errorf("RDAP query returned status %d", resp.StatusCode)
+		return rdapDomain{}, fmt.Errorf("RDAP query returned status %d", resp.StatusCode)
 	}
 
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return whoisparser.WhoisInfo{}, fmt.Errorf("failed to read RDAP response: %w", err)
+		return rdapDomain{}, fmt.Errorf("failed to read RDAP response: %w", err)
 	}
 
 	// Parse RDAP response
 	var rdapDomainResp rdapDomain
 	if err := json.Unmarshal(bodyBytes, &rdapDomainResp); err != nil {
-		return whoisparser.WhoisInfo{}, fmt.Errorf("failed to parse RDAP response: %w", err)
+		return rdapDomain{}, fmt.Errorf("failed to parse RDAP response: %w", err)
 	}
-
-	// Convert RDAP response to whoisparser.WhoisInfo
-	return convertRDAPToWhoisInfo(rdapDomainResp, fqdn), nil
+	return rdapDomainResp, nil
 }
 
 // getRDAPServerFromBootstrap queries ICANN's RDAP bootstrap service
 func getRDAPServerFromBootstrap(tld string) (string, error) {
 	// ICANN bootstrap service uses dns.json for domain queries
 	bootstrapURL := "https://data.iana.org/rdap/dns.json"
-	
+
 	client := &http.Client{
 		Timeout: 10 * time.Second,
 	}
-	
+
 	resp, err := client.Get(bootstrapURL)
 	if err != nil {
 		return "", err
@@ -138,7 +194,7 @@ func getRDAPServerFromBootstrap(tld string) (string, error) {
 	var bootstrapData struct {
 		Services [][]interface{} `json:"services"`
 	}
-	
+
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return "", err
@@ -202,6 +258,11 @@ func convertRDAPToWhoisInfo(rdap rdapDomain, fqdn string) whoisparser.WhoisInfo
 		NameServers: []string{},
 	}
 
+	// The registry publishes DS records for the domain
+	if rdap.SecureDNS != nil {
+		domain.DNSSec = rdap.SecureDNS.DelegationSigned
+	}
+
 	// Extract nameservers
 	for _, ns := range rdap.Nameservers {
 		if ns.LDHName != "" {
@@ -222,7 +283,7 @@ func convertRDAPToWhoisInfo(rdap rdapDomain, fqdn string) whoisparser.WhoisInfo
 				break
 			}
 		}
-		
+
 		if isRegistrar {
 			// Try to extract registrar name from vCard
 			if len(entity.VCardArray) > 1 {
@@ -263,7 +324,7 @@ func convertRDAPToWhoisInfo(rdap rdapDomain, fqdn string) whoisparser.WhoisInfo
 		if event.EventDate == "" {
 			continue
 		}
-		
+
 		eventTime, err := time.Parse(time.RFC3339, event.EventDate)
 		if err != nil {
 			// Try alternative format if RFC3339 fails
@@ -273,10 +334,10 @@ func convertRDAPToWhoisInfo(rdap rdapDomain, fqdn string) whoisparser.WhoisInfo
 				continue
 			}
 		}
-		
+
 		// Create pointer to time
 		eventTimePtr := &eventTime
-		
+
 		switch event.EventAction {
 		case "registration":
 			domain.CreatedDate = eventTime.Format(time.RFC3339)
@@ -1,10 +1,9 @@
 package configuration
 
 import (
+	"errors"
 	"log"
 	"os"
-
-	"gopkg.in/yaml.v3"
 )
 
 // Read the app configuration from the config file
@@ -12,9 +11,9 @@ func (dir ConfigDirectory) ReadAppConfig() Configuration {
 	var configInner ConfigurationFile
 	filepath := dir.DataDir + "/" + AppConfig
 
-	// read config file from the provided base path
-	file, err := os.ReadFile(filepath)
-	if err != nil {
+	// read config file from the provided base path (recovering from a backup if it is corrupt)
+	err := readYAMLFile(filepath, &configInner)
+	if errors.Is(err, os.ErrNotExist) {
 		log.Printf("\nerror: %v\n", err)
 		config := DefaultConfiguration(filepath)
 		log.Println("🆕 Using default configuration to create " + AppConfig)
@@ -22,9 +21,6 @@ func (dir ConfigDirectory) ReadAppConfig() Configuration {
 		config.Flush()
 		return config
 	}
-
-	// use file to parse yaml
-	err = yaml.Unmarshal(file, &configInner)
 	if err != nil {
 		log.Println("Error while unmarshalling configuration")
 		log.Fatalf("error: %v", err)
@@ -42,20 +38,17 @@ func (dir ConfigDirectory) ReadAppConfig() Configuration {
 		Config:   configInner,
 	}
 
-	// Flush the config to ensure it's up to date
-	config.Flush()
-
 	return config
 }
 
 // Read the domain configuration from the config file
-func (dir ConfigDirectory) ReadDomains() DomainConfiguration {
+func (dir ConfigDirectory) ReadDomains() *DomainConfiguration {
 	domains := DomainFile{}
 	filepath := dir.DataDir + "/" + Domains
 
-	// read config file
-	file, err := os.ReadFile(filepath)
-	if err != nil {
+	// read config file (recovering from a backup if it is corrupt)
+	err := readYAMLFile(filepath, &domains)
+	if errors.Is(err, os.ErrNotExist) {
 		log.Printf("\nerror: %v\n", err)
 		domainConfig := DefaultDomainConfiguration(filepath)
 		log.Println("🆕 Using default configuration to create " + Domains)
@@ -63,56 +56,364 @@ func (dir ConfigDirectory) ReadDomains() DomainConfiguration {
 		domainConfig.Flush()
 		return domainConfig
 	}
-
-	// use file to parse yaml
-	err = yaml.Unmarshal(file, &domains)
 	if err != nil {
 		log.Println("Error while unmarshalling configuration")
 		log.Fatalf("error: %v", err)
 	}
 
-	domainConfig := DomainConfiguration{
-
+	domainConfig := &DomainConfiguration{
 		Filepath:   filepath,
 		DomainFile: domains,
 	}
 
-	// Flush the config to ensure it's up to date
-	domainConfig.Flush()
-
 	return domainConfig
 }
 
 // Read the whois cache from the config file
-func (dir ConfigDirectory) ReadWhoisCache() WhoisCacheStorage {
+func (dir ConfigDirectory) ReadWhoisCache() *WhoisCacheStorage {
+	return dir.readWhoisCache(WhoisCacheName)
+}
+
+// Read the WHOIS cache of the lookalikes from its file
+func (dir ConfigDirectory) ReadLookalikeWhoisCache() *WhoisCacheStorage {
+	return dir.readWhoisCache(LookalikeWhoisCacheName)
+}
+
+func (dir ConfigDirectory) readWhoisCache(name string) *WhoisCacheStorage {
 	cache := WhoisCacheFile{}
-	filepath := dir.DataDir + "/" + WhoisCacheName
+	filepath := dir.DataDir + "/" + name
 
-	// read config file
-	file, err := os.ReadFile(filepath)
-	if err != nil {
+	// read config file (recovering from a backup if it is corrupt)
+	err := readYAMLFile(filepath, &cache)
+	if errors.Is(err, os.ErrNotExist) {
 		log.Printf("\nerror: %v\n", err)
 		cache := DefaultWhoisCacheStorage(filepath)
-		log.Println("🆕 Using default (empty) cache to create " + WhoisCacheName)
+		log.Println("🆕 Using default (empty) cache to create " + name)
 		// write default config to file
 		cache.Flush()
 		return cache
 	}
-
-	// use file to parse yaml
-	err = yaml.Unmarshal(file, &cache)
 	if err != nil {
 		log.Println("Error while unmarshalling whois cache")
 		log.Fatalf("error: %v", err)
 	}
 
-	whoisConfig := WhoisCacheStorage{
+	whoisConfig := &WhoisCacheStorage{
 		Filepath:     filepath,
 		FileContents: cache,
 	}
 
-	// Flush the config to ensure it's up to date
-	whoisConfig.Flush()
-
 	return whoisConfig
 }
+
+// LoadAppConfig reads the app configuration without writing to the data directory: a missing file gives the default
+// configuration and a corrupt one is read from its newest valid backup. Used by the read-only commands, which report
+// errors instead of exiting.
+func (dir ConfigDirectory) LoadAppConfig() (Configuration, error) {
+	filepath := dir.DataDir + "/" + AppConfig
+	var configInner ConfigurationFile
+	err := loadYAMLFile(filepath, &configInner)
+	if errors.Is(err, os.ErrNotExist) {
+		return DefaultConfiguration(filepath), nil
+	}
+	if err != nil {
+		return Configuration{}, err
+	}
+	return Configuration{Filepath: filepath, Config: configInner}, nil
+}
+
+// LoadDomains reads the domain configuration without writing to the data directory (see LoadAppConfig)
+func (dir ConfigDirectory) LoadDomains() (*DomainConfiguration, error) {
+	filepath := dir.DataDir + "/" + Domains
+	domains := DomainFile{}
+	err := loadYAMLFile(filepath, &domains)
+	if errors.Is(err, os.ErrNotExist) {
+		return DefaultDomainConfiguration(filepath), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &DomainConfiguration{Filepath: filepath, DomainFile: domains}, nil
+}
+
+// LoadWhoisCache reads the WHOIS cache without writing to the data directory (see LoadAppConfig)
+func (dir ConfigDirectory) LoadWhoisCache() (*WhoisCacheStorage, error) {
+	filepath := dir.DataDir + "/" + WhoisCacheName
+	cache := WhoisCacheFile{}
+	err := loadYAMLFile(filepath, &cache)
+	if errors.Is(err, os.ErrNotExist) {
+		return DefaultWhoisCacheStorage(filepath), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &WhoisCacheStorage{Filepath: filepath, FileContents: cache}, nil
+}
+
+// Read the alert ledger from its file
+func (dir ConfigDirectory) ReadAlertLedger() *AlertLedgerStorage {
+	ledger := AlertLedgerFile{}
+	filepath := dir.DataDir + "/" + AlertLedgerName
+
+	// read the ledger file (recovering from a backup if it is corrupt)
+	err := readYAMLFile(filepath, &ledger)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Println("🆕 Creating an empty " + AlertLedgerName)
+		storage := DefaultAlertLedgerStorage(filepath)
+		storage.Flush()
+		return storage
+	}
+	if err != nil {
+		log.Println("Error while unmarshalling alert ledger")
+		log.Fatalf("error: %v", err)
+	}
+	if ledger.Records == nil {
+		ledger.Records = []AlertRecord{}
+	}
+
+	return &AlertLedgerStorage{
+		Filepath:     filepath,
+		FileContents: ledger,
+	}
+}
+
+// Read the outbound notification queue from its file
+func (dir ConfigDirectory) ReadNotificationQueue() *NotificationQueueStorage {
+	queue := NotificationQueueFile{}
+	filepath := dir.DataDir + "/" + NotificationQueueName
+
+	// read the queue file (recovering from a backup if it is corrupt)
+	err := readYAMLFile(filepath, &queue)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Println("🆕 Creating an empty " + NotificationQueueName)
+		storage := DefaultNotificationQueueStorage(filepath)
+		storage.Flush()
+		return storage
+	}
+	if err != nil {
+		log.Println("Error while unmarshalling notification queue")
+		log.Fatalf("error: %v", err)
+	}
+	if queue.Items == nil {
+		queue.Items = []QueuedNotification{}
+	}
+
+	return &NotificationQueueStorage{
+		Filepath:     filepath,
+		FileContents: queue,
+	}
+}
+
+func (dir ConfigDirectory) ReadSnoozes() *SnoozeStorage {
+	snoozes := SnoozeFile{}
+	filepath := dir.DataDir + "/" + SnoozesName
+
+	// read the snooze file (recovering from a backup if it is corrupt)
+	err := readYAMLFile(filepath, &snoozes)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Println("🆕 Creating an empty " + SnoozesName)
+		storage := DefaultSnoozeStorage(filepath)
+		storage.Flush()
+		return storage
+	}
+	if err != nil {
+		log.Println("Error while unmarshalling snoozes")
+		log.Fatalf("error: %v", err)
+	}
+	if snoozes.Snoozes == nil {
+		snoozes.Snoozes = []Snooze{}
+	}
+
+	return &SnoozeStorage{
+		Filepath:     filepath,
+		FileContents: snoozes,
+	}
+}
+
+func (dir ConfigDirectory) ReadWatchlist() *WatchlistStorage {
+	watchlist := WatchlistFile{}
+	filepath := dir.DataDir + "/" + WatchlistName
+
+	// read the watchlist file (recovering from a backup if it is corrupt)
+	err := readYAMLFile(filepath, &watchlist)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Println("🆕 Creating an empty " + WatchlistName)
+		storage := DefaultWatchlistStorage(filepath)
+		storage.Flush()
+		return storage
+	}
+	if err != nil {
+		log.Println("Error while unmarshalling watchlist")
+		log.Fatalf("error: %v", err)
+	}
+	if watchlist.Domains == nil {
+		watchlist.Domains = []WatchedDomain{}
+	}
+
+	return &WatchlistStorage{
+		Filepath:     filepath,
+		FileContents: watchlist,
+	}
+}
+
+func (dir ConfigDirectory) ReadLookalikes() *LookalikeStorage {
+	lookalikes := LookalikeFile{}
+	filepath := dir.DataDir + "/" + LookalikesName
+
+	// read the lookalikes file (recovering from a backup if it is corrupt)
+	err := readYAMLFile(filepath, &lookalikes)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Println("🆕 Creating an empty " + LookalikesName)
+		storage := DefaultLookalikeStorage(filepath)
+		storage.Flush()
+		return storage
+	}
+	if err != nil {
+		log.Println("Error while unmarshalling lookalikes")
+		log.Fatalf("error: %v", err)
+	}
+	if lookalikes.Scans == nil {
+		lookalikes.Scans = []LookalikeScan{}
+	}
+	if lookalikes.Lookalikes == nil {
+		lookalikes.Lookalikes = []Lookalike{}
+	}
+
+	return &LookalikeStorage{
+		Filepath:     filepath,
+		FileContents: lookalikes,
+	}
+}
+
+func (dir ConfigDirectory) ReadCertificates() *CertificateStorage {
+	certificates := CertificateFile{}
+	filepath := dir.DataDir + "/" + CertificatesName
+
+	// read the certificates file (recovering from a backup if it is corrupt)
+	err := readYAMLFile(filepath, &certificates)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Println("🆕 Creating an empty " + CertificatesName)
+		storage := DefaultCertificateStorage(filepath)
+		storage.Flush()
+		return storage
+	}
+	if err != nil {
+		log.Println("Error while unmarshalling certificates")
+		log.Fatalf("error: %v", err)
+	}
+	if certificates.Polls == nil {
+		certificates.Polls = []CertificatePoll{}
+	}
+	if certificates.Certificates == nil {
+		certificates.Certificates = []Certificate{}
+	}
+
+	return &CertificateStorage{
+		Filepath:     filepath,
+		FileContents: certificates,
+	}
+}
+
+func (dir ConfigDirectory) ReadSubdomains() *SubdomainStorage {
+	subdomains := SubdomainFile{}
+	filepath := dir.DataDir + "/" + SubdomainsName
+
+	// read the subdomains file (recovering from a backup if it is corrupt)
+	err := readYAMLFile(filepath, &subdomains)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Println("🆕 Creating an empty " + SubdomainsName)
+		storage := DefaultSubdomainStorage(filepath)
+		storage.Flush()
+		return storage
+	}
+	if err != nil {
+		log.Println("Error while unmarshalling subdomains")
+		log.Fatalf("error: %v", err)
+	}
+	if subdomains.Subdomains == nil {
+		subdomains.Subdomains = []Subdomain{}
+	}
+
+	return &SubdomainStorage{
+		Filepath:     filepath,
+		FileContents: subdomains,
+	}
+}
+
+func (dir ConfigDirectory) ReadMailSecurity() *MailSecurityStorage {
+	reports := MailSecurityFile{}
+	filepath := dir.DataDir + "/" + MailSecurityName
+
+	// read the mail security file (recovering from a backup if it is corrupt)
+	err := readYAMLFile(filepath, &reports)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Println("🆕 Creating an empty " + MailSecurityName)
+		storage := DefaultMailSecurityStorage(filepath)
+		storage.Flush()
+		return storage
+	}
+	if err != nil {
+		log.Println("Error while unmarshalling mail security reports")
+		log.Fatalf("error: %v", err)
+	}
+	if reports.Reports == nil {
+		reports.Reports = []MailSecurityReport{}
+	}
+
+	return &MailSecurityStorage{
+		Filepath:     filepath,
+		FileContents: reports,
+	}
+}
+
+func (dir ConfigDirectory) ReadDNSSEC() *DNSSECStorage {
+	reports := DNSSECFile{}
+	filepath := dir.DataDir + "/" + DNSSECName
+
+	// read the DNSSEC file (recovering from a backup if it is corrupt)
+	err := readYAMLFile(filepath, &reports)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Println("🆕 Creating an empty " + DNSSECName)
+		storage := DefaultDNSSECStorage(filepath)
+		storage.Flush()
+		return storage
+	}
+	if err != nil {
+		log.Println("Error while unmarshalling DNSSEC reports")
+		log.Fatalf("error: %v", err)
+	}
+	if reports.Reports == nil {
+		reports.Reports = []DNSSECReport{}
+	}
+
+	return &DNSSECStorage{
+		Filepath:     filepath,
+		FileContents: reports,
+	}
+}
+
+func (dir ConfigDirectory) ReadDelegation() *DelegationStorage {
+	reports := DelegationFile{}
+	filepath := dir.DataDir + "/" + DelegationName
+
+	// read the delegation file (recovering from a backup if it is corrupt)
+	err := readYAMLFile(filepath, &reports)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Println("🆕 Creating an empty " + DelegationName)
+		storage := DefaultDelegationStorage(filepath)
+		storage.Flush()
+		return storage
+	}
+	if err != nil {
+		log.Println("Error while unmarshalling delegation reports")
+		log.Fatalf("error: %v", err)
+	}
+	if reports.Reports == nil {
+		reports.Reports = []DelegationReport{}
+	}
+
+	return &DelegationStorage{
+		Filepath:     filepath,
+		FileContents: reports,
+	}
+}
@@ -0,0 +1,619 @@
+package configuration
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldSchema describes a single configuration key, derived from the struct tags on the configuration types.
+//
+// The supported `validate` rules are:
+//
+//   - required: the value cannot be empty
+//   - min=N, max=N: numeric bounds (inclusive)
+//   - email: the value must be a bare email address (empty is allowed unless required)
+//   - url: the value must be an absolute http(s) URL (empty is allowed unless required)
+//   - recipient: the value must be an email address or a contact group name
+//   - clock: the value must be a time of day as HH:MM
+//   - timezone: the value must be an IANA timezone name
+//   - hostport: the value must be a host name or IP address, optionally with a port
+//   - oneof=a|b|c: the value must be one of the listed options
+type FieldSchema struct {
+	// Key of the field (the yaml/json name)
+	Key string `json:"key"`
+	// JSON Schema type of the field (string, integer, number, boolean, array)
+	Type string `json:"type"`
+	// Human readable description of the field
+	Description string `json:"description,omitempty"`
+	// Format hint (e.g. "email")
+	Format string `json:"format,omitempty"`
+	// Minimum value for numeric fields
+	Minimum *float64 `json:"minimum,omitempty"`
+	// Maximum value for numeric fields
+	Maximum *float64 `json:"maximum,omitempty"`
+	// Allowed values for the field
+	Enum []string `json:"enum,omitempty"`
+	// The value cannot be empty
+	Required bool `json:"required,omitempty"`
+	// The value is only readable when configuration is shown in the web interface
+	Sensitive bool `json:"sensitive,omitempty"`
+
+	index int
+}
+
+// ErrInvalidConfigurationKey is returned when a key does not exist in a configuration section
+type ErrInvalidConfigurationKey struct {
+	Key string
+}
+
+func (e *ErrInvalidConfigurationKey) Error() string {
+	return "Invalid configuration key: " + e.Key
+}
+
+// ErrInvalidConfigurationSection is returned when a configuration section does not exist
+type ErrInvalidConfigurationSection struct {
+	Section string
+}
+
+func (e *ErrInvalidConfigurationSection) Error() string {
+	return "Invalid configuration section: " + e.Section
+}
+
+// FieldError is a validation error for a single configuration key
+type FieldError struct {
+	Section string `json:"section"`
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s.%s: %s", e.Section, e.Key, e.Message)
+}
+
+// ValidationErrors collects every field error found while applying a set of values
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fe := range e {
+		messages[i] = fe.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// SectionNames returns the names of all configuration sections, in file order
+func SectionNames() []string {
+	t := reflect.TypeOf(ConfigurationFile{})
+	names := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		if name := tagName(t.Field(i)); name != "" && t.Field(i).Type.Kind() == reflect.Struct {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// IsSensitiveSection reports if a whole section is hidden when configuration is not shown in the web interface
+func IsSensitiveSection(section string) bool {
+	field, ok := sectionField(section)
+	return ok && field.Tag.Get("sensitive") == "true"
+}
+
+// SectionSchema returns the field schemas for a configuration section
+func SectionSchema(section string) ([]FieldSchema, error) {
+	field, ok := sectionField(section)
+	if !ok {
+		return nil, &ErrInvalidConfigurationSection{Section: section}
+	}
+
+	t := field.Type
+	fields := []FieldSchema{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := tagName(f)
+		if key == "" {
+			continue
+		}
+		schema := FieldSchema{
+			Key:         key,
+			Type:        jsonType(f.Type),
+			Description: f.Tag.Get("description"),
+			Sensitive:   f.Tag.Get("sensitive") == "true",
+			index:       i,
+		}
+		for _, rule := range splitRules(f.Tag.Get("validate")) {
+			name, arg, _ := strings.Cut(rule, "=")
+			switch name {
+			case "required":
+				schema.Required = true
+			case "min":
+				if v, err := strconv.ParseFloat(arg, 64); err == nil {
+					schema.Minimum = &v
+				}
+			case "max":
+				if v, err := strconv.ParseFloat(arg, 64); err == nil {
+					schema.Maximum = &v
+				}
+			case "email":
+				schema.Format = "email"
+			case "url":
+				schema.Format = "uri"
+			case "clock", "timezone", "recipient", "days", "hostport":
+				schema.Format = name
+			case "oneof":
+				schema.Enum = strings.Split(arg, "|")
+			}
+		}
+		fields = append(fields, schema)
+	}
+	return fields, nil
+}
+
+// SectionJSONSchema returns a JSON Schema document describing a configuration section
+func SectionJSONSchema(section string) (map[string]interface{}, error) {
+	fields, err := SectionSchema(section)
+	if err != nil {
+		return nil, err
+	}
+
+	properties := map[string]interface{}{}
+	required := []string{}
+	for _, f := range fields {
+		property := map[string]interface{}{"type": f.Type}
+		if f.Type == "array" {
+			property["items"] = map[string]interface{}{"type": "string"}
+		}
+		if f.Description != "" {
+			property["description"] = f.Description
+		}
+		if f.Format != "" {
+			property["format"] = f.Format
+		}
+		if f.Minimum != nil {
+			property["minimum"] = *f.Minimum
+		}
+		if f.Maximum != nil {
+			property["maximum"] = *f.Maximum
+		}
+		if len(f.Enum) > 0 {
+			property["enum"] = f.Enum
+		}
+		if f.Sensitive || IsSensitiveSection(section) {
+			property["writeOnly"] = true
+		}
+		properties[f.Key] = property
+		if f.Required {
+			required = append(required, f.Key)
+		}
+	}
+	sort.Strings(required)
+
+	schema := map[string]interface{}{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"$id":                  "/api/config/" + section + "/schema",
+		"title":                section,
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema, nil
+}
+
+// FieldSchemaFor returns the schema for a single key in a section
+func FieldSchemaFor(section string, key string) (FieldSchema, error) {
+	fields, err := SectionSchema(section)
+	if err != nil {
+		return FieldSchema{}, err
+	}
+	for _, f := range fields {
+		if f.Key == key {
+			return f, nil
+		}
+	}
+	return FieldSchema{}, &ErrInvalidConfigurationKey{Key: key}
+}
+
+// GetValue returns the value stored for a section and key
+func (c *ConfigurationFile) GetValue(section string, key string) (interface{}, error) {
+	schema, err := FieldSchemaFor(section, key)
+	if err != nil {
+		return nil, err
+	}
+	return c.sectionValue(section).Field(schema.index).Interface(), nil
+}
+
+// GetSection returns all values of a section, keyed by their yaml/json name
+func (c *ConfigurationFile) GetSection(section string) (map[string]interface{}, error) {
+	fields, err := SectionSchema(section)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]interface{}{}
+	v := c.sectionValue(section)
+	for _, f := range fields {
+		values[f.Key] = v.Field(f.index).Interface()
+	}
+	return values, nil
+}
+
+// SetValues coerces and validates every value, and only applies them if all of them are valid.
+//
+// Values may be native JSON types or strings as sent by HTML forms (toggles send "on" or "").
+func (c *ConfigurationFile) SetValues(section string, values map[string]interface{}) error {
+	fields, err := SectionSchema(section)
+	if err != nil {
+		return err
+	}
+	byKey := map[string]FieldSchema{}
+	for _, f := range fields {
+		byKey[f.Key] = f
+	}
+
+	// Work on a copy of the section so a single invalid value doesn't leave a partial update behind
+	target := c.sectionValue(section)
+	working := reflect.New(target.Type()).Elem()
+	working.Set(target)
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs ValidationErrors
+	for _, key := range keys {
+		schema, ok := byKey[key]
+		if !ok {
+			errs = append(errs, FieldError{Section: section, Key: key, Message: "unknown key"})
+			continue
+		}
+		field := working.Field(schema.index)
+		if err := coerce(values[key], field); err != nil {
+			errs = append(errs, FieldError{Section: section, Key: key, Message: err.Error()})
+			continue
+		}
+		if err := validateField(schema, field); err != nil {
+			errs = append(errs, FieldError{Section: section, Key: key, Message: err.Error()})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	target.Set(working)
+	return nil
+}
+
+// SetValue coerces, validates and applies a single value
+func (c *ConfigurationFile) SetValue(section string, key string, value interface{}) error {
+	if _, err := FieldSchemaFor(section, key); err != nil {
+		return err
+	}
+	return c.SetValues(section, map[string]interface{}{key: value})
+}
+
+// WithoutSecrets returns a copy of the configuration with every value marked `secret:"true"` cleared
+func (c ConfigurationFile) WithoutSecrets() ConfigurationFile {
+	root := reflect.ValueOf(&c).Elem()
+	for _, index := range secretFields() {
+		field := root.FieldByIndex(index)
+		field.Set(reflect.Zero(field.Type()))
+	}
+	return c
+}
+
+// WithSecretsFrom returns a copy of the configuration with every value marked `secret:"true"` taken from other
+func (c ConfigurationFile) WithSecretsFrom(other ConfigurationFile) ConfigurationFile {
+	root := reflect.ValueOf(&c).Elem()
+	source := reflect.ValueOf(other)
+	for _, index := range secretFields() {
+		root.FieldByIndex(index).Set(source.FieldByIndex(index))
+	}
+	return c
+}
+
+// secretFields returns the index path of every field marked `secret:"true"`
+func secretFields() [][]int {
+	fields := [][]int{}
+	t := reflect.TypeOf(ConfigurationFile{})
+	for i := 0; i < t.NumField(); i++ {
+		section := t.Field(i).Type
+		if section.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < section.NumField(); j++ {
+			if section.Field(j).Tag.Get("secret") == "true" {
+				fields = append(fields, []int{i, j})
+			}
+		}
+	}
+	return fields
+}
+
+func (c *ConfigurationFile) sectionValue(section string) reflect.Value {
+	field, _ := sectionField(section)
+	return reflect.ValueOf(c).Elem().FieldByIndex(field.Index)
+}
+
+func sectionField(section string) (reflect.StructField, bool) {
+	t := reflect.TypeOf(ConfigurationFile{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if tagName(f) == section && f.Type.Kind() == reflect.Struct {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// tagName returns the yaml name of a struct field, or an empty string if it isn't serialized
+func tagName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice:
+		return "array"
+	default:
+		return "string"
+	}
+}
+
+func splitRules(tag string) []string {
+	if tag == "" {
+		return nil
+	}
+	return strings.Split(tag, ",")
+}
+
+// coerce converts a raw value into the type of the given field and stores it
+func coerce(raw interface{}, field reflect.Value) error {
+	switch field.Kind() {
+	case reflect.Bool:
+		switch v := raw.(type) {
+		case bool:
+			field.SetBool(v)
+		case string:
+			switch strings.ToLower(strings.TrimSpace(v)) {
+			case "on", "true", "yes", "1":
+				field.SetBool(true)
+			case "", "off", "false", "no", "0":
+				field.SetBool(false)
+			default:
+				return fmt.Errorf("must be a boolean, got %q", v)
+			}
+		default:
+			return fmt.Errorf("must be a boolean")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch v := raw.(type) {
+		case int:
+			field.SetInt(int64(v))
+		case float64:
+			if v != float64(int64(v)) {
+				return fmt.Errorf("must be a whole number")
+			}
+			field.SetInt(int64(v))
+		case string:
+			i, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil {
+				return fmt.Errorf("must be a whole number, got %q", v)
+			}
+			field.SetInt(int64(i))
+		default:
+			return fmt.Errorf("must be a whole number")
+		}
+	case reflect.Float32, reflect.Float64:
+		switch v := raw.(type) {
+		case float64:
+			field.SetFloat(v)
+		case int:
+			field.SetFloat(float64(v))
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return fmt.Errorf("must be a number, got %q", v)
+			}
+			field.SetFloat(f)
+		default:
+			return fmt.Errorf("must be a number")
+		}
+	case reflect.String:
+		v, ok := raw.(string)
+		if !ok {
+			return fmt.Errorf("must be a string")
+		}
+		field.SetString(strings.TrimSpace(v))
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type")
+		}
+		list := []string{}
+		switch v := raw.(type) {
+		case string:
+			list = SplitList(v)
+		case []string:
+			for _, item := range v {
+				list = append(list, SplitList(item)...)
+			}
+		case []interface{}:
+			for _, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					return fmt.Errorf("must be a list of strings")
+				}
+				list = append(list, SplitList(s)...)
+			}
+		default:
+			return fmt.Errorf("must be a list of strings")
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Kind())
+	}
+	return nil
+}
+
+// SplitList splits a comma (or newline) separated string into a trimmed list, dropping empty items
+func SplitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' || r == ';' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// validateField checks a coerced value against the rules in the field schema
+func validateField(schema FieldSchema, field reflect.Value) error {
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Float32, reflect.Float64:
+		var n float64
+		if field.CanInt() {
+			n = float64(field.Int())
+		} else {
+			n = field.Float()
+		}
+		if schema.Minimum != nil && n < *schema.Minimum {
+			return fmt.Errorf("must be at least %s", strconv.FormatFloat(*schema.Minimum, 'f', -1, 64))
+		}
+		if schema.Maximum != nil && n > *schema.Maximum {
+			return fmt.Errorf("must be at most %s", strconv.FormatFloat(*schema.Maximum, 'f', -1, 64))
+		}
+	case reflect.String:
+		s := field.String()
+		if s == "" {
+			if schema.Required {
+				return fmt.Errorf("is required")
+			}
+			return nil
+		}
+		if schema.Format == "email" && !IsEmailAddress(s) {
+			return fmt.Errorf("must be a valid email address, got %q", s)
+		}
+		if schema.Format == "uri" && !IsWebURL(s) {
+			return fmt.Errorf("must be an http(s) URL, got %q", s)
+		}
+		if schema.Format == "clock" {
+			if _, _, err := ParseClock(s); err != nil {
+				return err
+			}
+		}
+		if schema.Format == "timezone" {
+			if _, err := time.LoadLocation(s); err != nil {
+				return fmt.Errorf("must be an IANA timezone like Europe/Berlin, got %q", s)
+			}
+		}
+		if schema.Format == "hostport" && !IsHostPort(s) {
+			return fmt.Errorf("must be a host name or IP address, optionally with a port, got %q", s)
+		}
+		if len(schema.Enum) > 0 && !contains(schema.Enum, s) {
+			return fmt.Errorf("must be one of %s", strings.Join(schema.Enum, ", "))
+		}
+	case reflect.Slice:
+		if field.Len() == 0 && schema.Required {
+			return fmt.Errorf("is required")
+		}
+		for i := 0; i < field.Len(); i++ {
+			s := field.Index(i).String()
+			if schema.Format == "email" && !IsEmailAddress(s) {
+				return fmt.Errorf("must only contain valid email addresses, got %q", s)
+			}
+			if schema.Format == "recipient" && !IsRecipient(s) {
+				return fmt.Errorf("must only contain email addresses or contact group names, got %q", s)
+			}
+			if _, err := strconv.Atoi(s); schema.Format == "days" && (err != nil || strings.HasPrefix(s, "-")) {
+				return fmt.Errorf("must only contain whole numbers of days, got %q", s)
+			}
+			if len(schema.Enum) > 0 && !contains(schema.Enum, s) {
+				return fmt.Errorf("must only contain %s", strings.Join(schema.Enum, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+var contactGroupName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// IsEmailAddress reports if the value is a bare email address (no display name)
+func IsEmailAddress(value string) bool {
+	address, err := mail.ParseAddress(value)
+	return err == nil && address.Address == value && address.Name == ""
+}
+
+// IsContactGroupName reports if the value can be used as a contact group name
+func IsContactGroupName(value string) bool {
+	return contactGroupName.MatchString(value)
+}
+
+// IsRecipient reports if the value is an email address or a contact group name
+func IsRecipient(value string) bool {
+	return IsEmailAddress(value) || IsContactGroupName(value)
+}
+
+// ParseClock parses a time of day in the HH:MM format
+func ParseClock(value string) (int, int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, 0, fmt.Errorf("must be a time of day as HH:MM, got %q", value)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// IsWebURL reports if the value is an absolute http or https URL
+func IsWebURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// IsHostPort reports if the value is a host name or IP address, optionally followed by a port (IPv6 addresses with a
+// port in brackets)
+func IsHostPort(value string) bool {
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		host, port = strings.Trim(value, "[]"), ""
+	}
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return false
+		}
+	}
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	return hostName.MatchString(host)
+}
+
+var hostName = regexp.MustCompile(`^(?i)[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*\.?$`)
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -11,9 +11,45 @@ const Domains = "domain.yaml"
 // Location for the whois cache
 const WhoisCacheName = "whois-cache.yaml"
 
+// Location for the alert ledger
+const AlertLedgerName = "alert-ledger.yaml"
+
+// Location for the outbound notification queue
+const NotificationQueueName = "notification-queue.yaml"
+
+// Location for the alert acknowledgements and snoozes
+const SnoozesName = "snoozes.yaml"
+
+// Location for the names watched for availability
+const WatchlistName = "watchlist.yaml"
+
+// Location for the lookalikes of the monitored domains
+const LookalikesName = "lookalikes.yaml"
+
+// Location for the WHOIS cache of the lookalikes, kept apart from the monitored domains
+const LookalikeWhoisCacheName = "lookalike-whois-cache.yaml"
+
+// Location for the certificates found in the certificate transparency logs
+const CertificatesName = "certificates.yaml"
+
+// Location for the subdomains discovered below the monitored domains
+const SubdomainsName = "subdomains.yaml"
+
+// Location for the email security reports of the monitored domains
+const MailSecurityName = "mail-security.yaml"
+
+// Location for the DNSSEC reports of the monitored domains
+const DNSSECName = "dnssec.yaml"
+
+// Location for the delegation health reports of the monitored domains
+const DelegationName = "delegation.yaml"
+
 // Interval for WHOIS to recheck expirations times and cache validity
 const WhoisRefreshInterval = time.Hour * 4
 
+// Number of rotating backups kept next to each data file (`<file>.1` is the newest)
+const FileBackupCount = 5
+
 // struct for tracking the directory
 type ConfigDirectory struct {
 	// The directory to store configuration and cache files
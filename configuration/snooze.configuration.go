@@ -0,0 +1,180 @@
+package configuration
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kinds of snoozes
+const (
+	// Silences the alerts until the domain is renewed (its expiration date changes), or until the snooze expires
+	SnoozeAcknowledge = "acknowledge"
+	// Silences the alerts until the snooze expires
+	SnoozeSnooze = "snooze"
+)
+
+// Expired snoozes are kept this long, so the dashboard can still show who acknowledged an alert
+const SnoozeRetention = 30 * 24 * time.Hour
+
+// Snooze silences the alerts of one domain, either every alert type or a single one
+type Snooze struct {
+	// Unique identifier of the snooze
+	ID string `yaml:"id" json:"id"`
+	// The silenced domain
+	FQDN string `yaml:"fqdn" json:"fqdn"`
+	// Template key of the silenced alert type (e.g. "daily"), empty for every alert of the domain
+	Alert string `yaml:"alert,omitempty" json:"alert,omitempty"`
+	// SnoozeAcknowledge or SnoozeSnooze
+	Kind string `yaml:"kind" json:"kind"`
+	// Note, e.g. a reference to the renewal ticket
+	Note string `yaml:"note,omitempty" json:"note,omitempty"`
+	// Who created the snooze, a name or e-mail address
+	By string `yaml:"by,omitempty" json:"by,omitempty"`
+	// Expiration date of the domain when it was acknowledged, nil if unknown or for plain snoozes
+	Expiration *time.Time `yaml:"expiration,omitempty" json:"expiration,omitempty"`
+	// The alerts are silenced until this time
+	Until time.Time `yaml:"until" json:"until"`
+	// When the snooze was created
+	CreatedAt time.Time `yaml:"createdAt" json:"createdAt"`
+}
+
+// Covers reports if the snooze silences an alert type (by template key)
+func (s Snooze) Covers(alertKey string) bool {
+	return s.Alert == "" || s.Alert == alertKey
+}
+
+// ActiveAt reports if the snooze still silences alerts at the given time, for a domain with the given expiration date.
+// An acknowledgement ends early once the domain is renewed.
+func (s Snooze) ActiveAt(now time.Time, expiration *time.Time) bool {
+	if !now.Before(s.Until) {
+		return false
+	}
+	if s.Kind == SnoozeAcknowledge && s.Expiration != nil && expiration != nil && !s.Expiration.Equal(*expiration) {
+		return false
+	}
+	return true
+}
+
+type SnoozeFile struct {
+	// Version of the file format, used for migrations
+	Version int `yaml:"version" json:"version"`
+	// Every snooze, oldest first
+	Snoozes []Snooze `yaml:"snoozes" json:"snoozes"`
+}
+
+// SnoozeStorage keeps the acknowledgements and snoozes of alerts. It is shared by the schedulers and the web handlers,
+// so it is always used as a pointer and guards its contents with a lock.
+type SnoozeStorage struct {
+	mu sync.Mutex
+	// The snooze file contents
+	FileContents SnoozeFile
+	// The path to the snooze file
+	Filepath string
+}
+
+func DefaultSnoozeStorage(path string) *SnoozeStorage {
+	return &SnoozeStorage{
+		FileContents: SnoozeFile{Version: SnoozesVersion, Snoozes: []Snooze{}},
+		Filepath:     path,
+	}
+}
+
+// Add stores a snooze and writes the file. The ID and creation time are set if empty.
+func (s *SnoozeStorage) Add(snooze Snooze) Snooze {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if snooze.CreatedAt.IsZero() {
+		snooze.CreatedAt = time.Now()
+	}
+	if snooze.ID == "" {
+		id := make([]byte, 8)
+		rand.Read(id)
+		snooze.ID = hex.EncodeToString(id)
+	}
+	s.FileContents.Snoozes = append(s.FileContents.Snoozes, snooze)
+	s.flush()
+	return snooze
+}
+
+// Remove deletes a snooze, returning false if there is no snooze with that ID
+func (s *SnoozeStorage) Remove(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.FileContents.Snoozes {
+		if s.FileContents.Snoozes[i].ID == id {
+			s.FileContents.Snoozes = append(s.FileContents.Snoozes[:i], s.FileContents.Snoozes[i+1:]...)
+			s.flush()
+			return true
+		}
+	}
+	return false
+}
+
+// List returns the snoozes of a domain (every domain if fqdn is empty), newest first
+func (s *SnoozeStorage) List(fqdn string) []Snooze {
+	snoozes := []Snooze{}
+	if s == nil {
+		return snoozes
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, snooze := range s.FileContents.Snoozes {
+		if fqdn == "" || snooze.FQDN == fqdn {
+			snoozes = append(snoozes, snooze)
+		}
+	}
+	sort.SliceStable(snoozes, func(i, j int) bool { return snoozes[i].CreatedAt.After(snoozes[j].CreatedAt) })
+	return snoozes
+}
+
+// Prune removes the snoozes that ended more than SnoozeRetention ago. Returns the number of removed snoozes.
+func (s *SnoozeStorage) Prune(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := []Snooze{}
+	for _, snooze := range s.FileContents.Snoozes {
+		if now.Sub(snooze.Until) < SnoozeRetention {
+			kept = append(kept, snooze)
+		}
+	}
+	removed := len(s.FileContents.Snoozes) - len(kept)
+	if removed > 0 {
+		s.FileContents.Snoozes = kept
+		s.flush()
+	}
+	return removed
+}
+
+// Flush the snoozes to their storage
+func (s *SnoozeStorage) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flush()
+}
+
+func (s *SnoozeStorage) flush() {
+	// Always write the current file format version
+	s.FileContents.Version = SnoozesVersion
+
+	data, err := MarshalYAML(s.FileContents)
+	if err != nil {
+		log.Printf("❌ Error while marshalling the snoozes: %v", err)
+		return
+	}
+
+	if err := writeFileAtomic(s.Filepath, data); err != nil {
+		log.Printf("❌ Error while writing snooze file: %v", err)
+		return
+	}
+
+	log.Printf("💾 Flushed snoozes to %s", filepath.Base(s.Filepath))
+}
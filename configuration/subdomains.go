@@ -0,0 +1,221 @@
+package configuration
+
+import (
+	"log"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Sources a subdomain can be discovered from
+const (
+	// Named on a certificate in the certificate transparency logs
+	SubdomainSourceCT = "ct"
+	// Owns a record in an imported zone file
+	SubdomainSourceZone = "zone"
+	// A name of the wordlist that resolves in DNS
+	SubdomainSourceWordlist = "wordlist"
+)
+
+// Subdomain is a host below a monitored domain, found by the discovery
+type Subdomain struct {
+	// The host name
+	FQDN string `yaml:"fqdn" json:"fqdn"`
+	// The monitored domain it belongs to
+	Of string `yaml:"of" json:"of"`
+	// Where it was found, see the SubdomainSource constants
+	Sources []string `yaml:"sources" json:"sources"`
+	// Addresses it resolved to when it was last resolved
+	Addresses []string `yaml:"addresses,omitempty" json:"addresses,omitempty"`
+	// When it was first and last seen by any source
+	FirstSeen time.Time `yaml:"firstSeen" json:"firstSeen"`
+	LastSeen  time.Time `yaml:"lastSeen" json:"lastSeen"`
+	// Its certificate is checked on the discovery schedule
+	TLS bool `yaml:"tls,omitempty" json:"tls,omitempty"`
+	// The last TLS check, nil if it wasn't checked yet
+	TLSCheck *TLSCheck `yaml:"tlsCheck,omitempty" json:"tlsCheck,omitempty"`
+}
+
+// HasSource reports if the subdomain was found by a source
+func (s Subdomain) HasSource(source string) bool {
+	return contains(s.Sources, source)
+}
+
+// TLSCheck is the result of connecting to a host with TLS on port 443
+type TLSCheck struct {
+	// When the host was checked
+	CheckedAt time.Time `yaml:"checkedAt" json:"checkedAt"`
+	// The certificate the host presented, empty if the connection failed
+	Issuer   string    `yaml:"issuer,omitempty" json:"issuer,omitempty"`
+	Names    []string  `yaml:"names,omitempty" json:"names,omitempty"`
+	NotAfter time.Time `yaml:"notAfter,omitempty" json:"notAfter,omitempty"`
+	// Why the connection or the certificate verification failed, empty if the certificate is valid for the host
+	Error string `yaml:"error,omitempty" json:"error,omitempty"`
+}
+
+// DaysLeft returns the whole days until the certificate expires, negative once it expired
+func (c TLSCheck) DaysLeft(now time.Time) int {
+	return int(c.NotAfter.Sub(now).Hours() / 24)
+}
+
+type SubdomainFile struct {
+	// Version of the file format, used for migrations
+	Version int `yaml:"version" json:"version"`
+	// Every subdomain found so far
+	Subdomains []Subdomain `yaml:"subdomains" json:"subdomains"`
+}
+
+// SubdomainStorage keeps the subdomains found for the monitored domains. It is shared by the scheduler and the web
+// handlers, so it is always used as a pointer and guards its contents with a lock.
+type SubdomainStorage struct {
+	mu sync.Mutex
+	// The subdomains file contents
+	FileContents SubdomainFile
+	// The path to the subdomains file
+	Filepath string
+}
+
+func DefaultSubdomainStorage(path string) *SubdomainStorage {
+	return &SubdomainStorage{
+		FileContents: SubdomainFile{Version: SubdomainsVersion, Subdomains: []Subdomain{}},
+		Filepath:     path,
+	}
+}
+
+// List returns the subdomains of a monitored domain (every subdomain for an empty fqdn), sorted by domain and name
+func (s *SubdomainStorage) List(of string) []Subdomain {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := []Subdomain{}
+	for _, subdomain := range s.FileContents.Subdomains {
+		if of == "" || subdomain.Of == of {
+			list = append(list, subdomain)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Of != list[j].Of {
+			return list[i].Of < list[j].Of
+		}
+		return list[i].FQDN < list[j].FQDN
+	})
+	return list
+}
+
+// Get returns a subdomain by its name
+func (s *SubdomainStorage) Get(fqdn string) (Subdomain, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, subdomain := range s.FileContents.Subdomains {
+		if subdomain.FQDN == fqdn {
+			return subdomain, true
+		}
+	}
+	return Subdomain{}, false
+}
+
+// Observe records that a source saw a subdomain at a time, adding it if it is new. The first and last seen times only
+// move outwards, so older evidence (e.g. a certificate logged last year) doesn't move the last seen time back. The
+// addresses are replaced when some are given. Returns true if the subdomain is new. The file isn't written.
+func (s *SubdomainStorage) Observe(of string, fqdn string, source string, addresses []string, at time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.FileContents.Subdomains {
+		subdomain := &s.FileContents.Subdomains[i]
+		if subdomain.FQDN != fqdn {
+			continue
+		}
+		if !contains(subdomain.Sources, source) {
+			subdomain.Sources = append(subdomain.Sources, source)
+			sort.Strings(subdomain.Sources)
+		}
+		if at.Before(subdomain.FirstSeen) {
+			subdomain.FirstSeen = at
+		}
+		if at.After(subdomain.LastSeen) {
+			subdomain.LastSeen = at
+		}
+		if len(addresses) > 0 {
+			subdomain.Addresses = addresses
+		}
+		return false
+	}
+	s.FileContents.Subdomains = append(s.FileContents.Subdomains, Subdomain{
+		FQDN: fqdn, Of: of, Sources: []string{source}, Addresses: addresses, FirstSeen: at, LastSeen: at,
+	})
+	return true
+}
+
+// SetTLS turns the TLS check of a subdomain on or off. Returns false if the subdomain isn't known. The file isn't
+// written.
+func (s *SubdomainStorage) SetTLS(fqdn string, enabled bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.FileContents.Subdomains {
+		if s.FileContents.Subdomains[i].FQDN == fqdn {
+			s.FileContents.Subdomains[i].TLS = enabled
+			if !enabled {
+				s.FileContents.Subdomains[i].TLSCheck = nil
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// RecordTLS stores the TLS check of a subdomain. The file isn't written.
+func (s *SubdomainStorage) RecordTLS(fqdn string, check TLSCheck) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.FileContents.Subdomains {
+		if s.FileContents.Subdomains[i].FQDN == fqdn {
+			s.FileContents.Subdomains[i].TLSCheck = &check
+		}
+	}
+}
+
+// Forget removes the subdomains of a domain that is no longer monitored. Returns true if there was something to
+// remove. The file isn't written.
+func (s *SubdomainStorage) Forget(of string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := false
+	subdomains := s.FileContents.Subdomains[:0]
+	for _, subdomain := range s.FileContents.Subdomains {
+		if subdomain.Of == of {
+			removed = true
+			continue
+		}
+		subdomains = append(subdomains, subdomain)
+	}
+	s.FileContents.Subdomains = subdomains
+	return removed
+}
+
+// Flush the subdomains to their storage
+func (s *SubdomainStorage) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Always write the current file format version
+	s.FileContents.Version = SubdomainsVersion
+
+	data, err := MarshalYAML(s.FileContents)
+	if err != nil {
+		log.Printf("❌ Error while marshalling the subdomains: %v", err)
+		return
+	}
+
+	if err := writeFileAtomic(s.Filepath, data); err != nil {
+		log.Printf("❌ Error while writing subdomains file: %v", err)
+		return
+	}
+
+	log.Printf("💾 Flushed subdomains to %s", filepath.Base(s.Filepath))
+}
@@ -0,0 +1,281 @@
+package configuration
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	whoisparser "github.com/likexian/whois-parser"
+)
+
+// Availability of a watched name
+const (
+	// Not checked yet, or every check failed so far
+	WatchUnknown = "unknown"
+	// Not registered, it can be registered right now
+	WatchAvailable = "available"
+	// Registered by someone
+	WatchRegistered = "registered"
+	// Expired and in the redemption period, only the current owner can still restore it
+	WatchRedemption = "redemption"
+	// About to be deleted and released for registration
+	WatchPendingDelete = "pendingdelete"
+)
+
+// WatchAlertStates are the availabilities that are alerted when a watched name enters them
+var WatchAlertStates = []string{WatchAvailable, WatchRegistered, WatchPendingDelete}
+
+// Errors of the watchlist
+var (
+	ErrWatchExists   = errors.New("name is already watched")
+	ErrWatchNotFound = errors.New("name is not watched")
+)
+
+// WatchedDomain is a name that isn't owned (yet) and is watched for becoming available
+type WatchedDomain struct {
+	// The watched name
+	FQDN string `yaml:"fqdn" json:"fqdn"`
+	// Why the name is watched, e.g. the backorder reference
+	Notes string `yaml:"notes,omitempty" json:"notes,omitempty"`
+	// Receive the alerts of this name instead of the default recipients (addresses or contact groups)
+	Owners []string `yaml:"owners,omitempty" json:"owners,omitempty"`
+	// When the name was added to the watchlist
+	AddedAt time.Time `yaml:"addedAt" json:"addedAt"`
+	// unknown, available, registered, redemption or pendingdelete
+	Availability string `yaml:"availability" json:"availability"`
+	// Registrar, expiration date and registry status values of the current registration, empty while not registered
+	Registrar  string     `yaml:"registrar,omitempty" json:"registrar,omitempty"`
+	Expiration *time.Time `yaml:"expiration,omitempty" json:"expiration,omitempty"`
+	Status     []string   `yaml:"status,omitempty" json:"status,omitempty"`
+	// When the name was last checked, and when its availability last changed
+	LastChecked *time.Time `yaml:"lastChecked,omitempty" json:"lastChecked,omitempty"`
+	LastChanged *time.Time `yaml:"lastChanged,omitempty" json:"lastChanged,omitempty"`
+	// Why the last check failed, empty if it succeeded
+	LastError string `yaml:"lastError,omitempty" json:"lastError,omitempty"`
+}
+
+// Normalize trims the name and splits the owners
+func (w *WatchedDomain) Normalize() {
+	w.FQDN = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(w.FQDN), "."))
+	w.Notes = strings.TrimSpace(w.Notes)
+	w.Owners = normalizeList(w.Owners)
+	if w.Availability == "" {
+		w.Availability = WatchUnknown
+	}
+}
+
+// Validate checks the name and the owners of a watched name
+func (w WatchedDomain) Validate() error {
+	var errs ValidationErrors
+	if !strings.Contains(w.FQDN, ".") || strings.ContainsAny(w.FQDN, " /:@") {
+		errs = append(errs, FieldError{Section: "watch", Key: "fqdn", Message: fmt.Sprintf("must be a domain name, got %q", w.FQDN)})
+	}
+	for _, owner := range w.Owners {
+		if !IsRecipient(owner) {
+			errs = append(errs, FieldError{Section: "watch", Key: "owners", Message: fmt.Sprintf("must only contain email addresses or contact group names, got %q", owner)})
+			break
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Expiring reports if the registration is about to end, the name may become available soon
+func (w WatchedDomain) Expiring() bool {
+	return w.Availability == WatchRedemption || w.Availability == WatchPendingDelete
+}
+
+// WatchAvailability classifies the result of a WHOIS or RDAP lookup. Lookups that failed for another reason than the
+// name not being registered return the error.
+func WatchAvailability(info whoisparser.WhoisInfo, err error) (string, error) {
+	if err == ErrDomainNotFound {
+		return WatchAvailable, nil
+	}
+	if err != nil {
+		return WatchUnknown, err
+	}
+	var status RegistryStatus
+	if info.Domain != nil {
+		status = InterpretStatus(info.Domain.Status)
+	}
+	switch {
+	case status.PendingDelete:
+		return WatchPendingDelete, nil
+	case status.Redemption:
+		return WatchRedemption, nil
+	}
+	return WatchRegistered, nil
+}
+
+// Record stores the result of a lookup. Failed lookups keep the last known availability. Returns the availability
+// before the lookup.
+func (w *WatchedDomain) Record(info whoisparser.WhoisInfo, err error, now time.Time) string {
+	previous := w.Availability
+	w.LastChecked = &now
+
+	availability, err := WatchAvailability(info, err)
+	if err != nil {
+		w.LastError = err.Error()
+		return previous
+	}
+	w.LastError = ""
+	w.Registrar, w.Expiration, w.Status = "", nil, nil
+	if availability != WatchAvailable {
+		if info.Registrar != nil {
+			w.Registrar = info.Registrar.Name
+			if w.Registrar == "" {
+				w.Registrar = info.Registrar.Organization
+			}
+		}
+		if info.Domain != nil {
+			w.Expiration = info.Domain.ExpirationDateInTime
+			w.Status = info.Domain.Status
+		}
+	}
+	if availability != previous {
+		w.Availability = availability
+		w.LastChanged = &now
+	}
+	return previous
+}
+
+// AlertDue reports if the availability changed into one of the WatchAlertStates. The first check of a name only
+// records its availability.
+func (w WatchedDomain) AlertDue(previous string) bool {
+	if previous == WatchUnknown || previous == "" || previous == w.Availability {
+		return false
+	}
+	for _, state := range WatchAlertStates {
+		if w.Availability == state {
+			return true
+		}
+	}
+	return false
+}
+
+type WatchlistFile struct {
+	// Version of the file format, used for migrations
+	Version int `yaml:"version" json:"version"`
+	// The watched names
+	Domains []WatchedDomain `yaml:"domains" json:"domains"`
+}
+
+// WatchlistStorage keeps the names watched for availability. It is shared by the scheduler and the web handlers, so it
+// is always used as a pointer and guards its contents with a lock.
+type WatchlistStorage struct {
+	mu sync.Mutex
+	// The watchlist file contents
+	FileContents WatchlistFile
+	// The path to the watchlist file
+	Filepath string
+}
+
+func DefaultWatchlistStorage(path string) *WatchlistStorage {
+	return &WatchlistStorage{
+		FileContents: WatchlistFile{Version: WatchlistVersion, Domains: []WatchedDomain{}},
+		Filepath:     path,
+	}
+}
+
+// List returns every watched name, sorted by name
+func (s *WatchlistStorage) List() []WatchedDomain {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := append([]WatchedDomain{}, s.FileContents.Domains...)
+	sort.Slice(list, func(i, j int) bool { return list[i].FQDN < list[j].FQDN })
+	return list
+}
+
+// Get returns a watched name
+func (s *WatchlistStorage) Get(fqdn string) (WatchedDomain, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, watch := range s.FileContents.Domains {
+		if watch.FQDN == fqdn {
+			return watch, true
+		}
+	}
+	return WatchedDomain{}, false
+}
+
+// Add stores a new watched name and writes the file. The name must already be normalized and validated.
+func (s *WatchlistStorage) Add(watch WatchedDomain) (WatchedDomain, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.FileContents.Domains {
+		if existing.FQDN == watch.FQDN {
+			return existing, ErrWatchExists
+		}
+	}
+	if watch.AddedAt.IsZero() {
+		watch.AddedAt = time.Now()
+	}
+	s.FileContents.Domains = append(s.FileContents.Domains, watch)
+	s.flush()
+	return watch, nil
+}
+
+// Remove deletes a watched name and writes the file
+func (s *WatchlistStorage) Remove(fqdn string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.FileContents.Domains {
+		if s.FileContents.Domains[i].FQDN == fqdn {
+			s.FileContents.Domains = append(s.FileContents.Domains[:i], s.FileContents.Domains[i+1:]...)
+			s.flush()
+			return nil
+		}
+	}
+	return ErrWatchNotFound
+}
+
+// Record stores the result of a lookup for a watched name, see WatchedDomain.Record. The file isn't written, call
+// Flush after recording a batch of lookups.
+func (s *WatchlistStorage) Record(fqdn string, info whoisparser.WhoisInfo, err error, now time.Time) (WatchedDomain, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.FileContents.Domains {
+		if s.FileContents.Domains[i].FQDN == fqdn {
+			previous := s.FileContents.Domains[i].Record(info, err, now)
+			return s.FileContents.Domains[i], previous, nil
+		}
+	}
+	return WatchedDomain{}, "", ErrWatchNotFound
+}
+
+// Flush the watchlist to its storage
+func (s *WatchlistStorage) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flush()
+}
+
+func (s *WatchlistStorage) flush() {
+	// Always write the current file format version
+	s.FileContents.Version = WatchlistVersion
+
+	data, err := MarshalYAML(s.FileContents)
+	if err != nil {
+		log.Printf("❌ Error while marshalling the watchlist: %v", err)
+		return
+	}
+
+	if err := writeFileAtomic(s.Filepath, data); err != nil {
+		log.Printf("❌ Error while writing watchlist file: %v", err)
+		return
+	}
+
+	log.Printf("💾 Flushed watchlist to %s", filepath.Base(s.Filepath))
+}
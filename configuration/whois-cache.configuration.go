@@ -1,14 +1,14 @@
 package configuration
 
 import (
-	"bytes"
+	"errors"
 	"log"
-	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/likexian/whois"
 	whoisparser "github.com/likexian/whois-parser"
-	"gopkg.in/yaml.v3"
 )
 
 type WhoisCache struct {
@@ -31,28 +31,59 @@ type WhoisCache struct {
 	Sent3DayAlert bool `yaml:"sent3DayAlert" json:"sent3DayAlert"`
 	// Date of the last alert sent
 	LastAlertSent time.Time `yaml:"lastAlertSent" json:"lastAlertSent"`
+	// Registry status alerts sent since the domain entered the status, cleared once it leaves it
+	SentStatusAlerts []string `yaml:"sentStatusAlerts,omitempty" json:"sentStatusAlerts,omitempty"`
+	// The most recent alerts sent for this domain and who received them
+	SentAlerts []SentAlert `yaml:"sentAlerts,omitempty" json:"sentAlerts,omitempty"`
 }
 
+// SentAlert records an alert that was sent and its recipients
+type SentAlert struct {
+	Alert      string    `yaml:"alert" json:"alert"`
+	SentAt     time.Time `yaml:"sentAt" json:"sentAt"`
+	Recipients []string  `yaml:"recipients" json:"recipients"`
+}
+
+// Number of sent alerts kept per WHOIS cache entry
+const sentAlertHistory = 20
+
 type WhoisCacheFile struct {
+	// Version of the file format, used for migrations
+	Version int `yaml:"version" json:"version"`
 	// The whois cache entries
 	Entries []WhoisCache `yaml:"entries" json:"entries"`
 }
 
+// WhoisCacheStorage keeps the WHOIS entries of the domains. It is shared by the schedulers, the services and the web
+// handlers, so it is always used as a pointer and guards its entries with a lock. The entries it returns are copies.
 type WhoisCacheStorage struct {
+	mu sync.Mutex
 	// The whois file contents
 	FileContents WhoisCacheFile
 	// The path to the whois cache file
 	Filepath string
 }
 
-func DefaultWhoisCacheStorage(path string) WhoisCacheStorage {
-	return WhoisCacheStorage{
-		FileContents: WhoisCacheFile{},
+func DefaultWhoisCacheStorage(path string) *WhoisCacheStorage {
+	return &WhoisCacheStorage{
+		FileContents: WhoisCacheFile{Version: WhoisCacheVersion},
 		Filepath:     path,
 	}
 }
 
+// Get returns a copy of the entry of a domain, nil if it isn't cached
 func (w *WhoisCacheStorage) Get(fqdn string) *WhoisCache {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if entry := w.get(fqdn); entry != nil {
+		copied := *entry
+		return &copied
+	}
+	return nil
+}
+
+func (w *WhoisCacheStorage) get(fqdn string) *WhoisCache {
 	// Find the entry
 	for i := range w.FileContents.Entries {
 		if w.FileContents.Entries[i].FQDN == fqdn {
@@ -64,160 +95,303 @@ func (w *WhoisCacheStorage) Get(fqdn string) *WhoisCache {
 	return nil
 }
 
+// GetAll returns a copy of every entry
 func (w *WhoisCacheStorage) GetAll() []WhoisCache {
-	return w.FileContents.Entries
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return append([]WhoisCache{}, w.FileContents.Entries...)
 }
 
+// Add looks up a domain, stores its entry and writes the cache
 func (w *WhoisCacheStorage) Add(fqdn string) {
-	// Create a new entry
-	newEntry := WhoisCache{
-		FQDN:        fqdn,
-		WhoisInfo:   whoisparser.WhoisInfo{},
-		LastUpdated: time.Time{},
-	}
+	w.RefreshEntry(fqdn)
+	w.Flush()
+}
 
-	// Perform the whois query for the new domain
-	newEntry.Refresh()
+// RefreshEntry looks up a domain right away and stores the result in its entry, which is added if it is missing.
+// Returns a copy of the entry. The lookup runs without holding the lock, so a slow WHOIS server doesn't block the
+// other users of the cache. The cache isn't written.
+func (w *WhoisCacheStorage) RefreshEntry(fqdn string) WhoisCache {
+	info, err := LookupDomain(fqdn)
 
-	// Add the entry to the list
-	w.FileContents.Entries = append(w.FileContents.Entries, newEntry)
-	// Flush the cache to disk
-	w.Flush()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	entry := w.get(fqdn)
+	if entry == nil {
+		w.FileContents.Entries = append(w.FileContents.Entries, WhoisCache{FQDN: fqdn})
+		entry = &w.FileContents.Entries[len(w.FileContents.Entries)-1]
+	}
+	entry.apply(info, err)
+	return *entry
+}
+
+// Fetch returns a copy of the entry of a domain, looking it up if it is missing or expired. The cache isn't written,
+// call Flush after fetching a batch of entries.
+func (w *WhoisCacheStorage) Fetch(fqdn string) WhoisCache {
+	if entry := w.Get(fqdn); entry != nil && !entry.IsExpired() {
+		return *entry
+	}
+	return w.RefreshEntry(fqdn)
 }
 
+// Refresh looks up the expired entries again, and writes the cache if any was refreshed
 func (w *WhoisCacheStorage) Refresh() {
-	nothingRefreshed := true
-	// Only refresh the entries that are expired
-	for i := range w.FileContents.Entries {
-		if w.FileContents.Entries[i].IsExpired() {
-			w.FileContents.Entries[i].Refresh()
-			nothingRefreshed = false
+	expired := []string{}
+	for _, entry := range w.GetAll() {
+		// Only refresh the entries that are expired
+		if entry.IsExpired() {
+			expired = append(expired, entry.FQDN)
 		}
 	}
+	for _, fqdn := range expired {
+		w.RefreshEntry(fqdn)
+	}
 
 	// If nothing was refreshed, log a short message that the cache is up to date
-	if nothingRefreshed {
+	if len(expired) == 0 {
 		log.Println("✅ WHOIS cache not reporting any expired entries. Cache is up to date.")
 	} else {
 		w.Flush()
 	}
 }
 
-func (w *WhoisCacheStorage) RefreshWithDomains(domains DomainConfiguration) {
-	// Make sure we have whois entries for all the domains
-	for _, domain := range domains.DomainFile.Domains {
-		if w.Get(domain.FQDN) == nil {
+// RefreshWithDomains adds the missing WHOIS entries of the monitored domains and refreshes their expired entries.
+// Paused and archived domains are left alone.
+func (w *WhoisCacheStorage) RefreshWithDomains(domains *DomainConfiguration) {
+	nothingRefreshed := true
+	for _, domain := range domains.List() {
+		if !domain.Monitored() {
+			continue
+		}
+		entry := w.Get(domain.FQDN)
+		if entry == nil {
 			log.Printf("📄 Adding WHOIS entry for %s", domain.FQDN)
 			w.Add(domain.FQDN)
+		} else if entry.IsExpired() {
+			w.RefreshEntry(domain.FQDN)
+			nothingRefreshed = false
+		}
+	}
+
+	if nothingRefreshed {
+		log.Println("✅ WHOIS cache not reporting any expired entries. Cache is up to date.")
+	} else {
+		w.Flush()
+	}
+}
+
+// SaveAlerts stores the sent alerts of entries returned by Get, after they were marked with MarkAlertSentTo. The rest
+// of the cached entry is kept, so a refresh that finished in the meantime isn't undone. The cache isn't written.
+func (w *WhoisCacheStorage) SaveAlerts(entries ...*WhoisCache) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, entry := range entries {
+		if entry == nil {
+			continue
 		}
+		stored := w.get(entry.FQDN)
+		if stored == nil {
+			continue
+		}
+		stored.Sent2MonthAlert, stored.Sent1MonthAlert, stored.Sent2WeekAlert = entry.Sent2MonthAlert, entry.Sent1MonthAlert, entry.Sent2WeekAlert
+		stored.Sent1WeekAlert, stored.Sent3DayAlert = entry.Sent1WeekAlert, entry.Sent3DayAlert
+		stored.LastAlertSent = entry.LastAlertSent
+		stored.SentStatusAlerts = append([]string(nil), entry.SentStatusAlerts...)
+		stored.SentAlerts = append([]SentAlert(nil), entry.SentAlerts...)
 	}
-	// Refresh the entries
-	w.Refresh()
 }
 
 func (w *WhoisCacheStorage) Remove(fqdn string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
 	// Find the entry
 	for i := range w.FileContents.Entries {
 		if w.FileContents.Entries[i].FQDN == fqdn {
 			// Remove the entry
 			w.FileContents.Entries = append(w.FileContents.Entries[:i], w.FileContents.Entries[i+1:]...)
 			log.Printf("🗑 Removed WHOIS entry for %s", fqdn)
-			w.Flush()
+			w.flush()
 			return
 		}
 	}
 
-	w.Flush()
+	w.flush()
 }
 
+// How old a WHOIS entry may get before it is refreshed. The registry status alerts are only evaluated after a
+// refresh, so the domains whose status can change any day (expiring within the first alert, already expired, or on
+// hold, in redemption or pending delete) are refreshed daily. Otherwise a domain could enter and leave the redemption
+// period or pending delete between two refreshes without an alert.
+const (
+	whoisStaleAge       = 30 * 24 * time.Hour
+	whoisUrgentStaleAge = 24 * time.Hour
+	whoisUrgentWindow   = 60 * 24 * time.Hour
+)
+
 func (w *WhoisCache) IsExpired() bool {
-	// If the last update was more than 30 days ago, then it's expired
-	return time.Since(w.LastUpdated) > 30*24*time.Hour
+	age := time.Since(w.LastUpdated)
+	if age > whoisStaleAge {
+		return true
+	}
+	return age > whoisUrgentStaleAge && w.Urgent(time.Now())
 }
 
-func (w *WhoisCache) Refresh() {
+// Urgent reports if the entry is refreshed daily: the domain expires within 60 days (the first alert), already
+// expired, or is in a dangerous registry status
+func (w *WhoisCache) Urgent(now time.Time) bool {
+	if w.RegistryStatus().Dangerous() {
+		return true
+	}
+	if w.WhoisInfo.Domain == nil || w.WhoisInfo.Domain.ExpirationDateInTime == nil {
+		return false
+	}
+	return w.WhoisInfo.Domain.ExpirationDateInTime.Sub(now) < whoisUrgentWindow
+}
+
+// ErrDomainNotFound is returned by LookupDomain when the domain isn't registered
+var ErrDomainNotFound = errors.New("domain is not registered")
+
+// LookupDomain queries WHOIS for a domain, falling back to RDAP if the query or parsing fails. Returns
+// ErrDomainNotFound if the registry reports the domain as not registered.
+func LookupDomain(fqdn string) (whoisparser.WhoisInfo, error) {
 	// Try WHOIS first (default method)
-	whoisRaw, err := whois.Whois(w.FQDN)
+	whoisRaw, err := whois.Whois(fqdn)
 	if err != nil {
-		log.Printf("⚠️ WHOIS query failed for %s: %s, trying RDAP fallback...", w.FQDN, err)
+		log.Printf("⚠️ WHOIS query failed for %s: %s, trying RDAP fallback...", fqdn, err)
 		// Fallback to RDAP if WHOIS fails
-		whoisInfo, rdapErr := QueryRDAP(w.FQDN)
+		whoisInfo, rdapErr := QueryRDAP(fqdn)
 		if rdapErr != nil {
-			log.Printf("❌ RDAP query also failed for %s: %s", w.FQDN, rdapErr)
-			return
+			log.Printf("❌ RDAP query also failed for %s: %s", fqdn, rdapErr)
+			if rdapErr == errRDAPNotFound {
+				return whoisInfo, ErrDomainNotFound
+			}
+			return whoisInfo, rdapErr
 		}
-		// RDAP succeeded, update the object
-		w.WhoisInfo = whoisInfo
-		w.LastUpdated = time.Now()
-		log.Printf("📄 Refreshed domain info for %s via RDAP (WHOIS fallback)", w.FQDN)
-		return
+		log.Printf("📄 Looked up %s via RDAP (WHOIS fallback)", fqdn)
+		return whoisInfo, nil
 	}
 
 	// Parse the whois response
 	whoisInfo, err := whoisparser.Parse(whoisRaw)
 	if err != nil {
-		log.Printf("⚠️ Error parsing whois for %s: %s, trying RDAP fallback...", w.FQDN, err)
+		log.Printf("⚠️ Error parsing whois for %s: %s, trying RDAP fallback...", fqdn, err)
 		// If parsing failed but we got a response, it might be empty/invalid
 		// Try RDAP as fallback
-		whoisInfo, rdapErr := QueryRDAP(w.FQDN)
+		whoisInfo, rdapErr := QueryRDAP(fqdn)
 		if rdapErr != nil {
-			log.Printf("❌ RDAP query also failed for %s: %s", w.FQDN, rdapErr)
-			if err == whoisparser.ErrNotFoundDomain {
-				w.NxDomain = true
+			log.Printf("❌ RDAP query also failed for %s: %s", fqdn, rdapErr)
+			if err == whoisparser.ErrNotFoundDomain || rdapErr == errRDAPNotFound {
+				return whoisInfo, ErrDomainNotFound
 			}
-			return
+			return whoisInfo, err
 		}
-		// RDAP succeeded, update the object
-		w.WhoisInfo = whoisInfo
-		w.LastUpdated = time.Now()
-		log.Printf("📄 Refreshed domain info for %s via RDAP (WHOIS parse fallback)", w.FQDN)
+		log.Printf("📄 Looked up %s via RDAP (WHOIS parse fallback)", fqdn)
+		return whoisInfo, nil
+	}
+
+	return whoisInfo, nil
+}
+
+func (w *WhoisCache) Refresh() {
+	w.apply(LookupDomain(w.FQDN))
+}
+
+// apply stores the result of a lookup in the entry. A failed lookup leaves the entry as it is.
+func (w *WhoisCache) apply(whoisInfo whoisparser.WhoisInfo, err error) {
+	if err == ErrDomainNotFound {
+		w.NxDomain = true
+		log.Printf("🈳 %s is not registered", w.FQDN)
+		return
+	}
+	if err != nil {
 		return
 	}
 
-	// WHOIS succeeded, update the object
+	// The lookup succeeded, update the object
 	w.WhoisInfo = whoisInfo
+	w.NxDomain = false
 	w.LastUpdated = time.Now()
+	w.resetStatusAlerts()
 
 	log.Printf("📄 Refreshed whois for %s", w.FQDN)
 }
 
 // Flush the whois cache to its storage
-func (w WhoisCacheStorage) Flush() {
+func (w *WhoisCacheStorage) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flush()
+}
+
+func (w *WhoisCacheStorage) flush() {
+	// Always write the current file format version
+	w.FileContents.Version = WhoisCacheVersion
+
 	// Write the FileContents to the FilePath
-	var buf bytes.Buffer
-	encoder := yaml.NewEncoder(&buf)
-	encoder.SetIndent(4)
-	err := encoder.Encode(w.FileContents)
+	data, err := MarshalYAML(w.FileContents)
 	if err != nil {
 		log.Println("Error while marshalling WHOIS cache")
 		log.Fatalf("error: %v", err)
 	}
-	encoder.Close()
-	
-	// Process the YAML to ensure all string values are quoted
-	data := quoteYAMLStrings(buf.Bytes())
 
-	file, err := os.Create(w.Filepath)
-	if err != nil {
-		log.Println("Error while creating WHOIS cache file")
-		log.Fatalf("error: %v", err)
+	if err := writeFileAtomic(w.Filepath, data); err != nil {
+		log.Printf("❌ Error while writing WHOIS cache file: %v", err)
+		return
 	}
 
-	defer file.Close()
+	log.Printf("💾 Flushed WHOIS data cache to %s", filepath.Base(w.Filepath))
+}
 
-	_, err = file.Write(data)
-	if err != nil {
-		log.Println("Error while writing WHOIS cache file")
-		log.Fatalf("error: %v", err)
+// Check if a one-time alert has been sent, by specifying the Alert type. The daily alert is never marked as sent
+// (see LastAlertSent instead).
+func (w *WhoisCache) AlertSent(alert Alert) bool {
+	switch alert {
+	case Alert2Months:
+		return w.Sent2MonthAlert
+	case Alert1Month:
+		return w.Sent1MonthAlert
+	case Alert2Weeks:
+		return w.Sent2WeekAlert
+	case Alert1Week:
+		return w.Sent1WeekAlert
+	case Alert3Days:
+		return w.Sent3DayAlert
 	}
-	// Check if the file has been written
-	fileInfo, err := file.Stat()
-	if err != nil {
-		log.Println("Error while checking WHOIS cache file")
-		log.Fatalf("error: %v", err)
+	if alert.IsStatusAlert() {
+		return containsFold(w.SentStatusAlerts, alert.String())
 	}
+	return false
+}
 
-	log.Printf("💾 Flushed WHOIS data cache to %s", fileInfo.Name())
+// resetStatusAlerts forgets the status alerts of the statuses the domain left, so entering one again alerts again
+func (w *WhoisCache) resetStatusAlerts() {
+	current := w.RegistryStatus().Alerts()
+	kept := []string{}
+	for _, alert := range current {
+		if containsFold(w.SentStatusAlerts, alert.String()) {
+			kept = append(kept, alert.String())
+		}
+	}
+	if len(kept) < len(w.SentStatusAlerts) {
+		log.Printf("🔓 %s left the registry status of its earlier alerts", w.FQDN)
+	}
+	w.SentStatusAlerts = kept
+	if len(kept) == 0 {
+		w.SentStatusAlerts = nil
+	}
+}
+
+// Mark an alert as sent and record who it was sent to
+func (w *WhoisCache) MarkAlertSentTo(alert Alert, recipients []string) {
+	w.MarkAlertSent(alert)
+	w.SentAlerts = append(w.SentAlerts, SentAlert{Alert: alert.String(), SentAt: w.LastAlertSent, Recipients: recipients})
+	if len(w.SentAlerts) > sentAlertHistory {
+		w.SentAlerts = w.SentAlerts[len(w.SentAlerts)-sentAlertHistory:]
+	}
 }
 
 // Mark an alert as sent, by specifying the Alert type
@@ -253,6 +427,12 @@ func (w *WhoisCache) MarkAlertSent(alert Alert) {
 			log.Printf("⚠️ %s was already marked as sent for %s!", alert, w.FQDN)
 		}
 		w.Sent3DayAlert = true
+	case AlertHold, AlertRedemption, AlertPendingDelete:
+		if containsFold(w.SentStatusAlerts, alert.String()) {
+			log.Printf("⚠️ %s was already marked as sent for %s!", alert, w.FQDN)
+		} else {
+			w.SentStatusAlerts = append(w.SentStatusAlerts, alert.String())
+		}
 	case AlertDaily:
 		// Check if the alert has already been sent, and log the inconsistency
 		// We have to check if the date stored is today to know if we sent it already
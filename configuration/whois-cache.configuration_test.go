@@ -0,0 +1,103 @@
+package configuration
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	whoisparser "github.com/likexian/whois-parser"
+)
+
+func TestWhoisCacheIsExpired(t *testing.T) {
+	now := time.Now()
+	expiresIn := func(d time.Duration) *time.Time {
+		expiration := now.Add(d)
+		return &expiration
+	}
+
+	tests := []struct {
+		name       string
+		updated    time.Duration
+		expiration *time.Time
+		status     []string
+		expired    bool
+	}{
+		{"fresh", 2 * time.Hour, expiresIn(365 * 24 * time.Hour), nil, false},
+		{"far from expiry, refreshed monthly", 10 * 24 * time.Hour, expiresIn(365 * 24 * time.Hour), nil, false},
+		{"stale after 30 days", 31 * 24 * time.Hour, expiresIn(365 * 24 * time.Hour), nil, true},
+		{"near expiry, refreshed daily", 25 * time.Hour, expiresIn(45 * 24 * time.Hour), nil, true},
+		{"near expiry, refreshed today", 20 * time.Hour, expiresIn(45 * 24 * time.Hour), nil, false},
+		{"expired, refreshed daily", 25 * time.Hour, expiresIn(-10 * 24 * time.Hour), nil, true},
+		{"in redemption, refreshed daily", 25 * time.Hour, expiresIn(365 * 24 * time.Hour), []string{"redemptionPeriod"}, true},
+		{"on hold, refreshed daily", 25 * time.Hour, nil, []string{"client hold"}, true},
+		{"no expiration date", 25 * time.Hour, nil, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := WhoisCache{
+				FQDN:        "example.com",
+				LastUpdated: now.Add(-tt.updated),
+				WhoisInfo:   whoisparser.WhoisInfo{Domain: &whoisparser.Domain{ExpirationDateInTime: tt.expiration, Status: tt.status}},
+			}
+			if got := entry.IsExpired(); got != tt.expired {
+				t.Errorf("IsExpired() = %v, want %v", got, tt.expired)
+			}
+		})
+	}
+}
+
+func TestWhoisCacheSaveAlerts(t *testing.T) {
+	cache := DefaultWhoisCacheStorage(filepath.Join(t.TempDir(), WhoisCacheName))
+	cache.FileContents.Entries = []WhoisCache{{FQDN: "example.com"}}
+
+	// An alert is sent from a copy while a refresh stores a newer lookup
+	entry := cache.Get("example.com")
+	entry.MarkAlertSentTo(Alert1Week, []string{"admin@example.com"})
+	if stored := cache.Get("example.com"); stored.Sent1WeekAlert {
+		t.Fatal("marking a copy changed the cached entry")
+	}
+	refreshed := time.Now()
+	cache.FileContents.Entries[0].LastUpdated = refreshed
+
+	cache.SaveAlerts(entry, nil)
+	stored := cache.Get("example.com")
+	if !stored.Sent1WeekAlert || len(stored.SentAlerts) != 1 || stored.SentAlerts[0].Recipients[0] != "admin@example.com" {
+		t.Errorf("stored %+v, want the sent 1 week alert", stored)
+	}
+	if !stored.LastUpdated.Equal(refreshed) {
+		t.Error("saving the alerts undid the refresh")
+	}
+}
+
+func TestSharedStoragesConcurrentUse(t *testing.T) {
+	dir := t.TempDir()
+	domains := DefaultDomainConfiguration(filepath.Join(dir, Domains))
+	cache := DefaultWhoisCacheStorage(filepath.Join(dir, WhoisCacheName))
+	cache.FileContents.Entries = []WhoisCache{{FQDN: "example.com", LastUpdated: time.Now()}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			domains.UpdateDomain(Domain{FQDN: "example.com", Enabled: true})
+			domains.List()
+		}()
+		go func() {
+			defer wg.Done()
+			entry := cache.Get("example.com")
+			entry.MarkAlertSent(AlertDaily)
+			cache.SaveAlerts(entry)
+			cache.Flush()
+		}()
+	}
+	wg.Wait()
+
+	file := DomainFile{}
+	readBackRaw(t, domains.Filepath, &file)
+	if len(file.Domains) != 1 {
+		t.Errorf("wrote %d domains, want 1", len(file.Domains))
+	}
+}
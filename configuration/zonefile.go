@@ -0,0 +1,101 @@
+package configuration
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ZoneFileHosts returns the host names owned by records in a zone file (RFC 1035 master file format, e.g. an export
+// of the DNS provider or the output of `dig axfr`) that are below origin. Relative names are completed with $ORIGIN
+// (origin until the file sets one). Wildcards and service names starting with an underscore (e.g. _dmarc) aren't hosts
+// and are left out, so is the origin itself.
+func ZoneFileHosts(r io.Reader, origin string) ([]string, error) {
+	origin = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(origin)), ".")
+	current := origin
+	owner := ""
+	depth := 0
+	seen := map[string]bool{}
+	hosts := []string{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := stripZoneComment(scanner.Text())
+		continued := depth > 0
+		depth += strings.Count(text, "(") - strings.Count(text, ")")
+		if depth < 0 {
+			return nil, fmt.Errorf("line %d: unbalanced parentheses", line)
+		}
+		// Lines inside parentheses continue the previous record
+		if continued || strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		switch strings.ToUpper(fields[0]) {
+		case "$ORIGIN":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %d: $ORIGIN without a name", line)
+			}
+			current = absoluteZoneName(fields[1], current)
+			continue
+		case "$TTL", "$INCLUDE", "$GENERATE":
+			continue
+		}
+
+		// A record starting with whitespace belongs to the previous owner
+		if text[0] != ' ' && text[0] != '\t' {
+			owner = absoluteZoneName(fields[0], current)
+		}
+		if owner == "" || owner == origin || !strings.HasSuffix(owner, "."+origin) {
+			continue
+		}
+		label := strings.Split(owner, ".")[0]
+		if label == "*" || strings.HasPrefix(label, "_") || seen[owner] {
+			continue
+		}
+		seen[owner] = true
+		hosts = append(hosts, owner)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(hosts)
+	return hosts, nil
+}
+
+// stripZoneComment removes a comment (from `;` outside quotes) from a zone file line
+func stripZoneComment(line string) string {
+	quoted := false
+	for i, c := range line {
+		switch c {
+		case '"':
+			quoted = !quoted
+		case ';':
+			if !quoted {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+// absoluteZoneName completes a zone file name: `@` is the origin, names ending in a dot are absolute, others are
+// relative to the origin. The result is lowercase without the trailing dot.
+func absoluteZoneName(name string, origin string) string {
+	name = strings.ToLower(name)
+	switch {
+	case name == "@":
+		return origin
+	case strings.HasSuffix(name, "."):
+		return strings.TrimSuffix(name, ".")
+	case origin == "":
+		return name
+	}
+	return name + "." + origin
+}
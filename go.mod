@@ -10,7 +10,10 @@ require (
 	github.com/labstack/echo/v4 v4.13.4
 	github.com/likexian/whois v1.15.6
 	github.com/likexian/whois-parser v1.24.20
+	github.com/miekg/dns v1.1.68
 	github.com/wneessen/go-mail v0.7.2
+	golang.org/x/net v0.46.0
+	golang.org/x/sys v0.37.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -22,8 +25,9 @@ require (
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.2 // indirect
 	golang.org/x/crypto v0.43.0 // indirect
-	golang.org/x/net v0.46.0 // indirect
-	golang.org/x/sys v0.37.0 // indirect
+	golang.org/x/mod v0.28.0 // indirect
+	golang.org/x/sync v0.17.0 // indirect
 	golang.org/x/text v0.30.0 // indirect
 	golang.org/x/time v0.14.0 // indirect
+	golang.org/x/tools v0.37.0 // indirect
 )
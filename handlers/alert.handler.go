@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nwesterhausen/domain-monitor/configuration"
+	"github.com/nwesterhausen/domain-monitor/service"
+	"github.com/nwesterhausen/domain-monitor/views/alerts"
+)
+
+type AlertHandler struct {
+	Ledger               *configuration.AlertLedgerStorage
+	Notifications        *service.NotificationService
+	ConfigurationService *service.ConfigurationService
+}
+
+func NewAlertHandler(ledger *configuration.AlertLedgerStorage, ns *service.NotificationService, cs *service.ConfigurationService) *AlertHandler {
+	return &AlertHandler{
+		Ledger:               ledger,
+		Notifications:        ns,
+		ConfigurationService: cs,
+	}
+}
+
+// List the alert deliveries, newest first.
+//
+// Filters: `fqdn`, `alert`, `channel` (email, webhook), `status` (sent, failed), `since` (a date or RFC 3339 time) and
+// `limit`. With `format=csv` the records are returned as a CSV download. Recipients are only included when the
+// configuration is shown in the web interface.
+func (h *AlertHandler) GetAlerts(c echo.Context) error {
+	filter, err := h.filter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	records := h.redact(h.Ledger.Find(filter))
+
+	if c.QueryParam("format") == "csv" {
+		c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+		c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="alert-ledger.csv"`)
+		c.Response().WriteHeader(http.StatusOK)
+		w := csv.NewWriter(c.Response())
+		w.Write([]string{"id", "created_at", "updated_at", "fqdn", "alert", "channel", "recipients", "subject", "status", "error", "attempts"})
+		for _, r := range records {
+			fqdn := r.FQDN
+			if fqdn == "" {
+				fqdn = strings.Join(r.Domains, " ")
+			}
+			w.Write([]string{r.ID, r.CreatedAt.Format(time.RFC3339), r.UpdatedAt.Format(time.RFC3339), fqdn, r.Alert, r.Channel,
+				strings.Join(r.Recipients, " "), r.Subject, r.Status, r.Error, strconv.Itoa(r.Attempts)})
+		}
+		w.Flush()
+		return w.Error()
+	}
+
+	return c.JSON(http.StatusOK, records)
+}
+
+// Render the alerts page, with the same filters as the API
+func (h *AlertHandler) RenderAlerts(c echo.Context) error {
+	filter, err := h.filter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if filter.Limit == 0 {
+		filter.Limit = 200
+	}
+	return View(c, alerts.Alerts(h.redact(h.Ledger.Find(filter)), filter, h.undelivered(), h.ConfigurationService.GetAppConfiguration().ShowConfiguration))
+}
+
+// List the outbound notification queue, newest first, optionally filtered by `state` (pending, sent, dead)
+func (h *AlertHandler) GetNotifications(c echo.Context) error {
+	items := h.Notifications.List(c.QueryParam("state"))
+	if !h.ConfigurationService.GetAppConfiguration().ShowConfiguration {
+		for i := range items {
+			items[i].To, items[i].CC = nil, nil
+		}
+	}
+	return c.JSON(http.StatusOK, items)
+}
+
+// Schedule a dead notification for another round of delivery attempts. Renders the queue section for htmx requests.
+func (h *AlertHandler) PostRetryNotification(c echo.Context) error {
+	item, err := h.Notifications.Retry(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	// Try right away, so the page shows the outcome
+	h.Notifications.Process(time.Now())
+	if c.Request().Header.Get("HX-Request") != "" {
+		return View(c, alerts.Queue(h.undelivered(), true))
+	}
+	item, _ = h.queued(item.ID)
+	return c.JSON(http.StatusOK, item)
+}
+
+// Discard a notification that wasn't delivered. Renders the queue section for htmx requests.
+func (h *AlertHandler) DeleteNotification(c echo.Context) error {
+	if err := h.Notifications.Discard(c.Param("id")); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	if c.Request().Header.Get("HX-Request") != "" {
+		return View(c, alerts.Queue(h.undelivered(), true))
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// The pending and dead notifications
+func (h *AlertHandler) undelivered() []configuration.QueuedNotification {
+	return append(h.Notifications.List(configuration.QueueDead), h.Notifications.List(configuration.QueuePending)...)
+}
+
+func (h *AlertHandler) queued(id string) (configuration.QueuedNotification, bool) {
+	for _, item := range h.Notifications.List("") {
+		if item.ID == id {
+			return item, true
+		}
+	}
+	return configuration.QueuedNotification{}, false
+}
+
+func (h *AlertHandler) filter(c echo.Context) (configuration.AlertLedgerFilter, error) {
+	var filter configuration.AlertLedgerFilter
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, &filter); err != nil {
+		return filter, err
+	}
+	filter.FQDN = strings.ToLower(strings.TrimSpace(filter.FQDN))
+	if since := c.QueryParam("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			t, err = time.ParseInLocation("2006-01-02", since, time.Local)
+		}
+		if err != nil {
+			return filter, err
+		}
+		filter.Since = t
+	}
+	return filter, nil
+}
+
+// Recipients are sensitive, like the alert settings they come from
+func (h *AlertHandler) redact(records []configuration.AlertRecord) []configuration.AlertRecord {
+	if h.ConfigurationService.GetAppConfiguration().ShowConfiguration {
+		return records
+	}
+	for i := range records {
+		records[i].Recipients = nil
+	}
+	return records
+}
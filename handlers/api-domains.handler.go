@@ -6,6 +6,7 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"github.com/nwesterhausen/domain-monitor/configuration"
+	"github.com/nwesterhausen/domain-monitor/service"
 )
 
 type ApiDomainService interface {
@@ -14,28 +15,33 @@ type ApiDomainService interface {
 	GetDomains() ([]configuration.Domain, error)
 	UpdateDomain(domain configuration.Domain) error
 	DeleteDomain(fqdn string) error
+	TransitionDomain(fqdn string, transition string) (configuration.Domain, error)
 	Flush()
 }
 
-func NewApiDomainHandler(ds ApiDomainService) *ApiDomainHandler {
+func NewApiDomainHandler(ds ApiDomainService, cs *service.ConfigurationService) *ApiDomainHandler {
 	return &ApiDomainHandler{
-		DomainService: ds,
+		DomainService:        ds,
+		ConfigurationService: cs,
 	}
 }
 
 type ApiDomainHandler struct {
 	DomainService ApiDomainService
+	// Expands the contact groups of the owner filter
+	ConfigurationService *service.ConfigurationService
 }
 
 func (h *ApiDomainHandler) HandleDomainCreate(c echo.Context) error {
-	var domain configuration.Domain
+	// a new domain is monitored unless the request says otherwise
+	domain := configuration.Domain{Enabled: true}
 	if err := c.Bind(&domain); err != nil {
 		return err
 	}
 
 	id, err := h.DomainService.CreateDomain(domain)
 	if err != nil {
-		return err
+		return respondValidationError(c, err)
 	}
 
 	defer h.DomainService.Flush()
@@ -56,13 +62,18 @@ func (h *ApiDomainHandler) HandleDomainShow(c echo.Context) error {
 	return c.JSON(http.StatusOK, domain)
 }
 
+// List the domains, optionally filtered by `tag`, `group`, `owner` and `state`
 func (h *ApiDomainHandler) HandleDomainList(c echo.Context) error {
 	domains, err := h.DomainService.GetDomains()
 	if err != nil {
 		return err
 	}
+	filter, err := domainFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
 
-	return c.JSON(http.StatusOK, domains)
+	return c.JSON(http.StatusOK, filter.Filter(domains, h.ConfigurationService.GetConfiguration()))
 }
 
 func (h *ApiDomainHandler) HandleDomainUpdate(c echo.Context) error {
@@ -73,7 +84,7 @@ func (h *ApiDomainHandler) HandleDomainUpdate(c echo.Context) error {
 
 	err := h.DomainService.UpdateDomain(domain)
 	if err != nil {
-		return err
+		return respondValidationError(c, err)
 	}
 
 	return c.NoContent(http.StatusNoContent)
@@ -93,3 +104,28 @@ func (h *ApiDomainHandler) HandleDomainDelete(c echo.Context) error {
 	defer h.DomainService.Flush()
 	return c.NoContent(http.StatusNoContent)
 }
+
+// Move a domain to another lifecycle state and return the updated domain
+func (h *ApiDomainHandler) HandleDomainTransition(transition string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		fqdn := c.Param("fqdn")
+		if _, err := h.DomainService.GetDomain(fqdn); err != nil {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		domain, err := h.DomainService.TransitionDomain(fqdn, transition)
+		if err != nil {
+			return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusOK, domain)
+	}
+}
+
+// domainFilter reads the `tag`, `group`, `owner` and `state` query parameters
+func domainFilter(c echo.Context) (configuration.DomainFilter, error) {
+	var filter configuration.DomainFilter
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, &filter); err != nil {
+		return filter, err
+	}
+	filter.Normalize()
+	return filter, filter.Validate()
+}
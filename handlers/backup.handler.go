@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nwesterhausen/domain-monitor/service"
+)
+
+type BackupHandler struct {
+	BackupService *service.BackupService
+}
+
+func NewBackupHandler(bs *service.BackupService) *BackupHandler {
+	return &BackupHandler{
+		BackupService: bs,
+	}
+}
+
+// Stream a backup archive of the data directory.
+//
+// Secrets (like the SMTP password) are left out unless `includeSecrets=true` is passed.
+func (h *BackupHandler) GetBackup(c echo.Context) error {
+	includeSecrets := c.QueryParam("includeSecrets") == "true"
+	filename := fmt.Sprintf("domain-monitor-backup-%s.tar.gz", time.Now().UTC().Format("20060102-150405"))
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/gzip")
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	c.Response().WriteHeader(http.StatusOK)
+
+	log.Printf("📦 Creating backup archive (secrets included: %t)", includeSecrets)
+	return h.BackupService.WriteArchive(c.Response(), includeSecrets)
+}
+
+// Validate an uploaded backup archive and stage it to be restored.
+//
+// The archive is sent either as the multipart field `archive` or as the raw request body. With `dryRun=true`, only the
+// summary of changes is returned. Otherwise the files are swapped in the next time the server starts, because the
+// running server would overwrite them with the data it has in memory.
+func (h *BackupHandler) PostRestore(c echo.Context) error {
+	var body io.Reader = c.Request().Body
+	dryRun := c.QueryParam("dryRun") == "true"
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		file, err := c.FormFile("archive")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "missing archive: " + err.Error()})
+		}
+		src, err := file.Open()
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		body = src
+		dryRun = dryRun || c.FormValue("dryRun") == "true"
+	}
+
+	archive, err := service.ReadArchive(body)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	summary, err := h.BackupService.Summarize(archive)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if !dryRun {
+		if err := h.BackupService.StageRestore(archive); err != nil {
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"summary":         summary,
+		"dryRun":          dryRun,
+		"restartRequired": !dryRun,
+	})
+}
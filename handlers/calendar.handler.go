@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nwesterhausen/domain-monitor/configuration"
+	"github.com/nwesterhausen/domain-monitor/service"
+)
+
+type CalendarHandler struct {
+	Domains              *configuration.DomainConfiguration
+	WhoisCache           *configuration.WhoisCacheStorage
+	ConfigurationService *service.ConfigurationService
+}
+
+func NewCalendarHandler(domains *configuration.DomainConfiguration, whoisCache *configuration.WhoisCacheStorage, cs *service.ConfigurationService) *CalendarHandler {
+	return &CalendarHandler{
+		Domains:              domains,
+		WhoisCache:           whoisCache,
+		ConfigurationService: cs,
+	}
+}
+
+// Serve the expiration dates as an iCalendar feed. With `app.calendarToken` set, the same token must be passed as
+// `token`. The domains can be filtered with `tag`, `group` and `owner`.
+func (h *CalendarHandler) GetCalendar(c echo.Context) error {
+	config := h.ConfigurationService.GetConfiguration()
+	if config.App.CalendarToken != "" && subtle.ConstantTimeCompare([]byte(c.QueryParam("token")), []byte(config.App.CalendarToken)) != 1 {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "a valid token is required"})
+	}
+
+	filter, err := domainFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	calendar := service.BuildCalendar(h.Domains.List(), h.WhoisCache, filter, config, time.Now())
+	c.Response().Header().Set(echo.HeaderContentDisposition, `inline; filename="domain-expirations.ics"`)
+	return c.Blob(http.StatusOK, "text/calendar; charset=utf-8", []byte(calendar))
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nwesterhausen/domain-monitor/service"
+	"github.com/nwesterhausen/domain-monitor/views/certificates"
+)
+
+type CertificateHandler struct {
+	Certificates         *service.CertificateService
+	Notifications        *service.NotificationService
+	ConfigurationService *service.ConfigurationService
+}
+
+func NewCertificateHandler(cts *service.CertificateService, ns *service.NotificationService, cs *service.ConfigurationService) *CertificateHandler {
+	return &CertificateHandler{
+		Certificates:         cts,
+		Notifications:        ns,
+		ConfigurationService: cs,
+	}
+}
+
+// List the certificates found so far, of one domain with `domain`
+func (h *CertificateHandler) GetCertificates(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.Certificates.List(c.QueryParam("domain")))
+}
+
+// List the last search of each domain
+func (h *CertificateHandler) GetPolls(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.Certificates.Polls())
+}
+
+// Search the certificates of a domain (every monitored domain without `domain`) now, alerting suspicious ones like the
+// scheduled searches do
+func (h *CertificateHandler) PostPoll(c echo.Context) error {
+	results, err := h.poll(c.QueryParam("domain"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, results)
+}
+
+// Render the certificates page
+func (h *CertificateHandler) RenderCertificates(c echo.Context) error {
+	return h.render(c, c.QueryParam("domain"), "")
+}
+
+// Search from the certificates page and render it again
+func (h *CertificateHandler) PostPollForm(c echo.Context) error {
+	domain := c.FormValue("domain")
+	if _, err := h.poll(domain); err != nil {
+		return h.render(c, domain, err.Error())
+	}
+	return h.render(c, domain, "")
+}
+
+func (h *CertificateHandler) poll(fqdn string) ([]service.CertificateResult, error) {
+	now := time.Now()
+	var results []service.CertificateResult
+	if fqdn == "" {
+		results = h.Certificates.PollAll(now)
+	} else {
+		result, err := h.Certificates.Poll(fqdn, now)
+		if err != nil {
+			return nil, err
+		}
+		results = []service.CertificateResult{result}
+	}
+	if service.NotifyCertificates(h.Notifications, h.ConfigurationService.GetConfiguration(), results, now) > 0 {
+		h.Notifications.Process(now)
+	}
+	return results, nil
+}
+
+func (h *CertificateHandler) render(c echo.Context, domain string, problem string) error {
+	canPoll := h.ConfigurationService.GetAppConfiguration().ShowConfiguration
+	return View(c, certificates.Certificates(h.Certificates.List(domain), h.Certificates.Polls(), domain, h.Certificates.Enabled(), canPoll, problem, time.Now()))
+}
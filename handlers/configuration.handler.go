@@ -1,9 +1,16 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/labstack/echo/v4"
+	config "github.com/nwesterhausen/domain-monitor/configuration"
 	"github.com/nwesterhausen/domain-monitor/service"
 	"github.com/nwesterhausen/domain-monitor/views/configuration"
 )
@@ -15,10 +22,10 @@ func HandlerRenderConfiguration(c echo.Context) error {
 }
 
 type ConfigurationHandler struct {
-	ConfigurationService service.ConfigurationService
+	ConfigurationService *service.ConfigurationService
 }
 
-func NewConfigurationHandler(cs service.ConfigurationService) *ConfigurationHandler {
+func NewConfigurationHandler(cs *service.ConfigurationService) *ConfigurationHandler {
 	return &ConfigurationHandler{
 		ConfigurationService: cs,
 	}
@@ -55,12 +62,221 @@ func (h *ConfigurationHandler) SetSectionKey(c echo.Context) error {
 	err := h.ConfigurationService.SetConfigurationValue(section, key, value)
 	if err != nil {
 		log.Printf("🚨 Error setting configuration value: %s", err.Error())
-		return err
+		return respondValidationError(c, err)
 	}
 
 	return c.NoContent(201)
 }
 
+// Get all the values of a configuration section.
+func (h *ConfigurationHandler) GetSection(c echo.Context) error {
+	values, err := h.ConfigurationService.GetConfigurationSection(c.Param("section"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, values)
+}
+
+// Set several keys of a configuration section at once.
+//
+// The body can either be a JSON object (`{"port": 3124, "showConfiguration": true}`) or form values. Nothing is
+// changed unless every value is valid; otherwise a 422 response lists the error for each key.
+func (h *ConfigurationHandler) PatchSection(c echo.Context) error {
+	section := c.Param("section")
+	values := map[string]interface{}{}
+
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		if err := json.NewDecoder(c.Request().Body).Decode(&values); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid JSON body: " + err.Error()})
+		}
+	} else {
+		params, err := c.FormParams()
+		if err != nil {
+			return err
+		}
+		for key, list := range params {
+			if len(list) == 1 {
+				values[key] = list[0]
+			} else {
+				values[key] = list
+			}
+		}
+	}
+
+	if err := h.ConfigurationService.SetConfigurationValues(section, values); err != nil {
+		log.Printf("🚨 Error setting configuration values: %s", err.Error())
+		return respondValidationError(c, err)
+	}
+
+	return h.GetSection(c)
+}
+
+// Get the JSON Schema for a configuration section.
+func (h *ConfigurationHandler) GetSectionSchema(c echo.Context) error {
+	schema, err := config.SectionJSONSchema(c.Param("section"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, schema)
+}
+
+// Get the JSON Schema for every configuration section, keyed by section name.
+func (h *ConfigurationHandler) GetSchemas(c echo.Context) error {
+	schemas := map[string]interface{}{}
+	for _, section := range config.SectionNames() {
+		schema, err := config.SectionJSONSchema(section)
+		if err != nil {
+			return err
+		}
+		schemas[section] = schema
+	}
+
+	return c.JSON(http.StatusOK, schemas)
+}
+
+// Respond with field-level errors for validation failures, or pass any other error on to the error handler.
+func respondValidationError(c echo.Context, err error) error {
+	var validationErrors config.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{"errors": validationErrors})
+	}
+	var keyErr *config.ErrInvalidConfigurationKey
+	var sectionErr *config.ErrInvalidConfigurationSection
+	if errors.As(err, &keyErr) || errors.As(err, &sectionErr) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	return err
+}
+
+// List the contact groups.
+func (h *ConfigurationHandler) GetContactGroups(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.ConfigurationService.GetContactGroups())
+}
+
+// Add or replace a contact group. The body is `{"members": ["a@example.com", ...]}` or a form with a (comma
+// separated) `members` value.
+func (h *ConfigurationHandler) PutContactGroup(c echo.Context) error {
+	group := config.ContactGroup{Name: c.Param("name")}
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		if err := json.NewDecoder(c.Request().Body).Decode(&group); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid JSON body: " + err.Error()})
+		}
+		group.Name = c.Param("name")
+	} else {
+		params, err := c.FormParams()
+		if err != nil {
+			return err
+		}
+		group.Members = params["members"]
+	}
+
+	if err := h.ConfigurationService.SetContactGroup(group); err != nil {
+		log.Printf("🚨 Error saving contact group: %s", err.Error())
+		return respondValidationError(c, err)
+	}
+	return h.GetContactGroups(c)
+}
+
+// Remove a contact group.
+func (h *ConfigurationHandler) DeleteContactGroup(c echo.Context) error {
+	removed, err := h.ConfigurationService.RemoveContactGroup(c.Param("name"))
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "unknown contact group " + c.Param("name")})
+	}
+	return h.GetContactGroups(c)
+}
+
+// List the tag routes.
+func (h *ConfigurationHandler) GetTagRoutes(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.ConfigurationService.GetTagRoutes())
+}
+
+// Add or replace the route of a tag. The body is `{"recipients": ["oncall", ...]}` or a form with a (comma separated)
+// `recipients` value.
+func (h *ConfigurationHandler) PutTagRoute(c echo.Context) error {
+	route := config.TagRoute{Tag: c.Param("tag")}
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		if err := json.NewDecoder(c.Request().Body).Decode(&route); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid JSON body: " + err.Error()})
+		}
+		route.Tag = c.Param("tag")
+	} else {
+		params, err := c.FormParams()
+		if err != nil {
+			return err
+		}
+		route.Recipients = params["recipients"]
+	}
+
+	if err := h.ConfigurationService.SetTagRoute(route); err != nil {
+		log.Printf("🚨 Error saving tag route: %s", err.Error())
+		return respondValidationError(c, err)
+	}
+	return h.GetTagRoutes(c)
+}
+
+// Remove the route of a tag.
+func (h *ConfigurationHandler) DeleteTagRoute(c echo.Context) error {
+	removed, err := h.ConfigurationService.RemoveTagRoute(c.Param("tag"))
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "no route for tag " + c.Param("tag")})
+	}
+	return h.GetTagRoutes(c)
+}
+
+// List the exchange rates of the cost reports.
+func (h *ConfigurationHandler) GetExchangeRates(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.ConfigurationService.GetExchangeRates())
+}
+
+// Add or replace the exchange rate of a currency (URL encoded, e.g. `%E2%82%AC` for €). The body is `{"rate": 1.08}`
+// or a form with a `rate` value: the value of one unit of the currency in the base currency.
+func (h *ConfigurationHandler) PutExchangeRate(c echo.Context) error {
+	currency, err := url.PathUnescape(c.Param("currency"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	rate := config.ExchangeRate{Currency: currency}
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		if err := json.NewDecoder(c.Request().Body).Decode(&rate); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid JSON body: " + err.Error()})
+		}
+		rate.Currency = currency
+	} else if rate.Rate, err = strconv.ParseFloat(strings.TrimSpace(c.FormValue("rate")), 64); err != nil {
+		return respondValidationError(c, config.ValidationErrors{{Section: "exchangeRates", Key: "rate", Message: "must be a number"}})
+	}
+
+	if err := h.ConfigurationService.SetExchangeRate(rate); err != nil {
+		log.Printf("🚨 Error saving exchange rate: %s", err.Error())
+		return respondValidationError(c, err)
+	}
+	return h.GetExchangeRates(c)
+}
+
+// Remove the exchange rate of a currency.
+func (h *ConfigurationHandler) DeleteExchangeRate(c echo.Context) error {
+	currency, err := url.PathUnescape(c.Param("currency"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	removed, err := h.ConfigurationService.RemoveExchangeRate(currency)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "unknown currency " + currency})
+	}
+	return h.GetExchangeRates(c)
+}
+
 // Render the domain configuration page.
 func (h *ConfigurationHandler) RenderDomainConfiguration(c echo.Context) error {
 	return View(c, configuration.DomainTab())
@@ -81,7 +297,37 @@ func (h *ConfigurationHandler) RenderSchedulerConfiguration(c echo.Context) erro
 	return View(c, configuration.SchedulerTab(h.ConfigurationService.GetSchedulerConfiguration()))
 }
 
+// Render the costs configuration page.
+func (h *ConfigurationHandler) RenderCostsConfiguration(c echo.Context) error {
+	return View(c, configuration.CostsTab(h.ConfigurationService.GetCostsConfiguration(), h.ConfigurationService.GetExchangeRates()))
+}
+
 // Render the alerts configuration page.
 func (h *ConfigurationHandler) RenderAlertsConfiguration(c echo.Context) error {
-	return View(c, configuration.AlertsTab(h.ConfigurationService.GetAlertsConfiguration()))
+	return View(c, configuration.AlertsTab(h.ConfigurationService.GetAlertsConfiguration(), h.ConfigurationService.GetContactGroups(), h.ConfigurationService.GetTagRoutes()))
+}
+
+// Render the DNS configuration page.
+func (h *ConfigurationHandler) RenderDNSConfiguration(c echo.Context) error {
+	return View(c, configuration.DNSTab(h.ConfigurationService.GetDNSConfiguration(), h.ConfigurationService.GetLookalikesConfiguration(), h.ConfigurationService.GetDiscoveryConfiguration()))
+}
+
+// Render the certificates configuration page.
+func (h *ConfigurationHandler) RenderCertificatesConfiguration(c echo.Context) error {
+	return View(c, configuration.CertificatesTab(h.ConfigurationService.GetCertificatesConfiguration()))
+}
+
+// Render the mail security configuration page.
+func (h *ConfigurationHandler) RenderMailSecurityConfiguration(c echo.Context) error {
+	return View(c, configuration.MailSecurityTab(h.ConfigurationService.GetMailSecurityConfiguration()))
+}
+
+// Render the DNSSEC configuration page.
+func (h *ConfigurationHandler) RenderDNSSECConfiguration(c echo.Context) error {
+	return View(c, configuration.DNSSECTab(h.ConfigurationService.GetDNSSECConfiguration()))
+}
+
+// Render the delegation configuration page.
+func (h *ConfigurationHandler) RenderDelegationConfiguration(c echo.Context) error {
+	return View(c, configuration.DelegationTab(h.ConfigurationService.GetDelegationConfiguration()))
 }
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nwesterhausen/domain-monitor/configuration"
+	"github.com/nwesterhausen/domain-monitor/service"
+	"github.com/nwesterhausen/domain-monitor/views/reports"
+)
+
+type CostHandler struct {
+	Domains              *configuration.DomainConfiguration
+	WhoisCache           *configuration.WhoisCacheStorage
+	ConfigurationService *service.ConfigurationService
+}
+
+func NewCostHandler(domains *configuration.DomainConfiguration, whoisCache *configuration.WhoisCacheStorage, cs *service.ConfigurationService) *CostHandler {
+	return &CostHandler{
+		Domains:              domains,
+		WhoisCache:           whoisCache,
+		ConfigurationService: cs,
+	}
+}
+
+// Project the renewal costs over the next `months` (default 12, at most 60), optionally only of the domains matching
+// `tag`, `group` and `owner`.
+//
+// With `format=csv` the projected renewals are returned as a CSV download, or with `by` (month, year, currency,
+// registrar, tag) the totals of that grouping.
+func (h *CostHandler) GetCosts(c echo.Context) error {
+	report, err := h.report(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if c.QueryParam("format") != "csv" {
+		return c.JSON(http.StatusOK, report)
+	}
+
+	by := c.QueryParam("by")
+	var totals []configuration.CostTotal
+	if by != "" {
+		if totals = service.CostTotals(report, by); totals == nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "unknown grouping " + by})
+		}
+	}
+
+	filename := "renewal-costs.csv"
+	if by != "" {
+		filename = "renewal-costs-by-" + by + ".csv"
+	}
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	c.Response().WriteHeader(http.StatusOK)
+	w := csv.NewWriter(c.Response())
+	if by != "" {
+		w.Write([]string{by, "renewals", "currency", "amount", "converted", "base_currency", "complete"})
+		for _, total := range totals {
+			for _, amount := range total.Amounts {
+				w.Write([]string{total.Key, strconv.Itoa(total.Renewals), amount.Currency, formatAmount(amount.Amount),
+					formatAmount(total.Converted), report.BaseCurrency, strconv.FormatBool(total.Complete)})
+			}
+		}
+	} else {
+		w.Write([]string{"date", "fqdn", "name", "registrar", "group", "tags", "price", "currency", "converted", "base_currency", "overdue"})
+		for _, r := range report.Renewals {
+			converted := ""
+			if r.Convertible {
+				converted = formatAmount(r.Converted)
+			}
+			w.Write([]string{r.Date.Format("2006-01-02"), r.FQDN, r.Name, r.Registrar, r.Group, strings.Join(r.Tags, " "), formatAmount(r.Price), r.Currency,
+				converted, report.BaseCurrency, strconv.FormatBool(r.Overdue)})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// Render the cost report page, with the same parameters as the API
+func (h *CostHandler) RenderCosts(c echo.Context) error {
+	report, err := h.report(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return View(c, reports.Costs(report, configuration.DomainTags(h.Domains.List()), configuration.DomainGroups(h.Domains.List())))
+}
+
+func (h *CostHandler) report(c echo.Context) (configuration.CostReport, error) {
+	months := service.DefaultCostMonths
+	if value := strings.TrimSpace(c.QueryParam("months")); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > service.MaxCostMonths {
+			return configuration.CostReport{}, errors.New("months must be a whole number from 1 to " + strconv.Itoa(service.MaxCostMonths))
+		}
+		months = n
+	}
+	filter, err := domainFilter(c)
+	if err != nil {
+		return configuration.CostReport{}, err
+	}
+	return service.BuildCostReport(h.Domains.List(), h.WhoisCache, filter, h.ConfigurationService.GetConfiguration(), time.Now(), months), nil
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nwesterhausen/domain-monitor/service"
+	"github.com/nwesterhausen/domain-monitor/views/domains"
+)
+
+type DelegationHandler struct {
+	Delegation           *service.DelegationService
+	Notifications        *service.NotificationService
+	ConfigurationService *service.ConfigurationService
+}
+
+func NewDelegationHandler(ds *service.DelegationService, ns *service.NotificationService, cs *service.ConfigurationService) *DelegationHandler {
+	return &DelegationHandler{
+		Delegation:           ds,
+		Notifications:        ns,
+		ConfigurationService: cs,
+	}
+}
+
+// List the delegation report of every checked domain
+func (h *DelegationHandler) GetReports(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.Delegation.List())
+}
+
+// Get the delegation report of a domain
+func (h *DelegationHandler) GetReport(c echo.Context) error {
+	report, ok := h.Delegation.Get(c.Param("fqdn"))
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "no delegation report for " + c.Param("fqdn")})
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// Check the delegation of a domain (every monitored domain without `domain`) now, alerting problems like the
+// scheduled checks do
+func (h *DelegationHandler) PostCheck(c echo.Context) error {
+	results, err := h.check(c.QueryParam("domain"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, results)
+}
+
+// Render the delegation health panel of a domain card
+func (h *DelegationHandler) GetCardPanel(c echo.Context) error {
+	return h.renderCardPanel(c, c.Param("fqdn"), "")
+}
+
+// Check the delegation of a domain from its card and render the updated panel
+func (h *DelegationHandler) PostCardCheck(c echo.Context) error {
+	if _, err := h.check(c.Param("fqdn")); err != nil {
+		return h.renderCardPanel(c, c.Param("fqdn"), err.Error())
+	}
+	return h.renderCardPanel(c, c.Param("fqdn"), "")
+}
+
+func (h *DelegationHandler) check(fqdn string) ([]service.DelegationResult, error) {
+	now := time.Now()
+	var results []service.DelegationResult
+	if fqdn == "" {
+		results = h.Delegation.CheckAll(now)
+	} else {
+		result, err := h.Delegation.Check(fqdn, now)
+		if err != nil {
+			return nil, err
+		}
+		results = []service.DelegationResult{result}
+	}
+	if service.NotifyDelegations(h.Notifications, h.ConfigurationService.GetConfiguration(), results, now) > 0 {
+		h.Notifications.Process(now)
+	}
+	return results, nil
+}
+
+func (h *DelegationHandler) renderCardPanel(c echo.Context, fqdn string, problem string) error {
+	report, checked := h.Delegation.Get(fqdn)
+	canCheck := h.ConfigurationService.GetAppConfiguration().ShowConfiguration
+	return View(c, domains.DelegationPanel(fqdn, report, checked, h.Delegation.Enabled(), canCheck, problem))
+}
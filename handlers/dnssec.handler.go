@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nwesterhausen/domain-monitor/service"
+	"github.com/nwesterhausen/domain-monitor/views/domains"
+)
+
+type DNSSECHandler struct {
+	DNSSEC               *service.DNSSECService
+	Notifications        *service.NotificationService
+	ConfigurationService *service.ConfigurationService
+}
+
+func NewDNSSECHandler(ds *service.DNSSECService, ns *service.NotificationService, cs *service.ConfigurationService) *DNSSECHandler {
+	return &DNSSECHandler{
+		DNSSEC:               ds,
+		Notifications:        ns,
+		ConfigurationService: cs,
+	}
+}
+
+// List the DNSSEC report of every checked domain
+func (h *DNSSECHandler) GetReports(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.DNSSEC.List())
+}
+
+// Get the DNSSEC report of a domain
+func (h *DNSSECHandler) GetReport(c echo.Context) error {
+	report, ok := h.DNSSEC.Get(c.Param("fqdn"))
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "no DNSSEC report for " + c.Param("fqdn")})
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// Check the DNSSEC chain of a domain (every monitored domain without `domain`) now, alerting problems like the
+// scheduled checks do
+func (h *DNSSECHandler) PostCheck(c echo.Context) error {
+	results, err := h.check(c.QueryParam("domain"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, results)
+}
+
+// Render the DNSSEC panel of a domain card
+func (h *DNSSECHandler) GetCardPanel(c echo.Context) error {
+	return h.renderCardPanel(c, c.Param("fqdn"), "")
+}
+
+// Check the DNSSEC chain of a domain from its card and render the updated panel
+func (h *DNSSECHandler) PostCardCheck(c echo.Context) error {
+	if _, err := h.check(c.Param("fqdn")); err != nil {
+		return h.renderCardPanel(c, c.Param("fqdn"), err.Error())
+	}
+	return h.renderCardPanel(c, c.Param("fqdn"), "")
+}
+
+func (h *DNSSECHandler) check(fqdn string) ([]service.DNSSECResult, error) {
+	now := time.Now()
+	var results []service.DNSSECResult
+	if fqdn == "" {
+		results = h.DNSSEC.CheckAll(now)
+	} else {
+		result, err := h.DNSSEC.Check(fqdn, now)
+		if err != nil {
+			return nil, err
+		}
+		results = []service.DNSSECResult{result}
+	}
+	if service.NotifyDNSSECs(h.Notifications, h.ConfigurationService.GetConfiguration(), results, now) > 0 {
+		h.Notifications.Process(now)
+	}
+	return results, nil
+}
+
+func (h *DNSSECHandler) renderCardPanel(c echo.Context, fqdn string, problem string) error {
+	report, checked := h.DNSSEC.Get(fqdn)
+	canCheck := h.ConfigurationService.GetAppConfiguration().ShowConfiguration
+	expiring := report.ExpiresWithin(service.DNSSECExpiryWarning(h.ConfigurationService.GetDNSSECConfiguration()), time.Now())
+	return View(c, domains.DNSSECPanel(fqdn, report, checked, expiring, h.DNSSEC.Enabled(), canCheck, problem))
+}
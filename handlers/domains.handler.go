@@ -3,6 +3,7 @@ package handlers
 import (
 	"errors"
 	"log"
+	"net/http"
 	"sort"
 	"strings"
 
@@ -15,12 +16,15 @@ import (
 type DomainHandler struct {
 	DomainService ApiDomainService
 	WhoisService  *service.ServicesWhois
+	// Expands the contact groups of the owner filter
+	ConfigurationService *service.ConfigurationService
 }
 
-func NewDomainHandler(ds ApiDomainService, ws *service.ServicesWhois) *DomainHandler {
+func NewDomainHandler(ds ApiDomainService, ws *service.ServicesWhois, cs *service.ConfigurationService) *DomainHandler {
 	return &DomainHandler{
-		DomainService: ds,
-		WhoisService:  ws,
+		DomainService:        ds,
+		WhoisService:         ws,
+		ConfigurationService: cs,
 	}
 }
 
@@ -39,12 +43,23 @@ func (h *DomainHandler) GetCard(c echo.Context) error {
 	return View(c, card)
 }
 
-// Get the HTML for all the domain cards
+// Get the HTML for all the domain cards, optionally filtered by `tag`, `group`, `owner` and `state`. Archived domains
+// are only shown when asked for with `state=archived`.
 func (h *DomainHandler) GetCards(c echo.Context) error {
-	domainList, err := h.DomainService.GetDomains()
+	allDomains, err := h.DomainService.GetDomains()
+	if err != nil {
+		return err
+	}
+	filter, err := domainFilter(c)
 	if err != nil {
 		return err
 	}
+	domainList := []configuration.Domain{}
+	for _, domain := range filter.Filter(allDomains, h.ConfigurationService.GetConfiguration()) {
+		if !domain.Archived || filter.State == configuration.DomainArchived {
+			domainList = append(domainList, domain)
+		}
+	}
 
 	// Get sort parameter from query string (default: expiration_date)
 	sortBy := c.QueryParam("sort")
@@ -61,7 +76,7 @@ func (h *DomainHandler) GetCards(c echo.Context) error {
 		})
 	}
 
-	cards := domains.DomainCards(domainList, sortBy)
+	cards := domains.DomainCards(domainList, sortBy, filter, configuration.DomainTags(allDomains), configuration.DomainGroups(allDomains))
 	return View(c, cards)
 }
 
@@ -122,13 +137,17 @@ func (h *DomainHandler) sortDomainsWithWhois(domainList []configuration.Domain,
 	return sortedDomains
 }
 
-// Get HTML for domain list as tbody
+// Get HTML for domain list as tbody, optionally filtered by `tag`, `group`, `owner` and `state`
 func (h *DomainHandler) GetListTbody(c echo.Context) error {
 	domainList, err := h.DomainService.GetDomains()
 	if err != nil {
 		return err
 	}
-	list := domains.DomainListingTbody(domainList)
+	filter, err := domainFilter(c)
+	if err != nil {
+		return err
+	}
+	list := domains.DomainListingTbody(filter.Filter(domainList, h.ConfigurationService.GetConfiguration()))
 	return View(c, list)
 }
 
@@ -143,7 +162,7 @@ func (h *DomainHandler) PostNewDomain(c echo.Context) error {
 
 	_, err := h.DomainService.CreateDomain(domain)
 	if err != nil {
-		return err
+		return respondValidationError(c, err)
 	}
 
 	return h.GetListTbody(c)
@@ -194,7 +213,7 @@ func (h *DomainHandler) PostUpdateDomain(c echo.Context) error {
 
 	err := h.DomainService.UpdateDomain(domain)
 	if err != nil {
-		return err
+		return respondValidationError(c, err)
 	}
 
 	// Get the updated domain from storage to ensure we have the latest data
@@ -206,6 +225,17 @@ func (h *DomainHandler) PostUpdateDomain(c echo.Context) error {
 	return h.GetListDomainRow(updatedDomain, c)
 }
 
+// Move a domain to another lifecycle state and return its updated row
+func (h *DomainHandler) PostTransition(transition string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		domain, err := h.DomainService.TransitionDomain(c.Param("fqdn"), transition)
+		if err != nil {
+			return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+		}
+		return h.GetListDomainRow(domain, c)
+	}
+}
+
 // Get the HTML for a single domain row
 func (h *DomainHandler) GetListDomainRow(domain configuration.Domain, c echo.Context) error {
 	row := domains.DomainTableRow(domain)
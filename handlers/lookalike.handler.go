@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nwesterhausen/domain-monitor/service"
+	"github.com/nwesterhausen/domain-monitor/views/lookalikes"
+)
+
+type LookalikeHandler struct {
+	Lookalikes           *service.LookalikeService
+	Notifications        *service.NotificationService
+	ConfigurationService *service.ConfigurationService
+}
+
+func NewLookalikeHandler(ls *service.LookalikeService, ns *service.NotificationService, cs *service.ConfigurationService) *LookalikeHandler {
+	return &LookalikeHandler{
+		Lookalikes:           ls,
+		Notifications:        ns,
+		ConfigurationService: cs,
+	}
+}
+
+// List the lookalikes found so far, of one domain with `domain`
+func (h *LookalikeHandler) GetLookalikes(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.Lookalikes.List(c.QueryParam("domain")))
+}
+
+// List the last scan of each domain
+func (h *LookalikeHandler) GetScans(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.Lookalikes.Scans())
+}
+
+// List the lookalikes generated for a name, without resolving them
+func (h *LookalikeHandler) GetPermutations(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.Lookalikes.Permutations(c.Param("fqdn")))
+}
+
+// Scan a domain (every monitored domain without `domain`) for lookalikes now, alerting new ones like the scheduled
+// scans do
+func (h *LookalikeHandler) PostScan(c echo.Context) error {
+	results, err := h.scan(c.QueryParam("domain"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, results)
+}
+
+// Render the lookalikes page
+func (h *LookalikeHandler) RenderLookalikes(c echo.Context) error {
+	return h.render(c, c.QueryParam("domain"), "")
+}
+
+// Scan from the lookalikes page and render it again
+func (h *LookalikeHandler) PostScanForm(c echo.Context) error {
+	domain := c.FormValue("domain")
+	if _, err := h.scan(domain); err != nil {
+		return h.render(c, domain, err.Error())
+	}
+	return h.render(c, domain, "")
+}
+
+func (h *LookalikeHandler) scan(fqdn string) ([]service.LookalikeResult, error) {
+	now := time.Now()
+	var results []service.LookalikeResult
+	if fqdn == "" {
+		results = h.Lookalikes.ScanAll(now)
+	} else {
+		result, err := h.Lookalikes.Scan(fqdn, now)
+		if err != nil {
+			return nil, err
+		}
+		results = []service.LookalikeResult{result}
+	}
+	if service.NotifyLookalikes(h.Notifications, h.ConfigurationService.GetConfiguration(), results, now) > 0 {
+		h.Notifications.Process(now)
+	}
+	return results, nil
+}
+
+func (h *LookalikeHandler) render(c echo.Context, domain string, problem string) error {
+	canScan := h.ConfigurationService.GetAppConfiguration().ShowConfiguration
+	return View(c, lookalikes.Lookalikes(h.Lookalikes.List(domain), h.Lookalikes.Scans(), domain, h.Lookalikes.Enabled(), canScan, problem))
+}
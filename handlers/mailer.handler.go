@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"log"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -9,14 +10,14 @@ import (
 )
 
 type MailerHandler struct {
-	MailerService *service.MailerService
-	Recipient     string
+	MailerService        *service.MailerService
+	ConfigurationService *service.ConfigurationService
 }
 
-func NewMailerHandler(ms *service.MailerService, recipient string) *MailerHandler {
+func NewMailerHandler(ms *service.MailerService, cs *service.ConfigurationService) *MailerHandler {
 	return &MailerHandler{
-		MailerService: ms,
-		Recipient:     recipient,
+		MailerService:        ms,
+		ConfigurationService: cs,
 	}
 }
 
@@ -27,25 +28,28 @@ func (mh MailerHandler) HandleTestMail(c echo.Context) error {
 		return c.HTML(200, `<span class="text-error">❌ SMTP mailer service is not initialized. Please check server logs for details and ensure SMTP is properly configured.</span>`)
 	}
 	
-	if mh.Recipient == "" {
+	// Resolve the recipients now, so changes to the alert settings apply without a restart
+	recipients := service.DefaultRecipients(mh.ConfigurationService.GetConfiguration())
+	if len(recipients) == 0 {
 		log.Println("⚠️ Test mail requested but recipient email is empty")
 		c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
 		return c.HTML(200, `<span class="text-error">❌ Admin email is not set. Please configure admin email in Alerts settings.</span>`)
 	}
+	recipient := strings.Join(recipients, ", ")
 	
-	log.Printf("📧 Attempting to send test email to %s (timeout: 35 seconds)", mh.Recipient)
+	log.Printf("📧 Attempting to send test email to %s (timeout: 35 seconds)", recipient)
 	
 	// Run email sending in goroutine to avoid blocking HTTP request
 	resultChan := make(chan error, 1)
 	go func() {
-		resultChan <- mh.MailerService.TestMail(mh.Recipient)
+		resultChan <- mh.MailerService.TestMail(recipients...)
 	}()
 	
 	// Wait for result with timeout
 	select {
 	case err := <-resultChan:
 		if err != nil {
-			log.Printf("❌ Failed to send test mail to %s: %s", mh.Recipient, err)
+			log.Printf("❌ Failed to send test mail to %s: %s", recipient, err)
 			c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
 			errorMsg := err.Error()
 			if len(errorMsg) > 200 {
@@ -53,9 +57,9 @@ func (mh MailerHandler) HandleTestMail(c echo.Context) error {
 			}
 			return c.HTML(200, `<span class="text-error">❌ `+errorMsg+`</span>`)
 		}
-		log.Printf("✅ Test mail sent successfully to %s", mh.Recipient)
+		log.Printf("✅ Test mail sent successfully to %s", recipient)
 		c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
-		return c.HTML(200, `<span class="text-success">✅ Test email sent successfully to `+mh.Recipient+`!</span>`)
+		return c.HTML(200, `<span class="text-success">✅ Test email sent successfully to `+recipient+`!</span>`)
 	case <-time.After(35 * time.Second):
 		log.Printf("❌ Test mail request timed out after 35 seconds")
 		c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nwesterhausen/domain-monitor/service"
+	"github.com/nwesterhausen/domain-monitor/views/domains"
+)
+
+type MailSecurityHandler struct {
+	MailSecurity         *service.MailSecurityService
+	Notifications        *service.NotificationService
+	ConfigurationService *service.ConfigurationService
+}
+
+func NewMailSecurityHandler(ms *service.MailSecurityService, ns *service.NotificationService, cs *service.ConfigurationService) *MailSecurityHandler {
+	return &MailSecurityHandler{
+		MailSecurity:         ms,
+		Notifications:        ns,
+		ConfigurationService: cs,
+	}
+}
+
+// List the mail security report of every checked domain
+func (h *MailSecurityHandler) GetReports(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.MailSecurity.List())
+}
+
+// Get the mail security report of a domain
+func (h *MailSecurityHandler) GetReport(c echo.Context) error {
+	report, ok := h.MailSecurity.Get(c.Param("fqdn"))
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "no mail security report for " + c.Param("fqdn")})
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// Check the mail security of a domain (every monitored domain without `domain`) now, alerting regressions like the
+// scheduled checks do
+func (h *MailSecurityHandler) PostCheck(c echo.Context) error {
+	results, err := h.check(c.QueryParam("domain"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, results)
+}
+
+// Render the mail security panel of a domain card
+func (h *MailSecurityHandler) GetCardPanel(c echo.Context) error {
+	return h.renderCardPanel(c, c.Param("fqdn"), "")
+}
+
+// Check the mail security of a domain from its card and render the updated panel
+func (h *MailSecurityHandler) PostCardCheck(c echo.Context) error {
+	if _, err := h.check(c.Param("fqdn")); err != nil {
+		return h.renderCardPanel(c, c.Param("fqdn"), err.Error())
+	}
+	return h.renderCardPanel(c, c.Param("fqdn"), "")
+}
+
+func (h *MailSecurityHandler) check(fqdn string) ([]service.MailSecurityResult, error) {
+	now := time.Now()
+	var results []service.MailSecurityResult
+	if fqdn == "" {
+		results = h.MailSecurity.CheckAll(now)
+	} else {
+		result, err := h.MailSecurity.Check(fqdn, now)
+		if err != nil {
+			return nil, err
+		}
+		results = []service.MailSecurityResult{result}
+	}
+	if service.NotifyMailSecurities(h.Notifications, h.ConfigurationService.GetConfiguration(), results, now) > 0 {
+		h.Notifications.Process(now)
+	}
+	return results, nil
+}
+
+func (h *MailSecurityHandler) renderCardPanel(c echo.Context, fqdn string, problem string) error {
+	report, checked := h.MailSecurity.Get(fqdn)
+	canCheck := h.ConfigurationService.GetAppConfiguration().ShowConfiguration
+	return View(c, domains.MailSecurityPanel(fqdn, report, checked, h.MailSecurity.Enabled(), canCheck, problem))
+}
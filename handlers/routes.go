@@ -17,14 +17,17 @@ func SetupRoutes(app *echo.Echo, includeConfiguration bool) {
 	}
 }
 
-func SetupDomainRoutes(app *echo.Echo, domains configuration.DomainConfiguration, whoisCache configuration.WhoisCacheStorage, configurationEnabled bool) {
+func SetupDomainRoutes(app *echo.Echo, domains *configuration.DomainConfiguration, whoisCache *configuration.WhoisCacheStorage, cs *service.ConfigurationService, configurationEnabled bool) {
 	domainHtmx := app.Group("/domain")
 	domainApi := app.Group("/api/domain")
 
 	ds := service.NewDomainService(domains)
 	ws := service.NewWhoisService(whoisCache)
-	dhapi := NewApiDomainHandler(ds)
-	dh := NewDomainHandler(ds, ws)
+	dhapi := NewApiDomainHandler(ds, cs)
+	dh := NewDomainHandler(ds, ws, cs)
+
+	// Lifecycle transitions: pause, resume, archive and restore
+	transitions := []string{configuration.TransitionPause, configuration.TransitionResume, configuration.TransitionArchive, configuration.TransitionRestore}
 
 	domainApi.GET("", dhapi.HandleDomainList)
 	domainApi.GET("/:fqdn", dhapi.HandleDomainShow)
@@ -32,6 +35,9 @@ func SetupDomainRoutes(app *echo.Echo, domains configuration.DomainConfiguration
 		domainApi.POST("/create", dhapi.HandleDomainCreate)
 		domainApi.PUT("/:fqdn", dhapi.HandleDomainUpdate)
 		domainApi.DELETE("/:fqdn", dhapi.HandleDomainDelete)
+		for _, transition := range transitions {
+			domainApi.POST("/:fqdn/"+transition, dhapi.HandleDomainTransition(transition))
+		}
 	}
 
 	domainHtmx.GET("/:fqdn/card", dh.GetCard)
@@ -42,44 +48,239 @@ func SetupDomainRoutes(app *echo.Echo, domains configuration.DomainConfiguration
 		domainHtmx.POST("/update", dh.PostUpdateDomain)
 		domainHtmx.POST("/new", dh.PostNewDomain)
 		domainHtmx.DELETE("/:fqdn", dh.DeleteDomain)
+		for _, transition := range transitions {
+			domainHtmx.POST("/:fqdn/"+transition, dh.PostTransition(transition))
+		}
 	}
 }
 
-func SetupConfigRoutes(app *echo.Echo, config configuration.Configuration) {
+func SetupConfigRoutes(app *echo.Echo, cs *service.ConfigurationService) {
 	configGroup := app.Group("/config")
 	configApi := app.Group("/api/config")
+	contactApi := app.Group("/api/contact-groups")
+	tagRoutesApi := app.Group("/api/tag-routes")
+	ratesApi := app.Group("/api/exchange-rates")
 
-	cs := service.NewConfigurationService(config)
 	ch := NewConfigurationHandler(cs)
+	showConfiguration := cs.GetAppConfiguration().ShowConfiguration
 
+	configApi.GET("/schema", ch.GetSchemas)
+	configApi.GET("/:section", ch.GetSection)
+	configApi.GET("/:section/schema", ch.GetSectionSchema)
 	configApi.GET("/:section/:key", ch.GetSectionKey)
-	if config.Config.App.ShowConfiguration {
+	if showConfiguration {
 		configApi.POST("/:section/:key", ch.SetSectionKey)
+		configApi.PATCH("/:section", ch.PatchSection)
+
+		// Contact groups and tag routes list recipients, so they are only available with configuration enabled
+		contactApi.GET("", ch.GetContactGroups)
+		contactApi.PUT("/:name", ch.PutContactGroup)
+		contactApi.DELETE("/:name", ch.DeleteContactGroup)
+
+		tagRoutesApi.GET("", ch.GetTagRoutes)
+		tagRoutesApi.PUT("/:tag", ch.PutTagRoute)
+		tagRoutesApi.DELETE("/:tag", ch.DeleteTagRoute)
+
+		ratesApi.PUT("/:currency", ch.PutExchangeRate)
+		ratesApi.DELETE("/:currency", ch.DeleteExchangeRate)
 	}
+	ratesApi.GET("", ch.GetExchangeRates)
 
-	if config.Config.App.ShowConfiguration {
+	if showConfiguration {
 		configGroup.GET("/app", ch.RenderAppConfiguration)
 		configGroup.GET("/domain", ch.RenderDomainConfiguration)
 		configGroup.GET("/smtp", ch.RenderSmtpConfiguration)
 		configGroup.GET("/scheduler", ch.RenderSchedulerConfiguration)
 		configGroup.GET("/alerts", ch.RenderAlertsConfiguration)
+		configGroup.GET("/costs", ch.RenderCostsConfiguration)
+		configGroup.GET("/dns", ch.RenderDNSConfiguration)
+		configGroup.GET("/certificates", ch.RenderCertificatesConfiguration)
+		configGroup.GET("/mail-security", ch.RenderMailSecurityConfiguration)
+		configGroup.GET("/dnssec", ch.RenderDNSSECConfiguration)
+		configGroup.GET("/delegation", ch.RenderDelegationConfiguration)
 	}
 }
 
-func SetupMailerRoutes(app *echo.Echo, ms *service.MailerService, alertRecipient string) {
+func SetupMailerRoutes(app *echo.Echo, ms *service.MailerService, cs *service.ConfigurationService) {
 	mailerGroup := app.Group("/mailer")
 
-	mh := NewMailerHandler(ms, alertRecipient)
+	mh := NewMailerHandler(ms, cs)
 
 	mailerGroup.POST("/test", mh.HandleTestMail)
 }
 
-func SetupWhoisRoutes(app *echo.Echo, ws *service.ServicesWhois) {
+func SetupWhoisRoutes(app *echo.Echo, ws *service.ServicesWhois, cs *service.ConfigurationService) {
 	whoisGroup := app.Group("/whois")
 
-	wh := NewWhoisHandler(ws)
+	wh := NewWhoisHandler(ws, cs)
 
 	whoisGroup.POST("/", wh.GetCard)
+	app.GET("/api/whois/:fqdn/status", wh.GetRegistryStatus)
+}
+
+func SetupBackupRoutes(app *echo.Echo, dir configuration.ConfigDirectory, configurationEnabled bool) {
+	// Backups contain every setting and domain, so they are only available with configuration enabled
+	if !configurationEnabled {
+		return
+	}
+
+	bh := NewBackupHandler(service.NewBackupService(dir))
+
+	app.GET("/api/backup", bh.GetBackup)
+	app.POST("/api/restore", bh.PostRestore)
+}
+
+func SetupAlertRoutes(app *echo.Echo, ledger *configuration.AlertLedgerStorage, ns *service.NotificationService, cs *service.ConfigurationService) {
+	ah := NewAlertHandler(ledger, ns, cs)
+
+	app.GET("/alerts", ah.RenderAlerts)
+	app.GET("/api/alerts", ah.GetAlerts)
+	app.GET("/api/notifications", ah.GetNotifications)
+	if cs.GetAppConfiguration().ShowConfiguration {
+		app.POST("/api/notifications/:id/retry", ah.PostRetryNotification)
+		app.DELETE("/api/notifications/:id", ah.DeleteNotification)
+	}
+}
+
+func SetupSnoozeRoutes(app *echo.Echo, ss *service.SnoozeService, domains *configuration.DomainConfiguration, whoisCache *configuration.WhoisCacheStorage, configurationEnabled bool) {
+	sh := NewSnoozeHandler(ss, service.NewDomainService(domains), whoisCache, configurationEnabled)
+
+	app.GET("/api/snoozes", sh.GetSnoozes)
+	app.GET("/domain/:fqdn/snoozes", sh.GetCardSnoozes)
+	if configurationEnabled {
+		app.POST("/api/snoozes/:fqdn", sh.PostSnooze)
+		app.DELETE("/api/snoozes/:id", sh.DeleteSnooze)
+		app.POST("/domain/:fqdn/snoozes", sh.PostCardSnooze)
+		app.DELETE("/domain/:fqdn/snoozes/:id", sh.DeleteCardSnooze)
+	}
+
+	// Signed links from alert e-mails carry their own authorization
+	app.GET("/snooze", sh.GetSnoozeLink)
+	app.POST("/snooze", sh.PostSnoozeLink)
+}
+
+func SetupCalendarRoutes(app *echo.Echo, domains *configuration.DomainConfiguration, whoisCache *configuration.WhoisCacheStorage, cs *service.ConfigurationService) {
+	ch := NewCalendarHandler(domains, whoisCache, cs)
+
+	// Protected by app.calendarToken instead of showConfiguration, so calendar clients can subscribe
+	app.GET("/calendar.ics", ch.GetCalendar)
+}
+
+func SetupCostRoutes(app *echo.Echo, domains *configuration.DomainConfiguration, whoisCache *configuration.WhoisCacheStorage, cs *service.ConfigurationService) {
+	ch := NewCostHandler(domains, whoisCache, cs)
+
+	app.GET("/reports/costs", ch.RenderCosts)
+	app.GET("/api/costs", ch.GetCosts)
+}
+
+func SetupTemplateRoutes(app *echo.Echo, ts *service.TemplateService, domains *configuration.DomainConfiguration, whoisCache *configuration.WhoisCacheStorage, baseURL string) {
+	templateApi := app.Group("/api/templates")
+
+	th := NewTemplateHandler(ts, domains, whoisCache, baseURL)
+
+	templateApi.GET("", th.GetTemplates)
+	templateApi.GET("/:key/preview", th.GetPreview)
+}
+
+func SetupWatchlistRoutes(app *echo.Echo, ws *service.WatchService, ns *service.NotificationService, cs *service.ConfigurationService) {
+	wh := NewWatchlistHandler(ws, ns, cs)
+
+	app.GET("/watchlist", wh.RenderWatchlist)
+	app.GET("/api/watchlist", wh.GetWatchlist)
+	app.GET("/api/watchlist/:fqdn", wh.GetWatch)
+	if cs.GetAppConfiguration().ShowConfiguration {
+		app.POST("/api/watchlist", wh.PostWatch)
+		app.DELETE("/api/watchlist/:fqdn", wh.DeleteWatch)
+		app.POST("/api/watchlist/:fqdn/check", wh.PostCheck)
+		app.POST("/watchlist", wh.PostWatchForm)
+		app.DELETE("/watchlist/:fqdn", wh.DeleteWatchForm)
+		app.POST("/watchlist/:fqdn/check", wh.PostCheckForm)
+	}
+}
+
+func SetupLookalikeRoutes(app *echo.Echo, ls *service.LookalikeService, ns *service.NotificationService, cs *service.ConfigurationService) {
+	lh := NewLookalikeHandler(ls, ns, cs)
+
+	app.GET("/lookalikes", lh.RenderLookalikes)
+	app.GET("/api/lookalikes", lh.GetLookalikes)
+	app.GET("/api/lookalikes/scans", lh.GetScans)
+	app.GET("/api/lookalikes/permutations/:fqdn", lh.GetPermutations)
+	// Scans send hundreds of DNS queries, so they can only be started with configuration enabled
+	if cs.GetAppConfiguration().ShowConfiguration {
+		app.POST("/api/lookalikes/scan", lh.PostScan)
+		app.POST("/lookalikes/scan", lh.PostScanForm)
+	}
+}
+
+func SetupCertificateRoutes(app *echo.Echo, cts *service.CertificateService, ns *service.NotificationService, cs *service.ConfigurationService) {
+	ch := NewCertificateHandler(cts, ns, cs)
+
+	app.GET("/certificates", ch.RenderCertificates)
+	app.GET("/api/certificates", ch.GetCertificates)
+	app.GET("/api/certificates/polls", ch.GetPolls)
+	// Searches go out to the certificate search API, so they can only be started with configuration enabled
+	if cs.GetAppConfiguration().ShowConfiguration {
+		app.POST("/api/certificates/poll", ch.PostPoll)
+		app.POST("/certificates/poll", ch.PostPollForm)
+	}
+}
+
+func SetupSubdomainRoutes(app *echo.Echo, ds *service.DiscoveryService, cs *service.ConfigurationService, domains *configuration.DomainConfiguration) {
+	monitored := []string{}
+	for _, domain := range domains.List() {
+		if domain.Monitored() {
+			monitored = append(monitored, domain.FQDN)
+		}
+	}
+	sh := NewSubdomainHandler(ds, cs, monitored)
+
+	app.GET("/subdomains", sh.RenderSubdomains)
+	app.GET("/api/subdomains", sh.GetSubdomains)
+	if cs.GetAppConfiguration().ShowConfiguration {
+		app.POST("/api/subdomains/discover", sh.PostDiscover)
+		app.POST("/api/subdomains/import", sh.PostImport)
+		app.PUT("/api/subdomains/:fqdn/tls", sh.PutTLS)
+		app.DELETE("/api/subdomains/:fqdn/tls", sh.DeleteTLS)
+		app.POST("/subdomains/discover", sh.PostDiscoverForm)
+		app.POST("/subdomains/import", sh.PostImportForm)
+		app.POST("/subdomains/:fqdn/tls", sh.PostTLSForm)
+	}
+}
+
+func SetupMailSecurityRoutes(app *echo.Echo, ms *service.MailSecurityService, ns *service.NotificationService, cs *service.ConfigurationService) {
+	mh := NewMailSecurityHandler(ms, ns, cs)
+
+	app.GET("/api/mail-security", mh.GetReports)
+	app.GET("/api/mail-security/:fqdn", mh.GetReport)
+	app.GET("/domain/:fqdn/mail-security", mh.GetCardPanel)
+	if cs.GetAppConfiguration().ShowConfiguration {
+		app.POST("/api/mail-security/check", mh.PostCheck)
+		app.POST("/domain/:fqdn/mail-security", mh.PostCardCheck)
+	}
+}
+
+func SetupDNSSECRoutes(app *echo.Echo, ds *service.DNSSECService, ns *service.NotificationService, cs *service.ConfigurationService) {
+	dh := NewDNSSECHandler(ds, ns, cs)
+
+	app.GET("/api/dnssec", dh.GetReports)
+	app.GET("/api/dnssec/:fqdn", dh.GetReport)
+	app.GET("/domain/:fqdn/dnssec", dh.GetCardPanel)
+	if cs.GetAppConfiguration().ShowConfiguration {
+		app.POST("/api/dnssec/check", dh.PostCheck)
+		app.POST("/domain/:fqdn/dnssec", dh.PostCardCheck)
+	}
+}
+
+func SetupDelegationRoutes(app *echo.Echo, ds *service.DelegationService, ns *service.NotificationService, cs *service.ConfigurationService) {
+	dh := NewDelegationHandler(ds, ns, cs)
+
+	app.GET("/api/delegation", dh.GetReports)
+	app.GET("/api/delegation/:fqdn", dh.GetReport)
+	app.GET("/domain/:fqdn/delegation", dh.GetCardPanel)
+	if cs.GetAppConfiguration().ShowConfiguration {
+		app.POST("/api/delegation/check", dh.PostCheck)
+		app.POST("/domain/:fqdn/delegation", dh.PostCardCheck)
+	}
 }
 
 func View(c echo.Context, cmp templ.Component) error {
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nwesterhausen/domain-monitor/configuration"
+	"github.com/nwesterhausen/domain-monitor/service"
+	"github.com/nwesterhausen/domain-monitor/views/alerts"
+	"github.com/nwesterhausen/domain-monitor/views/domains"
+)
+
+type SnoozeHandler struct {
+	Snoozes       *service.SnoozeService
+	DomainService ApiDomainService
+	WhoisCache    *configuration.WhoisCacheStorage
+	// Snoozes can only be changed from the web interface and API with configuration enabled
+	CanManage bool
+}
+
+func NewSnoozeHandler(ss *service.SnoozeService, ds ApiDomainService, whoisCache *configuration.WhoisCacheStorage, canManage bool) *SnoozeHandler {
+	return &SnoozeHandler{
+		Snoozes:       ss,
+		DomainService: ds,
+		WhoisCache:    whoisCache,
+		CanManage:     canManage,
+	}
+}
+
+// List the snoozes, newest first, optionally filtered by `fqdn`. With `active=true` only the snoozes that still silence
+// alerts are listed.
+func (h *SnoozeHandler) GetSnoozes(c echo.Context) error {
+	fqdn := strings.ToLower(strings.TrimSpace(c.QueryParam("fqdn")))
+	if c.QueryParam("active") != "true" {
+		return c.JSON(http.StatusOK, h.Snoozes.List(fqdn))
+	}
+	active := []configuration.Snooze{}
+	now := time.Now()
+	for _, snooze := range h.Snoozes.List(fqdn) {
+		if snooze.ActiveAt(now, h.expiration(snooze.FQDN)) {
+			active = append(active, snooze)
+		}
+	}
+	return c.JSON(http.StatusOK, active)
+}
+
+// Acknowledge or snooze the alerts of a domain. The body is a JSON service.SnoozeRequest, e.g.
+// `{"kind": "acknowledge", "alert": "daily", "note": "TICKET-12", "by": "jane"}`.
+func (h *SnoozeHandler) PostSnooze(c echo.Context) error {
+	fqdn := c.Param("fqdn")
+	if _, err := h.DomainService.GetDomain(fqdn); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	var request service.SnoozeRequest
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	snooze, err := h.Snoozes.Create(fqdn, h.expiration(fqdn), request, time.Now())
+	if err != nil {
+		return respondValidationError(c, err)
+	}
+	return c.JSON(http.StatusCreated, snooze)
+}
+
+// Remove a snooze, so the alerts it silenced are sent again
+func (h *SnoozeHandler) DeleteSnooze(c echo.Context) error {
+	if !h.Snoozes.Remove(c.Param("id")) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "unknown snooze " + c.Param("id")})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Render the snooze section of a domain card
+func (h *SnoozeHandler) GetCardSnoozes(c echo.Context) error {
+	return h.renderCardSnoozes(c, c.Param("fqdn"), "")
+}
+
+// Create a snooze from the domain card form and render the updated section
+func (h *SnoozeHandler) PostCardSnooze(c echo.Context) error {
+	fqdn := c.Param("fqdn")
+	if _, err := h.DomainService.GetDomain(fqdn); err != nil {
+		return err
+	}
+	var request service.SnoozeRequest
+	if err := c.Bind(&request); err != nil {
+		return h.renderCardSnoozes(c, fqdn, err.Error())
+	}
+	if _, err := h.Snoozes.Create(fqdn, h.expiration(fqdn), request, time.Now()); err != nil {
+		return h.renderCardSnoozes(c, fqdn, err.Error())
+	}
+	return h.renderCardSnoozes(c, fqdn, "")
+}
+
+// Remove a snooze from the domain card and render the updated section
+func (h *SnoozeHandler) DeleteCardSnooze(c echo.Context) error {
+	h.Snoozes.Remove(c.Param("id"))
+	return h.renderCardSnoozes(c, c.Param("fqdn"), "")
+}
+
+// Show the confirmation page of a signed link from an alert e-mail
+func (h *SnoozeHandler) GetSnoozeLink(c echo.Context) error {
+	token := c.QueryParam("token")
+	action, err := h.Snoozes.VerifyAction(token, time.Now())
+	if err != nil {
+		c.Response().WriteHeader(http.StatusForbidden)
+		return View(c, alerts.SnoozeLink("", "", "", "", nil, "This link is invalid or has expired."))
+	}
+	return View(c, alerts.SnoozeLink(token, action.FQDN, service.SnoozeScope(action.Alert), action.Kind, nil, ""))
+}
+
+// Apply a signed link from an alert e-mail, after it was confirmed
+func (h *SnoozeHandler) PostSnoozeLink(c echo.Context) error {
+	now := time.Now()
+	action, err := h.Snoozes.VerifyAction(c.FormValue("token"), now)
+	if err != nil {
+		c.Response().WriteHeader(http.StatusForbidden)
+		return View(c, alerts.SnoozeLink("", "", "", "", nil, "This link is invalid or has expired."))
+	}
+	scope := service.SnoozeScope(action.Alert)
+	if _, err := h.DomainService.GetDomain(action.FQDN); err != nil {
+		return View(c, alerts.SnoozeLink("", action.FQDN, scope, action.Kind, nil, "This domain is no longer monitored."))
+	}
+
+	by := strings.TrimSpace(c.FormValue("by"))
+	if by == "" {
+		by = "e-mail link"
+	}
+	request := service.SnoozeRequest{Alert: action.Alert, Kind: action.Kind, Note: c.FormValue("note"), By: by}
+	snooze, err := h.Snoozes.Create(action.FQDN, h.expiration(action.FQDN), request, now)
+	if err != nil {
+		return View(c, alerts.SnoozeLink("", action.FQDN, scope, action.Kind, nil, err.Error()))
+	}
+	return View(c, alerts.SnoozeLink("", action.FQDN, scope, action.Kind, &snooze, ""))
+}
+
+func (h *SnoozeHandler) renderCardSnoozes(c echo.Context, fqdn string, problem string) error {
+	if fqdn == "" {
+		return errors.New("invalid domain (FQDN required)")
+	}
+	active := h.Snoozes.Active(fqdn, h.expiration(fqdn), time.Now())
+	return View(c, domains.SnoozeSection(fqdn, active, alertOptions(), h.CanManage, problem))
+}
+
+// The expiration date of a domain from the WHOIS cache, nil if unknown
+func (h *SnoozeHandler) expiration(fqdn string) *time.Time {
+	entry := h.WhoisCache.Get(fqdn)
+	if entry == nil || entry.WhoisInfo.Domain == nil {
+		return nil
+	}
+	return entry.WhoisInfo.Domain.ExpirationDateInTime
+}
+
+// The alert types that can be snoozed
+func alertOptions() []domains.AlertOption {
+	options := []domains.AlertOption{}
+	for _, alert := range configuration.AllAlerts {
+		options = append(options, domains.AlertOption{Key: service.TemplateKey(alert), Label: alert.String()})
+	}
+	return options
+}
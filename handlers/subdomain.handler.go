@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nwesterhausen/domain-monitor/service"
+	"github.com/nwesterhausen/domain-monitor/views/subdomains"
+)
+
+// Largest zone file that can be imported
+const maxZoneFileSize = 10 << 20
+
+type SubdomainHandler struct {
+	Discovery            *service.DiscoveryService
+	ConfigurationService *service.ConfigurationService
+	Domains              []string
+}
+
+func NewSubdomainHandler(ds *service.DiscoveryService, cs *service.ConfigurationService, domains []string) *SubdomainHandler {
+	return &SubdomainHandler{
+		Discovery:            ds,
+		ConfigurationService: cs,
+		Domains:              domains,
+	}
+}
+
+// List the subdomains discovered so far, of one domain with `domain`
+func (h *SubdomainHandler) GetSubdomains(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.Discovery.List(c.QueryParam("domain")))
+}
+
+// Discover the subdomains of a domain (every monitored domain without `domain`) now
+func (h *SubdomainHandler) PostDiscover(c echo.Context) error {
+	results, err := h.discover(c.QueryParam("domain"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, results)
+}
+
+// Import the hosts of a zone file in the request body into the inventory of `domain`
+func (h *SubdomainHandler) PostImport(c echo.Context) error {
+	added, err := h.Discovery.Import(c.QueryParam("domain"), http.MaxBytesReader(c.Response(), c.Request().Body, maxZoneFileSize), time.Now())
+	if errors.Is(err, service.ErrDomainNotMonitored) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"new": added})
+}
+
+// Turn the TLS check of a subdomain on, checking it right away
+func (h *SubdomainHandler) PutTLS(c echo.Context) error {
+	return h.promote(c, true)
+}
+
+// Turn the TLS check of a subdomain off
+func (h *SubdomainHandler) DeleteTLS(c echo.Context) error {
+	return h.promote(c, false)
+}
+
+func (h *SubdomainHandler) promote(c echo.Context, enabled bool) error {
+	subdomain, err := h.Discovery.Promote(c.Param("fqdn"), enabled, time.Now())
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, subdomain)
+}
+
+// Render the subdomain inventory
+func (h *SubdomainHandler) RenderSubdomains(c echo.Context) error {
+	return h.render(c, c.QueryParam("domain"), "", "")
+}
+
+// Discover from the inventory page and render it again
+func (h *SubdomainHandler) PostDiscoverForm(c echo.Context) error {
+	domain := c.FormValue("domain")
+	if _, err := h.discover(domain); err != nil {
+		return h.render(c, domain, err.Error(), "")
+	}
+	return h.render(c, domain, "", "")
+}
+
+// Import an uploaded zone file from the inventory page and render it again
+func (h *SubdomainHandler) PostImportForm(c echo.Context) error {
+	domain := c.FormValue("domain")
+	file, err := c.FormFile("zone")
+	if err != nil {
+		return h.render(c, domain, "Choose a zone file to import", "")
+	}
+	zone, err := file.Open()
+	if err != nil {
+		return h.render(c, domain, err.Error(), "")
+	}
+	defer zone.Close()
+	added, err := h.Discovery.Import(domain, zone, time.Now())
+	if err != nil {
+		return h.render(c, domain, err.Error(), "")
+	}
+	if len(added) == 0 {
+		return h.render(c, domain, "", "The zone file has no new hosts")
+	}
+	return h.render(c, domain, "", "New hosts: "+strings.Join(added, ", "))
+}
+
+// Turn the TLS check of a subdomain on or off from the inventory page and render the row again
+func (h *SubdomainHandler) PostTLSForm(c echo.Context) error {
+	subdomain, err := h.Discovery.Promote(c.Param("fqdn"), c.FormValue("enabled") == "true", time.Now())
+	if err != nil {
+		return c.String(http.StatusNotFound, err.Error())
+	}
+	return View(c, subdomains.SubdomainRow(subdomain, true, time.Now()))
+}
+
+func (h *SubdomainHandler) discover(fqdn string) ([]service.DiscoveryResult, error) {
+	now := time.Now()
+	if fqdn == "" {
+		return h.Discovery.DiscoverAll(now), nil
+	}
+	result, err := h.Discovery.Discover(fqdn, now)
+	if err != nil {
+		return nil, err
+	}
+	return []service.DiscoveryResult{result}, nil
+}
+
+func (h *SubdomainHandler) render(c echo.Context, domain string, problem string, notice string) error {
+	canChange := h.ConfigurationService.GetAppConfiguration().ShowConfiguration
+	return View(c, subdomains.Subdomains(h.Discovery.List(domain), h.Domains, domain, h.Discovery.Enabled(), canChange, problem, notice, time.Now()))
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nwesterhausen/domain-monitor/configuration"
+	"github.com/nwesterhausen/domain-monitor/service"
+)
+
+type TemplateHandler struct {
+	Templates  *service.TemplateService
+	Domains    *configuration.DomainConfiguration
+	WhoisCache *configuration.WhoisCacheStorage
+	BaseURL    string
+}
+
+func NewTemplateHandler(ts *service.TemplateService, domains *configuration.DomainConfiguration, whoisCache *configuration.WhoisCacheStorage, baseURL string) *TemplateHandler {
+	return &TemplateHandler{
+		Templates:  ts,
+		Domains:    domains,
+		WhoisCache: whoisCache,
+		BaseURL:    baseURL,
+	}
+}
+
+// List the template keys, what they are sent for and which of their parts are overridden in the data directory
+func (h *TemplateHandler) GetTemplates(c echo.Context) error {
+	type templateInfo struct {
+		Key         string   `json:"key"`
+		Description string   `json:"description"`
+		Overridden  []string `json:"overridden"`
+	}
+	templates := []templateInfo{}
+	for _, template := range service.Templates() {
+		templates = append(templates, templateInfo{Key: template.Key, Description: template.Description, Overridden: h.Templates.Overridden(template.Key)})
+	}
+	return c.JSON(http.StatusOK, templates)
+}
+
+// Render a template with the sample data of the template registry, or only its `format=html` or `format=text` part.
+func (h *TemplateHandler) GetPreview(c echo.Context) error {
+	key := c.Param("key")
+	rendered, err := h.Templates.Preview(key, service.PreviewSource{
+		Domains:      h.Domains,
+		WhoisCache:   h.WhoisCache,
+		FQDN:         c.QueryParam("fqdn"),
+		Availability: c.QueryParam("availability"),
+		BaseURL:      h.BaseURL,
+		Now:          time.Now(),
+	})
+	if errors.Is(err, service.ErrUnknownTemplate) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "unknown template " + key})
+	}
+	if errors.Is(err, service.ErrNoPreviewDomain) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	}
+
+	switch c.QueryParam("format") {
+	case "html":
+		return c.HTML(http.StatusOK, rendered.HTML)
+	case "text":
+		return c.String(http.StatusOK, "Subject: "+rendered.Subject+"\n\n"+rendered.Text)
+	}
+	return c.JSON(http.StatusOK, rendered)
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nwesterhausen/domain-monitor/configuration"
+	"github.com/nwesterhausen/domain-monitor/service"
+	"github.com/nwesterhausen/domain-monitor/views/watchlist"
+)
+
+type WatchlistHandler struct {
+	Watches              *service.WatchService
+	Notifications        *service.NotificationService
+	ConfigurationService *service.ConfigurationService
+}
+
+func NewWatchlistHandler(ws *service.WatchService, ns *service.NotificationService, cs *service.ConfigurationService) *WatchlistHandler {
+	return &WatchlistHandler{
+		Watches:              ws,
+		Notifications:        ns,
+		ConfigurationService: cs,
+	}
+}
+
+// List the watched names with their last known availability
+func (h *WatchlistHandler) GetWatchlist(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.Watches.List())
+}
+
+// Get a watched name
+func (h *WatchlistHandler) GetWatch(c echo.Context) error {
+	watch, err := h.Watches.Get(c.Param("fqdn"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, watch)
+}
+
+// Start watching a name, from a JSON body with `fqdn`, `notes` and `owners`
+func (h *WatchlistHandler) PostWatch(c echo.Context) error {
+	var watch configuration.WatchedDomain
+	if err := c.Bind(&watch); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	// the availability is only set by checks
+	watch = configuration.WatchedDomain{FQDN: watch.FQDN, Notes: watch.Notes, Owners: watch.Owners}
+	watch, err := h.Watches.Add(watch)
+	if err != nil {
+		return c.JSON(watchErrorStatus(err), map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusCreated, watch)
+}
+
+// Stop watching a name
+func (h *WatchlistHandler) DeleteWatch(c echo.Context) error {
+	if err := h.Watches.Remove(c.Param("fqdn")); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Check the availability of a watched name now, alerting a change like the scheduled checks do
+func (h *WatchlistHandler) PostCheck(c echo.Context) error {
+	change, err := h.check(c.Param("fqdn"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, change)
+}
+
+// Render the watchlist page
+func (h *WatchlistHandler) RenderWatchlist(c echo.Context) error {
+	return h.render(c, "")
+}
+
+// Add a name from the watchlist form and render the watchlist again
+func (h *WatchlistHandler) PostWatchForm(c echo.Context) error {
+	watch := configuration.WatchedDomain{FQDN: c.FormValue("fqdn"), Notes: c.FormValue("notes"), Owners: []string{c.FormValue("owners")}}
+	if _, err := h.Watches.Add(watch); err != nil {
+		return h.render(c, err.Error())
+	}
+	return h.render(c, "")
+}
+
+// Remove a name from the watchlist page and render the watchlist again
+func (h *WatchlistHandler) DeleteWatchForm(c echo.Context) error {
+	if err := h.Watches.Remove(c.Param("fqdn")); err != nil {
+		return h.render(c, err.Error())
+	}
+	return h.render(c, "")
+}
+
+// Check a name from the watchlist page and render the watchlist again
+func (h *WatchlistHandler) PostCheckForm(c echo.Context) error {
+	if _, err := h.check(c.Param("fqdn")); err != nil {
+		return h.render(c, err.Error())
+	}
+	return h.render(c, "")
+}
+
+func (h *WatchlistHandler) check(fqdn string) (service.WatchChange, error) {
+	now := time.Now()
+	change, err := h.Watches.Check(fqdn, now)
+	if err != nil {
+		return change, err
+	}
+	if service.NotifyWatchChanges(h.Notifications, h.ConfigurationService.GetConfiguration(), []service.WatchChange{change}, now) > 0 {
+		h.Notifications.Process(now)
+	}
+	return change, nil
+}
+
+func (h *WatchlistHandler) render(c echo.Context, problem string) error {
+	return View(c, watchlist.Watchlist(h.Watches.List(), h.ConfigurationService.GetAppConfiguration().ShowConfiguration, problem))
+}
+
+// watchErrorStatus maps the errors of adding a watched name to a status code
+func watchErrorStatus(err error) int {
+	var validation configuration.ValidationErrors
+	switch {
+	case errors.As(err, &validation):
+		return http.StatusBadRequest
+	case errors.Is(err, configuration.ErrWatchExists), errors.Is(err, service.ErrWatchOwned):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"errors"
+	"net/http"
 
 	"github.com/a-h/templ"
 	"github.com/labstack/echo/v4"
@@ -11,11 +12,14 @@ import (
 
 type WhoisHandler struct {
 	WhoisService *service.ServicesWhois
+	// Provides the expected locks
+	ConfigurationService *service.ConfigurationService
 }
 
-func NewWhoisHandler(ws *service.ServicesWhois) *WhoisHandler {
+func NewWhoisHandler(ws *service.ServicesWhois, cs *service.ConfigurationService) *WhoisHandler {
 	return &WhoisHandler{
-		WhoisService: ws,
+		WhoisService:         ws,
+		ConfigurationService: cs,
 	}
 }
 
@@ -32,8 +36,23 @@ func (h *WhoisHandler) GetCard(c echo.Context) error {
 	if err != nil {
 		card = domains.WhoisError(err)
 	} else {
-	card = domains.WhoisDetail(whois)
+	card = domains.WhoisDetail(whois, h.ConfigurationService.GetConfiguration().Alerts.ExpectedLocks)
 	}
 
 	return View(c, card)
 }
+
+// Get the registry status of a domain from its cached WHOIS entry, as EPP codes, with the expected locks it's missing
+func (h *WhoisHandler) GetRegistryStatus(c echo.Context) error {
+	whois, err := h.WhoisService.GetWhois(c.Param("fqdn"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	status := whois.RegistryStatus()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"fqdn":         whois.FQDN,
+		"status":       status,
+		"dangerous":    status.Dangerous(),
+		"missingLocks": status.MissingLocks(h.ConfigurationService.GetConfiguration().Alerts.ExpectedLocks),
+	})
+}
@@ -0,0 +1,399 @@
+package service
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+	"gopkg.in/yaml.v3"
+)
+
+// Version of the backup archive layout
+const BackupFormatVersion = 1
+
+// Name of the manifest inside a backup archive
+const backupManifestName = "manifest.json"
+
+// Largest archive accepted for a restore
+const maxBackupSize = 64 << 20
+
+// BackupManifest is stored as the first entry of every backup archive
+type BackupManifest struct {
+	// Version of the archive layout
+	Format int `json:"format"`
+	// When the backup was created
+	CreatedAt time.Time `json:"createdAt"`
+	// If false, secrets (e.g. the SMTP password) were removed from the configuration
+	IncludesSecrets bool `json:"includesSecrets"`
+	// The data files in the archive
+	Files []BackupFile `json:"files"`
+}
+
+// BackupFile describes a single data file in a backup archive
+type BackupFile struct {
+	// File name, relative to the data directory
+	Name string `json:"name"`
+	// Format version of the file
+	Version int `json:"version"`
+	// Size in bytes
+	Size int `json:"size"`
+	// Hex encoded SHA-256 checksum of the file
+	SHA256 string `json:"sha256"`
+}
+
+// BackupArchive is a validated backup, read into memory
+type BackupArchive struct {
+	Manifest BackupManifest
+	// File contents, keyed by name
+	Files map[string][]byte
+}
+
+// RestoreSummary describes what a restore would change in the data directory
+type RestoreSummary struct {
+	// When the backup was created
+	CreatedAt time.Time `json:"createdAt"`
+	// If the backup contains secrets. If not, the current secrets are kept.
+	IncludesSecrets bool `json:"includesSecrets"`
+	// Domains only in the backup
+	DomainsAdded []string `json:"domainsAdded"`
+	// Domains that will be removed
+	DomainsRemoved []string `json:"domainsRemoved"`
+	// Domains with different settings in the backup
+	DomainsChanged []string `json:"domainsChanged"`
+	// Configuration keys (section.key) with a different value in the backup
+	ConfigChanged []string `json:"configChanged"`
+	// Number of entries in each data file, currently and in the backup
+	Entries map[string][2]int `json:"entries"`
+	// Data files that differ from the current ones
+	FilesChanged []string `json:"filesChanged"`
+}
+
+type BackupService struct {
+	dir configuration.ConfigDirectory
+}
+
+func NewBackupService(dir configuration.ConfigDirectory) *BackupService {
+	return &BackupService{dir: dir}
+}
+
+// WriteArchive streams a gzipped tar archive of the data directory to w. The manifest comes first, followed by every
+// data file that exists. Unless includeSecrets is set, secret configuration values are blanked out.
+func (s *BackupService) WriteArchive(w io.Writer, includeSecrets bool) error {
+	manifest := BackupManifest{
+		Format:          BackupFormatVersion,
+		CreatedAt:       time.Now().UTC(),
+		IncludesSecrets: includeSecrets,
+	}
+	contents := map[string][]byte{}
+
+	for _, file := range configuration.DataFiles() {
+		data, err := os.ReadFile(filepath.Join(s.dir.DataDir, file.Name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if file.Name == configuration.AppConfig && !includeSecrets {
+			if data, err = stripSecrets(data); err != nil {
+				return fmt.Errorf("unable to remove secrets from %s: %w", file.Name, err)
+			}
+		}
+		sum := sha256.Sum256(data)
+		manifest.Files = append(manifest.Files, BackupFile{
+			Name:    file.Name,
+			Version: fileVersion(data),
+			Size:    len(data),
+			SHA256:  hex.EncodeToString(sum[:]),
+		})
+		contents[file.Name] = data
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	if err := writeTarEntry(tw, backupManifestName, manifestData, manifest.CreatedAt); err != nil {
+		return err
+	}
+	for _, file := range manifest.Files {
+		if err := writeTarEntry(tw, file.Name, contents[file.Name], manifest.CreatedAt); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// ReadArchive reads a backup archive and validates the manifest, checksums and file versions
+func ReadArchive(r io.Reader) (*BackupArchive, error) {
+	gz, err := gzip.NewReader(io.LimitReader(r, maxBackupSize))
+	if err != nil {
+		return nil, fmt.Errorf("not a backup archive: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	archive := &BackupArchive{Files: map[string][]byte{}}
+	var manifestData []byte
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("unexpected entry %s in backup archive", header.Name)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		if header.Name == backupManifestName {
+			manifestData = data
+			continue
+		}
+		if !configuration.IsDataFile(header.Name) {
+			return nil, fmt.Errorf("unexpected file %s in backup archive", header.Name)
+		}
+		archive.Files[header.Name] = data
+	}
+
+	if manifestData == nil {
+		return nil, errors.New("backup archive has no manifest")
+	}
+	if err := json.Unmarshal(manifestData, &archive.Manifest); err != nil {
+		return nil, fmt.Errorf("invalid backup manifest: %w", err)
+	}
+	if archive.Manifest.Format > BackupFormatVersion {
+		return nil, fmt.Errorf("backup format %d is newer than the supported format %d", archive.Manifest.Format, BackupFormatVersion)
+	}
+
+	supported := map[string]int{}
+	for _, file := range configuration.DataFiles() {
+		supported[file.Name] = file.Version
+	}
+	for _, file := range archive.Manifest.Files {
+		data, ok := archive.Files[file.Name]
+		if !ok {
+			return nil, fmt.Errorf("%s is listed in the manifest but missing from the archive", file.Name)
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != file.SHA256 || len(data) != file.Size {
+			return nil, fmt.Errorf("checksum mismatch for %s", file.Name)
+		}
+		if file.Version > supported[file.Name] {
+			return nil, fmt.Errorf("%s has version %d, but this build only supports up to version %d", file.Name, file.Version, supported[file.Name])
+		}
+		if err := yaml.Unmarshal(data, &map[string]interface{}{}); err != nil {
+			return nil, fmt.Errorf("%s in the backup is not valid: %w", file.Name, err)
+		}
+	}
+	if len(archive.Files) != len(archive.Manifest.Files) {
+		return nil, errors.New("backup archive contains files not listed in the manifest")
+	}
+
+	return archive, nil
+}
+
+// Summarize compares a backup with the current data directory
+func (s *BackupService) Summarize(archive *BackupArchive) (RestoreSummary, error) {
+	summary := RestoreSummary{
+		CreatedAt:       archive.Manifest.CreatedAt,
+		IncludesSecrets: archive.Manifest.IncludesSecrets,
+		DomainsAdded:    []string{},
+		DomainsRemoved:  []string{},
+		DomainsChanged:  []string{},
+		ConfigChanged:   []string{},
+		Entries:         map[string][2]int{},
+		FilesChanged:    []string{},
+	}
+
+	for _, file := range configuration.DataFiles() {
+		current, _ := os.ReadFile(filepath.Join(s.dir.DataDir, file.Name))
+		restored, ok := archive.Files[file.Name]
+		if !ok {
+			continue
+		}
+		if !bytes.Equal(current, restored) {
+			summary.FilesChanged = append(summary.FilesChanged, file.Name)
+		}
+		summary.Entries[file.Name] = [2]int{countEntries(current), countEntries(restored)}
+	}
+
+	// Domains, by FQDN
+	currentDomains := configuration.DomainFile{}
+	restoredDomains := configuration.DomainFile{}
+	if data, err := os.ReadFile(filepath.Join(s.dir.DataDir, configuration.Domains)); err == nil {
+		yaml.Unmarshal(data, &currentDomains)
+	}
+	if data, ok := archive.Files[configuration.Domains]; ok {
+		if err := yaml.Unmarshal(data, &restoredDomains); err != nil {
+			return summary, err
+		}
+		current := map[string]configuration.Domain{}
+		for _, d := range currentDomains.Domains {
+			current[d.FQDN] = d
+		}
+		restored := map[string]bool{}
+		for _, d := range restoredDomains.Domains {
+			restored[d.FQDN] = true
+			existing, ok := current[d.FQDN]
+			if !ok {
+				summary.DomainsAdded = append(summary.DomainsAdded, d.FQDN)
+			} else if !reflect.DeepEqual(existing, d) {
+				summary.DomainsChanged = append(summary.DomainsChanged, d.FQDN)
+			}
+		}
+		for _, d := range currentDomains.Domains {
+			if !restored[d.FQDN] {
+				summary.DomainsRemoved = append(summary.DomainsRemoved, d.FQDN)
+			}
+		}
+	}
+
+	// Configuration, by section and key
+	if data, ok := archive.Files[configuration.AppConfig]; ok {
+		current := configuration.ConfigurationFile{}
+		restored := configuration.ConfigurationFile{}
+		if data, err := os.ReadFile(filepath.Join(s.dir.DataDir, configuration.AppConfig)); err == nil {
+			yaml.Unmarshal(data, &current)
+		}
+		if err := yaml.Unmarshal(data, &restored); err != nil {
+			return summary, err
+		}
+		if !archive.Manifest.IncludesSecrets {
+			restored = restored.WithSecretsFrom(current)
+		}
+		for _, section := range configuration.SectionNames() {
+			a, _ := current.GetSection(section)
+			b, _ := restored.GetSection(section)
+			for key := range b {
+				if !reflect.DeepEqual(a[key], b[key]) {
+					summary.ConfigChanged = append(summary.ConfigChanged, section+"."+key)
+				}
+			}
+		}
+	}
+
+	sort.Strings(summary.DomainsAdded)
+	sort.Strings(summary.DomainsRemoved)
+	sort.Strings(summary.DomainsChanged)
+	sort.Strings(summary.ConfigChanged)
+	return summary, nil
+}
+
+// Restore swaps the files of a backup into the data directory. Only use this while the server isn't running (see
+// StageRestore).
+func (s *BackupService) Restore(archive *BackupArchive) error {
+	files, err := s.restoredFiles(archive)
+	if err != nil {
+		return err
+	}
+	return s.dir.ReplaceFiles(files)
+}
+
+// StageRestore saves the files of a backup to be swapped into the data directory on the next start
+func (s *BackupService) StageRestore(archive *BackupArchive) error {
+	files, err := s.restoredFiles(archive)
+	if err != nil {
+		return err
+	}
+	return s.dir.StageRestore(files)
+}
+
+// restoredFiles returns the files to write for a restore, keeping the current secrets if the backup has none
+func (s *BackupService) restoredFiles(archive *BackupArchive) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	for name, data := range archive.Files {
+		files[name] = data
+	}
+
+	data, ok := files[configuration.AppConfig]
+	if !ok || archive.Manifest.IncludesSecrets {
+		return files, nil
+	}
+	current := configuration.ConfigurationFile{}
+	if existing, err := os.ReadFile(filepath.Join(s.dir.DataDir, configuration.AppConfig)); err == nil {
+		yaml.Unmarshal(existing, &current)
+	}
+	restored := configuration.ConfigurationFile{}
+	if err := yaml.Unmarshal(data, &restored); err != nil {
+		return nil, err
+	}
+	merged, err := configuration.MarshalYAML(restored.WithSecretsFrom(current))
+	if err != nil {
+		return nil, err
+	}
+	files[configuration.AppConfig] = merged
+	return files, nil
+}
+
+func writeTarEntry(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0o600,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// stripSecrets removes secret values from a config.yaml file
+func stripSecrets(data []byte) ([]byte, error) {
+	config := configuration.ConfigurationFile{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return configuration.MarshalYAML(config.WithoutSecrets())
+}
+
+// fileVersion reads the top-level version field of a data file
+func fileVersion(data []byte) int {
+	doc := struct {
+		Version int `yaml:"version"`
+	}{}
+	yaml.Unmarshal(data, &doc)
+	return doc.Version
+}
+
+// countEntries counts the items of the first top-level list in a data file (domains, cache entries, ...)
+func countEntries(data []byte) int {
+	doc := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return 0
+	}
+	keys := make([]string, 0, len(doc))
+	for key := range doc {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if list, ok := doc[key].([]interface{}); ok {
+			return len(list)
+		}
+	}
+	return 0
+}
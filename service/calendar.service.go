@@ -0,0 +1,128 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+// CalendarAlarmDays returns the reminder days for the calendar events, largest first: the configured ones, or the
+// thresholds of the enabled alerts
+func CalendarAlarmDays(config configuration.ConfigurationFile) []int {
+	days := []int{}
+	for _, value := range config.App.CalendarAlarms {
+		if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+			days = append(days, n)
+		}
+	}
+	if len(config.App.CalendarAlarms) == 0 {
+		for _, threshold := range alertThresholds {
+			if alertEnabled(config.Alerts, threshold.alert) {
+				days = append(days, int(threshold.days))
+			}
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(days)))
+	return days
+}
+
+// BuildCalendar renders an iCalendar (RFC 5545) feed with an all-day event on the expiration date of every monitored
+// domain that matches the filter. Expiration dates are taken in the scheduler timezone. The event UIDs only depend on
+// the FQDN, so calendar clients update the event after a renewal instead of adding another one.
+func BuildCalendar(domains []configuration.Domain, cache *configuration.WhoisCacheStorage, filter configuration.DomainFilter, config configuration.ConfigurationFile, now time.Time) string {
+	alarms := CalendarAlarmDays(config)
+	loc := SchedulerLocation(config.Scheduler)
+	baseURL := config.App.BaseURL
+
+	var b strings.Builder
+	writeCalendarLine(&b, "BEGIN:VCALENDAR")
+	writeCalendarLine(&b, "VERSION:2.0")
+	writeCalendarLine(&b, "PRODID:-//domain-monitor//Domain Expirations//EN")
+	writeCalendarLine(&b, "CALSCALE:GREGORIAN")
+	writeCalendarLine(&b, "METHOD:PUBLISH")
+	writeCalendarLine(&b, "X-WR-CALNAME:Domain Expirations")
+
+	for _, domain := range domains {
+		if !domain.Monitored() || !filter.Matches(domain, config) {
+			continue
+		}
+		entry := cache.Get(domain.FQDN)
+		if entry == nil || entry.WhoisInfo.Domain == nil || entry.WhoisInfo.Domain.ExpirationDateInTime == nil {
+			continue
+		}
+		expiration := entry.WhoisInfo.Domain.ExpirationDateInTime.In(loc)
+		day := time.Date(expiration.Year(), expiration.Month(), expiration.Day(), 0, 0, 0, 0, time.UTC)
+		name := domain.Name
+		if name == "" {
+			name = domain.FQDN
+		}
+
+		description := []string{fmt.Sprintf("%s expires on %s.", domain.FQDN, expiration.Format("2006-01-02 15:04 MST"))}
+		if registrar := RegistrarName(entry); registrar != "" {
+			description = append(description, "Registrar: "+registrar)
+		}
+		if price := RenewalPrice(domain); price != "" {
+			description = append(description, "Renewal price: "+price)
+		}
+		if baseURL != "" {
+			description = append(description, "Dashboard: "+baseURL)
+		}
+
+		modified := entry.LastUpdated
+		if modified.IsZero() {
+			modified = now
+		}
+
+		writeCalendarLine(&b, "BEGIN:VEVENT")
+		writeCalendarLine(&b, "UID:expiration-"+domain.FQDN+"@domain-monitor")
+		writeCalendarLine(&b, "DTSTAMP:"+now.UTC().Format("20060102T150405Z"))
+		writeCalendarLine(&b, "LAST-MODIFIED:"+modified.UTC().Format("20060102T150405Z"))
+		writeCalendarLine(&b, "DTSTART;VALUE=DATE:"+day.Format("20060102"))
+		writeCalendarLine(&b, "DTEND;VALUE=DATE:"+day.AddDate(0, 0, 1).Format("20060102"))
+		writeCalendarLine(&b, "SUMMARY:"+escapeCalendarText(name+" expires"))
+		writeCalendarLine(&b, "DESCRIPTION:"+escapeCalendarText(strings.Join(description, "\n")))
+		if baseURL != "" {
+			writeCalendarLine(&b, "URL:"+baseURL)
+		}
+		writeCalendarLine(&b, "TRANSP:TRANSPARENT")
+		for _, days := range alarms {
+			writeCalendarLine(&b, "BEGIN:VALARM")
+			writeCalendarLine(&b, "ACTION:DISPLAY")
+			writeCalendarLine(&b, fmt.Sprintf("TRIGGER:-P%dD", days))
+			writeCalendarLine(&b, "DESCRIPTION:"+escapeCalendarText(fmt.Sprintf("%s expires in %d days", domain.FQDN, days)))
+			writeCalendarLine(&b, "END:VALARM")
+		}
+		writeCalendarLine(&b, "END:VEVENT")
+	}
+
+	writeCalendarLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+// escapeCalendarText escapes a TEXT value (RFC 5545 section 3.3.11)
+func escapeCalendarText(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(value)
+}
+
+// writeCalendarLine writes a content line, folded at 75 octets without splitting UTF-8 sequences, ended by CRLF
+func writeCalendarLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// continuation lines start with a space, which counts towards the limit
+		limit = 74
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
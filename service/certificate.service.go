@@ -0,0 +1,285 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+// Template key of the certificate transparency alerts
+const TemplateKeyCertificate = "certificate"
+
+// Interval between the certificate searches, unless scheduler.certificatePollInterval is set
+const DefaultCertificateInterval = 12 * time.Hour
+
+// CertificateInterval returns the interval between the certificate searches
+func CertificateInterval(scheduler configuration.SchedulerConfiguration) time.Duration {
+	if scheduler.CertificatePollInterval <= 0 {
+		return DefaultCertificateInterval
+	}
+	return time.Duration(scheduler.CertificatePollInterval) * time.Hour
+}
+
+// CertificateResult is the result of searching the certificates of a monitored domain
+type CertificateResult struct {
+	// The searched domain
+	Domain configuration.Domain `json:"domain"`
+	// Counts of the search
+	Poll configuration.CertificatePoll `json:"poll"`
+	// Certificates that weren't found before
+	New []configuration.Certificate `json:"new"`
+	// The new certificates with a new issuer or unexpected names, these are alerted
+	Suspicious []configuration.Certificate `json:"suspicious"`
+}
+
+// CertificateService searches the certificate transparency logs for certificates of the monitored domains
+type CertificateService struct {
+	store   *configuration.CertificateStorage
+	domains *configuration.DomainConfiguration
+	config  configuration.CertificatesConfiguration
+	// Finds the certificates of a domain
+	source  CertificateSource
+	timeout time.Duration
+	// One search at a time, so a certificate isn't reported twice
+	polling sync.Mutex
+}
+
+func NewCertificateService(store *configuration.CertificateStorage, domains *configuration.DomainConfiguration, config configuration.ConfigurationFile) *CertificateService {
+	return &CertificateService{
+		store:   store,
+		domains: domains,
+		config:  config.Certificates,
+		source:  NewCrtShSource(config.Certificates),
+		timeout: CertificateSearchTimeout(config.Certificates),
+	}
+}
+
+// UseSource replaces the source of the certificates, e.g. with a local server or a fake in tests
+func (s *CertificateService) UseSource(source CertificateSource) {
+	s.source = source
+}
+
+// Enabled reports if the certificates are searched on a schedule
+func (s *CertificateService) Enabled() bool {
+	return s.config.Enabled
+}
+
+// List returns the certificates found for a monitored domain, or for every domain if fqdn is empty
+func (s *CertificateService) List(fqdn string) []configuration.Certificate {
+	return s.store.List(strings.ToLower(strings.TrimSpace(fqdn)))
+}
+
+// Polls returns the last search of each monitored domain
+func (s *CertificateService) Polls() []configuration.CertificatePoll {
+	return s.store.Polls()
+}
+
+// Poll searches the certificates of a monitored domain now
+func (s *CertificateService) Poll(fqdn string, now time.Time) (CertificateResult, error) {
+	fqdn = strings.ToLower(strings.TrimSpace(fqdn))
+	for _, domain := range s.domains.List() {
+		if domain.FQDN == fqdn && domain.Monitored() {
+			s.polling.Lock()
+			defer s.polling.Unlock()
+
+			result := s.poll(domain, now)
+			s.store.Flush()
+			return result, nil
+		}
+	}
+	return CertificateResult{}, ErrDomainNotMonitored
+}
+
+// PollAll searches the certificates of every monitored domain. The certificates of domains that were removed are
+// forgotten, paused and archived domains keep theirs.
+func (s *CertificateService) PollAll(now time.Time) []CertificateResult {
+	s.polling.Lock()
+	defer s.polling.Unlock()
+
+	known := map[string]bool{}
+	results := []CertificateResult{}
+	for _, domain := range s.domains.List() {
+		known[domain.FQDN] = true
+		if domain.Monitored() {
+			results = append(results, s.poll(domain, now))
+		}
+	}
+	for _, poll := range s.store.Polls() {
+		if !known[poll.FQDN] && s.store.Forget(poll.FQDN) {
+			log.Printf("🗑 Forgot the certificates of %s, it is no longer in the domain list", poll.FQDN)
+		}
+	}
+	s.store.Flush()
+	return results
+}
+
+// poll searches the certificates of a domain and flags the new issuers and unexpected names
+func (s *CertificateService) poll(domain configuration.Domain, now time.Time) CertificateResult {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*s.timeout)
+	defer cancel()
+
+	poll := configuration.CertificatePoll{FQDN: domain.FQDN, PolledAt: now}
+	found, err := s.source.Search(ctx, domain.FQDN)
+	if err != nil {
+		poll.Error = err.Error()
+		s.store.Record(poll, nil)
+		log.Printf("❌ Failed to search the certificates of %s: %s", domain.FQDN, err)
+		return CertificateResult{Domain: domain, Poll: poll, New: []configuration.Certificate{}, Suspicious: []configuration.Certificate{}}
+	}
+
+	// The first search only records what exists, nothing is flagged
+	baseline := s.store.Baseline(domain.FQDN)
+	issuers := map[string]bool{}
+	for _, issuer := range append(s.store.Issuers(domain.FQDN), s.config.Issuers...) {
+		issuers[strings.ToLower(issuer)] = true
+	}
+	for i := range found {
+		found[i].Of = domain.FQDN
+		if baseline {
+			continue
+		}
+		found[i].NewIssuer = !issuers[strings.ToLower(found[i].IssuerOrganization())]
+		found[i].UnexpectedNames = s.unexpectedNames(found[i].Names)
+	}
+
+	added := s.store.Record(poll, found)
+	result := CertificateResult{Domain: domain, Poll: poll, New: added, Suspicious: []configuration.Certificate{}}
+	for _, certificate := range added {
+		if certificate.Suspicious() {
+			result.Suspicious = append(result.Suspicious, certificate)
+		}
+	}
+	result.Poll.Found, result.Poll.New, result.Poll.Succeeded = len(found), len(added), now
+	log.Printf("📜 Found %d certificates of %s (%d new, %d suspicious)", len(found), domain.FQDN, len(added), len(result.Suspicious))
+	return result
+}
+
+// unexpectedNames returns the names that aren't a monitored domain, a subdomain of one or an allowed name
+func (s *CertificateService) unexpectedNames(names []string) []string {
+	unexpected := []string{}
+	domains := s.domains.List()
+	for _, name := range names {
+		host := strings.TrimPrefix(name, "*.")
+		expected := false
+		for _, domain := range domains {
+			if host == domain.FQDN || strings.HasSuffix(host, "."+domain.FQDN) {
+				expected = true
+				break
+			}
+		}
+		for _, allowed := range s.config.AllowedNames {
+			allowed = strings.ToLower(strings.TrimSpace(allowed))
+			if wildcard, ok := strings.CutPrefix(allowed, "*."); ok {
+				if host == wildcard || strings.HasSuffix(host, "."+wildcard) {
+					expected = true
+				}
+			} else if name == allowed || host == allowed {
+				expected = true
+			}
+		}
+		if !expected {
+			unexpected = append(unexpected, name)
+		}
+	}
+	return unexpected
+}
+
+// CertificateTemplateData is the data available to the certificate alert templates
+type CertificateTemplateData struct {
+	// Application name, for signatures
+	AppName string
+	// Human readable alert, e.g. "2 unexpected certificates for example.com"
+	Alert string
+	// Always "certificate"
+	AlertKey string
+	// The monitored domain
+	Domain configuration.Domain
+	FQDN   string
+	Name   string
+	// The new certificates with a new issuer or unexpected names
+	Certificates []configuration.Certificate
+	Count        int
+	// Link to the dashboard, empty if no base URL is configured
+	DashboardURL string
+	// When the message was rendered
+	Now time.Time
+}
+
+// NewCertificateTemplateData builds the template data for the suspicious certificates of a domain
+func NewCertificateTemplateData(result CertificateResult, baseURL string, now time.Time) CertificateTemplateData {
+	data := CertificateTemplateData{
+		AppName:      "Domain Monitor",
+		AlertKey:     TemplateKeyCertificate,
+		Domain:       result.Domain,
+		FQDN:         result.Domain.FQDN,
+		Name:         result.Domain.Name,
+		Certificates: result.Suspicious,
+		Count:        len(result.Suspicious),
+		Now:          now,
+	}
+	if data.Name == "" {
+		data.Name = data.FQDN
+	}
+	data.Alert = "Unexpected certificate for " + data.FQDN
+	if data.Count > 1 {
+		data.Alert = fmt.Sprintf("%d unexpected certificates for %s", data.Count, data.FQDN)
+	}
+	if baseURL != "" {
+		data.DashboardURL = strings.TrimRight(baseURL, "/") + "/"
+	}
+	return data
+}
+
+// NotifyCertificate queues the alert for the suspicious certificates of a domain, to the recipients of the domain.
+// Returns who it was queued for.
+func NotifyCertificate(notifications *NotificationService, config configuration.ConfigurationFile, result CertificateResult, now time.Time) ([]string, error) {
+	// Certificates aren't about the expiration, so the escalation recipients are left out
+	recipients := ResolveRecipients(config, result.Domain, math.Inf(1))
+	if recipients.Empty() {
+		log.Printf("⚠️ No recipients for the certificate alert of %s, configure alerts.admin or domain owners", result.Domain.FQDN)
+		return nil, nil
+	}
+
+	data := NewCertificateTemplateData(result, config.App.BaseURL, now)
+	rendered, err := notifications.Render(TemplateKeyCertificate, data)
+	if err != nil {
+		log.Printf("❌ Failed to render the certificate alert for %s: %s", result.Domain.FQDN, err)
+		return nil, err
+	}
+
+	// Each certificate is alerted once
+	keyParts := []string{TemplateKeyCertificate, result.Domain.FQDN}
+	for _, certificate := range result.Suspicious {
+		keyParts = append(keyParts, certificate.Issuer+"/"+certificate.Serial)
+	}
+	item := configuration.QueuedNotification{FQDN: result.Domain.FQDN, Alert: TemplateKeyCertificate}
+	return enqueue(notifications, recipients, rendered, data, item, keyParts)
+}
+
+// NotifyCertificates queues the alerts of the domains with suspicious certificates and alerts turned on. Nothing is
+// queued without a mailer or notifier webhooks. Returns the number of queued alerts.
+func NotifyCertificates(notifications *NotificationService, config configuration.ConfigurationFile, results []CertificateResult, now time.Time) int {
+	if notifications == nil || !notifications.Enabled() {
+		return 0
+	}
+	queued := 0
+	for _, result := range results {
+		if len(result.Suspicious) == 0 || !result.Domain.Alerts {
+			continue
+		}
+		received, err := NotifyCertificate(notifications, config, result, now)
+		if err != nil {
+			log.Printf("❌ Failed to queue the certificate alert for %s: %s", result.Domain.FQDN, err)
+		}
+		if len(received) > 0 {
+			queued++
+		}
+	}
+	return queued
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+// crtShFixture serves a crt.sh search response captured in testdata. Like crt.sh, a query for a name returns the
+// entries with that name, a query for %.name the entries with a subdomain of it.
+type crtShFixture struct {
+	mu sync.Mutex
+	// File in testdata that is served, an empty file answers with an error
+	file    string
+	queries []string
+}
+
+func (f *crtShFixture) serve(file string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.file = file
+}
+
+func (f *crtShFixture) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	params := r.URL.Query()
+	f.queries = append(f.queries, params.Get("q"))
+	if params.Get("output") != "json" || params.Get("exclude") != "expired" {
+		http.Error(w, "unexpected parameters "+r.URL.RawQuery, http.StatusBadRequest)
+		return
+	}
+	if f.file == "" {
+		http.Error(w, "too many requests", http.StatusServiceUnavailable)
+		return
+	}
+	data, err := os.ReadFile(filepath.Join("testdata", f.file))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	entries := []crtShEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query := params.Get("q")
+	matching := []crtShEntry{}
+	for _, entry := range entries {
+		for _, name := range strings.Fields(entry.NameValue) {
+			if suffix, ok := strings.CutPrefix(query, "%"); (ok && strings.HasSuffix(name, suffix)) || name == query {
+				matching = append(matching, entry)
+				break
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(matching)
+}
+
+// newTestCertificateService polls example.com against the fixture, cdn.partner.net may share its certificates
+func newTestCertificateService(t *testing.T) (*CertificateService, *configuration.CertificateStorage, *crtShFixture) {
+	t.Helper()
+	fixture := &crtShFixture{}
+	server := httptest.NewServer(fixture)
+	t.Cleanup(server.Close)
+
+	store := configuration.DefaultCertificateStorage(filepath.Join(t.TempDir(), configuration.CertificatesName))
+	domains := &configuration.DomainConfiguration{DomainFile: configuration.DomainFile{Domains: []configuration.Domain{
+		{FQDN: "example.com", Name: "Example", Enabled: true, Alerts: true},
+	}}}
+	config := configuration.ConfigurationFile{Certificates: configuration.CertificatesConfiguration{
+		Enabled:      true,
+		URL:          server.URL + "/",
+		Timeout:      5,
+		AllowedNames: []string{"*.partner.net"},
+	}}
+	return NewCertificateService(store, domains, config), store, fixture
+}
+
+func certificateIDs(certificates []configuration.Certificate) []int64 {
+	ids := []int64{}
+	for _, certificate := range certificates {
+		ids = append(ids, certificate.ID)
+	}
+	return ids
+}
+
+func TestCertificatePoll(t *testing.T) {
+	s, store, fixture := newTestCertificateService(t)
+	now := time.Date(2024, 8, 10, 12, 0, 0, 0, time.UTC)
+
+	// The first poll is the baseline, the precertificate and its certificate are stored once
+	fixture.serve("crtsh-example.com-1.json")
+	result, err := s.Poll("example.com", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fixture.queries, []string{"example.com", "%.example.com"}) {
+		t.Errorf("queries = %v, want the domain and its subdomains", fixture.queries)
+	}
+	if len(result.New) != 0 || len(result.Suspicious) != 0 {
+		t.Errorf("first poll returned %v new and %v suspicious, want nothing", certificateIDs(result.New), certificateIDs(result.Suspicious))
+	}
+	if result.Poll.Found != 3 || result.Poll.Error != "" {
+		t.Errorf("first poll found %d (error %q), want the 3 log entries", result.Poll.Found, result.Poll.Error)
+	}
+	list := store.List("example.com")
+	if len(list) != 2 {
+		t.Fatalf("stored %v, want 2 certificates", certificateIDs(list))
+	}
+	for _, certificate := range list {
+		if !certificate.Baseline || certificate.Suspicious() {
+			t.Errorf("certificate %d is not a quiet baseline: %+v", certificate.ID, certificate)
+		}
+	}
+	www := list[1]
+	if www.ID != 14130011111 || !reflect.DeepEqual(www.Names, []string{"example.com", "www.example.com"}) {
+		t.Errorf("stored %d with names %v, want the first log entry with its sorted names", www.ID, www.Names)
+	}
+	if want := time.Date(2024, 8, 1, 6, 12, 43, 992000000, time.UTC); !www.LoggedAt.Equal(want) {
+		t.Errorf("logged at %s, want %s", www.LoggedAt, want)
+	}
+	if www.IssuerOrganization() != "Let's Encrypt" {
+		t.Errorf("issuer organization = %q, want Let's Encrypt", www.IssuerOrganization())
+	}
+
+	// A new issuer and a name outside the monitored domains are alerted, a new intermediate of a known issuer and an
+	// allowed name aren't
+	fixture.serve("crtsh-example.com-2.json")
+	result, err = s.Poll("example.com", now.Add(12*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := certificateIDs(result.New); !reflect.DeepEqual(ids, []int64{14201000001, 14201000002, 14201000003, 14201000004}) {
+		t.Errorf("second poll returned %v as new, want the 4 certificates after the baseline", ids)
+	}
+	if len(result.Suspicious) != 2 {
+		t.Fatalf("second poll flagged %v, want 2 certificates", certificateIDs(result.Suspicious))
+	}
+	sectigo, login := result.Suspicious[0], result.Suspicious[1]
+	if !sectigo.NewIssuer || len(sectigo.UnexpectedNames) != 0 || sectigo.IssuerOrganization() != "Sectigo Limited" {
+		t.Errorf("flagged %+v, want the Sectigo certificate as a new issuer", sectigo)
+	}
+	if login.NewIssuer || !reflect.DeepEqual(login.UnexpectedNames, []string{"example-login.net"}) {
+		t.Errorf("flagged %+v, want example-login.net as an unexpected name", login)
+	}
+	if result.Poll.Found != 7 || result.Poll.New != 4 {
+		t.Errorf("second poll found %d with %d new, want 7 and 4", result.Poll.Found, result.Poll.New)
+	}
+
+	// The same response again is nothing new
+	result, err = s.Poll("example.com", now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.New) != 0 || len(result.Suspicious) != 0 {
+		t.Errorf("third poll returned %v new and %v suspicious, want nothing", certificateIDs(result.New), certificateIDs(result.Suspicious))
+	}
+	if stored := store.List("example.com"); len(stored) != 6 {
+		t.Errorf("stored %d certificates, want 6", len(stored))
+	}
+}
+
+func TestCertificatePollFailure(t *testing.T) {
+	s, store, fixture := newTestCertificateService(t)
+	now := time.Date(2024, 8, 10, 12, 0, 0, 0, time.UTC)
+
+	// A failed first poll isn't a baseline, the next successful poll is
+	result, _ := s.Poll("example.com", now)
+	if !strings.Contains(result.Poll.Error, "status 503") {
+		t.Errorf("poll error = %q, want the status of the response", result.Poll.Error)
+	}
+	if !store.Baseline("example.com") {
+		t.Error("a failed poll ended the baseline")
+	}
+	fixture.serve("crtsh-example.com-1.json")
+	if result, _ = s.Poll("example.com", now.Add(time.Hour)); len(result.New) != 0 {
+		t.Errorf("first successful poll returned %v as new, want a baseline", certificateIDs(result.New))
+	}
+
+	// A failure keeps the counts of the last successful poll and doesn't forget anything
+	fixture.serve("")
+	result, _ = s.Poll("example.com", now.Add(2*time.Hour))
+	polls := store.Polls()
+	if result.Poll.Error == "" || len(polls) != 1 || polls[0].Found != 3 || !polls[0].Succeeded.Equal(now.Add(time.Hour)) {
+		t.Errorf("polls = %+v, want the failure with the counts of the last success", polls)
+	}
+	if len(store.List("example.com")) != 2 {
+		t.Error("certificates were lost after a failed poll")
+	}
+	fixture.serve("crtsh-example.com-1.json")
+	if result, _ = s.Poll("example.com", now.Add(3*time.Hour)); len(result.New) != 0 {
+		t.Errorf("poll after a failure returned %v as new, want nothing", certificateIDs(result.New))
+	}
+}
+
+func TestCertificatePollOnlyMonitoredDomains(t *testing.T) {
+	s, _, _ := newTestCertificateService(t)
+	if _, err := s.Poll("unknown.com", time.Now()); err != ErrDomainNotMonitored {
+		t.Errorf("Poll(unknown.com) = %v, want ErrDomainNotMonitored", err)
+	}
+}
@@ -3,8 +3,7 @@ package service
 import (
 	"errors"
 	"log"
-	"strconv"
-
+	
 	"github.com/nwesterhausen/domain-monitor/configuration"
 )
 
@@ -12,8 +11,8 @@ type ConfigurationService struct {
 	store configuration.Configuration
 }
 
-func NewConfigurationService(store configuration.Configuration) ConfigurationService {
-	return ConfigurationService{store: store}
+func NewConfigurationService(store configuration.Configuration) *ConfigurationService {
+	return &ConfigurationService{store: store}
 }
 
 func (s *ConfigurationService) GetConfiguration() configuration.ConfigurationFile {
@@ -36,6 +35,38 @@ func (s *ConfigurationService) GetSchedulerConfiguration() configuration.Schedul
 	return s.store.Config.Scheduler
 }
 
+func (s *ConfigurationService) GetCostsConfiguration() configuration.CostsConfiguration {
+	return s.store.Config.Costs
+}
+
+func (s *ConfigurationService) GetDNSConfiguration() configuration.DNSConfiguration {
+	return s.store.Config.DNS
+}
+
+func (s *ConfigurationService) GetLookalikesConfiguration() configuration.LookalikesConfiguration {
+	return s.store.Config.Lookalikes
+}
+
+func (s *ConfigurationService) GetCertificatesConfiguration() configuration.CertificatesConfiguration {
+	return s.store.Config.Certificates
+}
+
+func (s *ConfigurationService) GetDiscoveryConfiguration() configuration.DiscoveryConfiguration {
+	return s.store.Config.Discovery
+}
+
+func (s *ConfigurationService) GetMailSecurityConfiguration() configuration.MailSecurityConfiguration {
+	return s.store.Config.MailSecurity
+}
+
+func (s *ConfigurationService) GetDNSSECConfiguration() configuration.DNSSECConfiguration {
+	return s.store.Config.DNSSEC
+}
+
+func (s *ConfigurationService) GetDelegationConfiguration() configuration.DelegationConfiguration {
+	return s.store.Config.Delegation
+}
+
 func (s *ConfigurationService) SetConfiguration(config configuration.ConfigurationFile) {
 	s.store.Config = config
 	s.store.Flush()
@@ -61,241 +92,178 @@ func (s *ConfigurationService) SetSchedulerConfiguration(config configuration.Sc
 	s.store.Flush()
 }
 
-type ErrInvalidConfigurationKey struct {
-	Key string
-}
+// Get each specific configuration value
+//
+// Sensitive values (marked with `sensitive:"true"` on the configuration types) are only returned when the configuration
+// is shown in the web interface.
+func (s *ConfigurationService) GetConfigurationValue(section string, key string) (interface{}, error) {
+	schema, err := configuration.FieldSchemaFor(section, key)
+	if err != nil {
+		return nil, err
+	}
+	if (schema.Sensitive || configuration.IsSensitiveSection(section)) && !s.GetAppConfiguration().ShowConfiguration {
+		log.Printf("🚨 Configuration editing is disabled in config.yaml ('%s:%s' is not accessible via GET)", section, key)
+		return nil, errors.New("configuration editing is disabled")
+	}
 
-type ErrInvalidConfigurationSection struct {
-	Section string
+	return s.store.Config.GetValue(section, key)
 }
 
-func (e *ErrInvalidConfigurationKey) Error() string {
-	return "Invalid configuration key: " + e.Key
+// Get all values of a configuration section, omitting sensitive values unless the configuration is shown in the web interface
+func (s *ConfigurationService) GetConfigurationSection(section string) (map[string]interface{}, error) {
+	values, err := s.store.Config.GetSection(section)
+	if err != nil {
+		return nil, err
+	}
+	if s.GetAppConfiguration().ShowConfiguration {
+		return values, nil
+	}
+	if configuration.IsSensitiveSection(section) {
+		log.Printf("🚨 Configuration editing is disabled in config.yaml ('%s' settings are not accessible via GET)", section)
+		return nil, errors.New("configuration editing is disabled")
+	}
+	fields, _ := configuration.SectionSchema(section)
+	for _, f := range fields {
+		if f.Sensitive {
+			delete(values, f.Key)
+		}
+	}
+	return values, nil
 }
 
-func (e *ErrInvalidConfigurationSection) Error() string {
-	return "Invalid configuration section: " + e.Section
+// Set each specific configuration value
+func (s *ConfigurationService) SetConfigurationValue(section string, key string, value interface{}) error {
+	return s.SetConfigurationValues(section, map[string]interface{}{key: value})
 }
 
-// Get each specific configuration value
-func (s *ConfigurationService) GetConfigurationValue(section string, key string) (interface{}, error) {
-	switch section {
-	case "app":
-		switch key {
-		case "port":
-			return s.GetAppConfiguration().Port, nil
-		case "automateWHOISRefresh":
-			return s.GetAppConfiguration().AutomateWHOISRefresh, nil
-		case "showConfiguration":
-			return s.GetAppConfiguration().ShowConfiguration, nil
-		default:
-			return nil, &ErrInvalidConfigurationKey{
-				Key: key,
-			}
-		}
-	case "alerts":
-		switch key {
-		case "admin":
-			if !s.GetAppConfiguration().ShowConfiguration {
-				log.Println("🚨 Configuration editing is disabled in config.yaml (Admin email is not accessible via GET)")
-				return nil, errors.New("configuration editing is disabled")
-			}
-			return s.GetAlertsConfiguration().Admin, nil
-		case "sendAlerts":
-			return s.GetAlertsConfiguration().SendAlerts, nil
-		case "send2MonthAlert":
-			return s.GetAlertsConfiguration().Send2MonthAlert, nil
-		case "send1MonthAlert":
-			return s.GetAlertsConfiguration().Send1MonthAlert, nil
-		case "send2WeekAlert":
-			return s.GetAlertsConfiguration().Send2WeekAlert, nil
-		case "send1WeekAlert":
-			return s.GetAlertsConfiguration().Send1WeekAlert, nil
-		case "send3DayAlert":
-			return s.GetAlertsConfiguration().Send3DayAlert, nil
-		case "sendDailyExpiryAlert":
-			return s.GetAlertsConfiguration().SendDailyExpiryAlert, nil
-		default:
-			return nil, &ErrInvalidConfigurationKey{
-				Key: key,
-			}
-		}
-	case "smtp":
-		if !s.GetAppConfiguration().ShowConfiguration {
-			log.Println("🚨 Configuration editing is disabled in config.yaml (SMTP settings are not accessible via GET)")
-			return nil, errors.New("configuration editing is disabled")
-		}
+// Set several values of a configuration section at once. Either all values are applied, or none are and the
+// returned error is a configuration.ValidationErrors with a message for each invalid key.
+func (s *ConfigurationService) SetConfigurationValues(section string, values map[string]interface{}) error {
+	if !s.GetAppConfiguration().ShowConfiguration {
+		log.Println("🚨 Configuration editing is disabled in config.yaml")
+		return errors.New("configuration editing is disabled")
+	}
 
-		switch key {
-		case "host":
-			return s.GetSMTPConfiguration().Host, nil
-		case "port":
-			return s.GetSMTPConfiguration().Port, nil
-		case "encryptionType":
-			encType := s.GetSMTPConfiguration().EncryptionType
-			if encType == "" {
-				// Return legacy Secure-based value
-				port := s.GetSMTPConfiguration().Port
-				if port == 25 {
-					return "none", nil
-				} else if port == 465 {
-					return "ssl", nil
-				} else {
-					return "starttls", nil
-				}
-			}
-			// Normalize old values
-			if encType == "tls" || encType == "starttls-mandatory" || encType == "starttls-opportunistic" {
-				return "starttls", nil
-			}
-			return encType, nil
-		case "secure":
-			return s.GetSMTPConfiguration().Secure, nil
-		case "authUser":
-			return s.GetSMTPConfiguration().AuthUser, nil
-		case "authPass":
-			return s.GetSMTPConfiguration().AuthPass, nil
-		case "enabled":
-			return s.GetSMTPConfiguration().Enabled, nil
-		case "fromName":
-			return s.GetSMTPConfiguration().FromName, nil
-		case "fromAddress":
-			return s.GetSMTPConfiguration().FromAddress, nil
-		default:
-			return nil, &ErrInvalidConfigurationKey{
-				Key: key,
-			}
-		}
-	case "scheduler":
-		switch key {
-		case "whoisCacheStaleInterval":
-			return s.GetSchedulerConfiguration().WhoisCacheStaleInterval, nil
-		case "useStandardWhoisRefreshSchedule":
-			return s.GetSchedulerConfiguration().UseStandardWhoisRefreshSchedule, nil
-		default:
-			return nil, &ErrInvalidConfigurationKey{
-				Key: key,
-			}
-		}
-	default:
-		return nil, &ErrInvalidConfigurationSection{
-			Section: section,
-		}
+	// Log the received values (keys only, values may be secrets)
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	log.Printf("🛰️ Setting '%s' keys %v", section, keys)
+
+	if err := s.store.Config.SetValues(section, values); err != nil {
+		return err
 	}
+
+	s.store.Flush()
+
+	return nil
 }
 
-// Set each specific configuration value
-func (s *ConfigurationService) SetConfigurationValue(section string, key string, value interface{}) error {
+// List the contact groups
+func (s *ConfigurationService) GetContactGroups() []configuration.ContactGroup {
+	if s.store.Config.ContactGroups == nil {
+		return []configuration.ContactGroup{}
+	}
+	return s.store.Config.ContactGroups
+}
+
+// Add or replace a contact group. Invalid groups are rejected with a configuration.ValidationErrors.
+func (s *ConfigurationService) SetContactGroup(group configuration.ContactGroup) error {
 	if !s.GetAppConfiguration().ShowConfiguration {
 		log.Println("🚨 Configuration editing is disabled in config.yaml")
 		return errors.New("configuration editing is disabled")
 	}
+	if err := s.store.Config.SetContactGroup(group); err != nil {
+		return err
+	}
+	log.Printf("🛰️ Saved contact group '%s'", group.Name)
+	s.store.Flush()
+	return nil
+}
+
+// Remove a contact group, returning false if it doesn't exist. Fails if configuration editing is disabled.
+func (s *ConfigurationService) RemoveContactGroup(name string) (bool, error) {
+	if !s.GetAppConfiguration().ShowConfiguration {
+		log.Println("🚨 Configuration editing is disabled in config.yaml")
+		return false, errors.New("configuration editing is disabled")
+	}
+	if !s.store.Config.RemoveContactGroup(name) {
+		return false, nil
+	}
+	log.Printf("🗑️ Removed contact group '%s'", name)
+	s.store.Flush()
+	return true, nil
+}
 
+// List the tag routes
+func (s *ConfigurationService) GetTagRoutes() []configuration.TagRoute {
+	if s.store.Config.TagRoutes == nil {
+		return []configuration.TagRoute{}
+	}
+	return s.store.Config.TagRoutes
+}
 
-	stringVal, ok := value.(string)
-	if !ok {
-		log.Println("Value is not expected type (string)")
-		return errors.New("value is not expected type (string)")
+// Add or replace a tag route. Invalid routes are rejected with a configuration.ValidationErrors.
+func (s *ConfigurationService) SetTagRoute(route configuration.TagRoute) error {
+	if !s.GetAppConfiguration().ShowConfiguration {
+		log.Println("🚨 Configuration editing is disabled in config.yaml")
+		return errors.New("configuration editing is disabled")
 	}
-	intVal, intErr := strconv.Atoi(stringVal)
-	// The toggles just send "on" or "" as the string for the value
-	boolVal := stringVal == "on"
-
-	// Log the received values
-	log.Printf("🛰️ Setting '%s:%s' to %s (%d, %t)", section, key, stringVal, intVal, boolVal)
-
-	switch section {
-	case "app":
-		switch key {
-		case "port":
-			if intErr != nil {
-				log.Printf("Error converting port '%s' to int", stringVal)
-				return intErr
-			}
-			s.store.Config.App.Port = intVal
-		case "automateWHOISRefresh":
-			s.store.Config.App.AutomateWHOISRefresh = boolVal
-		case "showConfiguration":
-			s.store.Config.App.ShowConfiguration = boolVal
-		default:
-			return &ErrInvalidConfigurationKey{
-				Key: key,
-			}
-		}
-	case "alerts":
-		switch key {
-		case "admin":
-			s.store.Config.Alerts.Admin = stringVal
-		case "sendAlerts":
-			s.store.Config.Alerts.SendAlerts = boolVal
-		case "send2MonthAlert":
-			s.store.Config.Alerts.Send2MonthAlert = boolVal
-		case "send1MonthAlert":
-			s.store.Config.Alerts.Send1MonthAlert = boolVal
-		case "send2WeekAlert":
-			s.store.Config.Alerts.Send2WeekAlert = boolVal
-		case "send1WeekAlert":
-			s.store.Config.Alerts.Send1WeekAlert = boolVal
-		case "send3DayAlert":
-			s.store.Config.Alerts.Send3DayAlert = boolVal
-		case "sendDailyExpiryAlert":
-			s.store.Config.Alerts.SendDailyExpiryAlert = boolVal
-		default:
-			return &ErrInvalidConfigurationKey{
-				Key: key,
-			}
-		}
-	case "smtp":
-		switch key {
-		case "host":
-			s.store.Config.SMTP.Host = value.(string)
-		case "port":
-			if intErr != nil {
-				log.Printf("Error converting port '%s' to int", stringVal)
-				return intErr
-			}
-			s.store.Config.SMTP.Port = intVal
-		case "secure":
-			s.store.Config.SMTP.Secure = boolVal
-		case "encryptionType":
-			s.store.Config.SMTP.EncryptionType = value.(string)
-		case "authUser":
-			s.store.Config.SMTP.AuthUser = value.(string)
-		case "authPass":
-			s.store.Config.SMTP.AuthPass = value.(string)
-		case "enabled":
-			s.store.Config.SMTP.Enabled = boolVal
-		case "fromName":
-			s.store.Config.SMTP.FromName = value.(string)
-		case "fromAddress":
-			s.store.Config.SMTP.FromAddress = value.(string)
-		default:
-			return &ErrInvalidConfigurationKey{
-				Key: key,
-			}
-		}
-	case "scheduler":
-		switch key {
-		case "whoisCacheStaleInterval":
-			if intErr != nil {
-				log.Printf("Error converting whoisCacheStaleInterval '%s' to int", stringVal)
-				return intErr
-			}
-			s.store.Config.Scheduler.WhoisCacheStaleInterval = intVal
-		case "useStandardWhoisRefreshSchedule":
-			s.store.Config.Scheduler.UseStandardWhoisRefreshSchedule = boolVal
-		default:
-			return &ErrInvalidConfigurationKey{
-				Key: key,
-			}
-		}
-	default:
-		return &ErrInvalidConfigurationSection{
-			Section: section,
-		}
+	if err := s.store.Config.SetTagRoute(route); err != nil {
+		return err
 	}
+	log.Printf("🛰️ Saved tag route '%s'", route.Tag)
+	s.store.Flush()
+	return nil
+}
 
+// Remove a tag route, returning false if it doesn't exist. Fails if configuration editing is disabled.
+func (s *ConfigurationService) RemoveTagRoute(tag string) (bool, error) {
+	if !s.GetAppConfiguration().ShowConfiguration {
+		log.Println("🚨 Configuration editing is disabled in config.yaml")
+		return false, errors.New("configuration editing is disabled")
+	}
+	if !s.store.Config.RemoveTagRoute(tag) {
+		return false, nil
+	}
+	log.Printf("🗑️ Removed tag route '%s'", tag)
 	s.store.Flush()
+	return true, nil
+}
+
+// List the exchange rates of the cost reports
+func (s *ConfigurationService) GetExchangeRates() []configuration.ExchangeRate {
+	if s.store.Config.ExchangeRates == nil {
+		return []configuration.ExchangeRate{}
+	}
+	return s.store.Config.ExchangeRates
+}
 
+// Add or replace an exchange rate. Invalid rates are rejected with a configuration.ValidationErrors.
+func (s *ConfigurationService) SetExchangeRate(rate configuration.ExchangeRate) error {
+	if !s.GetAppConfiguration().ShowConfiguration {
+		log.Println("🚨 Configuration editing is disabled in config.yaml")
+		return errors.New("configuration editing is disabled")
+	}
+	if err := s.store.Config.SetExchangeRate(rate); err != nil {
+		return err
+	}
+	log.Printf("🛰️ Saved exchange rate '%s' = %v", rate.Currency, rate.Rate)
+	s.store.Flush()
 	return nil
 }
+
+// Remove an exchange rate, returning false if it doesn't exist. Fails if configuration editing is disabled.
+func (s *ConfigurationService) RemoveExchangeRate(currency string) (bool, error) {
+	if !s.GetAppConfiguration().ShowConfiguration {
+		log.Println("🚨 Configuration editing is disabled in config.yaml")
+		return false, errors.New("configuration editing is disabled")
+	}
+	if !s.store.Config.RemoveExchangeRate(currency) {
+		return false, nil
+	}
+	log.Printf("🗑️ Removed exchange rate '%s'", currency)
+	s.store.Flush()
+	return true, nil
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+func TestRemoveNeedsConfigurationEditing(t *testing.T) {
+	store := configuration.DefaultConfiguration(filepath.Join(t.TempDir(), "config.yaml"))
+	store.Config.App.ShowConfiguration = false
+	store.Config.ContactGroups = []configuration.ContactGroup{{Name: "oncall", Members: []string{"oncall@example.com"}}}
+	store.Config.TagRoutes = []configuration.TagRoute{{Tag: "prod", Recipients: []string{"oncall"}}}
+	store.Config.ExchangeRates = []configuration.ExchangeRate{{Currency: "€", Rate: 1.08}}
+	s := NewConfigurationService(store)
+
+	removes := map[string]func() (bool, error){
+		"contact group": func() (bool, error) { return s.RemoveContactGroup("oncall") },
+		"tag route":     func() (bool, error) { return s.RemoveTagRoute("prod") },
+		"exchange rate": func() (bool, error) { return s.RemoveExchangeRate("€") },
+	}
+	for name, remove := range removes {
+		if removed, err := remove(); removed || err == nil {
+			t.Errorf("removing the %s with editing disabled = %v, %v, want an error", name, removed, err)
+		}
+	}
+	if len(s.GetContactGroups()) != 1 || len(s.GetTagRoutes()) != 1 || len(s.GetExchangeRates()) != 1 {
+		t.Error("removed configuration while editing is disabled")
+	}
+
+	s.store.Config.App.ShowConfiguration = true
+	for name, remove := range removes {
+		if removed, err := remove(); !removed || err != nil {
+			t.Errorf("removing the %s = %v, %v, want it removed", name, removed, err)
+		}
+		if removed, err := remove(); removed || err != nil {
+			t.Errorf("removing the %s again = %v, %v, want false", name, removed, err)
+		}
+	}
+}
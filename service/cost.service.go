@@ -0,0 +1,212 @@
+package service
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+// Period of the cost projection in months
+const (
+	DefaultCostMonths = 12
+	MaxCostMonths     = 60
+)
+
+// Groupings of the cost report totals, also the `by` values of the CSV export
+const (
+	CostGroupMonth     = "month"
+	CostGroupYear      = "year"
+	CostGroupCurrency  = "currency"
+	CostGroupRegistrar = "registrar"
+	CostGroupTag       = "tag"
+)
+
+// BuildCostReport projects the renewal spend of the domains that pass the filter over the given number of months from
+// now. Paused domains still renew and are included, archived domains are let lapse and only listed. Every
+// domain is expected to renew yearly on its expiration date, at its renewal price. Domains that already expired are
+// counted as overdue in the first month. Amounts are converted to the base currency with the exchange rates of the
+// configuration; domains without a currency are taken to be priced in the base currency.
+func BuildCostReport(domains []configuration.Domain, cache *configuration.WhoisCacheStorage, filter configuration.DomainFilter, config configuration.ConfigurationFile, now time.Time, months int) configuration.CostReport {
+	if months <= 0 {
+		months = DefaultCostMonths
+	}
+	if months > MaxCostMonths {
+		months = MaxCostMonths
+	}
+	loc := SchedulerLocation(config.Scheduler)
+	now = now.In(loc)
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	to := from.AddDate(0, months, 0)
+	base := strings.TrimSpace(config.Costs.BaseCurrency)
+
+	report := configuration.CostReport{
+		From:          from,
+		To:            to,
+		Months:        months,
+		BaseCurrency:  base,
+		Filter:        filter,
+		Renewals:      []configuration.ProjectedRenewal{},
+		MissingRates:  []string{},
+		Unpriced:      []string{},
+		UnknownExpiry: []string{},
+		Archived:      []string{},
+	}
+
+	missing := map[string]bool{}
+	for _, domain := range domains {
+		if !filter.Matches(domain, config) {
+			continue
+		}
+		if domain.Archived {
+			report.Archived = append(report.Archived, domain.FQDN)
+			continue
+		}
+		if domain.RenewalPrice <= 0 {
+			report.Unpriced = append(report.Unpriced, domain.FQDN)
+			continue
+		}
+		entry := cache.Get(domain.FQDN)
+		if entry == nil || entry.WhoisInfo.Domain == nil || entry.WhoisInfo.Domain.ExpirationDateInTime == nil {
+			report.UnknownExpiry = append(report.UnknownExpiry, domain.FQDN)
+			continue
+		}
+
+		renewal := configuration.ProjectedRenewal{
+			FQDN:      domain.FQDN,
+			Name:      domain.Name,
+			Registrar: RegistrarName(entry),
+			Group:     domain.Group,
+			Tags:      domain.Tags,
+			Price:     domain.RenewalPrice,
+			Currency:  strings.TrimSpace(domain.Currency),
+		}
+		if renewal.Currency == "" {
+			renewal.Currency = base
+		}
+		if base != "" {
+			if rate, ok := config.GetExchangeRate(renewal.Currency); ok {
+				renewal.Converted = roundCents(renewal.Price * rate)
+				renewal.Convertible = true
+			} else if !missing[renewal.Currency] {
+				missing[renewal.Currency] = true
+				report.MissingRates = append(report.MissingRates, renewal.Currency)
+			}
+		}
+
+		next := entry.WhoisInfo.Domain.ExpirationDateInTime.In(loc)
+		if next.Before(from) {
+			overdue := renewal
+			overdue.Date = from
+			overdue.Overdue = true
+			report.Renewals = append(report.Renewals, overdue)
+			// Renewing extends the registration from the expiration date, so later renewals keep its anniversary
+			for next.Before(from) {
+				next = next.AddDate(1, 0, 0)
+			}
+		}
+		for ; next.Before(to); next = next.AddDate(1, 0, 0) {
+			r := renewal
+			r.Date = next
+			report.Renewals = append(report.Renewals, r)
+		}
+	}
+	sort.SliceStable(report.Renewals, func(i, j int) bool { return report.Renewals[i].Date.Before(report.Renewals[j].Date) })
+	sort.Strings(report.MissingRates)
+
+	report.Total = configuration.CostTotal{Key: "total", Amounts: []configuration.CurrencyAmount{}, Complete: true}
+	if totals := costTotals(report.Renewals, func(configuration.ProjectedRenewal) []string { return []string{"total"} }); len(totals) > 0 {
+		report.Total = totals[0]
+	}
+	report.ByMonth = costTotals(report.Renewals, func(r configuration.ProjectedRenewal) []string { return []string{r.Date.Format("2006-01")} })
+	report.ByYear = costTotals(report.Renewals, func(r configuration.ProjectedRenewal) []string { return []string{r.Date.Format("2006")} })
+	report.ByCurrency = costTotals(report.Renewals, func(r configuration.ProjectedRenewal) []string { return []string{r.Currency} })
+	report.ByRegistrar = costTotals(report.Renewals, func(r configuration.ProjectedRenewal) []string {
+		if r.Registrar == "" {
+			return []string{"unknown"}
+		}
+		return []string{r.Registrar}
+	})
+	report.ByTag = costTotals(report.Renewals, func(r configuration.ProjectedRenewal) []string {
+		if len(r.Tags) == 0 {
+			return []string{"untagged"}
+		}
+		return r.Tags
+	})
+	sortTotalsBySpend(report.ByCurrency)
+	sortTotalsBySpend(report.ByRegistrar)
+	sortTotalsBySpend(report.ByTag)
+	return report
+}
+
+// CostTotals returns the totals of a cost report for one of the CostGroup* groupings, nil for an unknown one
+func CostTotals(report configuration.CostReport, group string) []configuration.CostTotal {
+	switch group {
+	case CostGroupMonth:
+		return report.ByMonth
+	case CostGroupYear:
+		return report.ByYear
+	case CostGroupCurrency:
+		return report.ByCurrency
+	case CostGroupRegistrar:
+		return report.ByRegistrar
+	case CostGroupTag:
+		return report.ByTag
+	}
+	return nil
+}
+
+// costTotals sums up the renewals per key, in order of first appearance. A renewal with several keys is counted for
+// each of them.
+func costTotals(renewals []configuration.ProjectedRenewal, keys func(configuration.ProjectedRenewal) []string) []configuration.CostTotal {
+	totals := []configuration.CostTotal{}
+	index := map[string]int{}
+	for _, renewal := range renewals {
+		for _, k := range keys(renewal) {
+			i, ok := index[k]
+			if !ok {
+				i = len(totals)
+				index[k] = i
+				totals = append(totals, configuration.CostTotal{Key: k, Amounts: []configuration.CurrencyAmount{}, Complete: true})
+			}
+			total := &totals[i]
+			total.Renewals++
+			total.Amounts = addAmount(total.Amounts, renewal.Currency, renewal.Price)
+			if renewal.Convertible {
+				total.Converted = roundCents(total.Converted + renewal.Converted)
+			} else {
+				total.Complete = false
+			}
+		}
+	}
+	for i := range totals {
+		sort.SliceStable(totals[i].Amounts, func(a, b int) bool { return totals[i].Amounts[a].Amount > totals[i].Amounts[b].Amount })
+	}
+	return totals
+}
+
+func addAmount(amounts []configuration.CurrencyAmount, currency string, amount float64) []configuration.CurrencyAmount {
+	for i := range amounts {
+		if amounts[i].Currency == currency {
+			amounts[i].Amount = roundCents(amounts[i].Amount + amount)
+			return amounts
+		}
+	}
+	return append(amounts, configuration.CurrencyAmount{Currency: currency, Amount: roundCents(amount)})
+}
+
+// sortTotalsBySpend orders totals by their converted sum, then by number of renewals
+func sortTotalsBySpend(totals []configuration.CostTotal) {
+	sort.SliceStable(totals, func(i, j int) bool {
+		if totals[i].Converted != totals[j].Converted {
+			return totals[i].Converted > totals[j].Converted
+		}
+		return totals[i].Renewals > totals[j].Renewals
+	})
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+// Search API used unless certificates.url is set
+const DefaultCertificateSearchURL = "https://crt.sh/"
+
+// Timeout of a single certificate search, unless certificates.timeout is set. crt.sh is slow for busy domains.
+const DefaultCertificateSearchTimeout = 60 * time.Second
+
+// CertificateSource finds the certificates of a domain and its subdomains in the certificate transparency logs.
+// CrtShSource searches crt.sh or a compatible API, tests can point it at a local server or use a fake.
+type CertificateSource interface {
+	Search(ctx context.Context, fqdn string) ([]configuration.Certificate, error)
+}
+
+// CrtShSource searches an API compatible with the JSON output of crt.sh
+type CrtShSource struct {
+	// Base URL of the API, the query is added as `?q=...&output=json`
+	URL    string
+	Client *http.Client
+}
+
+// NewCrtShSource returns a source searching certificates.url, or crt.sh if none is configured
+func NewCrtShSource(config configuration.CertificatesConfiguration) *CrtShSource {
+	source := &CrtShSource{URL: config.URL, Client: &http.Client{Timeout: CertificateSearchTimeout(config)}}
+	if source.URL == "" {
+		source.URL = DefaultCertificateSearchURL
+	}
+	return source
+}
+
+// CertificateSearchTimeout returns the timeout of a single certificate search
+func CertificateSearchTimeout(config configuration.CertificatesConfiguration) time.Duration {
+	if config.Timeout <= 0 {
+		return DefaultCertificateSearchTimeout
+	}
+	return time.Duration(config.Timeout) * time.Second
+}
+
+// crtShEntry is a log entry in the JSON output of crt.sh
+type crtShEntry struct {
+	ID             int64  `json:"id"`
+	IssuerName     string `json:"issuer_name"`
+	CommonName     string `json:"common_name"`
+	NameValue      string `json:"name_value"`
+	SerialNumber   string `json:"serial_number"`
+	NotBefore      string `json:"not_before"`
+	NotAfter       string `json:"not_after"`
+	EntryTimestamp string `json:"entry_timestamp"`
+}
+
+// Search returns the unexpired certificates of a domain and its subdomains. crt.sh matches the domain and its
+// subdomains with separate queries, entries found by both are returned once.
+func (s *CrtShSource) Search(ctx context.Context, fqdn string) ([]configuration.Certificate, error) {
+	byID := map[int64]configuration.Certificate{}
+	for _, query := range []string{fqdn, "%." + fqdn} {
+		entries, err := s.query(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			byID[entry.ID] = entry.certificate()
+		}
+	}
+
+	certificates := make([]configuration.Certificate, 0, len(byID))
+	for _, certificate := range byID {
+		certificates = append(certificates, certificate)
+	}
+	sort.Slice(certificates, func(i, j int) bool { return certificates[i].ID < certificates[j].ID })
+	return certificates, nil
+}
+
+func (s *CrtShSource) query(ctx context.Context, query string) ([]crtShEntry, error) {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate search URL: %w", err)
+	}
+	params := u.Query()
+	params.Set("q", query)
+	params.Set("output", "json")
+	// Expired certificates can't be misused anymore, and precertificates are merged with their certificate
+	params.Set("exclude", "expired")
+	params.Set("deduplicate", "Y")
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("certificate search failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("certificate search returned status %d", resp.StatusCode)
+	}
+	entries := []crtShEntry{}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("failed to parse the certificate search response: %w", err)
+	}
+	return entries, nil
+}
+
+// certificate converts a log entry, the names are lowercased, deduplicated and sorted
+func (e crtShEntry) certificate() configuration.Certificate {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, name := range strings.Fields(strings.ToLower(e.NameValue + " " + e.CommonName)) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return configuration.Certificate{
+		ID:         e.ID,
+		Issuer:     e.IssuerName,
+		CommonName: strings.ToLower(e.CommonName),
+		Names:      names,
+		Serial:     e.SerialNumber,
+		NotBefore:  parseCrtShTime(e.NotBefore),
+		NotAfter:   parseCrtShTime(e.NotAfter),
+		LoggedAt:   parseCrtShTime(e.EntryTimestamp),
+	}
+}
+
+// parseCrtShTime parses the timestamps of crt.sh, which are in UTC without a zone, or RFC 3339 timestamps of other
+// APIs. Returns the zero time if the timestamp can't be parsed.
+func parseCrtShTime(value string) time.Time {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
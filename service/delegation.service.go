@@ -0,0 +1,620 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+// Template key of the delegation health alerts
+const TemplateKeyDelegation = "delegation"
+
+// Interval between the delegation checks, unless scheduler.delegationInterval is set
+const DefaultDelegationInterval = 6 * time.Hour
+
+// DelegationInterval returns the interval between the delegation checks
+func DelegationInterval(scheduler configuration.SchedulerConfiguration) time.Duration {
+	if scheduler.DelegationInterval <= 0 {
+		return DefaultDelegationInterval
+	}
+	return time.Duration(scheduler.DelegationInterval) * time.Hour
+}
+
+// DelegationResult is the result of checking the delegation of a monitored domain
+type DelegationResult struct {
+	// The checked domain
+	Domain configuration.Domain `json:"domain"`
+	// The new report, with the results of the last complete check if this one couldn't be completed
+	Report configuration.DelegationReport `json:"report"`
+	// The report of the last complete check before this one, nil if there was none
+	Previous *configuration.DelegationReport `json:"previous,omitempty"`
+	// How the delegation degraded since the previous report, these are alerted
+	Problems []string `json:"problems"`
+}
+
+// DelegationService compares the nameservers of the monitored domains in the WHOIS data, the parent zone and the
+// answers of the nameservers themselves, and checks that each of them answers authoritatively
+type DelegationService struct {
+	store   *configuration.DelegationStorage
+	whois   *configuration.WhoisCacheStorage
+	domains *configuration.DomainConfiguration
+	config  configuration.DelegationConfiguration
+	// Finds the parent zones and the addresses of the nameservers
+	resolver Resolver
+	// Sends the queries to the nameservers
+	exchanger Exchanger
+	timeout   time.Duration
+	// One check at a time, so a problem isn't reported twice
+	checking sync.Mutex
+}
+
+func NewDelegationService(store *configuration.DelegationStorage, whois *configuration.WhoisCacheStorage, domains *configuration.DomainConfiguration, config configuration.ConfigurationFile) *DelegationService {
+	return &DelegationService{
+		store:     store,
+		whois:     whois,
+		domains:   domains,
+		config:    config.Delegation,
+		resolver:  NewResolver(config.DNS),
+		exchanger: NewExchanger(config.DNS),
+		timeout:   DNSTimeout(config.DNS),
+	}
+}
+
+// UseResolver replaces the resolver of the parent zones and the nameserver addresses, e.g. with a fake in tests
+func (s *DelegationService) UseResolver(resolver Resolver) {
+	s.resolver = resolver
+}
+
+// UseExchanger replaces the exchanger of the queries to the nameservers, e.g. with a fake in tests
+func (s *DelegationService) UseExchanger(exchanger Exchanger) {
+	s.exchanger = exchanger
+}
+
+// Enabled reports if the delegations are checked on a schedule
+func (s *DelegationService) Enabled() bool {
+	return s.config.Enabled
+}
+
+// List returns the report of every checked domain
+func (s *DelegationService) List() []configuration.DelegationReport {
+	return s.store.List()
+}
+
+// Get returns the report of a domain, false if it wasn't checked yet
+func (s *DelegationService) Get(fqdn string) (configuration.DelegationReport, bool) {
+	return s.store.Get(strings.ToLower(strings.TrimSpace(fqdn)))
+}
+
+// Check checks the delegation of a monitored domain now
+func (s *DelegationService) Check(fqdn string, now time.Time) (DelegationResult, error) {
+	fqdn = strings.ToLower(strings.TrimSpace(fqdn))
+	for _, domain := range s.domains.List() {
+		if domain.FQDN == fqdn && domain.Monitored() {
+			s.checking.Lock()
+			defer s.checking.Unlock()
+
+			result := s.check(domain, now)
+			s.store.Flush()
+			return result, nil
+		}
+	}
+	return DelegationResult{}, ErrDomainNotMonitored
+}
+
+// CheckAll checks the delegation of every monitored domain. The reports of domains that were removed are forgotten,
+// paused and archived domains keep theirs.
+func (s *DelegationService) CheckAll(now time.Time) []DelegationResult {
+	s.checking.Lock()
+	defer s.checking.Unlock()
+
+	known := map[string]bool{}
+	results := []DelegationResult{}
+	for _, domain := range s.domains.List() {
+		known[domain.FQDN] = true
+		if domain.Monitored() {
+			results = append(results, s.check(domain, now))
+		}
+	}
+	for _, report := range s.store.List() {
+		if !known[report.FQDN] && s.store.Forget(report.FQDN) {
+			log.Printf("🗑 Forgot the delegation report of %s, it is no longer in the domain list", report.FQDN)
+		}
+	}
+	s.store.Flush()
+	return results
+}
+
+// check inspects the delegation of a domain and compares it with the last complete check
+func (s *DelegationService) check(domain configuration.Domain, now time.Time) DelegationResult {
+	report := configuration.DelegationReport{FQDN: domain.FQDN, CheckedAt: now}
+	if err := s.inspect(domain.FQDN, &report); err != nil {
+		report.Error = err.Error()
+		s.store.Record(report)
+		log.Printf("❌ Failed to check the delegation of %s: %s", domain.FQDN, err)
+		stored, _ := s.store.Get(domain.FQDN)
+		return DelegationResult{Domain: domain, Report: stored, Problems: []string{}}
+	}
+
+	report.Succeeded = now
+	EvaluateDelegation(&report)
+	result := DelegationResult{Domain: domain, Report: report}
+	if previous, ok := s.store.Record(report); ok {
+		result.Previous = &previous
+	}
+	result.Problems = DelegationProblems(result.Previous, report)
+	log.Printf("🧭 Delegation of %s is %s (%d nameservers, %d findings, %d problems)", domain.FQDN, report.Status, len(report.Nameservers), len(report.Findings), len(result.Problems))
+	return result
+}
+
+// inspect collects the nameservers of a domain from the WHOIS data and the referral of the parent zone into the
+// report, and asks each of them (and the nameservers they answer with) for the SOA and NS records of the domain.
+// Returns an error if the parent zone couldn't be asked, the delegation is unknown then.
+func (s *DelegationService) inspect(fqdn string, report *configuration.DelegationReport) error {
+	report.Registry = s.registryNameservers(fqdn)
+
+	zone, parentServers, err := s.parentZone(fqdn)
+	if err != nil {
+		return err
+	}
+	report.ParentZone = zone
+	parent, glue, err := s.referral(fqdn, zone, parentServers)
+	if err != nil {
+		return err
+	}
+	report.Parent = parent
+
+	checks := map[string]*configuration.NameserverCheck{}
+	list := func(hosts []string, place string) {
+		for _, host := range hosts {
+			if checks[host] == nil {
+				checks[host] = &configuration.NameserverCheck{Host: host, Listed: []string{}}
+			}
+			if !checks[host].IsListed(place) {
+				checks[host].Listed = append(checks[host].Listed, place)
+			}
+		}
+	}
+	list(report.Registry, configuration.ListedRegistry)
+	list(report.Parent, configuration.ListedParent)
+
+	// The nameservers may answer with hosts neither the registry nor the parent zone lists, those are checked too
+	checked := map[string]bool{}
+	for round := 0; round < 2; round++ {
+		for host, check := range checks {
+			if !checked[host] {
+				checked[host] = true
+				s.checkNameserver(fqdn, check, glue[host])
+			}
+		}
+		for _, check := range checks {
+			for _, host := range check.NameServers {
+				// Hosts found by the second round aren't checked anymore, so they aren't added
+				if round == 0 || checks[host] != nil {
+					list([]string{host}, configuration.ListedZone)
+				}
+			}
+		}
+	}
+
+	report.Nameservers = []configuration.NameserverCheck{}
+	for _, check := range checks {
+		report.Nameservers = append(report.Nameservers, *check)
+	}
+	sort.Slice(report.Nameservers, func(i, j int) bool { return report.Nameservers[i].Host < report.Nameservers[j].Host })
+	return nil
+}
+
+// registryNameservers returns the nameservers of the cached WHOIS or RDAP data of a domain, sorted
+func (s *DelegationService) registryNameservers(fqdn string) []string {
+	entry := s.whois.Get(fqdn)
+	if entry == nil || entry.NxDomain || entry.WhoisInfo.Domain == nil {
+		return []string{}
+	}
+	return hostSet(entry.WhoisInfo.Domain.NameServers)
+}
+
+// parentZone finds the zone a domain is delegated from and its nameservers: the closest enclosing name that has NS
+// records
+func (s *DelegationService) parentZone(fqdn string) (string, []string, error) {
+	labels := strings.Split(fqdn, ".")
+	for i := 1; i < len(labels); i++ {
+		zone := strings.Join(labels[i:], ".")
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		records, err := s.resolver.LookupNS(ctx, zone)
+		cancel()
+		if err != nil && !IsNotFound(err) {
+			return "", nil, fmt.Errorf("NS lookup for %s failed: %w", zone, err)
+		}
+		hosts := []string{}
+		for _, record := range records {
+			hosts = append(hosts, record.Host)
+		}
+		if hosts = hostSet(hosts); len(hosts) > 0 {
+			return zone, hosts, nil
+		}
+	}
+	return "", nil, fmt.Errorf("no parent zone of %s found", fqdn)
+}
+
+// referral asks the nameservers of the parent zone which nameservers the domain is delegated to, and for their glue
+// addresses. The first nameserver that answers is used. A domain the parent zone doesn't know gets no nameservers.
+func (s *DelegationService) referral(fqdn string, zone string, servers []string) ([]string, map[string][]string, error) {
+	var lastErr error
+	for _, server := range servers {
+		addresses, err := s.lookupHost(server)
+		if err != nil {
+			lastErr = fmt.Errorf("%s doesn't resolve: %w", server, err)
+			continue
+		}
+		for _, address := range addresses {
+			answer, err := s.ask(fqdn, dns.TypeNS, address)
+			if err != nil {
+				lastErr = fmt.Errorf("%s (%s): %w", server, address, err)
+				continue
+			}
+			glue := map[string][]string{}
+			if answer.Rcode == dns.RcodeNameError {
+				return []string{}, glue, nil
+			}
+			if answer.Rcode != dns.RcodeSuccess {
+				lastErr = fmt.Errorf("%s (%s) answered %s", server, address, dns.RcodeToString[answer.Rcode])
+				continue
+			}
+			hosts := []string{}
+			for _, record := range append(answer.Answer, answer.Ns...) {
+				if ns, ok := record.(*dns.NS); ok && strings.EqualFold(ns.Hdr.Name, dns.Fqdn(fqdn)) {
+					hosts = append(hosts, ns.Ns)
+				}
+			}
+			for _, record := range answer.Extra {
+				switch record := record.(type) {
+				case *dns.A:
+					host := normalizeHost(record.Hdr.Name)
+					glue[host] = append(glue[host], record.A.String())
+				case *dns.AAAA:
+					host := normalizeHost(record.Hdr.Name)
+					glue[host] = append(glue[host], record.AAAA.String())
+				}
+			}
+			return hostSet(hosts), glue, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("no nameserver of the parent zone %s answered: %w", zone, lastErr)
+}
+
+// checkNameserver asks every address of a nameserver for the SOA record of a domain, and the first address that
+// answers for the NS records. The nameserver is lame if no address answers authoritatively.
+func (s *DelegationService) checkNameserver(fqdn string, check *configuration.NameserverCheck, glue []string) {
+	addresses := glue
+	if len(addresses) == 0 {
+		resolved, err := s.lookupHost(check.Host)
+		if err != nil {
+			check.Lame = true
+			check.Error = "the host doesn't resolve"
+			return
+		}
+		addresses = resolved
+	}
+	check.Addresses = append([]string{}, addresses...)
+	sort.Strings(check.Addresses)
+
+	problems := []string{}
+	answered := 0
+	for _, address := range check.Addresses {
+		answer, err := s.askAuthoritative(fqdn, dns.TypeSOA, address)
+		if err != nil {
+			problems = append(problems, address+": "+err.Error())
+			continue
+		}
+		var serial uint32
+		for _, record := range answer.Answer {
+			if soa, ok := record.(*dns.SOA); ok {
+				serial = soa.Serial
+			}
+		}
+		if serial == 0 {
+			problems = append(problems, address+": the answer has no SOA record")
+			continue
+		}
+		answered++
+		if check.Serial == 0 {
+			check.Serial = serial
+		} else if serial != check.Serial {
+			problems = append(problems, fmt.Sprintf("%s: serial %d differs from %d", address, serial, check.Serial))
+		}
+
+		if check.NameServers == nil {
+			if answer, err := s.askAuthoritative(fqdn, dns.TypeNS, address); err == nil {
+				hosts := []string{}
+				for _, record := range answer.Answer {
+					if ns, ok := record.(*dns.NS); ok {
+						hosts = append(hosts, ns.Ns)
+					}
+				}
+				check.NameServers = hostSet(hosts)
+			}
+		}
+	}
+	check.Lame = answered == 0
+	check.Authoritative = answered == len(check.Addresses)
+	check.Error = strings.Join(problems, "; ")
+}
+
+// lookupHost resolves the addresses of a nameserver
+func (s *DelegationService) lookupHost(host string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	addresses, err := s.resolver.LookupHost(ctx, host)
+	if err == nil && len(addresses) == 0 {
+		err = errors.New("no addresses")
+	}
+	return addresses, err
+}
+
+// ask sends a non-recursive query for the records of a domain to an address of a nameserver
+func (s *DelegationService) ask(fqdn string, qtype uint16, address string) (*dns.Msg, error) {
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(fqdn), qtype)
+	query.RecursionDesired = false
+	query.SetEdns0(1232, false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	answer, err := s.exchanger.Exchange(ctx, query, net.JoinHostPort(address, "53"))
+	if err != nil {
+		return nil, fmt.Errorf("no answer: %w", err)
+	}
+	return answer, nil
+}
+
+// askAuthoritative asks an address of a nameserver and only accepts an authoritative answer
+func (s *DelegationService) askAuthoritative(fqdn string, qtype uint16, address string) (*dns.Msg, error) {
+	answer, err := s.ask(fqdn, qtype, address)
+	switch {
+	case err != nil:
+		return nil, err
+	case answer.Rcode != dns.RcodeSuccess:
+		return nil, fmt.Errorf("answered %s", dns.RcodeToString[answer.Rcode])
+	case !answer.Authoritative:
+		return nil, errors.New("the answer isn't authoritative")
+	}
+	return answer, nil
+}
+
+// normalizeHost lowercases a host name and drops the final dot
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(host), "."))
+}
+
+// hostSet normalizes host names and returns them sorted, without duplicates
+func hostSet(hosts []string) []string {
+	set := []string{}
+	seen := map[string]bool{}
+	for _, host := range hosts {
+		if host = normalizeHost(host); host != "" && !seen[host] {
+			seen[host] = true
+			set = append(set, host)
+		}
+	}
+	sort.Strings(set)
+	return set
+}
+
+// missingHosts returns the hosts of a set that aren't in another
+func missingHosts(hosts []string, from []string) []string {
+	missing := []string{}
+	for _, host := range hosts {
+		found := false
+		for _, other := range from {
+			found = found || host == other
+		}
+		if !found {
+			missing = append(missing, host)
+		}
+	}
+	return missing
+}
+
+// EvaluateDelegation sets the status of a report and lists its findings: lame nameservers, nameservers that are
+// listed in one place but not another, NS sets that differ from the parent zone and SOA serials that don't agree
+func EvaluateDelegation(report *configuration.DelegationReport) {
+	findings := []string{}
+	lame := len(report.Parent) == 0
+	if lame {
+		findings = append(findings, "The parent zone "+report.ParentZone+" doesn't delegate the domain")
+	}
+
+	if len(report.Registry) > 0 && len(report.Parent) > 0 {
+		for _, host := range missingHosts(report.Registry, report.Parent) {
+			findings = append(findings, host+" is listed by the registry but not delegated by the parent zone")
+		}
+		for _, host := range missingHosts(report.Parent, report.Registry) {
+			findings = append(findings, host+" is delegated by the parent zone but not listed by the registry")
+		}
+	}
+
+	serials := []string{}
+	seen := map[uint32]bool{}
+	// Nameservers that answer with an NS set other than the parent's, grouped by the set
+	differing, sets := map[string][]string{}, []string{}
+	for _, nameserver := range report.Nameservers {
+		switch {
+		case nameserver.Lame:
+			lame = true
+			findings = append(findings, nameserver.Host+" is lame: "+nameserver.Error)
+			continue
+		case nameserver.Error != "":
+			findings = append(findings, nameserver.Host+": "+nameserver.Error)
+		}
+		serials = append(serials, fmt.Sprintf("%s %d", nameserver.Host, nameserver.Serial))
+		seen[nameserver.Serial] = true
+
+		if len(report.Parent) > 0 && (len(missingHosts(nameserver.NameServers, report.Parent)) > 0 || len(missingHosts(report.Parent, nameserver.NameServers)) > 0) {
+			set := strings.Join(nameserver.NameServers, ", ")
+			if differing[set] == nil {
+				sets = append(sets, set)
+			}
+			differing[set] = append(differing[set], nameserver.Host)
+		}
+	}
+	for _, set := range sets {
+		findings = append(findings, fmt.Sprintf("%s answer with the NS set %s, the parent zone delegates to %s", strings.Join(differing[set], ", "),
+			set, strings.Join(report.Parent, ", ")))
+	}
+	if len(seen) > 1 {
+		findings = append(findings, "The SOA serials don't agree: "+strings.Join(serials, ", "))
+	}
+
+	switch {
+	case lame:
+		report.Status = configuration.DelegationLame
+	case len(findings) > 0:
+		report.Status = configuration.DelegationDegraded
+	default:
+		report.Status = configuration.DelegationHealthy
+	}
+	report.Findings = findings
+}
+
+// delegationRank orders the delegation states from healthy (0) to lame (2)
+func delegationRank(status string) int {
+	return map[string]int{configuration.DelegationHealthy: 0, configuration.DelegationDegraded: 1, configuration.DelegationLame: 2}[status]
+}
+
+// DelegationProblems lists how a delegation degraded: its status getting worse and nameservers becoming lame.
+// previous is the last complete report before the current one, nil if there was none (a first check that isn't
+// healthy is alerted).
+func DelegationProblems(previous *configuration.DelegationReport, current configuration.DelegationReport) []string {
+	problems := []string{}
+	before, lameBefore := configuration.DelegationHealthy, []string{}
+	if previous != nil {
+		before, lameBefore = previous.Status, previous.Lame()
+	}
+	if delegationRank(current.Status) > delegationRank(before) {
+		problems = append(problems, "The delegation is "+current.Status+" (it was "+before+")")
+	}
+	for _, host := range missingHosts(current.Lame(), lameBefore) {
+		for _, nameserver := range current.Nameservers {
+			if nameserver.Host == host {
+				problems = append(problems, host+" doesn't answer authoritatively: "+nameserver.Error)
+			}
+		}
+	}
+	return problems
+}
+
+// DelegationTemplateData is the data available to the delegation alert templates
+type DelegationTemplateData struct {
+	// Application name, for signatures
+	AppName string
+	// Human readable alert, e.g. "Delegation of example.com is lame"
+	Alert string
+	// Always "delegation"
+	AlertKey string
+	// The monitored domain
+	Domain configuration.Domain
+	FQDN   string
+	Name   string
+	// How the delegation degraded since the last check
+	Problems []string
+	Count    int
+	// The status now and after the last check
+	Status         string
+	PreviousStatus string
+	// The hosts of the lame nameservers
+	Lame []string
+	// Every nameserver and how it answers
+	Nameservers []configuration.NameserverCheck
+	// Everything found wrong with the delegation now
+	Findings []string
+	// Link to the dashboard, empty if no base URL is configured
+	DashboardURL string
+	// When the message was rendered
+	Now time.Time
+}
+
+// NewDelegationTemplateData builds the template data for the problems of a domain
+func NewDelegationTemplateData(result DelegationResult, baseURL string, now time.Time) DelegationTemplateData {
+	data := DelegationTemplateData{
+		AppName:     "Domain Monitor",
+		AlertKey:    TemplateKeyDelegation,
+		Domain:      result.Domain,
+		FQDN:        result.Domain.FQDN,
+		Name:        result.Domain.Name,
+		Problems:    result.Problems,
+		Count:       len(result.Problems),
+		Status:      result.Report.Status,
+		Lame:        result.Report.Lame(),
+		Nameservers: result.Report.Nameservers,
+		Findings:    result.Report.Findings,
+		Now:         now,
+	}
+	if data.Name == "" {
+		data.Name = data.FQDN
+	}
+	data.PreviousStatus = configuration.DelegationHealthy
+	if result.Previous != nil {
+		data.PreviousStatus = result.Previous.Status
+	}
+	data.Alert = "Delegation of " + data.FQDN + " is " + data.Status
+	if baseURL != "" {
+		data.DashboardURL = strings.TrimRight(baseURL, "/") + "/"
+	}
+	return data
+}
+
+// NotifyDelegation queues the alert for the problems of a domain, to the recipients of the domain. Returns who it was
+// queued for.
+func NotifyDelegation(notifications *NotificationService, config configuration.ConfigurationFile, result DelegationResult, now time.Time) ([]string, error) {
+	// The delegation isn't about the expiration of the registration, so the escalation recipients are left out
+	recipients := ResolveRecipients(config, result.Domain, math.Inf(1))
+	if recipients.Empty() {
+		log.Printf("⚠️ No recipients for the delegation alert of %s, configure alerts.admin or domain owners", result.Domain.FQDN)
+		return nil, nil
+	}
+
+	data := NewDelegationTemplateData(result, config.App.BaseURL, now)
+	rendered, err := notifications.Render(TemplateKeyDelegation, data)
+	if err != nil {
+		log.Printf("❌ Failed to render the delegation alert for %s: %s", result.Domain.FQDN, err)
+		return nil, err
+	}
+
+	// Each check compares with the one before, so its problems are alerted once
+	keyParts := append([]string{TemplateKeyDelegation, result.Domain.FQDN}, result.Problems...)
+	keyParts = append(keyParts, result.Report.Succeeded.Format(time.RFC3339))
+	item := configuration.QueuedNotification{FQDN: result.Domain.FQDN, Alert: TemplateKeyDelegation}
+	return enqueue(notifications, recipients, rendered, data, item, keyParts)
+}
+
+// NotifyDelegations queues the alerts of the domains whose delegation degraded and have alerts turned on. Nothing is
+// queued without a mailer or notifier webhooks. Returns the number of queued alerts.
+func NotifyDelegations(notifications *NotificationService, config configuration.ConfigurationFile, results []DelegationResult, now time.Time) int {
+	if notifications == nil || !notifications.Enabled() {
+		return 0
+	}
+	queued := 0
+	for _, result := range results {
+		if len(result.Problems) == 0 || !result.Domain.Alerts {
+			continue
+		}
+		received, err := NotifyDelegation(notifications, config, result, now)
+		if err != nil {
+			log.Printf("❌ Failed to queue the delegation alert for %s: %s", result.Domain.FQDN, err)
+		}
+		if len(received) > 0 {
+			queued++
+		}
+	}
+	return queued
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+// Digest modes (alerts.digestMode)
+const (
+	// One mail per alert (the default, also used for an empty mode)
+	DigestOff = "off"
+	// One digest mail per expiry check run
+	DigestRun = "run"
+	// One digest mail per day at the digest time
+	DigestDaily = "daily"
+	// One digest mail per week on the digest weekday at the digest time
+	DigestWeekly = "weekly"
+)
+
+// Template key of the digest mail
+const TemplateKeyDigest = "digest"
+
+// DigestItem is a domain in a digest, with every alert that was due for it
+type DigestItem struct {
+	AlertTemplateData
+	// The alerts included for this domain
+	Alerts []configuration.Alert
+	// The evaluated domain, used to mark the alerts as sent
+	Status ExpiryStatus `json:"-"`
+}
+
+// DigestGroup is a list of domains with the same urgency
+type DigestGroup struct {
+	// Expired, Critical, Warning or Upcoming
+	Name  string
+	Items []DigestItem
+}
+
+// DigestTemplateData is the data available to the digest templates
+type DigestTemplateData struct {
+	AppName      string
+	DashboardURL string
+	Now          time.Time
+	// Number of domains in the digest
+	Count int
+	// Non-empty groups, most urgent first
+	Groups []DigestGroup
+}
+
+// DigestEnabled reports if alerts are collected into digests instead of being sent one by one
+func DigestEnabled(alerts configuration.AlertsConfiguration) bool {
+	return alerts.DigestMode != "" && alerts.DigestMode != DigestOff
+}
+
+// IsCriticalAlert reports if an alert is for a domain expiring within a week, or a registry status alert
+func IsCriticalAlert(alert configuration.Alert) bool {
+	if alert == configuration.AlertDaily || alert.IsStatusAlert() {
+		return true
+	}
+	days, ok := ThresholdDays(alert)
+	criticalDays, _ := ThresholdDays(configuration.Alert1Week)
+	return ok && days <= criticalDays
+}
+
+// SplitDigest separates the due alerts into the ones to send right away and the ones to collect into a digest.
+// Statuses without due alerts are dropped.
+func SplitDigest(statuses []ExpiryStatus, alerts configuration.AlertsConfiguration) (immediate []ExpiryStatus, digest []ExpiryStatus) {
+	for _, status := range statuses {
+		if len(status.Due) == 0 {
+			continue
+		}
+		if !DigestEnabled(alerts) {
+			immediate = append(immediate, status)
+			continue
+		}
+		if !alerts.DigestImmediateCritical {
+			digest = append(digest, status)
+			continue
+		}
+
+		now, later := status, status
+		now.Due, later.Due = nil, nil
+		for _, alert := range status.Due {
+			if IsCriticalAlert(alert) {
+				now.Due = append(now.Due, alert)
+			} else {
+				later.Due = append(later.Due, alert)
+			}
+		}
+		if len(now.Due) > 0 {
+			immediate = append(immediate, now)
+		}
+		if len(later.Due) > 0 {
+			digest = append(digest, later)
+		}
+	}
+	return immediate, digest
+}
+
+// NewDigest groups the due alerts by urgency for the digest template
+func NewDigest(statuses []ExpiryStatus, baseURL string, now time.Time) DigestTemplateData {
+	warningDays, _ := ThresholdDays(configuration.Alert1Month)
+	criticalDays, _ := ThresholdDays(configuration.Alert1Week)
+
+	groups := map[string][]DigestItem{}
+	count := 0
+	for _, status := range statuses {
+		if len(status.Due) == 0 {
+			continue
+		}
+		// The most urgent alert is the last one due
+		item := DigestItem{
+			AlertTemplateData: NewAlertTemplateData(status, status.Due[len(status.Due)-1], baseURL, now),
+			Alerts:            status.Due,
+			Status:            status,
+		}
+		names := []string{}
+		for _, alert := range status.Due {
+			names = append(names, alert.String())
+		}
+		item.Alert = strings.Join(names, ", ")
+
+		group := "Upcoming"
+		switch {
+		case status.DaysLeft <= 0:
+			group = "Expired"
+		case CheckExpiry(status, warningDays, criticalDays) == CheckCritical || status.Due[len(status.Due)-1].IsStatusAlert():
+			group = "Critical"
+		case CheckExpiry(status, warningDays, criticalDays) == CheckWarning:
+			group = "Warning"
+		}
+		groups[group] = append(groups[group], item)
+		count++
+	}
+
+	digest := DigestTemplateData{AppName: "Domain Monitor", Now: now, Count: count}
+	if baseURL != "" {
+		digest.DashboardURL = strings.TrimRight(baseURL, "/") + "/"
+	}
+	for _, name := range []string{"Expired", "Critical", "Warning", "Upcoming"} {
+		items := groups[name]
+		if len(items) == 0 {
+			continue
+		}
+		sort.SliceStable(items, func(i, j int) bool { return items[i].Status.DaysLeft < items[j].Status.DaysLeft })
+		digest.Groups = append(digest.Groups, DigestGroup{Name: name, Items: items})
+	}
+	return digest
+}
+
+// SchedulerLocation returns the timezone used for scheduling, the server timezone if none (or an invalid one) is set
+func SchedulerLocation(scheduler configuration.SchedulerConfiguration) *time.Location {
+	if scheduler.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(scheduler.Timezone)
+	if err != nil {
+		log.Printf("⚠️ Unknown timezone %q, using the server timezone: %s", scheduler.Timezone, err)
+		return time.Local
+	}
+	return loc
+}
+
+// NextDigestTime returns when the next daily or weekly digest is due after now, in the given timezone
+func NextDigestTime(alerts configuration.AlertsConfiguration, loc *time.Location, now time.Time) time.Time {
+	hour, minute, err := configuration.ParseClock(alerts.DigestTime)
+	if err != nil {
+		hour, minute = 8, 0
+	}
+	now = now.In(loc)
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, loc)
+
+	if alerts.DigestMode == DigestWeekly {
+		weekday := digestWeekday(alerts.DigestWeekday)
+		next = next.AddDate(0, 0, (int(weekday)-int(next.Weekday())+7)%7)
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 7)
+		}
+		return next
+	}
+
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// digestWeekday parses the configured weekday, defaulting to monday
+func digestWeekday(name string) time.Weekday {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(day.String(), name) {
+			return day
+		}
+	}
+	return time.Monday
+}
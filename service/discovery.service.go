@@ -0,0 +1,356 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+// Interval between the subdomain discoveries, unless scheduler.discoveryInterval is set
+const DefaultDiscoveryInterval = 24 * time.Hour
+
+// Timeout of a TLS check, including the handshake
+const TLSCheckTimeout = 10 * time.Second
+
+// Number of wordlist names that are resolved at the same time
+const discoveryWorkers = 8
+
+// Names resolved below each domain unless discovery.wordlist is set
+var DefaultWordlist = []string{
+	"admin", "api", "app", "auth", "autodiscover", "beta", "blog", "cdn", "ci", "dev", "docs", "ftp", "git", "imap",
+	"intranet", "login", "m", "mail", "mx", "portal", "remote", "shop", "smtp", "sso", "staging", "static", "status",
+	"support", "test", "vpn", "webmail", "www",
+}
+
+// ErrUnknownSubdomain is returned for a host that wasn't discovered
+var ErrUnknownSubdomain = errors.New("subdomain was not discovered")
+
+// DiscoveryInterval returns the interval between the subdomain discoveries
+func DiscoveryInterval(scheduler configuration.SchedulerConfiguration) time.Duration {
+	if scheduler.DiscoveryInterval <= 0 {
+		return DefaultDiscoveryInterval
+	}
+	return time.Duration(scheduler.DiscoveryInterval) * time.Hour
+}
+
+// DiscoveryResult is the result of discovering the subdomains of a monitored domain
+type DiscoveryResult struct {
+	// The domain
+	Domain configuration.Domain `json:"domain"`
+	// Subdomains found by each source
+	Found map[string]int `json:"found"`
+	// Subdomains that weren't known before
+	New []string `json:"new"`
+	// The domain answers for any name, so the wordlist was skipped
+	Wildcard bool `json:"wildcard,omitempty"`
+	// Promoted subdomains whose certificate was checked
+	TLSChecked int `json:"tlsChecked"`
+}
+
+// DiscoveryService keeps the inventory of the subdomains of the monitored domains, found in the certificate
+// transparency logs, imported zone files and by resolving a wordlist
+type DiscoveryService struct {
+	store        *configuration.SubdomainStorage
+	certificates *configuration.CertificateStorage
+	domains      *configuration.DomainConfiguration
+	config       configuration.DiscoveryConfiguration
+	// Resolves the wordlist
+	resolver Resolver
+	timeout  time.Duration
+	// One discovery at a time, so the inventory is flushed once per run
+	running sync.Mutex
+}
+
+func NewDiscoveryService(store *configuration.SubdomainStorage, certificates *configuration.CertificateStorage, domains *configuration.DomainConfiguration, config configuration.ConfigurationFile) *DiscoveryService {
+	return &DiscoveryService{
+		store:        store,
+		certificates: certificates,
+		domains:      domains,
+		config:       config.Discovery,
+		resolver:     NewResolver(config.DNS),
+		timeout:      DNSTimeout(config.DNS),
+	}
+}
+
+// UseResolver replaces the resolver of the wordlist, e.g. with a fake in tests
+func (s *DiscoveryService) UseResolver(resolver Resolver) {
+	s.resolver = resolver
+}
+
+// Enabled reports if the subdomains are discovered on a schedule
+func (s *DiscoveryService) Enabled() bool {
+	return s.config.Enabled
+}
+
+// Wordlist returns the names resolved below each domain
+func (s *DiscoveryService) Wordlist() []string {
+	if len(s.config.Wordlist) == 0 {
+		return DefaultWordlist
+	}
+	return s.config.Wordlist
+}
+
+// List returns the subdomains of a monitored domain, or of every domain if fqdn is empty
+func (s *DiscoveryService) List(fqdn string) []configuration.Subdomain {
+	return s.store.List(strings.ToLower(strings.TrimSpace(fqdn)))
+}
+
+// Discover finds the subdomains of a monitored domain now and checks the certificates of the promoted ones
+func (s *DiscoveryService) Discover(fqdn string, now time.Time) (DiscoveryResult, error) {
+	domain, err := s.monitored(fqdn)
+	if err != nil {
+		return DiscoveryResult{}, err
+	}
+	s.running.Lock()
+	defer s.running.Unlock()
+
+	result := s.discover(domain, now)
+	s.store.Flush()
+	return result, nil
+}
+
+// DiscoverAll finds the subdomains of every monitored domain. The subdomains of domains that were removed are
+// forgotten, paused and archived domains keep theirs.
+func (s *DiscoveryService) DiscoverAll(now time.Time) []DiscoveryResult {
+	s.running.Lock()
+	defer s.running.Unlock()
+
+	known := map[string]bool{}
+	results := []DiscoveryResult{}
+	for _, domain := range s.domains.List() {
+		known[domain.FQDN] = true
+		if domain.Monitored() {
+			results = append(results, s.discover(domain, now))
+		}
+	}
+	forgotten := map[string]bool{}
+	for _, subdomain := range s.store.List("") {
+		if !known[subdomain.Of] && !forgotten[subdomain.Of] && s.store.Forget(subdomain.Of) {
+			forgotten[subdomain.Of] = true
+			log.Printf("🗑 Forgot the subdomains of %s, it is no longer in the domain list", subdomain.Of)
+		}
+	}
+	s.store.Flush()
+	return results
+}
+
+// Import adds the hosts of a zone file to the inventory of a monitored domain. Returns the hosts that weren't known
+// before.
+func (s *DiscoveryService) Import(fqdn string, zone io.Reader, now time.Time) ([]string, error) {
+	domain, err := s.monitored(fqdn)
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := configuration.ZoneFileHosts(zone, domain.FQDN)
+	if err != nil {
+		return nil, err
+	}
+	s.running.Lock()
+	defer s.running.Unlock()
+
+	added := []string{}
+	for _, host := range hosts {
+		if s.owner(host) == domain.FQDN && s.store.Observe(domain.FQDN, host, configuration.SubdomainSourceZone, nil, now) {
+			added = append(added, host)
+		}
+	}
+	s.store.Flush()
+	log.Printf("🗂 Imported %d hosts of %s from a zone file (%d new)", len(hosts), domain.FQDN, len(added))
+	return added, nil
+}
+
+// Promote turns the TLS check of a discovered subdomain on, and checks it right away, or off
+func (s *DiscoveryService) Promote(fqdn string, enabled bool, now time.Time) (configuration.Subdomain, error) {
+	fqdn = strings.ToLower(strings.TrimSpace(fqdn))
+	if !s.store.SetTLS(fqdn, enabled) {
+		return configuration.Subdomain{}, ErrUnknownSubdomain
+	}
+	if enabled {
+		s.store.RecordTLS(fqdn, CheckTLS(fqdn, now))
+	}
+	s.store.Flush()
+	subdomain, _ := s.store.Get(fqdn)
+	return subdomain, nil
+}
+
+// monitored returns the monitored domain with the name
+func (s *DiscoveryService) monitored(fqdn string) (configuration.Domain, error) {
+	fqdn = strings.ToLower(strings.TrimSpace(fqdn))
+	for _, domain := range s.domains.List() {
+		if domain.FQDN == fqdn && domain.Monitored() {
+			return domain, nil
+		}
+	}
+	return configuration.Domain{}, ErrDomainNotMonitored
+}
+
+// owner returns the domain a host belongs to, the longest domain in the list it is below. A host below
+// shop.example.com belongs to shop.example.com if both it and example.com are in the list.
+func (s *DiscoveryService) owner(host string) string {
+	owner := ""
+	for _, domain := range s.domains.List() {
+		if strings.HasSuffix(host, "."+domain.FQDN) && len(domain.FQDN) > len(owner) {
+			owner = domain.FQDN
+		}
+	}
+	return owner
+}
+
+// discover collects the subdomains from the certificates and the wordlist, then checks the promoted ones
+func (s *DiscoveryService) discover(domain configuration.Domain, now time.Time) DiscoveryResult {
+	result := DiscoveryResult{Domain: domain, Found: map[string]int{}, New: []string{}}
+	observe := func(host string, source string, addresses []string, at time.Time) {
+		result.Found[source]++
+		if s.store.Observe(domain.FQDN, host, source, addresses, at) {
+			result.New = append(result.New, host)
+		}
+	}
+
+	// Every name on a certificate was in use when the certificate was logged, the first and last certificate naming a
+	// host are its first and last sighting
+	first, last := map[string]time.Time{}, map[string]time.Time{}
+	for _, certificate := range s.certificates.List(domain.FQDN) {
+		at := certificate.LoggedAt
+		if at.IsZero() {
+			at = certificate.FirstSeen
+		}
+		for _, name := range certificate.Names {
+			if strings.HasPrefix(name, "*.") || s.owner(name) != domain.FQDN {
+				continue
+			}
+			if seen, ok := first[name]; !ok || at.Before(seen) {
+				first[name] = at
+			}
+			if at.After(last[name]) {
+				last[name] = at
+			}
+		}
+	}
+	for _, host := range sortedKeys(first) {
+		observe(host, configuration.SubdomainSourceCT, nil, first[host])
+		s.store.Observe(domain.FQDN, host, configuration.SubdomainSourceCT, nil, last[host])
+	}
+
+	// A domain with a wildcard record resolves every name, so the wordlist tells nothing
+	if s.wildcard(domain.FQDN) {
+		result.Wildcard = true
+		log.Printf("⚠️ %s resolves any name, the wordlist is skipped", domain.FQDN)
+	} else {
+		for host, addresses := range s.resolveWordlist(domain.FQDN) {
+			observe(host, configuration.SubdomainSourceWordlist, addresses, now)
+		}
+	}
+	sort.Strings(result.New)
+
+	for _, subdomain := range s.store.List(domain.FQDN) {
+		if subdomain.TLS {
+			s.store.RecordTLS(subdomain.FQDN, CheckTLS(subdomain.FQDN, now))
+			result.TLSChecked++
+		}
+	}
+	log.Printf("🗂 Discovered %d subdomains of %s (%d new)", len(s.store.List(domain.FQDN)), domain.FQDN, len(result.New))
+	return result
+}
+
+// wildcard reports if a random name below the domain resolves
+func (s *DiscoveryService) wildcard(fqdn string) bool {
+	label := make([]byte, 8)
+	if _, err := rand.Read(label); err != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	addresses, err := s.resolver.LookupHost(ctx, "dm-"+hex.EncodeToString(label)+"."+fqdn)
+	return err == nil && len(addresses) > 0
+}
+
+// resolveWordlist resolves each name of the wordlist below the domain, returning the ones that resolve with their
+// addresses
+func (s *DiscoveryService) resolveWordlist(fqdn string) map[string][]string {
+	resolved := map[string][]string{}
+	var mu sync.Mutex
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < discoveryWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for host := range work {
+				ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+				addresses, err := s.resolver.LookupHost(ctx, host)
+				cancel()
+				if err != nil {
+					if !IsNotFound(err) {
+						log.Printf("⚠️ Unable to resolve %s: %s", host, err)
+					}
+					continue
+				}
+				sort.Strings(addresses)
+				mu.Lock()
+				resolved[host] = addresses
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, word := range s.Wordlist() {
+		word = strings.Trim(strings.ToLower(strings.TrimSpace(word)), ".")
+		if word != "" {
+			work <- word + "." + fqdn
+		}
+	}
+	close(work)
+	wg.Wait()
+	return resolved
+}
+
+// CheckTLS connects to a host on port 443 and verifies the certificate it presents. The certificate is recorded even
+// if it isn't valid for the host, with the reason in the error.
+func CheckTLS(host string, now time.Time) configuration.TLSCheck {
+	check := configuration.TLSCheck{CheckedAt: now}
+	dialer := &net.Dialer{Timeout: TLSCheckTimeout}
+	// The chain is verified below, so an invalid certificate can still be recorded
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, "443"), &tls.Config{ServerName: host, InsecureSkipVerify: true})
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	defer conn.Close()
+
+	chain := conn.ConnectionState().PeerCertificates
+	if len(chain) == 0 {
+		check.Error = "no certificate presented"
+		return check
+	}
+	leaf := chain[0]
+	check.Issuer, check.Names, check.NotAfter = leaf.Issuer.String(), leaf.DNSNames, leaf.NotAfter
+	intermediates := x509.NewCertPool()
+	for _, certificate := range chain[1:] {
+		intermediates.AddCert(certificate)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: host, Intermediates: intermediates, CurrentTime: now}); err != nil {
+		check.Error = err.Error()
+	}
+	return check
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+// Timeout of a single DNS query, unless dns.timeout is set
+const DefaultDNSTimeout = 5 * time.Second
+
+// Resolver answers the DNS queries of the DNS checks. *net.Resolver implements it, tests can use a fake.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupNS(ctx context.Context, name string) ([]*net.NS, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// DNSTimeout returns the timeout of a single DNS query
+func DNSTimeout(config configuration.DNSConfiguration) time.Duration {
+	if config.Timeout <= 0 {
+		return DefaultDNSTimeout
+	}
+	return time.Duration(config.Timeout) * time.Second
+}
+
+// NewResolver returns a resolver that sends its queries to dns.resolver, or the system resolver if none is configured
+func NewResolver(config configuration.DNSConfiguration) Resolver {
+	if config.Resolver == "" {
+		return net.DefaultResolver
+	}
+	server := withDNSPort(config.Resolver)
+	dialer := net.Dialer{Timeout: DNSTimeout(config)}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// IsNotFound reports if a lookup failed because the name doesn't exist or has no records of the type
+func IsNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// withDNSPort adds the DNS port to a server that has none
+func withDNSPort(server string) string {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		return net.JoinHostPort(server, "53")
+	}
+	return server
+}
+
+// Exchanger sends DNS queries to a given server, for the checks that need more of the answer than a Resolver returns:
+// the DNSSEC records, or the flags of the answer of an authoritative server. Tests can use a fake.
+type Exchanger interface {
+	Exchange(ctx context.Context, query *dns.Msg, server string) (*dns.Msg, error)
+}
+
+// dnsExchanger sends the queries over UDP, and over TCP when the answer doesn't fit
+type dnsExchanger struct {
+	udp *dns.Client
+	tcp *dns.Client
+}
+
+// NewExchanger returns an Exchanger with the timeout of dns.timeout
+func NewExchanger(config configuration.DNSConfiguration) Exchanger {
+	timeout := DNSTimeout(config)
+	return &dnsExchanger{
+		udp: &dns.Client{Net: "udp", Timeout: timeout},
+		tcp: &dns.Client{Net: "tcp", Timeout: timeout},
+	}
+}
+
+// Exchange sends a query over UDP, and again over TCP if the answer was truncated
+func (e *dnsExchanger) Exchange(ctx context.Context, query *dns.Msg, server string) (*dns.Msg, error) {
+	answer, _, err := e.udp.ExchangeContext(ctx, query, server)
+	if err == nil && answer.Truncated {
+		answer, _, err = e.tcp.ExchangeContext(ctx, query, server)
+	}
+	return answer, err
+}
+
+// RecursiveServer returns the address of the resolver the queries of an Exchanger go to: dns.resolver, or the first
+// nameserver of /etc/resolv.conf if none is configured
+func RecursiveServer(config configuration.DNSConfiguration) (string, error) {
+	if config.Resolver != "" {
+		return withDNSPort(config.Resolver), nil
+	}
+	resolv, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return "", fmt.Errorf("no resolver configured (dns.resolver) and the system resolver is unknown: %w", err)
+	}
+	if len(resolv.Servers) == 0 {
+		return "", errors.New("no resolver configured (dns.resolver) and /etc/resolv.conf has no nameserver")
+	}
+	return net.JoinHostPort(resolv.Servers[0], resolv.Port), nil
+}
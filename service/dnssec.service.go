@@ -0,0 +1,538 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+// Template key of the DNSSEC alerts
+const TemplateKeyDNSSEC = "dnssec"
+
+// Interval between the DNSSEC checks, unless scheduler.dnssecInterval is set
+const DefaultDNSSECInterval = 12 * time.Hour
+
+// Days before a signature expires that it is alerted, unless dnssec.expiryWarningDays is set
+const DefaultDNSSECExpiryWarningDays = 3
+
+// DNSSECInterval returns the interval between the DNSSEC checks
+func DNSSECInterval(scheduler configuration.SchedulerConfiguration) time.Duration {
+	if scheduler.DNSSECInterval <= 0 {
+		return DefaultDNSSECInterval
+	}
+	return time.Duration(scheduler.DNSSECInterval) * time.Hour
+}
+
+// DNSSECExpiryWarning returns how long before a signature expires that it is alerted
+func DNSSECExpiryWarning(config configuration.DNSSECConfiguration) time.Duration {
+	days := config.ExpiryWarningDays
+	if days <= 0 {
+		days = DefaultDNSSECExpiryWarningDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// DNSSECResult is the result of checking the DNSSEC chain of a monitored domain
+type DNSSECResult struct {
+	// The checked domain
+	Domain configuration.Domain `json:"domain"`
+	// The new report, with the results of the last complete check if this one couldn't be completed
+	Report configuration.DNSSECReport `json:"report"`
+	// The report of the last complete check before this one, nil if there was none
+	Previous *configuration.DNSSECReport `json:"previous,omitempty"`
+	// What went wrong since the previous report, these are alerted
+	Problems []string `json:"problems"`
+}
+
+// DNSSECService checks the DS records of the parent zones, the keys and the signatures of the monitored domains, and
+// compares them with the delegation the registries report over RDAP
+type DNSSECService struct {
+	store   *configuration.DNSSECStorage
+	domains *configuration.DomainConfiguration
+	config  configuration.DNSSECConfiguration
+	// Sends the queries to the recursive resolver
+	exchanger Exchanger
+	server    string
+	// Why the recursive resolver is unknown, nil if it is known
+	serverErr error
+	timeout   time.Duration
+	// Looks up the delegation the registry reports
+	registry func(fqdn string) (*configuration.RDAPSecureDNS, error)
+	// One check at a time, so a problem isn't reported twice
+	checking sync.Mutex
+}
+
+func NewDNSSECService(store *configuration.DNSSECStorage, domains *configuration.DomainConfiguration, config configuration.ConfigurationFile) *DNSSECService {
+	server, err := RecursiveServer(config.DNS)
+	return &DNSSECService{
+		store:     store,
+		domains:   domains,
+		config:    config.DNSSEC,
+		exchanger: NewExchanger(config.DNS),
+		server:    server,
+		serverErr: err,
+		timeout:   DNSTimeout(config.DNS),
+		registry:  configuration.QuerySecureDNS,
+	}
+}
+
+// UseExchanger replaces the exchanger of the queries and the resolver they are sent to, e.g. with a fake in tests
+func (s *DNSSECService) UseExchanger(exchanger Exchanger, server string) {
+	s.exchanger, s.server, s.serverErr = exchanger, server, nil
+}
+
+// UseRegistry replaces the lookup of the delegation the registry reports, e.g. with a fake in tests. With nil the
+// registry isn't asked.
+func (s *DNSSECService) UseRegistry(registry func(fqdn string) (*configuration.RDAPSecureDNS, error)) {
+	s.registry = registry
+}
+
+// Enabled reports if the DNSSEC chains are checked on a schedule
+func (s *DNSSECService) Enabled() bool {
+	return s.config.Enabled
+}
+
+// List returns the report of every checked domain
+func (s *DNSSECService) List() []configuration.DNSSECReport {
+	return s.store.List()
+}
+
+// Get returns the report of a domain, false if it wasn't checked yet
+func (s *DNSSECService) Get(fqdn string) (configuration.DNSSECReport, bool) {
+	return s.store.Get(strings.ToLower(strings.TrimSpace(fqdn)))
+}
+
+// Check checks the DNSSEC chain of a monitored domain now
+func (s *DNSSECService) Check(fqdn string, now time.Time) (DNSSECResult, error) {
+	fqdn = strings.ToLower(strings.TrimSpace(fqdn))
+	for _, domain := range s.domains.List() {
+		if domain.FQDN == fqdn && domain.Monitored() {
+			s.checking.Lock()
+			defer s.checking.Unlock()
+
+			result := s.check(domain, now)
+			s.store.Flush()
+			return result, nil
+		}
+	}
+	return DNSSECResult{}, ErrDomainNotMonitored
+}
+
+// CheckAll checks the DNSSEC chain of every monitored domain. The reports of domains that were removed are
+// forgotten, paused and archived domains keep theirs.
+func (s *DNSSECService) CheckAll(now time.Time) []DNSSECResult {
+	s.checking.Lock()
+	defer s.checking.Unlock()
+
+	known := map[string]bool{}
+	results := []DNSSECResult{}
+	for _, domain := range s.domains.List() {
+		known[domain.FQDN] = true
+		if domain.Monitored() {
+			results = append(results, s.check(domain, now))
+		}
+	}
+	for _, report := range s.store.List() {
+		if !known[report.FQDN] && s.store.Forget(report.FQDN) {
+			log.Printf("🗑 Forgot the DNSSEC report of %s, it is no longer in the domain list", report.FQDN)
+		}
+	}
+	s.store.Flush()
+	return results
+}
+
+// check queries the chain of a domain and compares it with the last complete check
+func (s *DNSSECService) check(domain configuration.Domain, now time.Time) DNSSECResult {
+	report := configuration.DNSSECReport{FQDN: domain.FQDN, CheckedAt: now}
+	if err := s.inspect(domain.FQDN, &report, now); err != nil {
+		report.Error = err.Error()
+		s.store.Record(report)
+		log.Printf("❌ Failed to check the DNSSEC chain of %s: %s", domain.FQDN, err)
+		stored, _ := s.store.Get(domain.FQDN)
+		return DNSSECResult{Domain: domain, Report: stored, Problems: []string{}}
+	}
+
+	report.Succeeded = now
+	result := DNSSECResult{Domain: domain, Report: report}
+	if previous, ok := s.store.Record(report); ok {
+		result.Previous = &previous
+	}
+	result.Problems = DNSSECProblems(result.Previous, report, DNSSECExpiryWarning(s.config), now)
+	log.Printf("🔐 DNSSEC of %s is %s (%d findings, %d problems)", domain.FQDN, report.Status, len(report.Findings), len(result.Problems))
+	return result
+}
+
+// inspect queries the DS records, the keys and the signed SOA record of a domain into the report and asks the
+// registry for its delegation. Returns an error if a query failed, the chain is unknown then. The registry not
+// answering only leaves it out of the report.
+func (s *DNSSECService) inspect(fqdn string, report *configuration.DNSSECReport, now time.Time) error {
+	if s.serverErr != nil {
+		return s.serverErr
+	}
+	ds, err := s.query(fqdn, dns.TypeDS)
+	if err != nil {
+		return err
+	}
+	keys, err := s.query(fqdn, dns.TypeDNSKEY)
+	if err != nil {
+		return err
+	}
+	soa, err := s.query(fqdn, dns.TypeSOA)
+	if err != nil {
+		return err
+	}
+
+	if s.registry != nil {
+		secureDNS, err := s.registry(fqdn)
+		if err != nil {
+			report.RegistryError = err.Error()
+		}
+		report.Registry = secureDNS
+	}
+
+	EvaluateDNSSEC(report, append(append(ds, keys...), soa...), now)
+	return nil
+}
+
+// query asks the recursive resolver for the records of a type and their signatures. The answer isn't validated
+// (the checking disabled flag is set), so a broken chain can still be inspected.
+func (s *DNSSECService) query(fqdn string, qtype uint16) ([]dns.RR, error) {
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(fqdn), qtype)
+	query.SetEdns0(4096, true)
+	query.CheckingDisabled = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	answer, err := s.exchanger.Exchange(ctx, query, s.server)
+	if err != nil {
+		return nil, fmt.Errorf("%s query for %s failed: %w", dns.TypeToString[qtype], fqdn, err)
+	}
+	switch answer.Rcode {
+	case dns.RcodeSuccess:
+	case dns.RcodeNameError:
+		return nil, fmt.Errorf("%s doesn't exist", fqdn)
+	default:
+		return nil, fmt.Errorf("%s query for %s failed: %s", dns.TypeToString[qtype], fqdn, dns.RcodeToString[answer.Rcode])
+	}
+
+	records := []dns.RR{}
+	for _, record := range answer.Answer {
+		if strings.EqualFold(record.Header().Name, dns.Fqdn(fqdn)) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// sigTime converts a signature time, which wraps every 136 years (serial number arithmetic), to the time closest to
+// now
+func sigTime(value uint32, now time.Time) time.Time {
+	delta := int64(int32(value - uint32(now.Unix())))
+	return time.Unix(now.Unix()+delta, 0).UTC()
+}
+
+// EvaluateDNSSEC fills the report with the chain found in the records of a zone: the DS records of the parent zone
+// and which keys they match, the keys and the signatures over the keys and the SOA record. Sets the status and lists
+// the findings, including the differences with the delegation the registry reports.
+func EvaluateDNSSEC(report *configuration.DNSSECReport, records []dns.RR, now time.Time) {
+	var ds []*dns.DS
+	var keys []*dns.DNSKEY
+	var sigs []*dns.RRSIG
+	signed := map[uint16][]dns.RR{}
+	for _, record := range records {
+		switch record := record.(type) {
+		case *dns.DS:
+			ds = append(ds, record)
+		case *dns.DNSKEY:
+			keys = append(keys, record)
+			signed[dns.TypeDNSKEY] = append(signed[dns.TypeDNSKEY], record)
+		case *dns.SOA:
+			signed[dns.TypeSOA] = append(signed[dns.TypeSOA], record)
+		case *dns.RRSIG:
+			sigs = append(sigs, record)
+		}
+	}
+
+	report.DS, report.Keys, report.Signatures = []configuration.DSRecord{}, []configuration.DNSKEYRecord{}, []configuration.RRSIGRecord{}
+	report.Expires = time.Time{}
+	findings := []string{}
+
+	// Keys that a DS record of the parent zone matches, the entry points of the chain
+	entryPoints := map[uint16]bool{}
+	for _, record := range ds {
+		entry := configuration.DSRecord{KeyTag: int(record.KeyTag), Algorithm: int(record.Algorithm), DigestType: int(record.DigestType), Digest: strings.ToUpper(record.Digest)}
+		for _, key := range keys {
+			if key.KeyTag() != record.KeyTag || key.Algorithm != record.Algorithm {
+				continue
+			}
+			if digest := key.ToDS(record.DigestType); digest != nil && strings.EqualFold(digest.Digest, record.Digest) {
+				entry.Matched = true
+				entryPoints[record.KeyTag] = true
+			}
+		}
+		if !entry.Matched {
+			findings = append(findings, fmt.Sprintf("DS record %d of the parent zone doesn't match a key of the zone", record.KeyTag))
+		}
+		report.DS = append(report.DS, entry)
+	}
+	for _, key := range keys {
+		report.Keys = append(report.Keys, configuration.DNSKEYRecord{KeyTag: int(key.KeyTag()), Flags: int(key.Flags), Algorithm: int(key.Algorithm)})
+	}
+
+	// Verify the signatures over the keys and the SOA record, the keys are valid once an entry point signed them
+	keysSigned, soaSigned := false, false
+	for _, sig := range sigs {
+		rrset, ok := signed[sig.TypeCovered]
+		if !ok {
+			continue
+		}
+		entry := configuration.RRSIGRecord{
+			Covered:    dns.TypeToString[sig.TypeCovered],
+			KeyTag:     int(sig.KeyTag),
+			Algorithm:  int(sig.Algorithm),
+			Inception:  sigTime(sig.Inception, now),
+			Expiration: sigTime(sig.Expiration, now),
+		}
+		var signer *dns.DNSKEY
+		for _, key := range keys {
+			if key.KeyTag() == sig.KeyTag && key.Algorithm == sig.Algorithm {
+				signer = key
+			}
+		}
+		switch {
+		case signer == nil:
+			entry.Error = fmt.Sprintf("signed with key %d, which the zone doesn't publish", sig.KeyTag)
+		case !sig.ValidityPeriod(now) && now.Before(entry.Inception):
+			entry.Error = "not valid before " + entry.Inception.Format("2006-01-02 15:04 MST")
+		case !sig.ValidityPeriod(now):
+			entry.Error = "expired on " + entry.Expiration.Format("2006-01-02 15:04 MST")
+		default:
+			if err := sig.Verify(signer, rrset); err != nil {
+				entry.Error = err.Error()
+			}
+		}
+		if entry.Valid() {
+			keysSigned = keysSigned || (sig.TypeCovered == dns.TypeDNSKEY && entryPoints[sig.KeyTag])
+			soaSigned = soaSigned || sig.TypeCovered == dns.TypeSOA
+		} else {
+			findings = append(findings, fmt.Sprintf("The %s signature of key %d is invalid: %s", entry.Covered, sig.KeyTag, entry.Error))
+		}
+		if report.Expires.IsZero() || entry.Expiration.Before(report.Expires) {
+			report.Expires = entry.Expiration
+		}
+		report.Signatures = append(report.Signatures, entry)
+	}
+
+	switch {
+	case len(ds) == 0 && len(keys) == 0:
+		report.Status = configuration.DNSSECUnsigned
+	case len(ds) == 0:
+		report.Status = configuration.DNSSECUndelegated
+		findings = append([]string{"The zone is signed but the parent zone has no DS record, resolvers don't validate it"}, findings...)
+	case len(keys) == 0:
+		report.Status = configuration.DNSSECBroken
+		findings = append([]string{"The parent zone has DS records but the zone publishes no keys"}, findings...)
+	case len(entryPoints) == 0:
+		report.Status = configuration.DNSSECBroken
+		findings = append([]string{"No DS record of the parent zone matches a key of the zone"}, findings...)
+	case !keysSigned:
+		report.Status = configuration.DNSSECBroken
+		findings = append([]string{"The keys of the zone aren't signed by a key the parent zone has a DS record for"}, findings...)
+	case !soaSigned:
+		report.Status = configuration.DNSSECBroken
+		findings = append([]string{"The SOA record of the zone has no valid signature"}, findings...)
+	default:
+		report.Status = configuration.DNSSECSecure
+	}
+
+	report.Findings = append(findings, registryFindings(report)...)
+}
+
+// registryFindings compares the delegation the registry reports with the DS records and keys found in DNS
+func registryFindings(report *configuration.DNSSECReport) []string {
+	registry := report.Registry
+	if registry == nil {
+		return []string{}
+	}
+	findings := []string{}
+	switch {
+	case registry.DelegationSigned && len(report.DS) == 0:
+		findings = append(findings, "The registry reports a signed delegation but the parent zone has no DS record")
+	case !registry.DelegationSigned && len(report.DS) > 0:
+		findings = append(findings, "The registry reports an unsigned delegation but the parent zone has DS records")
+	}
+
+	if len(registry.DSData) > 0 {
+		for _, data := range registry.DSData {
+			found := false
+			for _, record := range report.DS {
+				found = found || (record.KeyTag == data.KeyTag && record.DigestType == data.DigestType && strings.EqualFold(record.Digest, data.Digest))
+			}
+			if !found {
+				findings = append(findings, fmt.Sprintf("DS record %d of the registry isn't published in the parent zone", data.KeyTag))
+			}
+		}
+		for _, record := range report.DS {
+			found := false
+			for _, data := range registry.DSData {
+				found = found || (record.KeyTag == data.KeyTag && record.DigestType == data.DigestType && strings.EqualFold(record.Digest, data.Digest))
+			}
+			if !found {
+				findings = append(findings, fmt.Sprintf("DS record %d of the parent zone isn't known to the registry", record.KeyTag))
+			}
+		}
+	}
+
+	// Registries that take keys instead of DS records report the keys
+	for _, data := range registry.KeyData {
+		key := dns.DNSKEY{Flags: uint16(data.Flags), Protocol: uint8(data.Protocol), Algorithm: uint8(data.Algorithm), PublicKey: data.PublicKey}
+		tag := int(key.KeyTag())
+		found := false
+		for _, published := range report.Keys {
+			found = found || (published.KeyTag == tag && published.Algorithm == data.Algorithm)
+		}
+		if !found {
+			findings = append(findings, fmt.Sprintf("Key %d of the registry isn't published in the zone", tag))
+		}
+	}
+	return findings
+}
+
+// DNSSECProblems lists what is alerted after a check: the chain breaking, DNSSEC being turned off and signatures
+// about to expire. Each is reported by the first check that finds it, previous is the last complete report before
+// the current one (nil if there was none).
+func DNSSECProblems(previous *configuration.DNSSECReport, current configuration.DNSSECReport, warning time.Duration, now time.Time) []string {
+	problems := []string{}
+	if current.Status == configuration.DNSSECBroken && (previous == nil || previous.Status != configuration.DNSSECBroken) {
+		reason := "DNSSEC validation fails"
+		if len(current.Findings) > 0 {
+			reason = current.Findings[0]
+		}
+		problems = append(problems, "The DNSSEC chain is broken: "+reason)
+	}
+	if previous != nil && previous.Status == configuration.DNSSECSecure && (current.Status == configuration.DNSSECUnsigned || current.Status == configuration.DNSSECUndelegated) {
+		problems = append(problems, "DNSSEC was turned off, the parent zone no longer has a DS record")
+	}
+	if current.Status == configuration.DNSSECSecure && current.ExpiresWithin(warning, now) {
+		alerted := previous != nil && previous.Status == configuration.DNSSECSecure && previous.Expires.Equal(current.Expires) &&
+			previous.ExpiresWithin(warning, previous.Succeeded)
+		if !alerted {
+			problems = append(problems, "A signature of the zone expires on "+current.Expires.Format("2006-01-02 15:04 MST")+" and wasn't renewed yet")
+		}
+	}
+	return problems
+}
+
+// DNSSECTemplateData is the data available to the DNSSEC alert templates
+type DNSSECTemplateData struct {
+	// Application name, for signatures
+	AppName string
+	// Human readable alert, e.g. "DNSSEC of example.com needs attention"
+	Alert string
+	// Always "dnssec"
+	AlertKey string
+	// The monitored domain
+	Domain configuration.Domain
+	FQDN   string
+	Name   string
+	// What went wrong since the last check
+	Problems []string
+	Count    int
+	// The status now and after the last check
+	Status         string
+	PreviousStatus string
+	// When the first signature expires, zero if the zone isn't signed
+	Expires time.Time
+	// Everything found wrong with the chain now
+	Findings []string
+	// Link to the dashboard, empty if no base URL is configured
+	DashboardURL string
+	// When the message was rendered
+	Now time.Time
+}
+
+// NewDNSSECTemplateData builds the template data for the problems of a domain
+func NewDNSSECTemplateData(result DNSSECResult, baseURL string, now time.Time) DNSSECTemplateData {
+	data := DNSSECTemplateData{
+		AppName:  "Domain Monitor",
+		AlertKey: TemplateKeyDNSSEC,
+		Domain:   result.Domain,
+		FQDN:     result.Domain.FQDN,
+		Name:     result.Domain.Name,
+		Problems: result.Problems,
+		Count:    len(result.Problems),
+		Status:   result.Report.Status,
+		Expires:  result.Report.Expires,
+		Findings: result.Report.Findings,
+		Now:      now,
+	}
+	if data.Name == "" {
+		data.Name = data.FQDN
+	}
+	data.PreviousStatus = data.Status
+	if result.Previous != nil {
+		data.PreviousStatus = result.Previous.Status
+	}
+	data.Alert = "DNSSEC of " + data.FQDN + " needs attention"
+	if data.Status == configuration.DNSSECBroken {
+		data.Alert = "DNSSEC of " + data.FQDN + " is broken"
+	}
+	if baseURL != "" {
+		data.DashboardURL = strings.TrimRight(baseURL, "/") + "/"
+	}
+	return data
+}
+
+// NotifyDNSSEC queues the alert for the problems of a domain, to the recipients of the domain. Returns who it was
+// queued for.
+func NotifyDNSSEC(notifications *NotificationService, config configuration.ConfigurationFile, result DNSSECResult, now time.Time) ([]string, error) {
+	// The chain isn't about the expiration of the registration, so the escalation recipients are left out
+	recipients := ResolveRecipients(config, result.Domain, math.Inf(1))
+	if recipients.Empty() {
+		log.Printf("⚠️ No recipients for the DNSSEC alert of %s, configure alerts.admin or domain owners", result.Domain.FQDN)
+		return nil, nil
+	}
+
+	data := NewDNSSECTemplateData(result, config.App.BaseURL, now)
+	rendered, err := notifications.Render(TemplateKeyDNSSEC, data)
+	if err != nil {
+		log.Printf("❌ Failed to render the DNSSEC alert for %s: %s", result.Domain.FQDN, err)
+		return nil, err
+	}
+
+	// Each problem is found by a single check, so it is alerted once
+	keyParts := append([]string{TemplateKeyDNSSEC, result.Domain.FQDN}, result.Problems...)
+	keyParts = append(keyParts, result.Report.Succeeded.Format(time.RFC3339))
+	item := configuration.QueuedNotification{FQDN: result.Domain.FQDN, Alert: TemplateKeyDNSSEC}
+	return enqueue(notifications, recipients, rendered, data, item, keyParts)
+}
+
+// NotifyDNSSECs queues the alerts of the domains with DNSSEC problems that have alerts turned on. Nothing is queued
+// without a mailer or notifier webhooks. Returns the number of queued alerts.
+func NotifyDNSSECs(notifications *NotificationService, config configuration.ConfigurationFile, results []DNSSECResult, now time.Time) int {
+	if notifications == nil || !notifications.Enabled() {
+		return 0
+	}
+	queued := 0
+	for _, result := range results {
+		if len(result.Problems) == 0 || !result.Domain.Alerts {
+			continue
+		}
+		received, err := NotifyDNSSEC(notifications, config, result, now)
+		if err != nil {
+			log.Printf("❌ Failed to queue the DNSSEC alert for %s: %s", result.Domain.FQDN, err)
+		}
+		if len(received) > 0 {
+			queued++
+		}
+	}
+	return queued
+}
@@ -3,22 +3,27 @@ package service
 import (
 	"errors"
 	"log"
+	"time"
 
 	"github.com/nwesterhausen/domain-monitor/configuration"
 )
 
 type ServicesDomain struct {
-	store configuration.DomainConfiguration
+	store *configuration.DomainConfiguration
 }
 
-func NewDomainService(store configuration.DomainConfiguration) *ServicesDomain {
+func NewDomainService(store *configuration.DomainConfiguration) *ServicesDomain {
 	return &ServicesDomain{store: store}
 }
 
 func (s *ServicesDomain) CreateDomain(domain configuration.Domain) (int, error) {
+	domain.Normalize()
+	if err := domain.Validate(); err != nil {
+		return -1, err
+	}
 	s.store.AddDomain(domain)
 	// Return the index of the domain in the list
-	for i, d := range s.store.DomainFile.Domains {
+	for i, d := range s.store.List() {
 		if d.FQDN == domain.FQDN {
 			return i, nil
 		}
@@ -28,28 +33,29 @@ func (s *ServicesDomain) CreateDomain(domain configuration.Domain) (int, error)
 }
 
 func (s *ServicesDomain) GetDomain(fqdn string) (configuration.Domain, error) {
-	for _, d := range s.store.DomainFile.Domains {
-		if d.FQDN == fqdn {
-			return d, nil
-		}
+	if d, ok := s.store.Get(fqdn); ok {
+		return d, nil
 	}
 	return configuration.Domain{}, errors.New("domain not found")
 }
 
 func (s *ServicesDomain) GetDomains() ([]configuration.Domain, error) {
-	return s.store.DomainFile.Domains, nil
+	return s.store.List(), nil
 }
 
 func (s *ServicesDomain) UpdateDomain(domain configuration.Domain) error {
 	// Log the received domain configuration
 	log.Printf("🛰️ Received domain update: %+v\n", domain)
 
+	domain.Normalize()
+	if err := domain.Validate(); err != nil {
+		return err
+	}
+
 	s.store.UpdateDomain(domain)
 	// Return nil to indicate success (we can confirm the domain was updated by checking the list)
-	for _, d := range s.store.DomainFile.Domains {
-		if d.FQDN == domain.FQDN {
-			return nil
-		}
+	if _, ok := s.store.Get(domain.FQDN); ok {
+		return nil
 	}
 	// This should never happen.. but just in case return an error
 	return errors.New("failed to update domain")
@@ -57,20 +63,21 @@ func (s *ServicesDomain) UpdateDomain(domain configuration.Domain) error {
 
 func (s *ServicesDomain) DeleteDomain(fqdn string) error {
 	// Get the domain to pass to RemoveDomain
-	for _, d := range s.store.DomainFile.Domains {
-		if d.FQDN == fqdn {
-			s.store.RemoveDomain(d)
-		}
+	if d, ok := s.store.Get(fqdn); ok {
+		s.store.RemoveDomain(d)
 	}
 	// Return nil to indicate success (we can confirm the domain was deleted by checking the list)
-	for _, d := range s.store.DomainFile.Domains {
-		if d.FQDN == fqdn {
-			return errors.New("failed to delete domain")
-		}
+	if _, ok := s.store.Get(fqdn); ok {
+		return errors.New("failed to delete domain")
 	}
 	return nil
 }
 
+// TransitionDomain moves a domain to another lifecycle state: pause, resume, archive or restore
+func (s *ServicesDomain) TransitionDomain(fqdn string, transition string) (configuration.Domain, error) {
+	return s.store.Transition(fqdn, transition, time.Now())
+}
+
 func (s *ServicesDomain) Flush() {
 	s.store.Flush()
 }
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+// ExpiryStatus is the result of evaluating a single domain against the alert thresholds
+type ExpiryStatus struct {
+	// The evaluated domain
+	Domain configuration.Domain
+	// The cached WHOIS entry, nil if the domain has none yet
+	Entry *configuration.WhoisCache
+	// Expiration date from the WHOIS entry, nil if unknown
+	Expiration *time.Time
+	// Calendar days left until the expiration date, in the timezone of the evaluation (0 on the day it expires,
+	// negative once expired)
+	DaysLeft float64
+	// Alerts that are due for this domain right now, in the order they should be sent
+	Due []configuration.Alert
+	// Due alerts that are silenced by an acknowledgement or snooze
+	Snoozed []configuration.Alert
+	// Due alerts that are held back by the send window or the quiet hours
+	Held []configuration.Alert
+	// Why the domain could not be evaluated, empty when it was
+	Problem string
+}
+
+// Threshold in days for each of the one-time expiry alerts
+var alertThresholds = []struct {
+	alert configuration.Alert
+	days  float64
+}{
+	{configuration.Alert2Months, 60},
+	{configuration.Alert1Month, 30},
+	{configuration.Alert2Weeks, 14},
+	{configuration.Alert1Week, 7},
+	{configuration.Alert3Days, 3},
+}
+
+// DaysUntilExpiration returns the calendar days left until the expiration date of a WHOIS entry, counted in the
+// timezone of now, and false if the entry has no expiration date
+func DaysUntilExpiration(entry *configuration.WhoisCache, now time.Time) (float64, bool) {
+	if entry == nil || entry.WhoisInfo.Domain == nil || entry.WhoisInfo.Domain.ExpirationDateInTime == nil {
+		return 0, false
+	}
+	return float64(calendarDays(now, entry.WhoisInfo.Domain.ExpirationDateInTime.In(now.Location()))), true
+}
+
+// calendarDays returns the number of day boundaries between two times, using their own (wall clock) dates
+func calendarDays(from time.Time, to time.Time) int {
+	a := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	b := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(b.Sub(a).Hours() / 24)
+}
+
+// EvaluateExpiration checks a domain against the alert thresholds, using its WHOIS cache entry.
+//
+// Alerts are only due for domains with alerts enabled, and only if they're enabled in the alerts configuration and
+// haven't been sent yet. The daily alert is due once per day within the last week before expiration. The registry
+// status alerts are due once after the domain enters a hold, the redemption period or pending delete. Days are counted
+// in the timezone of now, pass it in the scheduler timezone.
+func EvaluateExpiration(domain configuration.Domain, entry *configuration.WhoisCache, alerts configuration.AlertsConfiguration, now time.Time) ExpiryStatus {
+	status := ExpiryStatus{Domain: domain, Entry: entry}
+	if entry == nil {
+		status.Problem = "WHOIS entry not found"
+		return status
+	}
+	daysLeft, ok := DaysUntilExpiration(entry, now)
+	if !ok {
+		status.Problem = "no expiration date in WHOIS entry"
+		return status
+	}
+	status.Expiration = entry.WhoisInfo.Domain.ExpirationDateInTime
+	status.DaysLeft = daysLeft
+
+	if !domain.Alerts {
+		return status
+	}
+
+	for _, threshold := range alertThresholds {
+		if daysLeft <= threshold.days && alertEnabled(alerts, threshold.alert) && !entry.AlertSent(threshold.alert) {
+			status.Due = append(status.Due, threshold.alert)
+		}
+	}
+	// The daily alerts within one week of expiration need to check the last alert sent date. A threshold alert sent in
+	// the same run counts as today's alert.
+	if daysLeft <= 7 && daysLeft > 0 && alerts.SendDailyExpiryAlert && !sameDay(entry.LastAlertSent, now) && len(status.Due) == 0 {
+		status.Due = append(status.Due, configuration.AlertDaily)
+	}
+	for _, alert := range entry.RegistryStatus().Alerts() {
+		if alertEnabled(alerts, alert) && !entry.AlertSent(alert) {
+			status.Due = append(status.Due, alert)
+		}
+	}
+
+	return status
+}
+
+// EvaluateExpirations checks every monitored domain against the alert thresholds, paused and archived domains are
+// left out
+func EvaluateExpirations(domains []configuration.Domain, cache *configuration.WhoisCacheStorage, alerts configuration.AlertsConfiguration, now time.Time) []ExpiryStatus {
+	statuses := make([]ExpiryStatus, 0, len(domains))
+	for _, domain := range domains {
+		if !domain.Monitored() {
+			continue
+		}
+		statuses = append(statuses, EvaluateExpiration(domain, cache.Get(domain.FQDN), alerts, now))
+	}
+	return statuses
+}
+
+// alertEnabled reports if an alert type is turned on in the alerts configuration
+func alertEnabled(alerts configuration.AlertsConfiguration, alert configuration.Alert) bool {
+	switch alert {
+	case configuration.Alert2Months:
+		return alerts.Send2MonthAlert
+	case configuration.Alert1Month:
+		return alerts.Send1MonthAlert
+	case configuration.Alert2Weeks:
+		return alerts.Send2WeekAlert
+	case configuration.Alert1Week:
+		return alerts.Send1WeekAlert
+	case configuration.Alert3Days:
+		return alerts.Send3DayAlert
+	case configuration.AlertDaily:
+		return alerts.SendDailyExpiryAlert
+	case configuration.AlertHold, configuration.AlertRedemption, configuration.AlertPendingDelete:
+		return alerts.SendStatusAlerts
+	}
+	return false
+}
+
+// sameDay reports if two times fall on the same calendar day, in the timezone of b
+func sameDay(a time.Time, b time.Time) bool {
+	a = a.In(b.Location())
+	return a.Day() == b.Day() && a.Month() == b.Month() && a.Year() == b.Year()
+}
+
+// CheckState is the state of a monitoring plugin check, the values are the plugin exit codes
+type CheckState int
+
+const (
+	CheckOK       CheckState = 0
+	CheckWarning  CheckState = 1
+	CheckCritical CheckState = 2
+	CheckUnknown  CheckState = 3
+)
+
+func (s CheckState) String() string {
+	switch s {
+	case CheckOK:
+		return "OK"
+	case CheckWarning:
+		return "WARNING"
+	case CheckCritical:
+		return "CRITICAL"
+	}
+	return "UNKNOWN"
+}
+
+// Severity orders states from best to worst, for picking the overall state of several checks
+func (s CheckState) Severity() int {
+	switch s {
+	case CheckOK:
+		return 0
+	case CheckUnknown:
+		return 1
+	case CheckWarning:
+		return 2
+	}
+	return 3
+}
+
+// ThresholdDays returns how many days before the expiration date a one-time alert is due
+func ThresholdDays(alert configuration.Alert) (float64, bool) {
+	for _, threshold := range alertThresholds {
+		if threshold.alert == alert {
+			return threshold.days, true
+		}
+	}
+	return 0, false
+}
+
+// CheckExpiry classifies an evaluated domain against warning and critical thresholds (in days). It uses the same
+// days left as the alert evaluation, so a domain turns critical on the same day its alert of that threshold is due.
+func CheckExpiry(status ExpiryStatus, warningDays float64, criticalDays float64) CheckState {
+	if status.Problem != "" {
+		return CheckUnknown
+	}
+	if status.DaysLeft <= criticalDays {
+		return CheckCritical
+	}
+	if status.DaysLeft <= warningDays {
+		return CheckWarning
+	}
+	return CheckOK
+}
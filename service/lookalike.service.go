@@ -0,0 +1,317 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+// Template key of the lookalike alerts
+const TemplateKeyLookalike = "lookalike"
+
+// Interval between the lookalike scans, unless scheduler.lookalikeScanInterval is set
+const DefaultLookalikeInterval = 24 * time.Hour
+
+// Number of lookalikes that are resolved at the same time
+const lookalikeWorkers = 8
+
+// ErrDomainNotMonitored is returned when a domain that isn't monitored is scanned for lookalikes
+var ErrDomainNotMonitored = errors.New("domain is not monitored")
+
+// LookalikeInterval returns the interval between the lookalike scans
+func LookalikeInterval(scheduler configuration.SchedulerConfiguration) time.Duration {
+	if scheduler.LookalikeScanInterval <= 0 {
+		return DefaultLookalikeInterval
+	}
+	return time.Duration(scheduler.LookalikeScanInterval) * time.Hour
+}
+
+// LookalikeResult is the result of scanning a monitored domain for lookalikes
+type LookalikeResult struct {
+	// The scanned domain
+	Domain configuration.Domain `json:"domain"`
+	// Counts of the scan
+	Scan configuration.LookalikeScan `json:"scan"`
+	// Lookalikes that were registered since the last scan, or resolve again
+	Appeared []configuration.Lookalike `json:"appeared"`
+}
+
+// LookalikeService looks for registered lookalikes of the monitored domains
+type LookalikeService struct {
+	store   *configuration.LookalikeStorage
+	whois   *configuration.WhoisCacheStorage
+	domains *configuration.DomainConfiguration
+	config  configuration.LookalikesConfiguration
+	// Resolves the generated lookalikes
+	resolver Resolver
+	timeout  time.Duration
+	// One scan at a time, the WHOIS cache of the lookalikes isn't safe for concurrent use
+	scanning sync.Mutex
+}
+
+func NewLookalikeService(store *configuration.LookalikeStorage, whois *configuration.WhoisCacheStorage, domains *configuration.DomainConfiguration, config configuration.ConfigurationFile) *LookalikeService {
+	return &LookalikeService{
+		store:    store,
+		whois:    whois,
+		domains:  domains,
+		config:   config.Lookalikes,
+		resolver: NewResolver(config.DNS),
+		timeout:  DNSTimeout(config.DNS),
+	}
+}
+
+// UseResolver replaces the resolver of the lookalikes, e.g. with a fake in tests
+func (s *LookalikeService) UseResolver(resolver Resolver) {
+	s.resolver = resolver
+}
+
+// Enabled reports if the lookalikes are looked for on a schedule
+func (s *LookalikeService) Enabled() bool {
+	return s.config.Enabled
+}
+
+// List returns the lookalikes found for a monitored domain, or for every domain if fqdn is empty
+func (s *LookalikeService) List(fqdn string) []configuration.Lookalike {
+	return s.store.List(strings.ToLower(strings.TrimSpace(fqdn)))
+}
+
+// Scans returns the last scan of each monitored domain
+func (s *LookalikeService) Scans() []configuration.LookalikeScan {
+	return s.store.Scans()
+}
+
+// Permutations returns the lookalikes generated for a name, it doesn't have to be monitored
+func (s *LookalikeService) Permutations(fqdn string) []configuration.Permutation {
+	return configuration.Permutations(fqdn, s.config.TLDs)
+}
+
+// Scan looks for the lookalikes of a monitored domain now
+func (s *LookalikeService) Scan(fqdn string, now time.Time) (LookalikeResult, error) {
+	fqdn = strings.ToLower(strings.TrimSpace(fqdn))
+	for _, domain := range s.domains.List() {
+		if domain.FQDN == fqdn && domain.Monitored() {
+			s.scanning.Lock()
+			defer s.scanning.Unlock()
+
+			result := s.scan(domain, now)
+			s.whois.Flush()
+			s.store.Flush()
+			return result, nil
+		}
+	}
+	return LookalikeResult{}, ErrDomainNotMonitored
+}
+
+// ScanAll looks for the lookalikes of every monitored domain. The lookalikes of domains that were removed are
+// forgotten, paused and archived domains keep theirs.
+func (s *LookalikeService) ScanAll(now time.Time) []LookalikeResult {
+	s.scanning.Lock()
+	defer s.scanning.Unlock()
+
+	known := map[string]bool{}
+	results := []LookalikeResult{}
+	for _, domain := range s.domains.List() {
+		known[domain.FQDN] = true
+		if domain.Monitored() {
+			results = append(results, s.scan(domain, now))
+		}
+	}
+	for _, scan := range s.store.Scans() {
+		if !known[scan.FQDN] && s.store.Forget(scan.FQDN) {
+			log.Printf("🗑 Forgot the lookalikes of %s, it is no longer in the domain list", scan.FQDN)
+		}
+	}
+	s.whois.Flush()
+	s.store.Flush()
+	return results
+}
+
+// scan resolves every lookalike of a domain and looks up the registration of the ones that resolve
+func (s *LookalikeService) scan(domain configuration.Domain, now time.Time) LookalikeResult {
+	owned := map[string]bool{}
+	for _, d := range s.domains.List() {
+		owned[d.FQDN] = true
+	}
+	candidates := []configuration.Permutation{}
+	for _, permutation := range s.Permutations(domain.FQDN) {
+		if !owned[permutation.FQDN] {
+			candidates = append(candidates, permutation)
+		}
+	}
+
+	scan := configuration.LookalikeScan{FQDN: domain.FQDN, ScannedAt: now, Candidates: len(candidates)}
+	found := []configuration.Lookalike{}
+	failed := []string{}
+	var mu sync.Mutex
+	work := make(chan configuration.Permutation)
+	var wg sync.WaitGroup
+	for i := 0; i < lookalikeWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for permutation := range work {
+				addresses, nameServers, err := s.resolve(permutation.FQDN)
+				mu.Lock()
+				if err != nil {
+					failed = append(failed, permutation.FQDN)
+				} else if len(addresses) > 0 || len(nameServers) > 0 {
+					found = append(found, configuration.Lookalike{
+						FQDN: permutation.FQDN, Unicode: permutation.Unicode, Of: domain.FQDN, Kind: permutation.Kind,
+						Addresses: addresses, NameServers: nameServers, LastSeen: now, Active: true,
+					})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, candidate := range candidates {
+		work <- candidate
+	}
+	close(work)
+	wg.Wait()
+
+	// WHOIS servers rate limit, so the registrations are looked up one by one
+	sort.Slice(found, func(i, j int) bool { return found[i].FQDN < found[j].FQDN })
+	for i := range found {
+		entry := s.whois.Fetch(found[i].FQDN)
+		if entry.NxDomain || entry.WhoisInfo.Domain == nil {
+			continue
+		}
+		found[i].Created = entry.WhoisInfo.Domain.CreatedDateInTime
+		found[i].Registrar = RegistrarName(&entry)
+	}
+
+	scan.Found, scan.Failed = len(found), len(failed)
+	appeared := s.store.Record(scan, found, failed)
+	if scan.Failed > 0 {
+		log.Printf("⚠️ %d of %d lookalikes of %s could not be resolved", scan.Failed, scan.Candidates, domain.FQDN)
+	}
+	log.Printf("🎭 Found %d registered lookalikes of %s (%d new)", scan.Found, domain.FQDN, len(appeared))
+	return LookalikeResult{Domain: domain, Scan: scan, Appeared: appeared}
+}
+
+// resolve looks up the addresses and nameservers of a name. A name that doesn't exist has neither, other failures
+// (e.g. timeouts) return the error.
+func (s *LookalikeService) resolve(fqdn string) ([]string, []string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*s.timeout)
+	defer cancel()
+
+	addresses, err := s.resolver.LookupHost(ctx, fqdn)
+	if err != nil && !IsNotFound(err) {
+		return nil, nil, err
+	}
+	records, err := s.resolver.LookupNS(ctx, fqdn)
+	if err != nil && !IsNotFound(err) {
+		return nil, nil, err
+	}
+	nameServers := []string{}
+	for _, record := range records {
+		nameServers = append(nameServers, strings.TrimSuffix(record.Host, "."))
+	}
+	sort.Strings(addresses)
+	sort.Strings(nameServers)
+	return addresses, nameServers, nil
+}
+
+// LookalikeTemplateData is the data available to the lookalike alert templates
+type LookalikeTemplateData struct {
+	// Application name, for signatures
+	AppName string
+	// Human readable alert, e.g. "2 new lookalikes of example.com"
+	Alert string
+	// Always "lookalike"
+	AlertKey string
+	// The monitored domain
+	Domain configuration.Domain
+	FQDN   string
+	Name   string
+	// The lookalikes that were registered since the last scan
+	Lookalikes []configuration.Lookalike
+	Count      int
+	// Link to the lookalikes page, empty if no base URL is configured
+	DashboardURL string
+	// When the message was rendered
+	Now time.Time
+}
+
+// NewLookalikeTemplateData builds the template data for the lookalikes that appeared for a domain
+func NewLookalikeTemplateData(result LookalikeResult, baseURL string, now time.Time) LookalikeTemplateData {
+	data := LookalikeTemplateData{
+		AppName:    "Domain Monitor",
+		AlertKey:   TemplateKeyLookalike,
+		Domain:     result.Domain,
+		FQDN:       result.Domain.FQDN,
+		Name:       result.Domain.Name,
+		Lookalikes: result.Appeared,
+		Count:      len(result.Appeared),
+		Now:        now,
+	}
+	if data.Name == "" {
+		data.Name = data.FQDN
+	}
+	data.Alert = "New lookalike of " + data.FQDN
+	if data.Count > 1 {
+		data.Alert = fmt.Sprintf("%d new lookalikes of %s", data.Count, data.FQDN)
+	}
+	if baseURL != "" {
+		data.DashboardURL = strings.TrimRight(baseURL, "/") + "/"
+	}
+	return data
+}
+
+// NotifyLookalike queues the alert for the lookalikes that appeared for a domain, to the recipients of the domain.
+// Returns who it was queued for.
+func NotifyLookalike(notifications *NotificationService, config configuration.ConfigurationFile, result LookalikeResult, now time.Time) ([]string, error) {
+	// Lookalikes aren't about the expiration, so the escalation recipients are left out
+	recipients := ResolveRecipients(config, result.Domain, math.Inf(1))
+	if recipients.Empty() {
+		log.Printf("⚠️ No recipients for the lookalike alert of %s, configure alerts.admin or domain owners", result.Domain.FQDN)
+		return nil, nil
+	}
+
+	data := NewLookalikeTemplateData(result, config.App.BaseURL, now)
+	rendered, err := notifications.Render(TemplateKeyLookalike, data)
+	if err != nil {
+		log.Printf("❌ Failed to render the lookalike alert for %s: %s", result.Domain.FQDN, err)
+		return nil, err
+	}
+
+	// Each appearance of a lookalike is alerted once
+	keyParts := []string{TemplateKeyLookalike, result.Domain.FQDN}
+	for _, lookalike := range result.Appeared {
+		keyParts = append(keyParts, lookalike.FQDN)
+	}
+	keyParts = append(keyParts, result.Scan.ScannedAt.Format(time.RFC3339))
+	item := configuration.QueuedNotification{FQDN: result.Domain.FQDN, Alert: TemplateKeyLookalike}
+	return enqueue(notifications, recipients, rendered, data, item, keyParts)
+}
+
+// NotifyLookalikes queues the alerts of the domains with new lookalikes and alerts turned on. Nothing is queued without
+// a configured mailer. Returns the number of queued alerts.
+func NotifyLookalikes(notifications *NotificationService, config configuration.ConfigurationFile, results []LookalikeResult, now time.Time) int {
+	if notifications == nil || !notifications.Enabled() {
+		return 0
+	}
+	queued := 0
+	for _, result := range results {
+		if len(result.Appeared) == 0 || !result.Domain.Alerts {
+			continue
+		}
+		received, err := NotifyLookalike(notifications, config, result, now)
+		if err != nil {
+			log.Printf("❌ Failed to queue the lookalike alert for %s: %s", result.Domain.FQDN, err)
+		}
+		if len(received) > 0 {
+			queued++
+		}
+	}
+	return queued
+}
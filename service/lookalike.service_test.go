@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	whoisparser "github.com/likexian/whois-parser"
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+// fakeResolver answers from fixed address and NS records. Names without records don't exist, names in failing time
+// out.
+type fakeResolver struct {
+	mu        sync.Mutex
+	addresses map[string][]string
+	ns        map[string][]string
+	failing   map[string]bool
+	// Every name that was looked up
+	queried map[string]int
+}
+
+func newFakeResolver() *fakeResolver {
+	return &fakeResolver{
+		addresses: map[string][]string{},
+		ns:        map[string][]string{},
+		failing:   map[string]bool{},
+		queried:   map[string]int{},
+	}
+}
+
+func (r *fakeResolver) lookup(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queried[name]++
+	if r.failing[name] {
+		return &net.DNSError{Err: "i/o timeout", Name: name, IsTimeout: true}
+	}
+	return nil
+}
+
+func notFound(name string) error {
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if err := r.lookup(host); err != nil {
+		return nil, err
+	}
+	if addresses, ok := r.addresses[host]; ok {
+		return append([]string{}, addresses...), nil
+	}
+	return nil, notFound(host)
+}
+
+func (r *fakeResolver) LookupNS(ctx context.Context, name string) ([]*net.NS, error) {
+	if err := r.lookup(name); err != nil {
+		return nil, err
+	}
+	hosts, ok := r.ns[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	records := []*net.NS{}
+	for _, host := range hosts {
+		records = append(records, &net.NS{Host: host + "."})
+	}
+	return records, nil
+}
+
+func (r *fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if err := r.lookup(name); err != nil {
+		return nil, err
+	}
+	return nil, notFound(name)
+}
+
+func (r *fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	if err := r.lookup(name); err != nil {
+		return nil, err
+	}
+	return nil, notFound(name)
+}
+
+// newTestLookalikeService scans example.com (and owns exmple.com) with the lookalike TLD net, using the fake resolver.
+// The WHOIS cache of the lookalikes is filled with fresh entries, so no lookups leave the test.
+func newTestLookalikeService(t *testing.T, resolver Resolver, registrars map[string]string) (*LookalikeService, *configuration.LookalikeStorage) {
+	t.Helper()
+	dir := t.TempDir()
+	store := configuration.DefaultLookalikeStorage(filepath.Join(dir, configuration.LookalikesName))
+	whois := configuration.DefaultWhoisCacheStorage(filepath.Join(dir, configuration.LookalikeWhoisCacheName))
+	created := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for fqdn, registrar := range registrars {
+		whois.FileContents.Entries = append(whois.FileContents.Entries, configuration.WhoisCache{
+			FQDN:        fqdn,
+			LastUpdated: time.Now(),
+			WhoisInfo: whoisparser.WhoisInfo{
+				Domain:    &whoisparser.Domain{Domain: fqdn, CreatedDateInTime: &created},
+				Registrar: &whoisparser.Contact{Name: registrar},
+			},
+		})
+	}
+	domains := &configuration.DomainConfiguration{DomainFile: configuration.DomainFile{Domains: []configuration.Domain{
+		{FQDN: "example.com", Name: "Example", Enabled: true, Alerts: true},
+		{FQDN: "exmple.com", Name: "Typo we own", Enabled: false},
+	}}}
+	config := configuration.ConfigurationFile{Lookalikes: configuration.LookalikesConfiguration{Enabled: true, TLDs: []string{"net"}}}
+
+	s := NewLookalikeService(store, whois, domains, config)
+	s.UseResolver(resolver)
+	return s, store
+}
+
+func lookalikeNames(lookalikes []configuration.Lookalike) []string {
+	names := []string{}
+	for _, lookalike := range lookalikes {
+		names = append(names, lookalike.FQDN)
+	}
+	return names
+}
+
+func TestLookalikeScan(t *testing.T) {
+	resolver := newFakeResolver()
+	resolver.addresses["exmaple.com"] = []string{"203.0.113.7"}
+	resolver.ns["exmaple.com"] = []string{"ns2.parking.example", "ns1.parking.example"}
+	// An owned lookalike is never reported, even if it resolves
+	resolver.addresses["exmple.com"] = []string{"192.0.2.1"}
+	s, store := newTestLookalikeService(t, resolver, map[string]string{
+		"exmaple.com": "Cheap Names Ltd",
+		"example.net": "Cheap Names Ltd",
+		"examp1e.com": "Other Registrar",
+	})
+	now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	// The first scan is the baseline, nothing is alerted
+	result, err := s.Scan("example.com", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Appeared) != 0 {
+		t.Errorf("first scan alerted %v, want nothing", lookalikeNames(result.Appeared))
+	}
+	if result.Scan.Found != 1 || result.Scan.Failed != 0 {
+		t.Errorf("first scan found %d and failed %d, want 1 and 0", result.Scan.Found, result.Scan.Failed)
+	}
+	if resolver.queried["exmple.com"] > 0 {
+		t.Error("the owned exmple.com was resolved")
+	}
+	want := len(configuration.Permutations("example.com", []string{"net"})) - 1
+	if result.Scan.Candidates != want {
+		t.Errorf("%d candidates, want %d (the permutations without exmple.com)", result.Scan.Candidates, want)
+	}
+	list := store.List("example.com")
+	if len(list) != 1 || !list[0].Baseline || !list[0].Active {
+		t.Fatalf("stored %+v, want exmaple.com as active baseline", list)
+	}
+	if list[0].Registrar != "Cheap Names Ltd" || list[0].Created == nil || list[0].Kind != configuration.PermutationTransposition {
+		t.Errorf("stored %+v, want the registrar, creation date and kind", list[0])
+	}
+	if len(list[0].NameServers) != 2 || list[0].NameServers[0] != "ns1.parking.example" {
+		t.Errorf("name servers = %v, want them sorted without the trailing dot", list[0].NameServers)
+	}
+
+	// New registrations are alerted, a lookalike that can't be resolved isn't counted as gone
+	resolver.addresses["example.net"] = []string{"198.51.100.20"}
+	resolver.ns["examp1e.com"] = []string{"ns1.example-dns.net"}
+	resolver.failing["exmaple.com"] = true
+	result, err = s.Scan("example.com", now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	appeared := lookalikeNames(result.Appeared)
+	if len(appeared) != 2 || appeared[0] != "examp1e.com" || appeared[1] != "example.net" {
+		t.Errorf("second scan alerted %v, want examp1e.com and example.net", appeared)
+	}
+	if result.Scan.Found != 2 || result.Scan.Failed != 1 {
+		t.Errorf("second scan found %d and failed %d, want 2 and 1", result.Scan.Found, result.Scan.Failed)
+	}
+	for _, lookalike := range store.List("example.com") {
+		if !lookalike.Active {
+			t.Errorf("%s is inactive, want all active", lookalike.FQDN)
+		}
+	}
+
+	// A lookalike that stops resolving turns inactive, and is alerted again when it comes back
+	resolver.failing["exmaple.com"] = false
+	delete(resolver.addresses, "exmaple.com")
+	delete(resolver.ns, "exmaple.com")
+	if result, _ = s.Scan("example.com", now.Add(48*time.Hour)); len(result.Appeared) != 0 {
+		t.Errorf("third scan alerted %v, want nothing", lookalikeNames(result.Appeared))
+	}
+	for _, lookalike := range store.List("example.com") {
+		if lookalike.FQDN == "exmaple.com" && lookalike.Active {
+			t.Error("exmaple.com is still active after it stopped resolving")
+		}
+	}
+	resolver.addresses["exmaple.com"] = []string{"203.0.113.8"}
+	result, _ = s.Scan("example.com", now.Add(72*time.Hour))
+	if appeared := lookalikeNames(result.Appeared); len(appeared) != 1 || appeared[0] != "exmaple.com" {
+		t.Errorf("fourth scan alerted %v, want exmaple.com", appeared)
+	}
+}
+
+func TestLookalikeScanOnlyMonitoredDomains(t *testing.T) {
+	s, _ := newTestLookalikeService(t, newFakeResolver(), nil)
+	for _, fqdn := range []string{"exmple.com", "unknown.com"} {
+		if _, err := s.Scan(fqdn, time.Now()); !errors.Is(err, ErrDomainNotMonitored) {
+			t.Errorf("Scan(%s) = %v, want ErrDomainNotMonitored", fqdn, err)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/nwesterhausen/domain-monitor/configuration"
@@ -15,6 +16,10 @@ type MailerService struct {
 	from   string
 	host   string
 	port   int
+	// Templates used to render the messages
+	templates *TemplateService
+	// Base URL of the web app, for links in the messages
+	baseURL string
 }
 
 func NewMailerService(config configuration.SMTPConfiguration) *MailerService {
@@ -49,24 +54,8 @@ func NewMailerService(config configuration.SMTPConfiguration) *MailerService {
 		authStyle = ""
 	}
 
-	// Determine encryption type (backward compatible with old Secure field)
+	// Older values are migrated to "none", "ssl" or "starttls" when the configuration is loaded
 	encryptionType := config.EncryptionType
-	if encryptionType == "" {
-		// Legacy support: migrate from Secure boolean to EncryptionType
-		if config.Port == 25 {
-			encryptionType = "none"
-		} else if config.Port == 465 {
-			encryptionType = "ssl"
-		} else {
-			// Default to STARTTLS for ports 587 and others
-			encryptionType = "starttls"
-		}
-	}
-
-	// Normalize old values to new format
-	if encryptionType == "tls" || encryptionType == "starttls-mandatory" || encryptionType == "starttls-opportunistic" {
-		encryptionType = "starttls"
-	}
 
 	// Build options based on encryption type
 	var opts []mail.Option
@@ -131,24 +120,49 @@ func NewMailerService(config configuration.SMTPConfiguration) *MailerService {
 	return &MailerService{
 		client: client,
 		from:   from,
-		host:   config.Host,
-		port:   config.Port,
+		host:      config.Host,
+		port:      config.Port,
+		templates: NewTemplateService(""),
+	}
+}
+
+// UseTemplates sets the templates used to render messages, and the base URL used for links to the web app
+func (m *MailerService) UseTemplates(templates *TemplateService, baseURL string) {
+	m.templates = templates
+	m.baseURL = baseURL
+}
+
+// setBody sets the plain text body with the HTML version as alternative
+func setBody(msg *mail.Msg, rendered RenderedMessage) {
+	msg.Subject(rendered.Subject)
+	msg.SetBodyString(mail.TypeTextPlain, rendered.Text)
+	if rendered.HTML != "" {
+		msg.AddAlternativeString(mail.TypeTextHTML, rendered.HTML)
 	}
 }
 
-func (m *MailerService) TestMail(to string) error {
-	log.Printf("📧 Preparing test email to %s", to)
+// Render renders a template with the templates of the mailer
+func (m *MailerService) Render(key string, data interface{}) (RenderedMessage, error) {
+	return m.templates.Render(key, data)
+}
+
+func (m *MailerService) TestMail(to ...string) error {
+	log.Printf("📧 Preparing test email to %s", strings.Join(to, ", "))
 	msg := mail.NewMsg()
 	if err := msg.From(m.from); err != nil {
 		log.Printf("❌ Failed to set FROM address: %s", err)
 		return err
 	}
-	if err := msg.To(to); err != nil {
+	if err := msg.To(to...); err != nil {
 		log.Printf("❌ Failed to set TO address: %s", err)
 		return err
 	}
-	msg.Subject("Test E-Mail from Domain Monitor")
-	msg.SetBodyString(mail.TypeTextPlain, "This is a test e-mail from the Domain Monitor application. If you received this, it's working! 🎉")
+	rendered, err := m.templates.Render(TemplateKeyTest, NewTestTemplateData(m.baseURL, time.Now()))
+	if err != nil {
+		log.Printf("❌ Failed to render test mail: %s", err)
+		return err
+	}
+	setBody(msg, rendered)
 
 	// Quick connectivity check before attempting full SMTP connection
 	log.Printf("📧 Checking SMTP server connectivity to %s:%d...", m.host, m.port)
@@ -186,7 +200,7 @@ func (m *MailerService) TestMail(to string) error {
 			log.Printf("❌ Failed to deliver mail: %s", err)
 			return fmt.Errorf("SMTP error: %w", err)
 		}
-		log.Printf("✅ E-mail message sent successfully to %s", to)
+		log.Printf("✅ E-mail message sent successfully to %s", strings.Join(to, ", "))
 		return nil
 	case <-timeout:
 		log.Printf("❌ SMTP operation timed out after 25 seconds - authentication or sending may be failing")
@@ -194,28 +208,58 @@ func (m *MailerService) TestMail(to string) error {
 	}
 }
 
-func (m *MailerService) SendAlert(to string, fqdn string, alert configuration.Alert) error {
+// SendDigest renders the digest template and sends it
+func (m *MailerService) SendDigest(recipients Recipients, digest DigestTemplateData) error {
+	if m.baseURL != "" && digest.DashboardURL == "" {
+		digest.DashboardURL = strings.TrimRight(m.baseURL, "/") + "/"
+	}
+	rendered, err := m.templates.Render(TemplateKeyDigest, digest)
+	if err != nil {
+		log.Printf("❌ failed to render digest: %s", err)
+		return err
+	}
+	return m.SendMessage(recipients, rendered)
+}
+
+// SendAlert renders the template of an alert and sends it. The data is built with NewAlertTemplateData, the base URL of
+// the mailer is used for the dashboard link.
+func (m *MailerService) SendAlert(recipients Recipients, data AlertTemplateData) error {
+	if m.baseURL != "" && data.DashboardURL == "" {
+		data.DashboardURL = strings.TrimRight(m.baseURL, "/") + "/"
+	}
+	rendered, err := m.templates.Render(data.AlertKey, data)
+	if err != nil {
+		log.Printf("❌ failed to render %s for %s: %s", data.Alert, data.FQDN, err)
+		return err
+	}
+	return m.SendMessage(recipients, rendered)
+}
+
+// SendMessage sends an already rendered message to the TO and CC recipients
+func (m *MailerService) SendMessage(recipients Recipients, rendered RenderedMessage) error {
 	msg := mail.NewMsg()
 	if err := msg.From(m.from); err != nil {
 		log.Printf("❌ failed to set FROM address: %s", err)
 		return err
 	}
-	if err := msg.To(to); err != nil {
+	if err := msg.To(recipients.To...); err != nil {
 		log.Printf("❌ failed to set TO address: %s", err)
 		return err
 	}
-	msg.Subject("Domain Expiration Alert: " + fqdn)
-
-	body := fmt.Sprintf("Your domain %s is expiring in %s. Please renew it as soon as possible.", fqdn, alert)
-
-	msg.SetBodyString(mail.TypeTextPlain, body)
+	if len(recipients.CC) > 0 {
+		if err := msg.Cc(recipients.CC...); err != nil {
+			log.Printf("❌ failed to set CC address: %s", err)
+			return err
+		}
+	}
+	setBody(msg, rendered)
 
 	if err := m.client.DialAndSend(msg); err != nil {
 		log.Printf("❌ failed to deliver mail: %s", err)
 		return err
 	}
 
-	log.Printf("📧 E-mail message sent to " + to)
+	log.Printf("📧 E-mail message sent to %s", strings.Join(recipients.Addresses(), ", "))
 
 	return nil
 }
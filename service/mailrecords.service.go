@@ -0,0 +1,503 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+// Most DNS lookups an SPF record may need to be evaluated, including the included records (RFC 7208, section 4.6.4)
+const SPFLookupLimit = 10
+
+// Timeout of fetching an MTA-STS policy
+const MTASTSPolicyTimeout = 10 * time.Second
+
+// Largest MTA-STS policy that is read, policies are a few lines (RFC 8461 suggests 64 KiB)
+const maxMTASTSPolicySize = 64 * 1024
+
+// lookupError is a DNS lookup that failed for another reason than a missing record (e.g. a timeout), the records of
+// the domain are unknown and the check can't be completed
+type lookupError struct {
+	name string
+	err  error
+}
+
+func (e *lookupError) Error() string {
+	return fmt.Sprintf("DNS lookup of %s failed: %s", e.name, e.err)
+}
+
+func (e *lookupError) Unwrap() error {
+	return e.err
+}
+
+// newMTASTSClient returns the client fetching the MTA-STS policies, it doesn't follow redirects as RFC 8461 requires
+func newMTASTSClient() *http.Client {
+	return &http.Client{
+		Timeout: MTASTSPolicyTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// txtRecords returns the TXT records of a name that match, e.g. the ones starting with a version tag. A name without
+// records has none, other failures return a *lookupError.
+func (s *MailSecurityService) txtRecords(name string, match func(string) bool) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*s.timeout)
+	defer cancel()
+
+	records, err := s.resolver.LookupTXT(ctx, name)
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, &lookupError{name: name, err: err}
+	}
+	matching := []string{}
+	for _, record := range records {
+		if record = strings.TrimSpace(record); match(record) {
+			matching = append(matching, record)
+		}
+	}
+	return matching, nil
+}
+
+// isSPF matches SPF records
+func isSPF(record string) bool {
+	record = strings.ToLower(record)
+	return record == "v=spf1" || strings.HasPrefix(record, "v=spf1 ")
+}
+
+// hasVersion matches the tag-value records (DMARC, MTA-STS, TLS-RPT, BIMI) that start with a version tag
+func hasVersion(version string) func(string) bool {
+	return func(record string) bool {
+		first, _, _ := strings.Cut(record, ";")
+		key, value, found := strings.Cut(first, "=")
+		return found && strings.TrimSpace(key) == "v" && strings.EqualFold(strings.TrimSpace(value), version)
+	}
+}
+
+// isDKIM matches DKIM key records, the version tag is optional but the key tag isn't
+func isDKIM(record string) bool {
+	tags := parseTags(record)
+	_, hasKey := tags["p"]
+	return hasKey && (tags["v"] == "" || tags["v"] == "DKIM1")
+}
+
+// parseTags splits a tag-value record like "v=DMARC1; p=reject" into its tags, keyed by the lowercase tag name
+func parseTags(record string) map[string]string {
+	tags := map[string]string{}
+	for _, part := range strings.Split(record, ";") {
+		key, value, found := strings.Cut(part, "=")
+		if !found {
+			continue
+		}
+		tags[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+	return tags
+}
+
+// splitURIs splits a comma separated list of report addresses
+func splitURIs(value string) []string {
+	uris := []string{}
+	for _, uri := range strings.Split(value, ",") {
+		if uri = strings.TrimSpace(uri); uri != "" {
+			uris = append(uris, uri)
+		}
+	}
+	return uris
+}
+
+// checkSPF finds the SPF record of a domain and counts the DNS lookups it needs
+func (s *MailSecurityService) checkSPF(fqdn string) (configuration.SPFCheck, error) {
+	check := configuration.SPFCheck{}
+	records, err := s.txtRecords(fqdn, isSPF)
+	if err != nil || len(records) == 0 {
+		return check, err
+	}
+	check.Record = records[0]
+	if len(records) > 1 {
+		check.Error = "more than one SPF record"
+		return check, nil
+	}
+
+	lookups, all, err := s.spfLookups(fqdn, check.Record, map[string]bool{fqdn: true})
+	var lookupErr *lookupError
+	if errors.As(err, &lookupErr) {
+		return check, err
+	}
+	check.Lookups, check.All = lookups, all
+	if err != nil {
+		check.Error = err.Error()
+	} else if lookups > SPFLookupLimit {
+		check.Error = fmt.Sprintf("needs %d DNS lookups, at most %d are allowed", lookups, SPFLookupLimit)
+	}
+	return check, nil
+}
+
+// spfLookups counts the DNS lookups of an SPF record, following its includes and redirect. Returns the final all
+// mechanism of the record (or of its redirect). The names on the path are tracked to stop include loops.
+func (s *MailSecurityService) spfLookups(fqdn string, record string, path map[string]bool) (int, string, error) {
+	lookups, all, redirect := 0, "", ""
+	for _, term := range strings.Fields(strings.ToLower(record))[1:] {
+		mechanism := strings.TrimLeft(term, "+-~?")
+		qualifier := term[:len(term)-len(mechanism)]
+		name, value, separator := mechanism, "", byte(0)
+		if i := strings.IndexAny(mechanism, ":=/"); i >= 0 {
+			name, value, separator = mechanism[:i], mechanism[i+1:], mechanism[i]
+		}
+
+		switch name {
+		case "all":
+			if qualifier == "" {
+				qualifier = "+"
+			}
+			all = qualifier[:1] + "all"
+		case "include":
+			lookups++
+			included, err := s.spfInclude(value, path)
+			lookups += included
+			if err != nil {
+				return lookups, all, err
+			}
+		case "a", "mx", "ptr", "exists":
+			lookups++
+		case "ip4", "ip6", "exp":
+		case "redirect":
+			redirect = value
+		default:
+			// Unknown modifiers are ignored, unknown mechanisms make the record invalid
+			if separator != '=' {
+				return lookups, all, fmt.Errorf("unknown mechanism %q", term)
+			}
+		}
+	}
+
+	// A redirect is only followed when the record doesn't end in an all mechanism
+	if redirect != "" && all == "" {
+		lookups++
+		records, err := s.spfTarget(redirect, path)
+		if err != nil || records == "" {
+			return lookups, all, err
+		}
+		path[redirect] = true
+		defer delete(path, redirect)
+		redirected, redirectAll, err := s.spfLookups(redirect, records, path)
+		return lookups + redirected, redirectAll, err
+	}
+	return lookups, all, nil
+}
+
+// spfInclude counts the DNS lookups of an included SPF record
+func (s *MailSecurityService) spfInclude(target string, path map[string]bool) (int, error) {
+	record, err := s.spfTarget(target, path)
+	if err != nil || record == "" {
+		return 0, err
+	}
+	path[target] = true
+	defer delete(path, target)
+	lookups, _, err := s.spfLookups(target, record, path)
+	return lookups, err
+}
+
+// spfTarget returns the SPF record of an included or redirected domain. Targets with macros depend on the sender and
+// aren't followed, they return an empty record.
+func (s *MailSecurityService) spfTarget(target string, path map[string]bool) (string, error) {
+	switch {
+	case target == "":
+		return "", errors.New("include or redirect without a domain")
+	case strings.Contains(target, "%"):
+		return "", nil
+	case path[target]:
+		return "", fmt.Errorf("%s includes itself", target)
+	}
+	records, err := s.txtRecords(target, isSPF)
+	if err != nil {
+		return "", err
+	}
+	switch len(records) {
+	case 0:
+		return "", fmt.Errorf("%s has no SPF record", target)
+	case 1:
+		return records[0], nil
+	}
+	return "", fmt.Errorf("%s has more than one SPF record", target)
+}
+
+// checkDMARC finds the DMARC record of a domain
+func (s *MailSecurityService) checkDMARC(fqdn string) (configuration.DMARCCheck, error) {
+	check := configuration.DMARCCheck{}
+	records, err := s.txtRecords("_dmarc."+fqdn, hasVersion("DMARC1"))
+	if err != nil || len(records) == 0 {
+		return check, err
+	}
+	check.Record = records[0]
+	if len(records) > 1 {
+		check.Error = "more than one DMARC record"
+		return check, nil
+	}
+
+	tags := parseTags(check.Record)
+	check.Policy = strings.ToLower(tags["p"])
+	check.SubdomainPolicy = strings.ToLower(tags["sp"])
+	if check.SubdomainPolicy == "" {
+		check.SubdomainPolicy = check.Policy
+	}
+	check.Reports = splitURIs(tags["rua"])
+	check.Percent = 100
+	if pct, ok := tags["pct"]; ok {
+		percent, err := strconv.Atoi(pct)
+		if err != nil || percent < 0 || percent > 100 {
+			check.Error = fmt.Sprintf("invalid percentage pct=%s", pct)
+			return check, nil
+		}
+		check.Percent = percent
+	}
+	switch check.Policy {
+	case "none", "quarantine", "reject":
+	case "":
+		check.Error = "no policy (p=)"
+	default:
+		check.Error = fmt.Sprintf("unknown policy p=%s", check.Policy)
+	}
+	return check, nil
+}
+
+// checkDKIM finds the DKIM key of a selector and its size
+func (s *MailSecurityService) checkDKIM(fqdn string, selector string) (configuration.DKIMCheck, error) {
+	check := configuration.DKIMCheck{Selector: selector}
+	records, err := s.txtRecords(selector+"._domainkey."+fqdn, isDKIM)
+	if err != nil {
+		return check, err
+	}
+	switch len(records) {
+	case 0:
+		check.Error = "no key published"
+		return check, nil
+	case 1:
+	default:
+		check.Error = "more than one key published"
+		return check, nil
+	}
+
+	tags := parseTags(records[0])
+	check.KeyType = strings.ToLower(tags["k"])
+	if check.KeyType == "" {
+		check.KeyType = "rsa"
+	}
+	encoded := strings.Join(strings.Fields(tags["p"]), "")
+	if encoded == "" {
+		check.Revoked = true
+		return check, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		check.Error = "the key isn't valid base64"
+		return check, nil
+	}
+
+	switch check.KeyType {
+	case "rsa":
+		parsed, err := x509.ParsePKIXPublicKey(key)
+		if err != nil {
+			// Some signers publish the bare PKCS #1 key
+			if parsed, err = x509.ParsePKCS1PublicKey(key); err != nil {
+				check.Error = "the key isn't a valid RSA public key"
+				return check, nil
+			}
+		}
+		rsaKey, ok := parsed.(*rsa.PublicKey)
+		if !ok {
+			check.Error = "the key isn't an RSA public key"
+			return check, nil
+		}
+		check.KeyBits = rsaKey.N.BitLen()
+		if check.KeyBits < 1024 {
+			check.Error = fmt.Sprintf("the RSA key of %d bits is too short", check.KeyBits)
+		}
+	case "ed25519":
+		if len(key) != 32 {
+			check.Error = "the key isn't a valid Ed25519 public key"
+			return check, nil
+		}
+		check.KeyBits = 256
+	default:
+		check.Error = fmt.Sprintf("unknown key type k=%s", check.KeyType)
+	}
+	return check, nil
+}
+
+// checkMTASTS finds the MTA-STS record of a domain, fetches the policy it announces and checks that the policy covers
+// the MX hosts of the domain
+func (s *MailSecurityService) checkMTASTS(fqdn string) (configuration.MTASTSCheck, error) {
+	check := configuration.MTASTSCheck{}
+	records, err := s.txtRecords("_mta-sts."+fqdn, hasVersion("STSv1"))
+	if err != nil || len(records) == 0 {
+		return check, err
+	}
+	check.Record = records[0]
+	if len(records) > 1 {
+		check.Error = "more than one MTA-STS record"
+		return check, nil
+	}
+	check.ID = parseTags(check.Record)["id"]
+
+	if err := s.fetchMTASTSPolicy(fqdn, &check); err != nil {
+		check.Error = err.Error()
+		return check, nil
+	}
+	if check.ID == "" {
+		check.Error = "no policy id (id=)"
+		return check, nil
+	}
+	if check.Mode == "none" {
+		return check, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*s.timeout)
+	defer cancel()
+	mxs, err := s.resolver.LookupMX(ctx, fqdn)
+	if err != nil && !IsNotFound(err) {
+		return check, &lookupError{name: fqdn, err: err}
+	}
+	for _, mx := range mxs {
+		host := strings.ToLower(strings.TrimSuffix(mx.Host, "."))
+		if !mxCovered(host, check.MX) {
+			check.Error = fmt.Sprintf("the policy doesn't cover the MX host %s", host)
+			break
+		}
+	}
+	return check, nil
+}
+
+// fetchMTASTSPolicy fetches the MTA-STS policy of a domain from https://mta-sts.<domain>/.well-known/mta-sts.txt
+func (s *MailSecurityService) fetchMTASTSPolicy(fqdn string, check *configuration.MTASTSCheck) error {
+	resp, err := s.client.Get("https://mta-sts." + fqdn + "/.well-known/mta-sts.txt")
+	if err != nil {
+		return fmt.Errorf("the policy can't be fetched: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("fetching the policy returned status %d", resp.StatusCode)
+	}
+
+	version := ""
+	maxAge := ""
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, maxMTASTSPolicySize))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "version":
+			version = value
+		case "mode":
+			check.Mode = strings.ToLower(value)
+		case "mx":
+			check.MX = append(check.MX, strings.ToLower(value))
+		case "max_age":
+			maxAge = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("the policy can't be read: %w", err)
+	}
+
+	if version != "STSv1" {
+		return errors.New("the policy has no version STSv1")
+	}
+	switch check.Mode {
+	case "enforce", "testing", "none":
+	case "":
+		return errors.New("the policy has no mode")
+	default:
+		return fmt.Errorf("the policy has an unknown mode %q", check.Mode)
+	}
+	age, err := strconv.Atoi(maxAge)
+	if err != nil || age < 0 {
+		return errors.New("the policy has no valid max_age")
+	}
+	check.MaxAge = age
+	if len(check.MX) == 0 && check.Mode != "none" {
+		return errors.New("the policy has no mx")
+	}
+	return nil
+}
+
+// mxCovered reports if an MX host matches one of the MX patterns of an MTA-STS policy. A wildcard only matches a
+// single label, "*.example.com" matches "mx.example.com" but not "a.mx.example.com".
+func mxCovered(host string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if wildcard, ok := strings.CutPrefix(pattern, "*."); ok {
+			label, rest, found := strings.Cut(host, ".")
+			if found && label != "" && rest == wildcard {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTLSRPT finds the TLS reporting record of a domain
+func (s *MailSecurityService) checkTLSRPT(fqdn string) (configuration.TLSRPTCheck, error) {
+	check := configuration.TLSRPTCheck{}
+	records, err := s.txtRecords("_smtp._tls."+fqdn, hasVersion("TLSRPTv1"))
+	if err != nil || len(records) == 0 {
+		return check, err
+	}
+	check.Record = records[0]
+	if len(records) > 1 {
+		check.Error = "more than one TLS-RPT record"
+		return check, nil
+	}
+	check.Reports = splitURIs(parseTags(check.Record)["rua"])
+	if len(check.Reports) == 0 {
+		check.Error = "no report address (rua=)"
+	}
+	for _, uri := range check.Reports {
+		if !strings.HasPrefix(uri, "mailto:") && !strings.HasPrefix(uri, "https:") {
+			check.Error = fmt.Sprintf("report address %s isn't a mailto: or https: URI", uri)
+			break
+		}
+	}
+	return check, nil
+}
+
+// checkBIMI finds the default BIMI record of a domain
+func (s *MailSecurityService) checkBIMI(fqdn string) (configuration.BIMICheck, error) {
+	check := configuration.BIMICheck{}
+	records, err := s.txtRecords("default._bimi."+fqdn, hasVersion("BIMI1"))
+	if err != nil || len(records) == 0 {
+		return check, err
+	}
+	check.Record = records[0]
+	if len(records) > 1 {
+		check.Error = "more than one BIMI record"
+		return check, nil
+	}
+	tags := parseTags(check.Record)
+	check.Logo, check.Authority = tags["l"], tags["a"]
+	switch {
+	case check.Logo == "" && check.Authority == "":
+		check.Error = "the record declines BIMI (empty l=)"
+	case check.Logo != "" && !strings.HasPrefix(check.Logo, "https://"):
+		check.Error = "the logo isn't an https URL"
+	}
+	return check, nil
+}
@@ -0,0 +1,507 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+// Template key of the email security regression alerts
+const TemplateKeyMailSecurity = "mailsecurity"
+
+// Interval between the email security checks, unless scheduler.mailSecurityInterval is set
+const DefaultMailSecurityInterval = 24 * time.Hour
+
+// MailSecurityInterval returns the interval between the email security checks
+func MailSecurityInterval(scheduler configuration.SchedulerConfiguration) time.Duration {
+	if scheduler.MailSecurityInterval <= 0 {
+		return DefaultMailSecurityInterval
+	}
+	return time.Duration(scheduler.MailSecurityInterval) * time.Hour
+}
+
+// MailSecurityResult is the result of checking the email security records of a monitored domain
+type MailSecurityResult struct {
+	// The checked domain
+	Domain configuration.Domain `json:"domain"`
+	// The new report, with the results of the last complete check if this one couldn't be completed
+	Report configuration.MailSecurityReport `json:"report"`
+	// The report of the last complete check before this one, nil if there was none
+	Previous *configuration.MailSecurityReport `json:"previous,omitempty"`
+	// What got weaker since the previous report, these are alerted
+	Regressions []string `json:"regressions"`
+}
+
+// MailSecurityService checks the SPF, DMARC, DKIM, MTA-STS, TLS-RPT and BIMI records of the monitored domains
+type MailSecurityService struct {
+	store   *configuration.MailSecurityStorage
+	domains *configuration.DomainConfiguration
+	config  configuration.MailSecurityConfiguration
+	// Looks up the records
+	resolver Resolver
+	timeout  time.Duration
+	// Fetches the MTA-STS policies
+	client *http.Client
+	// One check at a time, so a regression isn't reported twice
+	checking sync.Mutex
+}
+
+func NewMailSecurityService(store *configuration.MailSecurityStorage, domains *configuration.DomainConfiguration, config configuration.ConfigurationFile) *MailSecurityService {
+	return &MailSecurityService{
+		store:    store,
+		domains:  domains,
+		config:   config.MailSecurity,
+		resolver: NewResolver(config.DNS),
+		timeout:  DNSTimeout(config.DNS),
+		client:   newMTASTSClient(),
+	}
+}
+
+// UseResolver replaces the resolver of the records, e.g. with a fake in tests
+func (s *MailSecurityService) UseResolver(resolver Resolver) {
+	s.resolver = resolver
+}
+
+// UseClient replaces the client fetching the MTA-STS policies, e.g. with one dialing a local server in tests
+func (s *MailSecurityService) UseClient(client *http.Client) {
+	s.client = client
+}
+
+// Enabled reports if the email security records are checked on a schedule
+func (s *MailSecurityService) Enabled() bool {
+	return s.config.Enabled
+}
+
+// List returns the report of every checked domain
+func (s *MailSecurityService) List() []configuration.MailSecurityReport {
+	return s.store.List()
+}
+
+// Get returns the report of a domain, false if it wasn't checked yet
+func (s *MailSecurityService) Get(fqdn string) (configuration.MailSecurityReport, bool) {
+	return s.store.Get(strings.ToLower(strings.TrimSpace(fqdn)))
+}
+
+// Check checks the email security records of a monitored domain now
+func (s *MailSecurityService) Check(fqdn string, now time.Time) (MailSecurityResult, error) {
+	fqdn = strings.ToLower(strings.TrimSpace(fqdn))
+	for _, domain := range s.domains.List() {
+		if domain.FQDN == fqdn && domain.Monitored() {
+			s.checking.Lock()
+			defer s.checking.Unlock()
+
+			result := s.check(domain, now)
+			s.store.Flush()
+			return result, nil
+		}
+	}
+	return MailSecurityResult{}, ErrDomainNotMonitored
+}
+
+// CheckAll checks the email security records of every monitored domain. The reports of domains that were removed are
+// forgotten, paused and archived domains keep theirs.
+func (s *MailSecurityService) CheckAll(now time.Time) []MailSecurityResult {
+	s.checking.Lock()
+	defer s.checking.Unlock()
+
+	known := map[string]bool{}
+	results := []MailSecurityResult{}
+	for _, domain := range s.domains.List() {
+		known[domain.FQDN] = true
+		if domain.Monitored() {
+			results = append(results, s.check(domain, now))
+		}
+	}
+	for _, report := range s.store.List() {
+		if !known[report.FQDN] && s.store.Forget(report.FQDN) {
+			log.Printf("🗑 Forgot the mail security report of %s, it is no longer in the domain list", report.FQDN)
+		}
+	}
+	s.store.Flush()
+	return results
+}
+
+// Selectors returns the DKIM selectors checked for a domain: its own, or mailSecurity.dkimSelectors
+func (s *MailSecurityService) Selectors(domain configuration.Domain) []string {
+	if len(domain.DKIMSelectors) > 0 {
+		return domain.DKIMSelectors
+	}
+	selectors := []string{}
+	for _, selector := range s.config.DKIMSelectors {
+		if selector = strings.ToLower(strings.TrimSpace(selector)); selector != "" {
+			selectors = append(selectors, selector)
+		}
+	}
+	return selectors
+}
+
+// check looks up the records of a domain, scores them and compares them with the last complete check
+func (s *MailSecurityService) check(domain configuration.Domain, now time.Time) MailSecurityResult {
+	report := configuration.MailSecurityReport{FQDN: domain.FQDN, CheckedAt: now}
+	if err := s.inspect(domain, &report); err != nil {
+		report.Error = err.Error()
+		s.store.Record(report)
+		log.Printf("❌ Failed to check the mail security of %s: %s", domain.FQDN, err)
+		stored, _ := s.store.Get(domain.FQDN)
+		return MailSecurityResult{Domain: domain, Report: stored, Regressions: []string{}}
+	}
+
+	report.Succeeded = now
+	ScoreMailSecurity(&report)
+	result := MailSecurityResult{Domain: domain, Report: report, Regressions: []string{}}
+	if previous, ok := s.store.Record(report); ok {
+		result.Previous = &previous
+		result.Regressions = MailSecurityRegressions(previous, report)
+	}
+	log.Printf("📧 Mail security of %s scored %d (%d findings, %d regressions)", domain.FQDN, report.Score, len(report.Findings), len(result.Regressions))
+	return result
+}
+
+// inspect looks up every record of a domain into the report. Returns an error if a lookup failed, the records are
+// unknown then.
+func (s *MailSecurityService) inspect(domain configuration.Domain, report *configuration.MailSecurityReport) error {
+	var err error
+	if report.SPF, err = s.checkSPF(domain.FQDN); err != nil {
+		return err
+	}
+	if report.DMARC, err = s.checkDMARC(domain.FQDN); err != nil {
+		return err
+	}
+	for _, selector := range s.Selectors(domain) {
+		check, err := s.checkDKIM(domain.FQDN, selector)
+		if err != nil {
+			return err
+		}
+		report.DKIM = append(report.DKIM, check)
+	}
+	if report.MTASTS, err = s.checkMTASTS(domain.FQDN); err != nil {
+		return err
+	}
+	if report.TLSRPT, err = s.checkTLSRPT(domain.FQDN); err != nil {
+		return err
+	}
+	report.BIMI, err = s.checkBIMI(domain.FQDN)
+	return err
+}
+
+// ScoreMailSecurity scores the records of a report from 0 to 100 and lists what lowers the score. SPF counts 25
+// points, DMARC 35, DKIM 20, MTA-STS 10, TLS-RPT and BIMI 5 each. DKIM is left out when no selectors were checked.
+func ScoreMailSecurity(report *configuration.MailSecurityReport) {
+	points, possible := 0.0, 0.0
+	findings := []string{}
+
+	possible += 25
+	switch {
+	case report.SPF.Record == "":
+		findings = append(findings, "No SPF record")
+	case report.SPF.Error != "":
+		findings = append(findings, "SPF record is invalid: "+report.SPF.Error)
+	default:
+		points += 15
+		switch report.SPF.All {
+		case "-all":
+			points += 10
+		case "~all":
+			points += 8
+			findings = append(findings, "SPF ends in ~all (soft fail), -all is stricter")
+		case "+all":
+			findings = append(findings, "SPF ends in +all, anyone may send mail for the domain")
+		case "?all":
+			findings = append(findings, "SPF ends in ?all (neutral)")
+		default:
+			findings = append(findings, "SPF doesn't end in an all mechanism")
+		}
+	}
+
+	possible += 35
+	switch {
+	case report.DMARC.Record == "":
+		findings = append(findings, "No DMARC record")
+	case report.DMARC.Error != "":
+		findings = append(findings, "DMARC record is invalid: "+report.DMARC.Error)
+	default:
+		points += 10
+		switch report.DMARC.Policy {
+		case "reject":
+			points += 25 * float64(report.DMARC.Percent) / 100
+		case "quarantine":
+			points += 15 * float64(report.DMARC.Percent) / 100
+			findings = append(findings, "DMARC policy is p=quarantine, p=reject is stricter")
+		case "none":
+			findings = append(findings, "DMARC policy is p=none, spoofed mail is still delivered")
+		}
+		if report.DMARC.Percent < 100 && report.DMARC.Policy != "none" {
+			findings = append(findings, fmt.Sprintf("DMARC policy only applies to %d%% of the mail", report.DMARC.Percent))
+		}
+		if len(report.DMARC.Reports) == 0 {
+			findings = append(findings, "DMARC has no aggregate report address (rua=)")
+		}
+	}
+
+	if len(report.DKIM) > 0 {
+		possible += 20
+		valid := 0
+		for _, key := range report.DKIM {
+			switch {
+			case key.Revoked:
+				findings = append(findings, "DKIM key "+key.Selector+" is revoked")
+			case key.Error != "":
+				findings = append(findings, "DKIM key "+key.Selector+": "+key.Error)
+			default:
+				valid++
+				if key.KeyType == "rsa" && key.KeyBits < 2048 {
+					findings = append(findings, fmt.Sprintf("DKIM key %s is only %d bits, use 2048", key.Selector, key.KeyBits))
+				}
+			}
+		}
+		points += 20 * float64(valid) / float64(len(report.DKIM))
+	}
+
+	possible += 10
+	switch {
+	case report.MTASTS.Record == "":
+		findings = append(findings, "No MTA-STS policy")
+	case report.MTASTS.Error != "":
+		findings = append(findings, "MTA-STS: "+report.MTASTS.Error)
+	case report.MTASTS.Mode == "enforce":
+		points += 10
+	case report.MTASTS.Mode == "testing":
+		points += 5
+		findings = append(findings, "MTA-STS is in testing mode")
+	default:
+		findings = append(findings, "MTA-STS mode is none")
+	}
+
+	possible += 5
+	switch {
+	case report.TLSRPT.Record == "":
+		findings = append(findings, "No TLS-RPT record")
+	case report.TLSRPT.Error != "":
+		findings = append(findings, "TLS-RPT record is invalid: "+report.TLSRPT.Error)
+	default:
+		points += 5
+	}
+
+	possible += 5
+	switch {
+	case report.BIMI.Record == "":
+		findings = append(findings, "No BIMI record")
+	case report.BIMI.Error != "":
+		findings = append(findings, "BIMI: "+report.BIMI.Error)
+	case report.DMARC.Policy != "quarantine" && report.DMARC.Policy != "reject":
+		findings = append(findings, "BIMI needs a DMARC policy of quarantine or reject")
+	default:
+		points += 5
+	}
+
+	report.Score = int(math.Round(points * 100 / possible))
+	report.Findings = findings
+}
+
+// dmarcRank orders the DMARC policies from missing or invalid (-1) to reject (2)
+func dmarcRank(check configuration.DMARCCheck) int {
+	if !check.Valid() {
+		return -1
+	}
+	return map[string]int{"none": 0, "quarantine": 1, "reject": 2}[check.Policy]
+}
+
+// spfAllRank orders the final SPF mechanisms from +all (0) to -all (3)
+func spfAllRank(all string) int {
+	return map[string]int{"+all": 0, "": 1, "?all": 1, "~all": 2, "-all": 3}[all]
+}
+
+// mtaSTSRank orders the MTA-STS modes from missing or invalid (-1) to enforce (2)
+func mtaSTSRank(check configuration.MTASTSCheck) int {
+	if !check.Valid() {
+		return -1
+	}
+	return map[string]int{"none": 0, "testing": 1, "enforce": 2}[check.Mode]
+}
+
+// recordLoss describes a record that was valid and no longer is, empty if it still is
+func recordLoss(name string, record string, err string, valid bool) string {
+	switch {
+	case valid:
+		return ""
+	case record == "":
+		return name + " record was removed"
+	}
+	return name + " record became invalid: " + err
+}
+
+// MailSecurityRegressions lists what got weaker between two complete reports of a domain, e.g. the DMARC policy
+// dropping from p=reject to p=none or a DKIM key disappearing
+func MailSecurityRegressions(previous configuration.MailSecurityReport, current configuration.MailSecurityReport) []string {
+	regressions := []string{}
+
+	if previous.SPF.Valid() {
+		if loss := recordLoss("SPF", current.SPF.Record, current.SPF.Error, current.SPF.Valid()); loss != "" {
+			regressions = append(regressions, loss)
+		} else if spfAllRank(current.SPF.All) < spfAllRank(previous.SPF.All) {
+			regressions = append(regressions, fmt.Sprintf("SPF weakened from %s to %s", allLabel(previous.SPF.All), allLabel(current.SPF.All)))
+		}
+	}
+
+	if rank := dmarcRank(current.DMARC); rank < dmarcRank(previous.DMARC) {
+		if loss := recordLoss("DMARC", current.DMARC.Record, current.DMARC.Error, rank >= 0); loss != "" {
+			regressions = append(regressions, loss)
+		} else {
+			regressions = append(regressions, fmt.Sprintf("DMARC policy weakened from p=%s to p=%s", previous.DMARC.Policy, current.DMARC.Policy))
+		}
+	} else if rank > 0 && rank == dmarcRank(previous.DMARC) && current.DMARC.Percent < previous.DMARC.Percent {
+		regressions = append(regressions, fmt.Sprintf("DMARC policy now applies to %d%% of the mail (was %d%%)", current.DMARC.Percent, previous.DMARC.Percent))
+	}
+
+	for _, before := range previous.DKIM {
+		if !before.Valid() {
+			continue
+		}
+		for _, after := range current.DKIM {
+			switch {
+			case after.Selector != before.Selector || after.Valid():
+			case after.Revoked:
+				regressions = append(regressions, "DKIM key "+after.Selector+" was revoked")
+			default:
+				regressions = append(regressions, "DKIM key "+after.Selector+": "+after.Error)
+			}
+		}
+	}
+
+	if rank := mtaSTSRank(current.MTASTS); rank < mtaSTSRank(previous.MTASTS) {
+		if loss := recordLoss("MTA-STS", current.MTASTS.Record, current.MTASTS.Error, rank >= 0); loss != "" {
+			regressions = append(regressions, loss)
+		} else {
+			regressions = append(regressions, fmt.Sprintf("MTA-STS mode weakened from %s to %s", previous.MTASTS.Mode, current.MTASTS.Mode))
+		}
+	}
+
+	if previous.TLSRPT.Valid() {
+		if loss := recordLoss("TLS-RPT", current.TLSRPT.Record, current.TLSRPT.Error, current.TLSRPT.Valid()); loss != "" {
+			regressions = append(regressions, loss)
+		}
+	}
+	if previous.BIMI.Valid() {
+		if loss := recordLoss("BIMI", current.BIMI.Record, current.BIMI.Error, current.BIMI.Valid()); loss != "" {
+			regressions = append(regressions, loss)
+		}
+	}
+	return regressions
+}
+
+// allLabel names a final SPF mechanism, a record without one is neutral
+func allLabel(all string) string {
+	if all == "" {
+		return "no all mechanism"
+	}
+	return all
+}
+
+// MailSecurityTemplateData is the data available to the mail security alert templates
+type MailSecurityTemplateData struct {
+	// Application name, for signatures
+	AppName string
+	// Human readable alert, e.g. "Email security of example.com regressed"
+	Alert string
+	// Always "mailsecurity"
+	AlertKey string
+	// The monitored domain
+	Domain configuration.Domain
+	FQDN   string
+	Name   string
+	// What got weaker since the last check
+	Regressions []string
+	Count       int
+	// The score and grade now and after the last check
+	Score         int
+	Grade         string
+	PreviousScore int
+	PreviousGrade string
+	// Everything that lowers the score now
+	Findings []string
+	// Link to the dashboard, empty if no base URL is configured
+	DashboardURL string
+	// When the message was rendered
+	Now time.Time
+}
+
+// NewMailSecurityTemplateData builds the template data for the regressions of a domain
+func NewMailSecurityTemplateData(result MailSecurityResult, baseURL string, now time.Time) MailSecurityTemplateData {
+	data := MailSecurityTemplateData{
+		AppName:     "Domain Monitor",
+		AlertKey:    TemplateKeyMailSecurity,
+		Domain:      result.Domain,
+		FQDN:        result.Domain.FQDN,
+		Name:        result.Domain.Name,
+		Regressions: result.Regressions,
+		Count:       len(result.Regressions),
+		Score:       result.Report.Score,
+		Grade:       result.Report.Grade(),
+		Findings:    result.Report.Findings,
+		Now:         now,
+	}
+	if data.Name == "" {
+		data.Name = data.FQDN
+	}
+	data.PreviousScore, data.PreviousGrade = data.Score, data.Grade
+	if result.Previous != nil {
+		data.PreviousScore, data.PreviousGrade = result.Previous.Score, result.Previous.Grade()
+	}
+	data.Alert = "Email security of " + data.FQDN + " regressed"
+	if baseURL != "" {
+		data.DashboardURL = strings.TrimRight(baseURL, "/") + "/"
+	}
+	return data
+}
+
+// NotifyMailSecurity queues the alert for the regressions of a domain, to the recipients of the domain. Returns who it
+// was queued for.
+func NotifyMailSecurity(notifications *NotificationService, config configuration.ConfigurationFile, result MailSecurityResult, now time.Time) ([]string, error) {
+	// The records aren't about the expiration, so the escalation recipients are left out
+	recipients := ResolveRecipients(config, result.Domain, math.Inf(1))
+	if recipients.Empty() {
+		log.Printf("⚠️ No recipients for the mail security alert of %s, configure alerts.admin or domain owners", result.Domain.FQDN)
+		return nil, nil
+	}
+
+	data := NewMailSecurityTemplateData(result, config.App.BaseURL, now)
+	rendered, err := notifications.Render(TemplateKeyMailSecurity, data)
+	if err != nil {
+		log.Printf("❌ Failed to render the mail security alert for %s: %s", result.Domain.FQDN, err)
+		return nil, err
+	}
+
+	// Each check compares with the one before, so its regressions are alerted once
+	keyParts := append([]string{TemplateKeyMailSecurity, result.Domain.FQDN}, result.Regressions...)
+	keyParts = append(keyParts, result.Report.Succeeded.Format(time.RFC3339))
+	item := configuration.QueuedNotification{FQDN: result.Domain.FQDN, Alert: TemplateKeyMailSecurity}
+	return enqueue(notifications, recipients, rendered, data, item, keyParts)
+}
+
+// NotifyMailSecurities queues the alerts of the domains whose email security regressed and have alerts turned on.
+// Nothing is queued without a mailer or notifier webhooks. Returns the number of queued alerts.
+func NotifyMailSecurities(notifications *NotificationService, config configuration.ConfigurationFile, results []MailSecurityResult, now time.Time) int {
+	if notifications == nil || !notifications.Enabled() {
+		return 0
+	}
+	queued := 0
+	for _, result := range results {
+		if len(result.Regressions) == 0 || !result.Domain.Alerts {
+			continue
+		}
+		received, err := NotifyMailSecurity(notifications, config, result, now)
+		if err != nil {
+			log.Printf("❌ Failed to queue the mail security alert for %s: %s", result.Domain.FQDN, err)
+		}
+		if len(received) > 0 {
+			queued++
+		}
+	}
+	return queued
+}
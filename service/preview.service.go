@@ -0,0 +1,254 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+// ErrNoPreviewDomain is returned by Preview when there is no domain to build the sample of an expiry alert from
+var ErrNoPreviewDomain = errors.New("no domain to preview")
+
+// PreviewSource is what the sample data of the template previews is built from
+type PreviewSource struct {
+	Domains    *configuration.DomainConfiguration
+	WhoisCache *configuration.WhoisCacheStorage
+	// Domain of the sample, by default the first monitored one (the first one with a cached WHOIS entry for the expiry
+	// alerts)
+	FQDN string
+	// Availability the name of the watchlist sample changes into: available, registered or pendingdelete
+	Availability string
+	BaseURL      string
+	Now          time.Time
+}
+
+// Preview renders a template with sample data built from the monitored domains
+func (t *TemplateService) Preview(key string, p PreviewSource) (RenderedMessage, error) {
+	template, ok := findTemplate(key)
+	if !ok {
+		return RenderedMessage{}, ErrUnknownTemplate
+	}
+	data, err := template.sample(p)
+	if err != nil {
+		return RenderedMessage{}, err
+	}
+	return t.Render(key, data)
+}
+
+// The monitored domain to build a sample for, a made up one if FQDN isn't monitored
+func (p PreviewSource) domain() configuration.Domain {
+	for _, d := range p.Domains.List() {
+		if d.FQDN == p.FQDN || (p.FQDN == "" && d.Monitored()) {
+			return d
+		}
+	}
+	if p.FQDN != "" {
+		return configuration.Domain{FQDN: p.FQDN}
+	}
+	return configuration.Domain{FQDN: "example.com"}
+}
+
+// Build a digest of every cached domain as if all the thresholds it has crossed were due. If no domain is within the
+// alert thresholds, every domain with a known expiration date is listed instead.
+func previewDigest(p PreviewSource) DigestTemplateData {
+	now := p.Now
+	all := configuration.AlertsConfiguration{Send2MonthAlert: true, Send1MonthAlert: true, Send2WeekAlert: true, Send1WeekAlert: true, Send3DayAlert: true, SendStatusAlerts: true}
+	statuses := []ExpiryStatus{}
+	known := []ExpiryStatus{}
+	for _, domain := range p.Domains.List() {
+		entry := p.WhoisCache.Get(domain.FQDN)
+		if entry == nil {
+			continue
+		}
+		// Evaluate a copy, so the sent flags of the cached entry don't hide any threshold
+		fresh := *entry
+		fresh.Sent2MonthAlert, fresh.Sent1MonthAlert, fresh.Sent2WeekAlert, fresh.Sent1WeekAlert, fresh.Sent3DayAlert = false, false, false, false, false
+		fresh.SentStatusAlerts = nil
+		domain.Alerts = true
+		status := EvaluateExpiration(domain, &fresh, all, now)
+		if status.Problem != "" {
+			continue
+		}
+		if len(status.Due) > 0 {
+			statuses = append(statuses, status)
+		}
+		status.Due = []configuration.Alert{configuration.Alert2Months}
+		known = append(known, status)
+	}
+	if len(statuses) == 0 {
+		statuses = known
+	}
+	return NewDigest(statuses, p.BaseURL, now)
+}
+
+// Build a watchlist alert for a made up change of a name, by default example.net becoming available
+func previewWatch(p PreviewSource) WatchTemplateData {
+	fqdn, now := p.FQDN, p.Now
+	if fqdn == "" {
+		fqdn = "example.net"
+	}
+	change := WatchChange{
+		Domain:   configuration.WatchedDomain{FQDN: fqdn, Availability: configuration.WatchAvailable, LastChanged: &now},
+		Previous: configuration.WatchPendingDelete,
+	}
+	switch p.Availability {
+	case configuration.WatchRegistered:
+		expiration := now.AddDate(1, 0, 0)
+		change.Domain.Availability, change.Domain.Registrar, change.Domain.Expiration = p.Availability, "Example Registrar, Inc.", &expiration
+		change.Domain.Status = []string{"clientTransferProhibited"}
+		change.Previous = configuration.WatchAvailable
+	case configuration.WatchPendingDelete:
+		change.Domain.Availability, change.Domain.Status = p.Availability, []string{"pendingDelete"}
+		change.Previous = configuration.WatchRegistered
+	}
+	return NewWatchTemplateData(change, p.BaseURL, now)
+}
+
+// Build a lookalike alert for a made up omission and IDN homoglyph of a domain, by default the first monitored one
+func previewLookalike(p PreviewSource) LookalikeTemplateData {
+	domain, now := p.domain(), p.Now
+
+	created := now.AddDate(0, 0, -2)
+	result := LookalikeResult{Domain: domain, Scan: configuration.LookalikeScan{FQDN: domain.FQDN, ScannedAt: now}}
+	kinds := map[string]bool{configuration.PermutationOmission: true, configuration.PermutationIDN: true}
+	for _, permutation := range configuration.Permutations(domain.FQDN, nil) {
+		if !kinds[permutation.Kind] {
+			continue
+		}
+		delete(kinds, permutation.Kind)
+		result.Appeared = append(result.Appeared, configuration.Lookalike{
+			FQDN: permutation.FQDN, Unicode: permutation.Unicode, Of: domain.FQDN, Kind: permutation.Kind,
+			Addresses: []string{"192.0.2.10"}, Registrar: "Example Registrar, Inc.", Created: &created,
+			FirstSeen: now, LastSeen: now, Active: true,
+		})
+	}
+	return NewLookalikeTemplateData(result, p.BaseURL, now)
+}
+
+// Build a certificate alert for a made up certificate from a new issuer that also covers a name outside the monitored
+// domains, for a domain that is by default the first monitored one
+func previewCertificate(p PreviewSource) CertificateTemplateData {
+	domain, now := p.domain(), p.Now
+
+	certificate := configuration.Certificate{
+		ID: 1, Of: domain.FQDN, Issuer: "C=US, O=Example CA, CN=Example Issuing CA 1", CommonName: domain.FQDN,
+		Names: []string{domain.FQDN, "login." + domain.FQDN, "login.example.net"}, Serial: "04a1b2c3d4e5f6",
+		NotBefore: now.AddDate(0, 0, -1), NotAfter: now.AddDate(0, 0, 89), LoggedAt: now.AddDate(0, 0, -1), FirstSeen: now,
+		NewIssuer: true, UnexpectedNames: []string{"login.example.net"},
+	}
+	result := CertificateResult{
+		Domain:     domain,
+		Poll:       configuration.CertificatePoll{FQDN: domain.FQDN, PolledAt: now, Succeeded: now, Found: 1, New: 1},
+		New:        []configuration.Certificate{certificate},
+		Suspicious: []configuration.Certificate{certificate},
+	}
+	return NewCertificateTemplateData(result, p.BaseURL, now)
+}
+
+// Build a mail security alert for a made up check of a domain, by default the first monitored one, whose DMARC policy
+// dropped from p=reject to p=none and whose DKIM key was revoked
+func previewMailSecurity(p PreviewSource) MailSecurityTemplateData {
+	domain, now := p.domain(), p.Now
+
+	previous := configuration.MailSecurityReport{
+		FQDN: domain.FQDN, CheckedAt: now.AddDate(0, 0, -1), Succeeded: now.AddDate(0, 0, -1),
+		SPF:    configuration.SPFCheck{Record: "v=spf1 include:_spf.example.net -all", Lookups: 2, All: "-all"},
+		DMARC:  configuration.DMARCCheck{Record: "v=DMARC1; p=reject; rua=mailto:dmarc@" + domain.FQDN, Policy: "reject", SubdomainPolicy: "reject", Percent: 100, Reports: []string{"mailto:dmarc@" + domain.FQDN}},
+		DKIM:   []configuration.DKIMCheck{{Selector: "selector1", KeyType: "rsa", KeyBits: 2048}},
+		TLSRPT: configuration.TLSRPTCheck{Record: "v=TLSRPTv1; rua=mailto:tls@" + domain.FQDN, Reports: []string{"mailto:tls@" + domain.FQDN}},
+	}
+	ScoreMailSecurity(&previous)
+	current := previous
+	current.CheckedAt, current.Succeeded = now, now
+	current.DMARC.Record, current.DMARC.Policy, current.DMARC.SubdomainPolicy = "v=DMARC1; p=none; rua=mailto:dmarc@"+domain.FQDN, "none", "none"
+	current.DKIM = []configuration.DKIMCheck{{Selector: "selector1", KeyType: "rsa", Revoked: true}}
+	ScoreMailSecurity(&current)
+
+	result := MailSecurityResult{
+		Domain:      domain,
+		Report:      current,
+		Previous:    &previous,
+		Regressions: MailSecurityRegressions(previous, current),
+	}
+	return NewMailSecurityTemplateData(result, p.BaseURL, now)
+}
+
+// Build a DNSSEC alert for a made up check of a domain, by default the first monitored one, whose DS record no longer
+// matches a key after a key rollover
+func previewDNSSEC(p PreviewSource) DNSSECTemplateData {
+	domain, now := p.domain(), p.Now
+
+	previous := configuration.DNSSECReport{
+		FQDN: domain.FQDN, CheckedAt: now.Add(-12 * time.Hour), Succeeded: now.Add(-12 * time.Hour),
+		Status:  configuration.DNSSECSecure,
+		DS:      []configuration.DSRecord{{KeyTag: 2371, Algorithm: 13, DigestType: 2, Digest: "C988EC423E3880EB8DD8A46E9B2B6F1D3A7E2B0C7D4F8E3B4D5C6A7B8C9D0E1F", Matched: true}},
+		Keys:    []configuration.DNSKEYRecord{{KeyTag: 2371, Flags: 257, Algorithm: 13}, {KeyTag: 34505, Flags: 256, Algorithm: 13}},
+		Expires: now.AddDate(0, 0, 9),
+	}
+	current := previous
+	current.CheckedAt, current.Succeeded = now, now
+	current.Status = configuration.DNSSECBroken
+	current.DS = []configuration.DSRecord{{KeyTag: 2371, Algorithm: 13, DigestType: 2, Digest: previous.DS[0].Digest}}
+	current.Keys = []configuration.DNSKEYRecord{{KeyTag: 11942, Flags: 257, Algorithm: 13}, {KeyTag: 34505, Flags: 256, Algorithm: 13}}
+	current.Findings = []string{
+		"No DS record of the parent zone matches a key of the zone",
+		"DS record 2371 of the parent zone doesn't match a key of the zone",
+	}
+
+	result := DNSSECResult{
+		Domain:   domain,
+		Report:   current,
+		Previous: &previous,
+		Problems: DNSSECProblems(&previous, current, DNSSECExpiryWarning(configuration.DNSSECConfiguration{}), now),
+	}
+	return NewDNSSECTemplateData(result, p.BaseURL, now)
+}
+
+// Build a delegation alert for a made up check of a domain, by default the first monitored one, after a migration to
+// a DNS provider that doesn't serve the zone on one of its nameservers yet
+func previewDelegation(p PreviewSource) DelegationTemplateData {
+	domain, now := p.domain(), p.Now
+
+	nameservers := []string{"ns1.dns-provider.example", "ns2.dns-provider.example"}
+	previous := configuration.DelegationReport{
+		FQDN: domain.FQDN, CheckedAt: now.Add(-6 * time.Hour), Succeeded: now.Add(-6 * time.Hour),
+		Status: configuration.DelegationHealthy, ParentZone: "com", Parent: nameservers, Registry: nameservers,
+	}
+	current := previous
+	current.CheckedAt, current.Succeeded = now, now
+	listed := []string{configuration.ListedRegistry, configuration.ListedParent, configuration.ListedZone}
+	current.Nameservers = []configuration.NameserverCheck{
+		{Host: nameservers[0], Listed: listed, Addresses: []string{"192.0.2.1"}, Authoritative: true, Serial: 2024060101, NameServers: nameservers},
+		{Host: nameservers[1], Listed: listed[:2], Addresses: []string{"192.0.2.2"}, Lame: true, Error: "192.0.2.2: answered REFUSED"},
+	}
+	EvaluateDelegation(&current)
+
+	result := DelegationResult{
+		Domain:   domain,
+		Report:   current,
+		Previous: &previous,
+		Problems: DelegationProblems(&previous, current),
+	}
+	return NewDelegationTemplateData(result, p.BaseURL, now)
+}
+
+// Build an alert for a cached domain, by default the first one with a cached WHOIS entry
+func previewAlert(alert configuration.Alert, p PreviewSource) (AlertTemplateData, error) {
+	for _, domain := range p.Domains.List() {
+		if p.FQDN != "" && domain.FQDN != p.FQDN {
+			continue
+		}
+		entry := p.WhoisCache.Get(domain.FQDN)
+		if entry == nil && p.FQDN == "" {
+			continue
+		}
+		status := EvaluateExpiration(domain, entry, configuration.AlertsConfiguration{}, p.Now)
+		return NewAlertTemplateData(status, alert, p.BaseURL, p.Now), nil
+	}
+	if p.FQDN != "" {
+		return AlertTemplateData{}, fmt.Errorf("%w: %s not found", ErrNoPreviewDomain, p.FQDN)
+	}
+	return AlertTemplateData{}, fmt.Errorf("%w, no domain has a cached WHOIS entry", ErrNoPreviewDomain)
+}
@@ -0,0 +1,231 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+// Retry policy of the notification queue: the delay doubles after every failed attempt, from the base up to the maximum.
+// After QueueMaxAttempts failed attempts the notification is dead until it is retried from the UI or API.
+const (
+	QueueMaxAttempts = 8
+	queueBaseBackoff = time.Minute
+	queueMaxBackoff  = 2 * time.Hour
+)
+
+// ErrNoMailer is the delivery error of e-mail notifications while no SMTP mailer is configured
+var ErrNoMailer = errors.New("no SMTP mailer configured")
+
+// NotificationService delivers the notifications in the durable queue and records each attempt in the alert ledger
+type NotificationService struct {
+	queue  *configuration.NotificationQueueStorage
+	ledger *configuration.AlertLedgerStorage
+	mailer *MailerService
+	// Deliver to the notifier webhooks of the domains, which doesn't need a mailer
+	webhooks bool
+	// Signs the acknowledge and snooze links in alert messages, nil for no links
+	snoozes *SnoozeService
+	// Only one delivery run at a time, so a notification is never attempted twice concurrently
+	processing sync.Mutex
+}
+
+func NewNotificationService(queue *configuration.NotificationQueueStorage, ledger *configuration.AlertLedgerStorage, mailer *MailerService) *NotificationService {
+	return &NotificationService{queue: queue, ledger: ledger, mailer: mailer}
+}
+
+// UseSnoozeLinks adds signed acknowledge and snooze links to the alert messages
+func (s *NotificationService) UseSnoozeLinks(snoozes *SnoozeService) {
+	s.snoozes = snoozes
+}
+
+// UseWebhooks turns on the delivery to the notifier webhooks of the domains
+func (s *NotificationService) UseWebhooks(enabled bool) {
+	s.webhooks = enabled
+}
+
+// Enabled reports if notifications can be delivered, by mail over the configured SMTP mailer or to the notifier
+// webhooks of the domains
+func (s *NotificationService) Enabled() bool {
+	return s.mailer != nil || s.webhooks
+}
+
+// IdempotencyKey derives a compact key from the parts that make a notification unique
+func IdempotencyKey(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Backoff returns the delay before the next attempt after the given number of failed attempts
+func Backoff(attempts int) time.Duration {
+	delay := queueBaseBackoff
+	for i := 1; i < attempts && delay < queueMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > queueMaxBackoff {
+		delay = queueMaxBackoff
+	}
+	return delay
+}
+
+// Render renders a template with the templates of the mailer
+func (s *NotificationService) Render(key string, data interface{}) (RenderedMessage, error) {
+	if s.mailer == nil {
+		return NewTemplateService("").Render(key, data)
+	}
+	return s.mailer.Render(key, data)
+}
+
+// Enqueue adds a notification to the queue. Returns false if it was already queued under the same idempotency key.
+func (s *NotificationService) Enqueue(item configuration.QueuedNotification) bool {
+	queued, added := s.queue.Enqueue(item)
+	if !added {
+		log.Printf("♻️ %s for %s over %s was already queued (%s), skipping", item.Alert, describeTarget(item), item.Channel, queued.State)
+		return false
+	}
+	log.Printf("📥 Queued %s for %s over %s", item.Alert, describeTarget(item), item.Channel)
+	return true
+}
+
+// List returns the queued notifications in a state (all if empty), newest first
+func (s *NotificationService) List(state string) []configuration.QueuedNotification {
+	return s.queue.List(state)
+}
+
+// Process attempts every notification that is due. Returns the number delivered and the number that failed.
+func (s *NotificationService) Process(now time.Time) (int, int) {
+	if !s.processing.TryLock() {
+		return 0, 0
+	}
+	defer s.processing.Unlock()
+
+	sent, failed := 0, 0
+	for _, item := range s.queue.Due(now) {
+		if s.attempt(item) {
+			sent++
+		} else {
+			failed++
+		}
+	}
+	return sent, failed
+}
+
+// Retry schedules a dead (or pending) notification for immediate delivery with a new round of attempts
+func (s *NotificationService) Retry(id string) (configuration.QueuedNotification, error) {
+	item, ok := s.queue.Get(id)
+	if !ok {
+		return item, errors.New("unknown notification " + id)
+	}
+	if item.State == configuration.QueueSent {
+		return item, errors.New("notification " + id + " was already delivered")
+	}
+	item.State = configuration.QueuePending
+	item.Retries++
+	item.NextAttempt = time.Now()
+	s.queue.Update(item)
+	log.Printf("🔁 Retrying %s for %s over %s", item.Alert, describeTarget(item), item.Channel)
+	return item, nil
+}
+
+// Discard removes a notification that wasn't delivered, the ledger keeps it as failed
+func (s *NotificationService) Discard(id string) error {
+	item, ok := s.queue.Get(id)
+	if !ok {
+		return errors.New("unknown notification " + id)
+	}
+	if item.State == configuration.QueueSent {
+		return errors.New("notification " + id + " was already delivered")
+	}
+	s.queue.Remove(id)
+	item.LastError = "discarded: " + item.LastError
+	s.ledger.Save(ledgerRecord(item, configuration.DeliveryFailed))
+	log.Printf("🗑️ Discarded %s for %s over %s", item.Alert, describeTarget(item), item.Channel)
+	return nil
+}
+
+// Prune drops delivered notifications past their retention
+func (s *NotificationService) Prune(now time.Time) {
+	s.queue.Prune(now)
+}
+
+// attempt delivers a notification once and updates its state. Returns true if it was delivered.
+func (s *NotificationService) attempt(item configuration.QueuedNotification) bool {
+	err := s.deliver(item)
+	item.Attempts++
+
+	status := configuration.DeliverySent
+	if err == nil {
+		item.State = configuration.QueueSent
+		item.LastError = ""
+	} else {
+		item.LastError = err.Error()
+		if item.Attempts >= QueueMaxAttempts*(item.Retries+1) {
+			item.State = configuration.QueueDead
+			status = configuration.DeliveryFailed
+			log.Printf("💀 Giving up on %s for %s over %s after %d attempts: %s", item.Alert, describeTarget(item), item.Channel, item.Attempts, err)
+		} else {
+			delay := Backoff(item.Attempts - QueueMaxAttempts*item.Retries)
+			item.NextAttempt = time.Now().Add(delay)
+			status = configuration.DeliveryRetrying
+			log.Printf("⏳ Delivery of %s for %s over %s failed (attempt %d), retrying in %s: %s", item.Alert, describeTarget(item), item.Channel, item.Attempts, delay, err)
+		}
+	}
+
+	s.queue.Update(item)
+	s.ledger.Save(ledgerRecord(item, status))
+	return err == nil
+}
+
+func (s *NotificationService) deliver(item configuration.QueuedNotification) error {
+	switch item.Channel {
+	case configuration.ChannelEmail:
+		if s.mailer == nil {
+			return ErrNoMailer
+		}
+		return s.mailer.SendMessage(Recipients{To: item.To, CC: item.CC}, RenderedMessage{Subject: item.Subject, Text: item.Text, HTML: item.HTML})
+	case configuration.ChannelWebhook:
+		if err := PostWebhookPayload(item.Webhook, []byte(item.Payload)); err != nil {
+			return err
+		}
+		log.Printf("🪝 Notification posted to %s", WebhookLabel(item.Webhook))
+		return nil
+	}
+	return errors.New("unknown channel " + item.Channel)
+}
+
+// ledgerRecord describes the current state of a queued notification for the alert ledger
+func ledgerRecord(item configuration.QueuedNotification, status string) configuration.AlertRecord {
+	record := configuration.AlertRecord{
+		ID:         item.ID,
+		FQDN:       item.FQDN,
+		Domains:    item.Domains,
+		Alert:      item.Alert,
+		Channel:    item.Channel,
+		Recipients: append(append([]string{}, item.To...), item.CC...),
+		Subject:    item.Subject,
+		Status:     status,
+		Attempts:   item.Attempts,
+		CreatedAt:  item.CreatedAt,
+		UpdatedAt:  time.Now(),
+	}
+	if status != configuration.DeliverySent {
+		record.Error = item.LastError
+	}
+	if item.Channel == configuration.ChannelWebhook {
+		record.Recipients = []string{WebhookLabel(item.Webhook)}
+	}
+	return record
+}
+
+func describeTarget(item configuration.QueuedNotification) string {
+	if item.FQDN != "" {
+		return item.FQDN
+	}
+	return strings.Join(item.Domains, ", ")
+}
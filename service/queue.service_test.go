@@ -0,0 +1,146 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, time.Minute},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{5, 16 * time.Minute},
+		{7, 64 * time.Minute},
+		// 128 minutes is capped at the maximum
+		{8, 2 * time.Hour},
+		{QueueMaxAttempts * 4, 2 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := Backoff(tt.attempts); got != tt.want {
+			t.Errorf("Backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+// newTestNotificationService queues in a temporary data directory. Without a mailer every e-mail fails with
+// ErrNoMailer, webhooks are delivered to the returned servers, one accepting and one failing every request.
+func newTestNotificationService(t *testing.T) (*NotificationService, configuration.ConfigDirectory, string, string) {
+	t.Helper()
+	accepting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(accepting.Close)
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(failing.Close)
+
+	dir := configuration.ConfigDirectory{DataDir: t.TempDir()}
+	s := NewNotificationService(dir.ReadNotificationQueue(), dir.ReadAlertLedger(), nil)
+	s.UseWebhooks(true)
+	return s, dir, accepting.URL, failing.URL
+}
+
+func TestAttempt(t *testing.T) {
+	tests := []struct {
+		name     string
+		channel  string
+		accepted bool
+		attempts int
+		retries  int
+		// State after the attempt, with the delay before the next one and the status in the ledger
+		wantState  string
+		wantDelay  time.Duration
+		wantStatus string
+	}{
+		{"delivered", configuration.ChannelWebhook, true, 0, 0, configuration.QueueSent, 0, configuration.DeliverySent},
+		{"delivered after failures", configuration.ChannelWebhook, true, 5, 0, configuration.QueueSent, 0, configuration.DeliverySent},
+		{"first failure", configuration.ChannelWebhook, false, 0, 0, configuration.QueuePending, time.Minute, configuration.DeliveryRetrying},
+		{"later failure", configuration.ChannelEmail, false, 4, 0, configuration.QueuePending, 16 * time.Minute, configuration.DeliveryRetrying},
+		{"last failure before giving up", configuration.ChannelEmail, false, QueueMaxAttempts - 2, 0, configuration.QueuePending, 64 * time.Minute, configuration.DeliveryRetrying},
+		{"gives up", configuration.ChannelEmail, false, QueueMaxAttempts - 1, 0, configuration.QueueDead, 0, configuration.DeliveryFailed},
+		{"first failure after a retry", configuration.ChannelEmail, false, QueueMaxAttempts, 1, configuration.QueuePending, time.Minute, configuration.DeliveryRetrying},
+		{"gives up after a retry", configuration.ChannelWebhook, false, 2*QueueMaxAttempts - 1, 1, configuration.QueueDead, 0, configuration.DeliveryFailed},
+		{"gives up after two retries", configuration.ChannelEmail, false, 3*QueueMaxAttempts - 1, 2, configuration.QueueDead, 0, configuration.DeliveryFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, dir, accepting, failing := newTestNotificationService(t)
+			item := configuration.QueuedNotification{Key: "key", Channel: tt.channel, FQDN: "example.com", Alert: "1 week alert", To: []string{"admin@example.com"}, Subject: "example.com expires in 1 week"}
+			item.Webhook = failing
+			if tt.accepted {
+				item.Webhook = accepting
+			}
+			item, _ = s.queue.Enqueue(item)
+			item.Attempts, item.Retries, item.LastError = tt.attempts, tt.retries, "earlier failure"
+
+			before := time.Now()
+			if delivered := s.attempt(item); delivered != tt.accepted {
+				t.Errorf("attempt() = %v, want %v", delivered, tt.accepted)
+			}
+			after := time.Now()
+
+			// The state is written to the queue file
+			got, ok := dir.ReadNotificationQueue().Get(item.ID)
+			if !ok {
+				t.Fatal("the notification is missing from the queue file")
+			}
+			if got.State != tt.wantState || got.Attempts != tt.attempts+1 || got.Retries != tt.retries {
+				t.Errorf("state %s after %d attempts (%d retries), want %s after %d", got.State, got.Attempts, got.Retries, tt.wantState, tt.attempts+1)
+			}
+			if tt.accepted && got.LastError != "" {
+				t.Errorf("kept the error %q of a delivered notification", got.LastError)
+			}
+			if !tt.accepted && (got.LastError == "" || got.LastError == "earlier failure") {
+				t.Errorf("last error = %q, want the error of this attempt", got.LastError)
+			}
+			if tt.wantDelay > 0 && (got.NextAttempt.Before(before.Add(tt.wantDelay)) || got.NextAttempt.After(after.Add(tt.wantDelay))) {
+				t.Errorf("next attempt in %s, want %s", got.NextAttempt.Sub(before).Round(time.Second), tt.wantDelay)
+			}
+
+			records := s.ledger.Find(configuration.AlertLedgerFilter{})
+			if len(records) != 1 || records[0].ID != item.ID || records[0].Status != tt.wantStatus || records[0].Attempts != tt.attempts+1 {
+				t.Errorf("ledger = %+v, want one %s record of %d attempts", records, tt.wantStatus, tt.attempts+1)
+			}
+		})
+	}
+}
+
+// Notifications that failed are picked up from the queue file after a restart, on their next attempt
+func TestQueueSurvivesRestart(t *testing.T) {
+	s, dir, accepting, failing := newTestNotificationService(t)
+	s.Enqueue(configuration.QueuedNotification{Key: "key", Channel: configuration.ChannelWebhook, Webhook: failing, FQDN: "example.com", Alert: "1 week alert", Payload: `{"text": "line one\nline two"}`})
+	if sent, failed := s.Process(time.Now()); sent != 0 || failed != 1 {
+		t.Fatalf("Process() = %d sent, %d failed, want the failure", sent, failed)
+	}
+
+	restarted := NewNotificationService(dir.ReadNotificationQueue(), dir.ReadAlertLedger(), nil)
+	restarted.UseWebhooks(true)
+	pending := restarted.List(configuration.QueuePending)
+	if len(pending) != 1 || pending[0].Attempts != 1 || !strings.Contains(pending[0].LastError, "503") {
+		t.Fatalf("pending after a restart: %+v, want the failed notification", pending)
+	}
+	if sent, failed := restarted.Process(time.Now()); sent != 0 || failed != 0 {
+		t.Errorf("Process() before the next attempt = %d sent, %d failed, want nothing attempted", sent, failed)
+	}
+
+	// The webhook is fixed in the meantime
+	item := pending[0]
+	item.Webhook = accepting
+	restarted.queue.Update(item)
+	if sent, failed := restarted.Process(item.NextAttempt); sent != 1 || failed != 0 {
+		t.Errorf("Process() at the next attempt = %d sent, %d failed, want it delivered", sent, failed)
+	}
+	got, _ := dir.ReadNotificationQueue().Get(item.ID)
+	if got.State != configuration.QueueSent || got.Attempts != 2 || got.Payload != item.Payload {
+		t.Errorf("read back %+v, want it sent after 2 attempts", got)
+	}
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+// Recipients of an alert, resolved from the alert configuration and the domain routing
+type Recipients struct {
+	To []string `json:"to"`
+	CC []string `json:"cc,omitempty"`
+	// Webhook URL of the domain notifier, empty if none
+	Webhook string `json:"webhook,omitempty"`
+}
+
+// Addresses returns every email address, the TO addresses first
+func (r Recipients) Addresses() []string {
+	return append(append([]string{}, r.To...), r.CC...)
+}
+
+// Empty reports if there is nobody to notify
+func (r Recipients) Empty() bool {
+	return len(r.To) == 0 && len(r.CC) == 0 && r.Webhook == ""
+}
+
+// key identifies recipients that can share one digest mail
+func (r Recipients) key() string {
+	return strings.ToLower(strings.Join(r.To, ",") + "|" + strings.Join(r.CC, ",") + "|" + r.Webhook)
+}
+
+// DefaultRecipients returns the recipients of alerts for domains without owners: the admin and the configured
+// recipients, with contact groups expanded
+func DefaultRecipients(config configuration.ConfigurationFile) []string {
+	entries := []string{}
+	if config.Alerts.Admin != "" {
+		entries = append(entries, config.Alerts.Admin)
+	}
+	return config.ExpandRecipients(append(entries, config.Alerts.Recipients...))
+}
+
+// ResolveRecipients decides who receives the alerts of a domain. The owners of the domain replace the default
+// recipients, the recipients of the tag routes matching its tags are added, the escalation recipients are added once
+// the domain expires within the escalation days, and the CC list of the domain is copied.
+func ResolveRecipients(config configuration.ConfigurationFile, domain configuration.Domain, daysLeft float64) Recipients {
+	var to []string
+	if len(domain.Owners) > 0 {
+		to = config.ExpandRecipients(domain.Owners)
+	} else {
+		to = DefaultRecipients(config)
+	}
+	if tagged := config.TagRecipients(domain); len(tagged) > 0 {
+		to = config.ExpandRecipients(append(to, tagged...))
+	}
+	if config.Alerts.EscalationDays > 0 && daysLeft <= float64(config.Alerts.EscalationDays) {
+		to = config.ExpandRecipients(append(to, config.Alerts.EscalationRecipients...))
+	}
+
+	inTo := map[string]bool{}
+	for _, address := range to {
+		inTo[strings.ToLower(address)] = true
+	}
+	cc := []string{}
+	for _, address := range config.ExpandRecipients(domain.CC) {
+		if !inTo[strings.ToLower(address)] {
+			cc = append(cc, address)
+		}
+	}
+	// A mail needs a TO address, so a CC-only domain mails its CC list directly
+	if len(to) == 0 {
+		to, cc = cc, nil
+	}
+	if len(cc) == 0 {
+		cc = nil
+	}
+
+	return Recipients{To: to, CC: cc, Webhook: domain.Notifier}
+}
+
+// NotifyAlert queues an alert for the resolved recipients of the domain, by mail and to its notifier webhook. The
+// idempotency keys make sure the same alert is never queued twice for the same recipients, so it can be marked as sent
+// as soon as it is queued. Returns who it was queued for (webhooks as "webhook:<host>").
+func NotifyAlert(notifications *NotificationService, config configuration.ConfigurationFile, status ExpiryStatus, alert configuration.Alert, now time.Time) ([]string, error) {
+	recipients := ResolveRecipients(config, status.Domain, status.DaysLeft)
+	if recipients.Empty() {
+		log.Printf("⚠️ No recipients for the %s of %s, configure alerts.admin or domain owners", alert, status.Domain.FQDN)
+		return nil, nil
+	}
+	data := NewAlertTemplateData(status, alert, config.App.BaseURL, now)
+	if notifications.snoozes != nil {
+		data.AcknowledgeURL = notifications.snoozes.ActionURL(status.Domain.FQDN, "", configuration.SnoozeAcknowledge, now)
+		data.SnoozeURL = notifications.snoozes.ActionURL(status.Domain.FQDN, data.AlertKey, configuration.SnoozeSnooze, now)
+	}
+	rendered, err := notifications.Render(data.AlertKey, data)
+	if err != nil {
+		log.Printf("❌ Failed to render %s for %s: %s", alert, status.Domain.FQDN, err)
+		return nil, err
+	}
+
+	// A renewal moves the expiration date, which starts a new series of alerts. Daily alerts are unique per day, and so
+	// are the status alerts, which are sent again when a domain enters the status again.
+	keyParts := []string{"alert", status.Domain.FQDN, data.AlertKey}
+	if status.Expiration != nil {
+		keyParts = append(keyParts, status.Expiration.Format("2006-01-02"))
+	}
+	if alert == configuration.AlertDaily || alert.IsStatusAlert() {
+		keyParts = append(keyParts, now.Format("2006-01-02"))
+	}
+
+	item := configuration.QueuedNotification{FQDN: status.Domain.FQDN, Alert: alert.String()}
+	return enqueue(notifications, recipients, rendered, data, item, keyParts)
+}
+
+// NotifyDigest queues one digest per distinct set of recipients and marks the included alerts as sent. Returns the
+// number of domains that were included in a queued digest.
+func NotifyDigest(notifications *NotificationService, config configuration.ConfigurationFile, statuses []ExpiryStatus, now time.Time) int {
+	groups := map[string][]ExpiryStatus{}
+	routes := map[string]Recipients{}
+	for _, status := range statuses {
+		if len(status.Due) == 0 {
+			continue
+		}
+		recipients := ResolveRecipients(config, status.Domain, status.DaysLeft)
+		if recipients.Empty() {
+			log.Printf("⚠️ No recipients for the alerts of %s, configure alerts.admin or domain owners", status.Domain.FQDN)
+			continue
+		}
+		key := recipients.key()
+		groups[key] = append(groups[key], status)
+		routes[key] = recipients
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	queued := 0
+	for _, key := range keys {
+		digest := NewDigest(groups[key], config.App.BaseURL, now)
+		rendered, err := notifications.Render(TemplateKeyDigest, digest)
+		if err != nil {
+			log.Printf("❌ Failed to render digest: %s", err)
+			continue
+		}
+
+		item := configuration.QueuedNotification{Alert: TemplateKeyDigest}
+		keyParts := []string{TemplateKeyDigest, now.Format("2006-01-02")}
+		for _, status := range groups[key] {
+			item.Domains = append(item.Domains, status.Domain.FQDN)
+			for _, alert := range status.Due {
+				keyParts = append(keyParts, status.Domain.FQDN+":"+TemplateKey(alert))
+			}
+		}
+		received, _ := enqueue(notifications, routes[key], rendered, digest, item, keyParts)
+		if len(received) == 0 {
+			continue
+		}
+
+		for _, status := range groups[key] {
+			for _, alert := range status.Due {
+				status.Entry.MarkAlertSentTo(alert, received)
+			}
+		}
+		queued += digest.Count
+	}
+	return queued
+}
+
+// enqueue queues a rendered message by mail and to the webhook of the recipients. Notifications that were already
+// queued under the same key count as queued.
+func enqueue(notifications *NotificationService, recipients Recipients, rendered RenderedMessage, data interface{}, item configuration.QueuedNotification, keyParts []string) ([]string, error) {
+	item.Subject = rendered.Subject
+	queued := []string{}
+
+	if len(recipients.To) > 0 && notifications.mailer == nil {
+		log.Printf("📵 No mailer configured, %s for %s isn't mailed", item.Alert, describeTarget(item))
+	} else if len(recipients.To) > 0 {
+		email := item
+		email.Channel = configuration.ChannelEmail
+		email.To, email.CC = recipients.To, recipients.CC
+		email.Text, email.HTML = rendered.Text, rendered.HTML
+		email.Key = IdempotencyKey(append(keyParts, email.Channel, strings.ToLower(strings.Join(recipients.Addresses(), ",")))...)
+		notifications.Enqueue(email)
+		queued = append(queued, recipients.Addresses()...)
+	}
+
+	if recipients.Webhook != "" {
+		payload, err := WebhookPayload(rendered, data)
+		if err != nil {
+			return queued, err
+		}
+		hook := item
+		hook.Channel = configuration.ChannelWebhook
+		hook.Webhook = recipients.Webhook
+		hook.Payload = string(payload)
+		hook.Key = IdempotencyKey(append(keyParts, hook.Channel, recipients.Webhook)...)
+		notifications.Enqueue(hook)
+		queued = append(queued, WebhookLabel(recipients.Webhook))
+	}
+
+	return queued, nil
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+// clockRange is a range of the day in minutes after midnight. The end is exclusive, a range with an end before its
+// start spans midnight.
+type clockRange struct {
+	start, end int
+	set        bool
+}
+
+func newClockRange(start string, end string) clockRange {
+	startHour, startMinute, err := configuration.ParseClock(start)
+	if err != nil {
+		return clockRange{}
+	}
+	endHour, endMinute, err := configuration.ParseClock(end)
+	if err != nil {
+		return clockRange{}
+	}
+	r := clockRange{start: startHour*60 + startMinute, end: endHour*60 + endMinute}
+	r.set = r.start != r.end
+	return r
+}
+
+// contains reports if the time of day falls in the range
+func (r clockRange) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if r.start < r.end {
+		return minute >= r.start && minute < r.end
+	}
+	return minute >= r.start || minute < r.end
+}
+
+// nextClock returns the next time after t at the given minute of the day
+func nextClock(t time.Time, minute int) time.Time {
+	next := time.Date(t.Year(), t.Month(), t.Day(), minute/60, minute%60, 0, 0, t.Location())
+	if !next.After(t) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// AlertSchedule decides when alerts may be sent: daily alerts only inside the send window, and alerts that aren't
+// critical not during the quiet hours. Times of day are in the scheduler timezone.
+type AlertSchedule struct {
+	Location *time.Location
+	window   clockRange
+	quiet    clockRange
+}
+
+func NewAlertSchedule(scheduler configuration.SchedulerConfiguration) AlertSchedule {
+	return AlertSchedule{
+		Location: SchedulerLocation(scheduler),
+		window:   newClockRange(scheduler.AlertWindowStart, scheduler.AlertWindowEnd),
+		quiet:    newClockRange(scheduler.QuietHoursStart, scheduler.QuietHoursEnd),
+	}
+}
+
+// Now returns the current time in the scheduler timezone
+func (s AlertSchedule) Now() time.Time {
+	return time.Now().In(s.Location)
+}
+
+// Allowed reports if an alert may be sent at the given time
+func (s AlertSchedule) Allowed(alert configuration.Alert, now time.Time) bool {
+	now = now.In(s.Location)
+	if alert == configuration.AlertDaily && s.window.set && !s.window.contains(now) {
+		return false
+	}
+	if !IsCriticalAlert(alert) && s.quiet.set && s.quiet.contains(now) {
+		return false
+	}
+	return true
+}
+
+// Hold moves the due alerts that may not be sent right now from Due to Held. They stay unsent, so the next check
+// after the quiet hours or inside the send window picks them up.
+func (s AlertSchedule) Hold(statuses []ExpiryStatus, now time.Time) []ExpiryStatus {
+	for i := range statuses {
+		due := []configuration.Alert{}
+		for _, alert := range statuses[i].Due {
+			if s.Allowed(alert, now) {
+				due = append(due, alert)
+				continue
+			}
+			log.Printf("🌙 Holding back the %s of %s until %s", alert, statuses[i].Domain.FQDN, s.NextAllowed(alert, now).Format("2006-01-02 15:04 MST"))
+			statuses[i].Held = append(statuses[i].Held, alert)
+		}
+		statuses[i].Due = due
+	}
+	return statuses
+}
+
+// NextAllowed returns when an alert that is held back at the given time may be sent
+func (s AlertSchedule) NextAllowed(alert configuration.Alert, now time.Time) time.Time {
+	now = now.In(s.Location)
+	if alert == configuration.AlertDaily && s.window.set && !s.window.contains(now) {
+		return nextClock(now, s.window.start)
+	}
+	if !IsCriticalAlert(alert) && s.quiet.set && s.quiet.contains(now) {
+		return nextClock(now, s.quiet.end)
+	}
+	return now
+}
+
+// NextCheck returns when the expiry check should run next: after the interval, or earlier when the send window opens
+// or the quiet hours end, so held alerts don't wait for a whole interval
+func (s AlertSchedule) NextCheck(now time.Time, interval time.Duration) time.Time {
+	now = now.In(s.Location)
+	next := now.Add(interval)
+	if s.window.set {
+		if start := nextClock(now, s.window.start); start.Before(next) {
+			next = start
+		}
+	}
+	if s.quiet.set {
+		if end := nextClock(now, s.quiet.end); end.Before(next) {
+			next = end
+		}
+	}
+	return next
+}
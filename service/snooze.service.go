@@ -0,0 +1,269 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+// Default length of a snooze, and of an acknowledgement of a domain without a known expiration date
+const (
+	DefaultSnoozeDays      = 7
+	DefaultAcknowledgeDays = 30
+)
+
+// How long the acknowledge and snooze links in alert e-mails can be used
+const SnoozeLinkValidity = 30 * 24 * time.Hour
+
+// ErrInvalidSnoozeLink is returned for a signed link that was changed, has expired, or was signed with another key
+var ErrInvalidSnoozeLink = errors.New("invalid or expired link")
+
+// SnoozeRequest describes a new acknowledgement or snooze, from the API, the dashboard or a signed link
+type SnoozeRequest struct {
+	// Template key of the alert type (e.g. "daily"), empty for every alert of the domain
+	Alert string `json:"alert" form:"alert"`
+	// configuration.SnoozeAcknowledge or configuration.SnoozeSnooze (the default)
+	Kind string `json:"kind" form:"kind"`
+	// Silence the alerts for this many days
+	Days int `json:"days" form:"days"`
+	// Silence the alerts until this date or RFC 3339 time, instead of Days
+	Until string `json:"until" form:"until"`
+	Note  string `json:"note" form:"note"`
+	By    string `json:"by" form:"by"`
+}
+
+// SnoozeAction is the content of a signed acknowledge or snooze link
+type SnoozeAction struct {
+	FQDN  string `json:"d"`
+	Alert string `json:"a,omitempty"`
+	Kind  string `json:"k"`
+	// Unix time the link expires
+	Expires int64 `json:"e"`
+}
+
+// SnoozeService creates and evaluates the acknowledgements and snoozes of alerts, and signs the links to them
+type SnoozeService struct {
+	store *configuration.SnoozeStorage
+	// Key for signing the links, links are disabled without one
+	secret []byte
+	// Base URL of the web app, links are disabled without one
+	baseURL string
+}
+
+func NewSnoozeService(store *configuration.SnoozeStorage, config configuration.ConfigurationFile) *SnoozeService {
+	return &SnoozeService{
+		store:   store,
+		secret:  []byte(config.App.LinkSecret),
+		baseURL: strings.TrimRight(config.App.BaseURL, "/"),
+	}
+}
+
+// Create validates a request and stores the snooze for a domain with the given expiration date (nil if unknown).
+// Without an end time, acknowledgements last until the expiration date and snoozes for DefaultSnoozeDays.
+func (s *SnoozeService) Create(fqdn string, expiration *time.Time, request SnoozeRequest, now time.Time) (configuration.Snooze, error) {
+	snooze := configuration.Snooze{
+		FQDN:      strings.ToLower(strings.TrimSpace(fqdn)),
+		Alert:     strings.TrimSpace(request.Alert),
+		Kind:      strings.TrimSpace(request.Kind),
+		Note:      strings.TrimSpace(request.Note),
+		By:        strings.TrimSpace(request.By),
+		CreatedAt: now,
+	}
+	if snooze.Kind == "" {
+		snooze.Kind = configuration.SnoozeSnooze
+	}
+	if snooze.Kind != configuration.SnoozeSnooze && snooze.Kind != configuration.SnoozeAcknowledge {
+		return snooze, snoozeError("kind", fmt.Sprintf("must be %q or %q, got %q", configuration.SnoozeAcknowledge, configuration.SnoozeSnooze, snooze.Kind))
+	}
+	if _, ok := AlertForTemplateKey(snooze.Alert); snooze.Alert != "" && !ok {
+		return snooze, snoozeError("alert", fmt.Sprintf("must be empty (every alert) or an alert type, got %q", snooze.Alert))
+	}
+	if snooze.Kind == configuration.SnoozeAcknowledge {
+		snooze.Expiration = expiration
+	}
+
+	switch {
+	case request.Until != "":
+		until, err := time.Parse(time.RFC3339, request.Until)
+		if err != nil {
+			// A date silences the alerts until the end of that day
+			until, err = time.ParseInLocation("2006-01-02", request.Until, now.Location())
+			until = until.AddDate(0, 0, 1)
+		}
+		if err != nil {
+			return snooze, snoozeError("until", "must be a date (YYYY-MM-DD) or an RFC 3339 time")
+		}
+		snooze.Until = until
+	case request.Days < 0:
+		return snooze, snoozeError("days", "must not be negative")
+	case request.Days > 0:
+		snooze.Until = now.AddDate(0, 0, request.Days)
+	case snooze.Kind == configuration.SnoozeAcknowledge && expiration != nil && expiration.After(now):
+		snooze.Until = *expiration
+	case snooze.Kind == configuration.SnoozeAcknowledge:
+		snooze.Until = now.AddDate(0, 0, DefaultAcknowledgeDays)
+	default:
+		snooze.Until = now.AddDate(0, 0, DefaultSnoozeDays)
+	}
+	if !snooze.Until.After(now) {
+		return snooze, snoozeError("until", "must be in the future")
+	}
+
+	snooze = s.store.Add(snooze)
+	log.Printf("🔕 %s %s of %s until %s (by %s)", kindVerb(snooze.Kind), SnoozeScope(snooze.Alert), snooze.FQDN, snooze.Until.Format("2006-01-02 15:04"), snoozeAuthor(snooze.By))
+	return snooze, nil
+}
+
+// Remove deletes a snooze, so the alerts it silenced are sent again
+func (s *SnoozeService) Remove(id string) bool {
+	return s.store.Remove(id)
+}
+
+// List returns the snoozes of a domain (every domain if fqdn is empty), newest first
+func (s *SnoozeService) List(fqdn string) []configuration.Snooze {
+	return s.store.List(fqdn)
+}
+
+// Active returns the snoozes of a domain that still silence alerts, newest first
+func (s *SnoozeService) Active(fqdn string, expiration *time.Time, now time.Time) []configuration.Snooze {
+	active := []configuration.Snooze{}
+	if s == nil {
+		return active
+	}
+	for _, snooze := range s.store.List(fqdn) {
+		if snooze.ActiveAt(now, expiration) {
+			active = append(active, snooze)
+		}
+	}
+	return active
+}
+
+// Apply moves the due alerts that are silenced by an active snooze from Due to Snoozed
+func (s *SnoozeService) Apply(statuses []ExpiryStatus, now time.Time) []ExpiryStatus {
+	if s == nil {
+		return statuses
+	}
+	for i := range statuses {
+		if len(statuses[i].Due) == 0 {
+			continue
+		}
+		active := s.Active(statuses[i].Domain.FQDN, statuses[i].Expiration, now)
+		if len(active) == 0 {
+			continue
+		}
+		due := []configuration.Alert{}
+		for _, alert := range statuses[i].Due {
+			if snoozed(active, TemplateKey(alert)) {
+				log.Printf("🔕 Skipping the %s of %s, it is snoozed", alert, statuses[i].Domain.FQDN)
+				statuses[i].Snoozed = append(statuses[i].Snoozed, alert)
+				continue
+			}
+			due = append(due, alert)
+		}
+		statuses[i].Due = due
+	}
+	return statuses
+}
+
+// Prune removes the snoozes that ended a while ago
+func (s *SnoozeService) Prune(now time.Time) {
+	if removed := s.store.Prune(now); removed > 0 {
+		log.Printf("🧹 Removed %d ended snoozes", removed)
+	}
+}
+
+// LinksEnabled reports if signed links can be created, which needs a base URL and a signing key
+func (s *SnoozeService) LinksEnabled() bool {
+	return s != nil && s.baseURL != "" && len(s.secret) > 0
+}
+
+// ActionURL returns a signed link to acknowledge or snooze the alerts of a domain, empty if links are disabled
+func (s *SnoozeService) ActionURL(fqdn string, alertKey string, kind string, now time.Time) string {
+	if !s.LinksEnabled() {
+		return ""
+	}
+	token := s.SignAction(SnoozeAction{FQDN: fqdn, Alert: alertKey, Kind: kind, Expires: now.Add(SnoozeLinkValidity).Unix()})
+	return s.baseURL + "/snooze?token=" + url.QueryEscape(token)
+}
+
+// SignAction encodes an action as `<payload>.<signature>`, both base64url encoded
+func (s *SnoozeService) SignAction(action SnoozeAction) string {
+	payload, _ := json.Marshal(action)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.sign(encoded))
+}
+
+// VerifyAction checks the signature and expiry of a signed link and returns its action
+func (s *SnoozeService) VerifyAction(token string, now time.Time) (SnoozeAction, error) {
+	var action SnoozeAction
+	if len(s.secret) == 0 {
+		return action, ErrInvalidSnoozeLink
+	}
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return action, ErrInvalidSnoozeLink
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, s.sign(encoded)) {
+		return action, ErrInvalidSnoozeLink
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || json.Unmarshal(payload, &action) != nil {
+		return action, ErrInvalidSnoozeLink
+	}
+	if now.Unix() > action.Expires {
+		return action, ErrInvalidSnoozeLink
+	}
+	return action, nil
+}
+
+func (s *SnoozeService) sign(encoded string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+// snoozed reports if one of the snoozes covers an alert type
+func snoozed(snoozes []configuration.Snooze, alertKey string) bool {
+	for _, snooze := range snoozes {
+		if snooze.Covers(alertKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func snoozeError(key string, message string) error {
+	return configuration.ValidationErrors{{Section: "snooze", Key: key, Message: message}}
+}
+
+// SnoozeScope describes which alerts a snooze covers, e.g. "every alert" or "the daily alert"
+func SnoozeScope(alertKey string) string {
+	if alert, ok := AlertForTemplateKey(alertKey); ok {
+		return "the " + alert.String()
+	}
+	return "every alert"
+}
+
+func kindVerb(kind string) string {
+	if kind == configuration.SnoozeAcknowledge {
+		return "Acknowledged"
+	}
+	return "Snoozed"
+}
+
+func snoozeAuthor(by string) string {
+	if by == "" {
+		return "anonymous"
+	}
+	return by
+}
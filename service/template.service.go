@@ -0,0 +1,344 @@
+package service
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+// Default mail templates, operators can override each of them in the data directory
+//
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+// Name of the directory (inside the data directory) with template overrides
+const TemplateOverrideDir = "templates"
+
+// Parts of a mail message, each one is a separate template file `<key>.<part>.tmpl`
+const (
+	TemplatePartSubject = "subject"
+	TemplatePartText    = "txt"
+	TemplatePartHTML    = "html"
+)
+
+// Template key of the test mail
+const TemplateKeyTest = "test"
+
+// Template key shared by the one-time expiry alerts, used when there's no template for the specific alert
+const templateKeyExpiry = "expiry"
+
+// Template key shared by the registry status alerts, used when there's no template for the specific alert
+const templateKeyStatus = "status"
+
+// ErrUnknownTemplate is returned for a template key that isn't an alert type or the test mail
+var ErrUnknownTemplate = errors.New("unknown template")
+
+// RenderedMessage is a mail message rendered from the templates
+type RenderedMessage struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+// AlertTemplateData is the data available to the mail templates
+type AlertTemplateData struct {
+	// Application name, for signatures
+	AppName string
+	// Human readable alert type, e.g. "1 week alert"
+	Alert string
+	// Template key of the alert type, e.g. "1week"
+	AlertKey string
+	// The monitored domain
+	Domain configuration.Domain
+	// Shortcuts for the domain FQDN and display name (the FQDN if the domain has no name)
+	FQDN string
+	Name string
+	// Expiration date from WHOIS, nil if unknown
+	Expiration *time.Time
+	// Whole days left until the expiration date
+	DaysLeft int
+	// Registrar name from WHOIS, empty if unknown
+	Registrar string
+	// Registry status of the domain, as EPP codes with their meaning
+	Statuses []configuration.EPPStatus
+	// Formatted renewal price, empty if not set
+	RenewalPrice string
+	// Link to the dashboard, empty if no base URL is configured
+	DashboardURL string
+	// Signed links to acknowledge every alert of the domain until it is renewed, and to snooze this alert type, empty
+	// if no base URL is configured
+	AcknowledgeURL string
+	SnoozeURL      string
+	// When the message was rendered
+	Now time.Time
+}
+
+// TemplateService renders mail messages from the embedded templates and the overrides in the data directory
+type TemplateService struct {
+	overrideDir string
+}
+
+// NewTemplateService creates a template service reading overrides from `<dataDir>/templates`. With an empty dataDir
+// only the embedded templates are used.
+func NewTemplateService(dataDir string) *TemplateService {
+	if dataDir == "" {
+		return &TemplateService{}
+	}
+	return &TemplateService{overrideDir: filepath.Join(dataDir, TemplateOverrideDir)}
+}
+
+// TemplateKey returns the template key for an alert type
+func TemplateKey(alert configuration.Alert) string {
+	return [...]string{"2month", "1month", "2week", "1week", "3day", "daily", "hold", "redemption", "pendingdelete"}[alert]
+}
+
+// TemplateInfo describes a template key that can be rendered
+type TemplateInfo struct {
+	Key string
+	// What the messages of the template are sent for
+	Description string
+	// Builds the data of a preview, of the type Render expects for the key
+	sample func(p PreviewSource) (interface{}, error)
+}
+
+// Every template key that can be rendered, in the order they are listed
+var templateRegistry = newTemplateRegistry()
+
+func newTemplateRegistry() []TemplateInfo {
+	registry := []TemplateInfo{}
+	for _, alert := range configuration.AllAlerts {
+		registry = append(registry, TemplateInfo{
+			Key:         TemplateKey(alert),
+			Description: "The " + alert.String() + " of a domain",
+			sample:      func(p PreviewSource) (interface{}, error) { return previewAlert(alert, p) },
+		})
+	}
+	return append(registry,
+		TemplateInfo{TemplateKeyDigest, "Every due alert in one message", func(p PreviewSource) (interface{}, error) { return previewDigest(p), nil }},
+		TemplateInfo{TemplateKeyWatch, "A watched name changed its availability", func(p PreviewSource) (interface{}, error) { return previewWatch(p), nil }},
+		TemplateInfo{TemplateKeyLookalike, "New lookalikes of a domain were registered", func(p PreviewSource) (interface{}, error) { return previewLookalike(p), nil }},
+		TemplateInfo{TemplateKeyCertificate, "Suspicious certificates of a domain were logged", func(p PreviewSource) (interface{}, error) { return previewCertificate(p), nil }},
+		TemplateInfo{TemplateKeyMailSecurity, "The mail security of a domain regressed", func(p PreviewSource) (interface{}, error) { return previewMailSecurity(p), nil }},
+		TemplateInfo{TemplateKeyDNSSEC, "The DNSSEC chain of a domain has problems", func(p PreviewSource) (interface{}, error) { return previewDNSSEC(p), nil }},
+		TemplateInfo{TemplateKeyDelegation, "The delegation of a domain has problems", func(p PreviewSource) (interface{}, error) { return previewDelegation(p), nil }},
+		TemplateInfo{TemplateKeyTest, "The test mail", func(p PreviewSource) (interface{}, error) { return NewTestTemplateData(p.BaseURL, p.Now), nil }},
+	)
+}
+
+// Templates returns every template key that can be rendered
+func Templates() []TemplateInfo {
+	return append([]TemplateInfo{}, templateRegistry...)
+}
+
+// TemplateKeys returns every template key that can be rendered
+func TemplateKeys() []string {
+	keys := []string{}
+	for _, template := range templateRegistry {
+		keys = append(keys, template.Key)
+	}
+	return keys
+}
+
+func findTemplate(key string) (TemplateInfo, bool) {
+	for _, template := range templateRegistry {
+		if template.Key == key {
+			return template, true
+		}
+	}
+	return TemplateInfo{}, false
+}
+
+// AlertForTemplateKey returns the alert type of a template key, false for the test mail and unknown keys
+func AlertForTemplateKey(key string) (configuration.Alert, bool) {
+	for _, alert := range configuration.AllAlerts {
+		if TemplateKey(alert) == key {
+			return alert, true
+		}
+	}
+	return 0, false
+}
+
+// NewAlertTemplateData builds the template data for an alert from an evaluated domain
+func NewAlertTemplateData(status ExpiryStatus, alert configuration.Alert, baseURL string, now time.Time) AlertTemplateData {
+	data := AlertTemplateData{
+		AppName:    "Domain Monitor",
+		Alert:      alert.String(),
+		AlertKey:   TemplateKey(alert),
+		Domain:     status.Domain,
+		FQDN:       status.Domain.FQDN,
+		Name:       status.Domain.Name,
+		Expiration: status.Expiration,
+		DaysLeft:   int(status.DaysLeft),
+		Now:        now,
+	}
+	if data.Name == "" {
+		data.Name = data.FQDN
+	}
+	data.Registrar = RegistrarName(status.Entry)
+	data.Statuses = status.Entry.RegistryStatus().Statuses
+	data.RenewalPrice = RenewalPrice(status.Domain)
+	if baseURL != "" {
+		data.DashboardURL = strings.TrimRight(baseURL, "/") + "/"
+	}
+	return data
+}
+
+// RegistrarName returns the registrar name (or organization) of a WHOIS entry, empty if unknown
+func RegistrarName(entry *configuration.WhoisCache) string {
+	if entry == nil || entry.WhoisInfo.Registrar == nil {
+		return ""
+	}
+	if entry.WhoisInfo.Registrar.Name != "" {
+		return entry.WhoisInfo.Registrar.Name
+	}
+	return entry.WhoisInfo.Registrar.Organization
+}
+
+// RenewalPrice returns the formatted renewal price of a domain, empty if it has none
+func RenewalPrice(domain configuration.Domain) string {
+	if domain.RenewalPrice <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s%.2f", domain.Currency, domain.RenewalPrice)
+}
+
+// NewTestTemplateData builds the template data for the test mail
+func NewTestTemplateData(baseURL string, now time.Time) AlertTemplateData {
+	data := AlertTemplateData{AppName: "Domain Monitor", Alert: "test mail", AlertKey: TemplateKeyTest, Now: now}
+	if baseURL != "" {
+		data.DashboardURL = strings.TrimRight(baseURL, "/") + "/"
+	}
+	return data
+}
+
+// Render renders all parts of the message for a template key. The data has the type the sample of the key has in the
+// template registry, e.g. an AlertTemplateData for the alerts or a DigestTemplateData for the digest.
+func (t *TemplateService) Render(key string, data interface{}) (RenderedMessage, error) {
+	if _, ok := findTemplate(key); !ok {
+		return RenderedMessage{}, ErrUnknownTemplate
+	}
+
+	var msg RenderedMessage
+	var err error
+	if msg.Subject, err = t.renderText(key, TemplatePartSubject, data); err != nil {
+		return msg, err
+	}
+	// Subjects must be a single line
+	msg.Subject = strings.Join(strings.Fields(msg.Subject), " ")
+	if msg.Text, err = t.renderText(key, TemplatePartText, data); err != nil {
+		return msg, err
+	}
+	if msg.HTML, err = t.renderHTML(key, data); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+// Overridden reports which parts of a template key are overridden in the data directory
+func (t *TemplateService) Overridden(key string) []string {
+	parts := []string{}
+	if t.overrideDir == "" {
+		return parts
+	}
+	for _, part := range []string{TemplatePartSubject, TemplatePartText, TemplatePartHTML} {
+		if _, err := os.Stat(filepath.Join(t.overrideDir, templateFile(key, part))); err == nil {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+func (t *TemplateService) renderText(key string, part string, data interface{}) (string, error) {
+	name, source, err := t.source(key, part)
+	if err != nil {
+		return "", err
+	}
+	tmpl, err := texttemplate.New(name).Funcs(texttemplate.FuncMap(templateFuncs)).Parse(source)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse %s: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("unable to render %s: %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+func (t *TemplateService) renderHTML(key string, data interface{}) (string, error) {
+	name, source, err := t.source(key, TemplatePartHTML)
+	if err != nil {
+		return "", err
+	}
+	tmpl, err := htmltemplate.New(name).Funcs(htmltemplate.FuncMap(templateFuncs)).Parse(source)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse %s: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("unable to render %s: %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+// source finds the template for a part, the most specific one wins:
+//
+//  1. `<data>/templates/<key>.<part>.tmpl`
+//  2. the embedded template for the key
+//  3. `<data>/templates/expiry.<part>.tmpl` (one-time expiry alerts) or `status.<part>.tmpl` (registry status alerts)
+//  4. the embedded expiry or status template
+func (t *TemplateService) source(key string, part string) (string, string, error) {
+	keys := []string{key}
+	if alert, ok := AlertForTemplateKey(key); ok && alert.IsStatusAlert() {
+		keys = append(keys, templateKeyStatus)
+	} else if ok && alert != configuration.AlertDaily {
+		keys = append(keys, templateKeyExpiry)
+	}
+
+	for _, k := range keys {
+		name := templateFile(k, part)
+		if t.overrideDir != "" {
+			data, err := os.ReadFile(filepath.Join(t.overrideDir, name))
+			if err == nil {
+				return name, string(data), nil
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return "", "", err
+			}
+		}
+		if data, err := defaultTemplates.ReadFile("templates/" + name); err == nil {
+			return name, string(data), nil
+		}
+	}
+	return "", "", fmt.Errorf("no %s template for %s", part, key)
+}
+
+func templateFile(key string, part string) string {
+	return key + "." + part + ".tmpl"
+}
+
+// Functions available in the templates
+var templateFuncs = map[string]interface{}{
+	// Format a date, the layout defaults to 2006-01-02
+	"date": func(t *time.Time, layout ...string) string {
+		if t == nil {
+			return "an unknown date"
+		}
+		if len(layout) > 0 {
+			return t.Format(layout[0])
+		}
+		return t.Format("2006-01-02")
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"join":  strings.Join,
+}
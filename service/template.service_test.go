@@ -0,0 +1,127 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	whoisparser "github.com/likexian/whois-parser"
+	"github.com/nwesterhausen/domain-monitor/configuration"
+	"gopkg.in/yaml.v3"
+)
+
+// An override of the test mail with content that looks like YAML, so it would break a queue file written as plain
+// scalars
+const testTextOverride = `Sent by: {{ .AppName }}
+  - key: "value"
+# not a comment
+'single' and "double" quotes: {{ .Alert }}
+`
+
+// Every template rendered with its preview data, including the overrides in the data directory, is queued and read
+// back from the queue file unchanged
+func TestRenderedTemplatesRoundTripThroughQueue(t *testing.T) {
+	dataDir := t.TempDir()
+	overrides := filepath.Join(dataDir, TemplateOverrideDir)
+	if err := os.MkdirAll(overrides, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(overrides, templateFile(TemplateKeyTest, TemplatePartText)), []byte(testTextOverride), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 10, 23, 9, 0, 0, 0, time.UTC)
+	expiration := now.AddDate(0, 0, 6)
+	whois := configuration.DefaultWhoisCacheStorage(filepath.Join(dataDir, configuration.WhoisCacheName))
+	whois.FileContents.Entries = []configuration.WhoisCache{{
+		FQDN:        "example.com",
+		LastUpdated: now,
+		WhoisInfo: whoisparser.WhoisInfo{
+			Domain:    &whoisparser.Domain{Domain: "example.com", ExpirationDateInTime: &expiration, Status: []string{"clientTransferProhibited"}},
+			Registrar: &whoisparser.Contact{Name: "Example Registrar, LLC"},
+		},
+	}}
+	source := PreviewSource{
+		Domains: &configuration.DomainConfiguration{DomainFile: configuration.DomainFile{Domains: []configuration.Domain{
+			{FQDN: "example.com", Name: `Example "Brand": main`, Enabled: true, Alerts: true, RenewalPrice: 12.5, Currency: "$"},
+		}}},
+		WhoisCache: whois,
+		BaseURL:    "https://monitor.example.com",
+		Now:        now,
+	}
+
+	ts := NewTemplateService(dataDir)
+	queue := configuration.DefaultNotificationQueueStorage(filepath.Join(dataDir, configuration.NotificationQueueName))
+	notifications := NewNotificationService(queue, nil, nil)
+	queued := map[string]configuration.QueuedNotification{}
+	for _, template := range Templates() {
+		data, err := template.sample(source)
+		if err != nil {
+			t.Fatalf("%s: %s", template.Key, err)
+		}
+		rendered, err := ts.Render(template.Key, data)
+		if err != nil {
+			t.Fatalf("%s: %s", template.Key, err)
+		}
+		if !strings.Contains(rendered.Text, "\n") || !strings.Contains(rendered.HTML, "\n") {
+			t.Errorf("%s: rendered a single line, want the multi-line text and HTML of a message", template.Key)
+		}
+		payload, err := WebhookPayload(rendered, data)
+		if err != nil {
+			t.Fatalf("%s: %s", template.Key, err)
+		}
+		item := configuration.QueuedNotification{
+			Key:     IdempotencyKey(template.Key),
+			Channel: configuration.ChannelEmail,
+			FQDN:    "example.com",
+			Alert:   template.Description,
+			To:      []string{"admin@example.com"},
+			Subject: rendered.Subject,
+			Text:    rendered.Text,
+			HTML:    rendered.HTML,
+			Payload: string(payload),
+		}
+		notifications.Enqueue(item)
+		queued[item.Key] = item
+	}
+	if !strings.Contains(queued[IdempotencyKey(TemplateKeyTest)].Text, "  - key: \"value\"\n") {
+		t.Errorf("the override wasn't rendered: %q", queued[IdempotencyKey(TemplateKeyTest)].Text)
+	}
+
+	// Parse the file itself, reading it through the storage would fall back to a backup
+	data, err := os.ReadFile(queue.Filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := configuration.NotificationQueueFile{}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		t.Fatalf("the queue file doesn't parse: %s", err)
+	}
+	if len(file.Items) != len(queued) {
+		t.Fatalf("read back %d notifications, want %d", len(file.Items), len(queued))
+	}
+	for _, got := range file.Items {
+		want := queued[got.Key]
+		if got.Subject != want.Subject || got.Text != want.Text || got.HTML != want.HTML || got.Payload != want.Payload {
+			t.Errorf("%s was read back as\n%q\n%q\n%q\nwant\n%q\n%q\n%q", want.Alert, got.Subject, got.Text, got.HTML, want.Subject, want.Text, want.HTML)
+		}
+	}
+}
+
+func TestPreviewAlertWithoutDomain(t *testing.T) {
+	source := PreviewSource{
+		Domains:    &configuration.DomainConfiguration{},
+		WhoisCache: configuration.DefaultWhoisCacheStorage(filepath.Join(t.TempDir(), configuration.WhoisCacheName)),
+		FQDN:       "unknown.com",
+		Now:        time.Now(),
+	}
+	if _, err := NewTemplateService("").Preview("1week", source); !errors.Is(err, ErrNoPreviewDomain) || !strings.Contains(err.Error(), "unknown.com") {
+		t.Errorf("Preview(1week) = %v, want ErrNoPreviewDomain for unknown.com", err)
+	}
+	if _, err := NewTemplateService("").Preview("unknown", source); err != ErrUnknownTemplate {
+		t.Errorf("Preview(unknown) = %v, want ErrUnknownTemplate", err)
+	}
+}
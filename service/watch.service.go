@@ -0,0 +1,253 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	whoisparser "github.com/likexian/whois-parser"
+	"github.com/nwesterhausen/domain-monitor/configuration"
+)
+
+// Template key of the watchlist alerts
+const TemplateKeyWatch = "watch"
+
+// Interval between the availability checks of the watched names, unless scheduler.watchCheckInterval is set
+const DefaultWatchInterval = 24 * time.Hour
+
+// ErrWatchOwned is returned when a monitored domain is added to the watchlist
+var ErrWatchOwned = errors.New("name is a monitored domain")
+
+// WatchInterval returns the interval between the availability checks of the watched names
+func WatchInterval(scheduler configuration.SchedulerConfiguration) time.Duration {
+	if scheduler.WatchCheckInterval <= 0 {
+		return DefaultWatchInterval
+	}
+	return time.Duration(scheduler.WatchCheckInterval) * time.Hour
+}
+
+// WatchChange is the result of checking a watched name
+type WatchChange struct {
+	// The watched name after the check
+	Domain configuration.WatchedDomain `json:"domain"`
+	// Availability before the check
+	Previous string `json:"previous"`
+}
+
+// Changed reports if the availability changed
+func (c WatchChange) Changed() bool {
+	return c.Previous != c.Domain.Availability
+}
+
+// AlertDue reports if the change should be alerted, see WatchedDomain.AlertDue
+func (c WatchChange) AlertDue() bool {
+	return c.Domain.AlertDue(c.Previous)
+}
+
+// WatchService manages the names watched for availability and checks them
+type WatchService struct {
+	store   *configuration.WatchlistStorage
+	domains *configuration.DomainConfiguration
+	// Looks up the registration of a name
+	lookup func(fqdn string) (whoisparser.WhoisInfo, error)
+}
+
+func NewWatchService(store *configuration.WatchlistStorage, domains *configuration.DomainConfiguration) *WatchService {
+	return &WatchService{store: store, domains: domains, lookup: configuration.LookupDomain}
+}
+
+// List returns every watched name
+func (s *WatchService) List() []configuration.WatchedDomain {
+	return s.store.List()
+}
+
+// Get returns a watched name
+func (s *WatchService) Get(fqdn string) (configuration.WatchedDomain, error) {
+	watch, ok := s.store.Get(strings.ToLower(strings.TrimSpace(fqdn)))
+	if !ok {
+		return watch, configuration.ErrWatchNotFound
+	}
+	return watch, nil
+}
+
+// Add starts watching a name. Monitored domains can't be watched, they're already owned.
+func (s *WatchService) Add(watch configuration.WatchedDomain) (configuration.WatchedDomain, error) {
+	watch.Normalize()
+	if err := watch.Validate(); err != nil {
+		return watch, err
+	}
+	for _, domain := range s.domains.List() {
+		if domain.FQDN == watch.FQDN {
+			return watch, ErrWatchOwned
+		}
+	}
+	watch, err := s.store.Add(watch)
+	if err == nil {
+		log.Printf("👀 Watching %s for availability", watch.FQDN)
+	}
+	return watch, err
+}
+
+// Remove stops watching a name
+func (s *WatchService) Remove(fqdn string) error {
+	fqdn = strings.ToLower(strings.TrimSpace(fqdn))
+	if err := s.store.Remove(fqdn); err != nil {
+		return err
+	}
+	log.Printf("🗑 Stopped watching %s", fqdn)
+	return nil
+}
+
+// Check looks up a watched name and records its availability
+func (s *WatchService) Check(fqdn string, now time.Time) (WatchChange, error) {
+	fqdn = strings.ToLower(strings.TrimSpace(fqdn))
+	if _, ok := s.store.Get(fqdn); !ok {
+		return WatchChange{}, configuration.ErrWatchNotFound
+	}
+	change := s.check(fqdn, now)
+	s.store.Flush()
+	return change, nil
+}
+
+// CheckAll looks up every watched name and records their availability
+func (s *WatchService) CheckAll(now time.Time) []WatchChange {
+	changes := []WatchChange{}
+	for _, watch := range s.store.List() {
+		changes = append(changes, s.check(watch.FQDN, now))
+	}
+	if len(changes) > 0 {
+		s.store.Flush()
+	}
+	return changes
+}
+
+// check looks up a name without holding the watchlist lock, the lookup can take a while
+func (s *WatchService) check(fqdn string, now time.Time) WatchChange {
+	info, err := s.lookup(fqdn)
+	watch, previous, recordErr := s.store.Record(fqdn, info, err, now)
+	if recordErr != nil {
+		// removed while it was looked up
+		return WatchChange{Domain: configuration.WatchedDomain{FQDN: fqdn, Availability: previous}, Previous: previous}
+	}
+	change := WatchChange{Domain: watch, Previous: previous}
+	if watch.LastError != "" {
+		log.Printf("❌ Availability check of %s failed: %s", fqdn, watch.LastError)
+	} else if change.Changed() {
+		log.Printf("👀 %s changed from %s to %s", fqdn, previous, watch.Availability)
+	}
+	return change
+}
+
+// WatchTemplateData is the data available to the watchlist alert templates
+type WatchTemplateData struct {
+	// Application name, for signatures
+	AppName string
+	// Human readable alert, e.g. "example.com is available"
+	Alert string
+	// Always "watch"
+	AlertKey string
+	// The watched name
+	Watch configuration.WatchedDomain
+	FQDN  string
+	// New and previous availability: available, registered, redemption or pendingdelete (unknown before the first check)
+	Availability string
+	Previous     string
+	// Registrar, expiration date and registry status of the current registration, empty if not registered
+	Registrar  string
+	Expiration *time.Time
+	Statuses   []configuration.EPPStatus
+	// Link to the dashboard, empty if no base URL is configured
+	DashboardURL string
+	// When the message was rendered
+	Now time.Time
+}
+
+// NewWatchTemplateData builds the template data for a change of a watched name
+func NewWatchTemplateData(change WatchChange, baseURL string, now time.Time) WatchTemplateData {
+	watch := change.Domain
+	data := WatchTemplateData{
+		AppName:      "Domain Monitor",
+		Alert:        watch.FQDN + " is " + WatchAvailabilityText(watch.Availability),
+		AlertKey:     TemplateKeyWatch,
+		Watch:        watch,
+		FQDN:         watch.FQDN,
+		Availability: watch.Availability,
+		Previous:     change.Previous,
+		Registrar:    watch.Registrar,
+		Expiration:   watch.Expiration,
+		Statuses:     configuration.InterpretStatus(watch.Status).Statuses,
+		Now:          now,
+	}
+	if baseURL != "" {
+		data.DashboardURL = strings.TrimRight(baseURL, "/") + "/"
+	}
+	return data
+}
+
+// WatchAvailabilityText describes an availability, e.g. "pending delete"
+func WatchAvailabilityText(availability string) string {
+	switch availability {
+	case configuration.WatchAvailable:
+		return "available"
+	case configuration.WatchRegistered:
+		return "registered"
+	case configuration.WatchRedemption:
+		return "in the redemption period"
+	case configuration.WatchPendingDelete:
+		return "pending delete"
+	}
+	return "unknown"
+}
+
+// NotifyWatch queues the alert for a change of a watched name, to its owners or the default recipients. Returns who it
+// was queued for.
+func NotifyWatch(notifications *NotificationService, config configuration.ConfigurationFile, change WatchChange, now time.Time) ([]string, error) {
+	to := DefaultRecipients(config)
+	if len(change.Domain.Owners) > 0 {
+		to = config.ExpandRecipients(change.Domain.Owners)
+	}
+	recipients := Recipients{To: to}
+	if recipients.Empty() {
+		log.Printf("⚠️ No recipients for the watchlist alert of %s, configure alerts.admin or the watch owners", change.Domain.FQDN)
+		return nil, nil
+	}
+
+	data := NewWatchTemplateData(change, config.App.BaseURL, now)
+	rendered, err := notifications.Render(TemplateKeyWatch, data)
+	if err != nil {
+		log.Printf("❌ Failed to render the watchlist alert for %s: %s", change.Domain.FQDN, err)
+		return nil, err
+	}
+
+	// A name can flip back and forth, each change is alerted once
+	changed := now
+	if change.Domain.LastChanged != nil {
+		changed = *change.Domain.LastChanged
+	}
+	keyParts := []string{TemplateKeyWatch, change.Domain.FQDN, change.Domain.Availability, changed.Format(time.RFC3339)}
+	item := configuration.QueuedNotification{FQDN: change.Domain.FQDN, Alert: "watch: " + change.Domain.Availability}
+	return enqueue(notifications, recipients, rendered, data, item, keyParts)
+}
+
+// NotifyWatchChanges queues the alerts of the changes that are due, see WatchChange.AlertDue. Nothing is queued without
+// a configured mailer. Returns the number of queued alerts.
+func NotifyWatchChanges(notifications *NotificationService, config configuration.ConfigurationFile, changes []WatchChange, now time.Time) int {
+	if notifications == nil || !notifications.Enabled() {
+		return 0
+	}
+	queued := 0
+	for _, change := range changes {
+		if !change.AlertDue() {
+			continue
+		}
+		received, err := NotifyWatch(notifications, config, change, now)
+		if err != nil {
+			log.Printf("❌ Failed to queue the watchlist alert for %s: %s", change.Domain.FQDN, err)
+		}
+		if len(received) > 0 {
+			queued++
+		}
+	}
+	return queued
+}
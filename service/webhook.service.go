@@ -0,0 +1,56 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var webhookClient = &http.Client{Timeout: 15 * time.Second}
+
+// PostWebhook posts a rendered message as JSON to a webhook URL.
+func PostWebhook(webhookURL string, msg RenderedMessage, data interface{}) error {
+	payload, err := WebhookPayload(msg, data)
+	if err != nil {
+		return err
+	}
+	return PostWebhookPayload(webhookURL, payload)
+}
+
+// WebhookPayload builds the JSON body of a webhook notification.
+//
+// The `text` field is understood by Slack, Mattermost and Rocket.Chat incoming webhooks. Custom receivers can use
+// `subject`, `body` and the template data in `data` instead.
+func WebhookPayload(msg RenderedMessage, data interface{}) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"text":    "*" + msg.Subject + "*\n\n" + msg.Text,
+		"subject": msg.Subject,
+		"body":    msg.Text,
+		"data":    data,
+	})
+}
+
+// PostWebhookPayload posts a JSON payload to a webhook URL, any non-2xx response is an error
+func PostWebhookPayload(webhookURL string, payload []byte) error {
+	resp, err := webhookClient.Post(webhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s responded with %s", WebhookLabel(webhookURL), resp.Status)
+	}
+	return nil
+}
+
+// WebhookLabel identifies a webhook in logs and sent alert records without exposing the (often secret) path
+func WebhookLabel(webhookURL string) string {
+	u, err := url.Parse(webhookURL)
+	if err != nil || u.Host == "" {
+		return "webhook"
+	}
+	return "webhook:" + u.Host
+}
@@ -3,44 +3,66 @@ package service
 import (
 	"errors"
 	"log"
+	"time"
 
 	"github.com/nwesterhausen/domain-monitor/configuration"
 )
 
 type ServicesWhois struct {
-	store configuration.WhoisCacheStorage
+	store *configuration.WhoisCacheStorage
 }
 
-func NewWhoisService(store configuration.WhoisCacheStorage) *ServicesWhois {
+func NewWhoisService(store *configuration.WhoisCacheStorage) *ServicesWhois {
 	return &ServicesWhois{store: store}
 }
 
 func (s *ServicesWhois) GetWhois(fqdn string) (configuration.WhoisCache, error) {
-	for _, entry := range s.store.FileContents.Entries {
-		if entry.FQDN == fqdn {
-			return entry, nil
-		}
+	if entry := s.store.Get(fqdn); entry != nil {
+		return *entry, nil
 	}
 	log.Println("🙅 WHOIS entry cache miss for", fqdn)
 
 	// Since we cache missed, let's try to fetch the WHOIS entry instead
 	s.store.Add(fqdn)
 	// Try to get the entry again
-	for _, entry := range s.store.FileContents.Entries {
-		if entry.FQDN == fqdn {
-			return entry, nil
-		}
+	if entry := s.store.Get(fqdn); entry != nil {
+		return *entry, nil
 	}
 
 	return configuration.WhoisCache{}, errors.New("entry missing")
 }
 
 func (s *ServicesWhois) MarkAlertSent(fqdn string, alert configuration.Alert) bool {
-	for i := range s.store.FileContents.Entries {
-		if s.store.FileContents.Entries[i].FQDN == fqdn {
-			s.store.FileContents.Entries[i].MarkAlertSent(alert)
-			return true
-		}
+	entry := s.store.Get(fqdn)
+	if entry == nil {
+		return false
 	}
-	return false
+	entry.MarkAlertSent(alert)
+	s.store.SaveAlerts(entry)
+	return true
+}
+
+// RefreshWhois queries WHOIS (or RDAP) for a domain right away, even if its cache entry is still fresh. A missing entry
+// is added to the cache.
+func (s *ServicesWhois) RefreshWhois(fqdn string) (configuration.WhoisCache, error) {
+	entry := s.store.RefreshEntry(fqdn)
+	s.store.Flush()
+	if entry.LastUpdated.IsZero() {
+		return configuration.WhoisCache{}, errors.New("unable to fetch WHOIS for " + fqdn)
+	}
+	return entry, nil
+}
+
+// RefreshAll makes sure every monitored domain has a WHOIS entry and refreshes the entries that are out of date
+func (s *ServicesWhois) RefreshAll(domains *configuration.DomainConfiguration) {
+	s.store.RefreshWithDomains(domains)
+}
+
+// EvaluateExpirations checks the domains against the alert thresholds using the cached WHOIS entries
+func (s *ServicesWhois) EvaluateExpirations(domains []configuration.Domain, alerts configuration.AlertsConfiguration, now time.Time) []ExpiryStatus {
+	return EvaluateExpirations(domains, s.store, alerts, now)
+}
+
+func (s *ServicesWhois) Flush() {
+	s.store.Flush()
 }